@@ -0,0 +1,72 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var clusterlog = logf.Log.WithName("cluster-resource")
+
+func (obj *Cluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-cluster,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=clusters,verbs=create;update,versions=v1,name=vcluster.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Cluster{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Cluster) ValidateCreate() error {
+	clusterlog.Info("validate create", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Cluster) ValidateUpdate(old runtime.Object) error {
+	clusterlog.Info("validate update", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *Cluster) ValidateDelete() error {
+	return nil
+}
+
+// validate checks the kubeconfig, which is not used by the current cluster
+func (obj *Cluster) validate() error {
+	if obj.IsCurrentCluster() {
+		return nil
+	}
+	if obj.Spec.Config == "" {
+		return fmt.Errorf("config is required")
+	}
+	if _, err := base64.StdEncoding.DecodeString(obj.Spec.Config); err != nil {
+		return fmt.Errorf("config must be base64 encoded kubeconfig: %v", err)
+	}
+	return nil
+}
@@ -17,6 +17,12 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/option"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -36,8 +42,23 @@ type HostSpec struct {
 	Password       string `json:"password,omitempty" yaml:"password,omitempty"`
 	PrivateKey     string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
 	PrivateKeyPath string `json:"privateKeyPath,omitempty" yaml:"privateKeyPath,omitempty"`
+	// Passphrase decrypts the private key, base64 encoded
+	Passphrase string `json:"passphrase,omitempty" yaml:"passphrase,omitempty"`
+	// Certificate is the OpenSSH user certificate of the private key, base64 encoded
+	Certificate    string `json:"certificate,omitempty" yaml:"certificate,omitempty"`
 	TimeOutSeconds int64  `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty" `
 	SecretRef      string `json:"secretRef,omitempty" yaml:"secretRef,omitempty"`
+	// HostKeyPolicy is one of strict, tofu and insecure, default tofu
+	// +kubebuilder:validation:Enum=strict;tofu;insecure
+	HostKeyPolicy string `json:"hostKeyPolicy,omitempty" yaml:"hostKeyPolicy,omitempty"`
+	// BastionRef is the name of Host in the same namespace to jump through
+	BastionRef string `json:"bastionRef,omitempty" yaml:"bastionRef,omitempty"`
+	// ProxyJump is the jump hosts, eg: user@1.1.1.1:22,2.2.2.2, connected with the credentials of this host, after bastionRef
+	ProxyJump string `json:"proxyJump,omitempty" yaml:"proxyJump,omitempty"`
+	// MaxSessions limits the concurrent ssh sessions on the host, default 8
+	MaxSessions int `json:"maxSessions,omitempty" yaml:"maxSessions,omitempty"`
+	// ForwardAgent forwards the ssh-agent to the host, only opscli with --agent has the agent
+	ForwardAgent bool `json:"forwardAgent,omitempty" yaml:"forwardAgent,omitempty"`
 }
 
 // HostStatus defines the observed state of Host
@@ -60,6 +81,10 @@ type HostStatus struct {
 	AcceleratorCount  string       `json:"acceleratorCount,omitempty" yaml:"acceleratorCount,omitempty"`
 	HeartStatus       string       `json:"heartStatus,omitempty" yaml:"heartStatus,omitempty"`
 	HeartTime         *metav1.Time `json:"heartTime,omitempty" yaml:"heartTime,omitempty"`
+	// HostKey is the trusted ssh host key in authorized_keys format
+	HostKey string `json:"hostKey,omitempty" yaml:"hostKey,omitempty"`
+	// HopKeys are the trusted ssh host keys of the proxyJump hops, keyed by address:port
+	HopKeys map[string]string `json:"hopKeys,omitempty" yaml:"hopKeys,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -102,6 +127,21 @@ func (obj *Host) FilledByOption(hostOpt option.HostOption) *Host {
 	if hostOpt.PrivateKeyPath != "" && obj.Spec.PrivateKeyPath == "" {
 		obj.Spec.PrivateKeyPath = hostOpt.PrivateKeyPath
 	}
+	if hostOpt.Passphrase != "" && obj.Spec.Passphrase == "" {
+		obj.Spec.Passphrase = hostOpt.Passphrase
+	}
+	if hostOpt.Certificate != "" && obj.Spec.Certificate == "" {
+		obj.Spec.Certificate = hostOpt.Certificate
+	}
+	if hostOpt.HostKeyPolicy != "" && obj.Spec.HostKeyPolicy == "" {
+		obj.Spec.HostKeyPolicy = hostOpt.HostKeyPolicy
+	}
+	if hostOpt.Bastion != "" && obj.Spec.ProxyJump == "" {
+		obj.Spec.ProxyJump = hostOpt.Bastion
+	}
+	if hostOpt.ForwardAgent {
+		obj.Spec.ForwardAgent = true
+	}
 	return obj
 }
 
@@ -110,6 +150,7 @@ func (h *Host) Cleaned() {
 		return
 	}
 	h.Spec.Password = ""
+	h.Spec.Passphrase = ""
 	if h.ObjectMeta.Annotations != nil {
 		if _, ok := h.ObjectMeta.Annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
 			delete(h.ObjectMeta.Annotations, "kubectl.kubernetes.io/last-applied-configuration")
@@ -118,6 +159,56 @@ func (h *Host) Cleaned() {
 	h.ObjectMeta.ManagedFields = nil
 }
 
+func (h *Host) GetHostKeyPolicy() string {
+	if h.Spec.HostKeyPolicy == "" {
+		return opsconstants.HostKeyPolicyTofu
+	}
+	return h.Spec.HostKeyPolicy
+}
+
+func (h *Host) GetMaxSessions() int {
+	if h.Spec.MaxSessions <= 0 {
+		return opsconstants.DefaultSSHMaxSessions
+	}
+	return h.Spec.MaxSessions
+}
+
+// GetProxyJumps returns the jump hosts in order, they inherit the credentials and policy of h
+func (h *Host) GetProxyJumps() (jumps []*Host, err error) {
+	for _, jump := range strings.Split(h.Spec.ProxyJump, ",") {
+		jump = strings.TrimSpace(jump)
+		if jump == "" {
+			continue
+		}
+		j := &Host{Spec: *h.Spec.DeepCopy()}
+		j.Namespace = h.Namespace
+		if h.Name != "" {
+			// the host key of hop is recorded in the status of the host declaring it
+			j.Annotations = map[string]string{opsconstants.AnnotationProxyJumpOfKey: h.Name}
+		}
+		j.Spec.BastionRef = ""
+		j.Spec.ProxyJump = ""
+		if user, address, found := strings.Cut(jump, "@"); found {
+			j.Spec.Username = user
+			jump = address
+		}
+		j.Spec.Address = jump
+		j.Spec.Port = 22
+		if address, port, err := net.SplitHostPort(jump); err == nil {
+			j.Spec.Address = address
+			j.Spec.Port, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in proxy jump %s", jump)
+			}
+		}
+		if j.Spec.Username == "" || j.Spec.Address == "" {
+			return nil, fmt.Errorf("invalid proxy jump %s", jump)
+		}
+		jumps = append(jumps, j)
+	}
+	return
+}
+
 func (h *Host) GetUniqueKey() string {
 	return types.NamespacedName{
 		Namespace: h.Namespace,
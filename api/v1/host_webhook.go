@@ -0,0 +1,91 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var hostlog = logf.Log.WithName("host-resource")
+
+func (obj *Host) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-host,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=hosts,verbs=create;update,versions=v1,name=mhost.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Host{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *Host) Default() {
+	hostlog.Info("default", "name", obj.Name)
+	if obj.Spec.Port == 0 {
+		obj.Spec.Port = 22
+	}
+	if obj.Spec.Username == "" {
+		obj.Spec.Username = "root"
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-host,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=hosts,verbs=create;update,versions=v1,name=vhost.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Host{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Host) ValidateCreate() error {
+	hostlog.Info("validate create", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Host) ValidateUpdate(old runtime.Object) error {
+	hostlog.Info("validate update", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *Host) ValidateDelete() error {
+	return nil
+}
+
+func (obj *Host) validate() error {
+	if obj.Spec.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if obj.Spec.Port < 0 || obj.Spec.Port > 65535 {
+		return fmt.Errorf("invalid port %d", obj.Spec.Port)
+	}
+	if obj.Spec.TimeOutSeconds < 0 {
+		return fmt.Errorf("timeoutSeconds must not be negative")
+	}
+	if obj.Spec.BastionRef != "" && obj.Spec.BastionRef == obj.Name {
+		return fmt.Errorf("bastionRef must not be the host itself")
+	}
+	if _, err := obj.GetProxyJumps(); err != nil {
+		return err
+	}
+	return nil
+}
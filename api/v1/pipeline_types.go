@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+	"strings"
+
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
@@ -41,10 +44,18 @@ type TaskRef struct {
 	Name         string            `json:"name,omitempty" yaml:"name,omitempty"`
 	TaskRef      string            `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
 	Variables    map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+	RunAfter     []string          `json:"runAfter,omitempty" yaml:"runAfter,omitempty"`
 	AllowFailure bool              `json:"allowFailure,omitempty" yaml:"allowFailure,omitempty"`
 	RunAlways    bool              `json:"runAlways,omitempty" yaml:"runAlways,omitempty"`
 }
 
+func (t TaskRef) GetName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.TaskRef
+}
+
 // PipelineStatus defines the observed state of Pipeline
 type PipelineStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -140,6 +151,82 @@ func (obj *Pipeline) MergeVariables(vars Variables) bool {
 	return changed
 }
 
+// GetRunAfter returns the upstream tasks of every task, keyed by task name.
+// If no task declares runAfter, tasks run one after another in the listed order.
+func (obj *Pipeline) GetRunAfter() map[string][]string {
+	isDAG := false
+	for _, t := range obj.Spec.Tasks {
+		if len(t.RunAfter) > 0 {
+			isDAG = true
+			break
+		}
+	}
+	runAfter := make(map[string][]string)
+	for i, t := range obj.Spec.Tasks {
+		if isDAG {
+			runAfter[t.GetName()] = t.RunAfter
+		} else if i > 0 {
+			runAfter[t.GetName()] = []string{obj.Spec.Tasks[i-1].GetName()}
+		} else {
+			runAfter[t.GetName()] = []string{}
+		}
+	}
+	return runAfter
+}
+
+// ValidateTasks checks task names are unique, runAfter only references known tasks
+// and the tasks form a DAG.
+func (obj *Pipeline) ValidateTasks() error {
+	names := make(map[string]bool)
+	for _, t := range obj.Spec.Tasks {
+		if t.TaskRef == "" {
+			return fmt.Errorf("task %s: taskRef is required", t.Name)
+		}
+		if names[t.GetName()] {
+			return fmt.Errorf("duplicate task name %s", t.GetName())
+		}
+		names[t.GetName()] = true
+	}
+	for _, t := range obj.Spec.Tasks {
+		for _, dep := range t.RunAfter {
+			if !names[dep] {
+				return fmt.Errorf("task %s runAfter unknown task %s", t.GetName(), dep)
+			}
+		}
+	}
+	// detect cycle with dfs
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	runAfter := obj.GetRunAfter()
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("cycle detected in tasks: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range runAfter[name] {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, t := range obj.Spec.Tasks {
+		if err := visit(t.GetName(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (obj *Pipeline) MergeVersion(merge *Pipeline) *Pipeline {
 	obj.ObjectMeta.ResourceVersion = merge.ObjectMeta.ResourceVersion
 	return obj
@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipelineGetRunAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []TaskRef
+		want  map[string][]string
+	}{
+		{
+			name:  "sequential without runAfter",
+			tasks: []TaskRef{{TaskRef: "a"}, {TaskRef: "b"}, {Name: "c", TaskRef: "b"}},
+			want:  map[string][]string{"a": {}, "b": {"a"}, "c": {"b"}},
+		},
+		{
+			name:  "dag with runAfter",
+			tasks: []TaskRef{{TaskRef: "a"}, {TaskRef: "b"}, {TaskRef: "c", RunAfter: []string{"a", "b"}}},
+			want:  map[string][]string{"a": nil, "b": nil, "c": {"a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		p := &Pipeline{Spec: PipelineSpec{Tasks: tt.tasks}}
+		if got := p.GetRunAfter(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GetRunAfter() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineValidateTasks(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []TaskRef
+		wantErr string
+	}{
+		{
+			name:  "sequential",
+			tasks: []TaskRef{{TaskRef: "a"}, {TaskRef: "b"}},
+		},
+		{
+			name: "diamond",
+			tasks: []TaskRef{
+				{TaskRef: "a"},
+				{TaskRef: "b", RunAfter: []string{"a"}},
+				{TaskRef: "c", RunAfter: []string{"a"}},
+				{TaskRef: "d", RunAfter: []string{"b", "c"}},
+			},
+		},
+		{
+			name:  "same taskRef with names",
+			tasks: []TaskRef{{Name: "first", TaskRef: "a"}, {Name: "second", TaskRef: "a", RunAfter: []string{"first"}}},
+		},
+		{
+			name:    "missing taskRef",
+			tasks:   []TaskRef{{Name: "a"}},
+			wantErr: "taskRef is required",
+		},
+		{
+			name:    "duplicate name",
+			tasks:   []TaskRef{{TaskRef: "a"}, {TaskRef: "a"}},
+			wantErr: "duplicate task name a",
+		},
+		{
+			name:    "unknown runAfter",
+			tasks:   []TaskRef{{TaskRef: "a", RunAfter: []string{"b"}}},
+			wantErr: "runAfter unknown task b",
+		},
+		{
+			name:    "self cycle",
+			tasks:   []TaskRef{{TaskRef: "a", RunAfter: []string{"a"}}},
+			wantErr: "cycle detected in tasks: a -> a",
+		},
+		{
+			name: "cycle",
+			tasks: []TaskRef{
+				{TaskRef: "a", RunAfter: []string{"c"}},
+				{TaskRef: "b", RunAfter: []string{"a"}},
+				{TaskRef: "c", RunAfter: []string{"b"}},
+			},
+			wantErr: "cycle detected in tasks: a -> c -> b -> a",
+		},
+		{
+			name: "cycle behind a root",
+			tasks: []TaskRef{
+				{TaskRef: "root"},
+				{TaskRef: "b", RunAfter: []string{"root", "c"}},
+				{TaskRef: "c", RunAfter: []string{"b"}},
+			},
+			wantErr: "cycle detected in tasks: b -> c -> b",
+		},
+	}
+	for _, tt := range tests {
+		p := &Pipeline{Spec: PipelineSpec{Tasks: tt.tasks}}
+		err := p.ValidateTasks()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: ValidateTasks() = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: ValidateTasks() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
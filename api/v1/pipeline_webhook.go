@@ -0,0 +1,83 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var pipelinelog = logf.Log.WithName("pipeline-resource")
+
+func (obj *Pipeline) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-pipeline,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=pipelines,verbs=create;update,versions=v1,name=mpipeline.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Pipeline{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *Pipeline) Default() {
+	pipelinelog.Info("default", "name", obj.Name)
+	for i := range obj.Spec.Tasks {
+		if obj.Spec.Tasks[i].Name == "" {
+			obj.Spec.Tasks[i].Name = obj.Spec.Tasks[i].TaskRef
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-pipeline,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=pipelines,verbs=create;update,versions=v1,name=vpipeline.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Pipeline{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Pipeline) ValidateCreate() error {
+	pipelinelog.Info("validate create", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Pipeline) ValidateUpdate(old runtime.Object) error {
+	pipelinelog.Info("validate update", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *Pipeline) ValidateDelete() error {
+	return nil
+}
+
+// validate checks the shape of tasks, the referenced tasks are checked by pipelinerun,
+// since tasks and pipelines may be applied in any order
+func (obj *Pipeline) validate() error {
+	if len(obj.Spec.Tasks) == 0 {
+		return fmt.Errorf("tasks is required")
+	}
+	if err := obj.ValidateTasks(); err != nil {
+		return err
+	}
+	return validateVariableDefinitions(obj.Spec.Variables)
+}
@@ -34,6 +34,8 @@ type PipelineRunSpec struct {
 	Crontab     string            `json:"crontab,omitempty" yaml:"crontab,omitempty"`
 	Variables   map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
 	PipelineRef string            `json:"pipelineRef,omitempty" yaml:"pipelineRef,omitempty"`
+	// Cancelled aborts the running tasks and skips the remaining ones
+	Cancelled bool `json:"cancelled,omitempty" yaml:"cancelled,omitempty"`
 }
 
 // PipelineRunStatus defines the observed state of PipelineRun
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var pipelinerunlog = logf.Log.WithName("pipelinerun-resource")
+
+func (obj *PipelineRun) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-pipelinerun,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=pipelineruns,verbs=create;update,versions=v1,name=mpipelinerun.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &PipelineRun{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *PipelineRun) Default() {
+	pipelinerunlog.Info("default", "name", obj.Name)
+	if obj.Spec.PipelineRef == "" {
+		return
+	}
+	if obj.Labels == nil {
+		obj.Labels = make(map[string]string)
+	}
+	if _, ok := obj.Labels[opsconstants.LabelPipelineRefKey]; !ok {
+		obj.Labels[opsconstants.LabelPipelineRefKey] = obj.Spec.PipelineRef
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-pipelinerun,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=pipelineruns,verbs=create;update,versions=v1,name=vpipelinerun.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &PipelineRun{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *PipelineRun) ValidateCreate() error {
+	pipelinerunlog.Info("validate create", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	return obj.validatePipelineRef()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type,
+// the pipeline is only checked when changed, the running one can be cancelled after the pipeline deleted
+func (obj *PipelineRun) ValidateUpdate(old runtime.Object) error {
+	pipelinerunlog.Info("validate update", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	if oldPr, ok := old.(*PipelineRun); ok && oldPr.Spec.PipelineRef == obj.Spec.PipelineRef {
+		return nil
+	}
+	return obj.validatePipelineRef()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *PipelineRun) ValidateDelete() error {
+	return nil
+}
+
+func (obj *PipelineRun) validate() error {
+	if obj.Spec.PipelineRef == "" {
+		return fmt.Errorf("pipelineRef is required")
+	}
+	return validateCrontab(obj.Spec.Crontab)
+}
+
+// validatePipelineRef checks the pipeline and its tasks exist, and the variables every task runs with
+func (obj *PipelineRun) validatePipelineRef() error {
+	p := &Pipeline{}
+	if err := getWebhookObject(obj.Namespace, obj.Spec.PipelineRef, p); err != nil {
+		return fmt.Errorf("get pipeline %s: %v", obj.Spec.PipelineRef, err)
+	}
+	if err := validateVariableValues(p.Spec.Variables, obj.Spec.Variables); err != nil {
+		return err
+	}
+	for _, tRef := range p.Spec.Tasks {
+		t := &Task{}
+		if err := getWebhookObject(obj.Namespace, tRef.TaskRef, t); err != nil {
+			return fmt.Errorf("task %s: get task %s: %v", tRef.GetName(), tRef.TaskRef, err)
+		}
+		// taskRef variables > pipelinerun variables
+		values := make(map[string]string)
+		for k, v := range obj.Spec.Variables {
+			values[k] = v
+		}
+		for k, v := range tRef.Variables {
+			values[k] = v
+		}
+		if err := validateVariableValues(t.Spec.Variables, values); err != nil {
+			return fmt.Errorf("task %s: %v", tRef.GetName(), err)
+		}
+	}
+	return nil
+}
@@ -17,9 +17,15 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -33,8 +39,60 @@ type TaskSpec struct {
 	Host                    string    `json:"host,omitempty" yaml:"host,omitempty"`
 	Variables               Variables `json:"variables,omitempty" yaml:"variables,omitempty"`
 	Steps                   []Step    `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Results                 []Result  `json:"results,omitempty" yaml:"results,omitempty"`
 	RuntimeImage            string    `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
 	TTlSecondsAfterFinished int       `json:"ttlSecondsAfterFinished,omitempty" yaml:"ttlSecondsAfterFinished,omitempty"`
+	// Facts are the custom facts gathered with the built-in ones before the steps, the name to the script,
+	// the first line of the output is referred to as ${facts.<name>}
+	Facts   map[string]string `json:"facts,omitempty" yaml:"facts,omitempty"`
+	Rollout `json:",inline" yaml:",inline"`
+}
+
+// Rollout controls how a task runs on multiple hosts or nodes
+type Rollout struct {
+	// Parallelism is the max hosts running at the same time, default 1
+	Parallelism int `json:"parallelism,omitempty" yaml:"parallelism,omitempty"`
+	// BatchSize splits hosts into batches, a batch starts after the previous one finished, 0 is one batch
+	BatchSize int `json:"batchSize,omitempty" yaml:"batchSize,omitempty"`
+	// MaxUnavailable limits the hosts of a batch, a number or a percentage of all hosts such as 25%
+	// +kubebuilder:validation:XIntOrString
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty" yaml:"maxUnavailable,omitempty" swaggertype:"string"`
+	// FailureThreshold stops the rollout after the number of hosts failed, 0 is never stop
+	FailureThreshold int `json:"failureThreshold,omitempty" yaml:"failureThreshold,omitempty"`
+}
+
+func (r Rollout) GetParallelism() int {
+	if r.Parallelism > 0 {
+		return r.Parallelism
+	}
+	return 1
+}
+
+// IsBatched checks the hosts run in more than one batch
+func (r Rollout) IsBatched() bool {
+	return r.BatchSize > 0 || r.MaxUnavailable != nil
+}
+
+// GetBatchSize returns the hosts of a batch in total hosts, the smaller one of batchSize and maxUnavailable, at least 1
+func (r Rollout) GetBatchSize(total int) int {
+	batchSize := total
+	if r.BatchSize > 0 && r.BatchSize < batchSize {
+		batchSize = r.BatchSize
+	}
+	if r.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(r.MaxUnavailable, total, true)
+		if err == nil && maxUnavailable < batchSize {
+			batchSize = maxUnavailable
+		}
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return batchSize
+}
+
+func (r Rollout) IsFailureExceeded(failed int) bool {
+	return r.FailureThreshold > 0 && failed >= r.FailureThreshold
 }
 
 type Step struct {
@@ -46,6 +104,199 @@ type Step struct {
 	Direction      string `json:"direction,omitempty" yaml:"direction,omitempty"`
 	AllowFailure   string `json:"allowfailure,omitempty" yaml:"allowfailure,omitempty"`
 	TimeOutSeconds int    `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty"`
+	// Executor runs the content read from stdin, such as bash, python3 or any binary with args,
+	// default python3 if the first line of content contains python, otherwise sh
+	Executor string `json:"executor,omitempty" yaml:"executor,omitempty"`
+	// Env is the environment variables of the executor
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// WorkDir is the working directory of the executor
+	WorkDir string `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	// Kube operates the resources through the Kubernetes API, instead of content or file
+	Kube *KubeStep `json:"kube,omitempty" yaml:"kube,omitempty"`
+	// HTTP sends a request from the controller or opscli, instead of content or file
+	HTTP *HTTPStep `json:"http,omitempty" yaml:"http,omitempty"`
+	// Ensure makes the target in the state only if it's not, instead of content or file
+	Ensure *EnsureStep `json:"ensure,omitempty" yaml:"ensure,omitempty"`
+	// Uses inlines the steps of another task, name or namespace/name, the steps are named <name>.<step>
+	// and the results of the task are named <name>.<result>
+	Uses string `json:"uses,omitempty" yaml:"uses,omitempty"`
+	// With sets the variables of the used task, default the values of the task
+	With map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
+	// Template renders content, localfile, remotefile and the content of ensure file as a Go template with .Vars and .Facts,
+	// instead of replacing ${name}
+	// +kubebuilder:validation:Enum=go
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+	// Retries is the max retry times after the first attempt failed
+	Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// RetryDelay is the seconds to wait before the first retry
+	RetryDelay int `json:"retryDelay,omitempty" yaml:"retryDelay,omitempty"`
+	// RetryBackoff multiplies the delay after every retry, 0 or 1 is constant delay
+	RetryBackoff int `json:"retryBackoff,omitempty" yaml:"retryBackoff,omitempty"`
+	// MaxRetryDelay is the max seconds of the delay, 0 is unlimited
+	MaxRetryDelay int `json:"maxRetryDelay,omitempty" yaml:"maxRetryDelay,omitempty"`
+}
+
+// KubeStep runs an action on the resources through the Kubernetes API, without kubectl and the runner pod,
+// the output is the JSON of the resources
+type KubeStep struct {
+	// +kubebuilder:validation:Enum=apply;get;patch;delete;wait;rollout-restart;cordon;uncordon;drain
+	Action     string `json:"action" yaml:"action"`
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Namespace of the namespaced resources, default "default"
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Name of the resource, or the node of cordon, uncordon and drain
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// LabelSelector selects the resources of get, delete and wait without name
+	LabelSelector string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+	// Manifest is the yaml or json of the resources to apply, documents are separated by ---
+	Manifest string `json:"manifest,omitempty" yaml:"manifest,omitempty"`
+	// Patch is the content of patch action
+	Patch string `json:"patch,omitempty" yaml:"patch,omitempty"`
+	// PatchType is one of merge, json and strategic, default merge
+	PatchType string `json:"patchType,omitempty" yaml:"patchType,omitempty"`
+	// Condition is the type of condition to be True for wait action, such as Ready, or delete for the deletion
+	Condition string `json:"condition,omitempty" yaml:"condition,omitempty"`
+	// JsonPath of the resource to wait for, such as {.status.phase}, to be Value or not empty
+	JsonPath string `json:"jsonPath,omitempty" yaml:"jsonPath,omitempty"`
+	Value    string `json:"value,omitempty" yaml:"value,omitempty"`
+	// Force deletes the resources immediately, and drains the pods not managed by controllers
+	Force bool `json:"force,omitempty" yaml:"force,omitempty"`
+}
+
+// EnsureStep is one of the idempotent modules, the result reports whether the target is changed
+type EnsureStep struct {
+	File    *EnsureFile    `json:"file,omitempty" yaml:"file,omitempty"`
+	Line    *EnsureLine    `json:"line,omitempty" yaml:"line,omitempty"`
+	Package *EnsurePackage `json:"package,omitempty" yaml:"package,omitempty"`
+	Service *EnsureService `json:"service,omitempty" yaml:"service,omitempty"`
+}
+
+// EnsureFile writes the content if the md5 differs, and sets the mode and owner if they differ
+type EnsureFile struct {
+	Path string `json:"path" yaml:"path"`
+	// Content is rendered as a Go template if the template of step is go
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// Mode is octal, such as 0644
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Owner is user or user:group
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// State is present or absent, default present
+	// +kubebuilder:validation:Enum=present;absent
+	State string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+// EnsureLine keeps the line in the file, the lines matching regexp are replaced by it, appended if none matches
+type EnsureLine struct {
+	Path string `json:"path" yaml:"path"`
+	Line string `json:"line,omitempty" yaml:"line,omitempty"`
+	// Regexp is the extended regex of awk, default the lines equal to line
+	Regexp string `json:"regexp,omitempty" yaml:"regexp,omitempty"`
+	// State is present or absent, absent removes the matching lines, default present
+	// +kubebuilder:validation:Enum=present;absent
+	State string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+// EnsurePackage installs or removes the packages with apt-get, dnf, yum, apk or zypper
+type EnsurePackage struct {
+	// Name is the packages separated by space
+	Name string `json:"name" yaml:"name"`
+	// State is present or absent, default present
+	// +kubebuilder:validation:Enum=present;absent
+	State string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+// EnsureService starts or stops the systemd service, and enables or disables it
+type EnsureService struct {
+	Name string `json:"name" yaml:"name"`
+	// State is started or stopped, default unchanged
+	// +kubebuilder:validation:Enum=started;stopped
+	State   string `json:"state,omitempty" yaml:"state,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+}
+
+// HTTPStep sends a request and asserts the response, the output is the response body
+type HTTPStep struct {
+	URL string `json:"url" yaml:"url"`
+	// Method is the request method, default GET
+	Method  string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string            `json:"body,omitempty" yaml:"body,omitempty"`
+	// JSON is encoded as the body with Content-Type application/json, the values are escaped after rendered
+	JSON map[string]string `json:"json,omitempty" yaml:"json,omitempty"`
+	// InsecureSkipVerify skips the verification of the server certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+	// CACert is the PEM of the CA to verify the server certificate, default the system CAs
+	CACert string `json:"caCert,omitempty" yaml:"caCert,omitempty"`
+	// ClientCert and ClientKey are the PEM of the client certificate
+	ClientCert string `json:"clientCert,omitempty" yaml:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty" yaml:"clientKey,omitempty"`
+	// ExpectStatus is the expected status codes separated by comma, such as 200,201 or 2xx, default 2xx
+	ExpectStatus string `json:"expectStatus,omitempty" yaml:"expectStatus,omitempty"`
+	// ExpectBody is a regex the response body must match
+	ExpectBody string `json:"expectBody,omitempty" yaml:"expectBody,omitempty"`
+	// Extract saves the values of jsonPath in the JSON response, such as {.data.id}, to the step result
+	Extract map[string]string `json:"extract,omitempty" yaml:"extract,omitempty"`
+}
+
+// IsExpectedStatus checks the status code against ExpectStatus, the error is returned if ExpectStatus is invalid
+func (obj *HTTPStep) IsExpectedStatus(code int) (bool, error) {
+	expect := obj.ExpectStatus
+	if strings.TrimSpace(expect) == "" {
+		expect = "2xx"
+	}
+	matched := false
+	for _, item := range strings.Split(expect, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if len(item) == 3 && strings.HasSuffix(item, "xx") && item[0] >= '1' && item[0] <= '5' {
+			matched = matched || code/100 == int(item[0]-'0')
+			continue
+		}
+		expectCode, err := strconv.Atoi(item)
+		if err != nil || expectCode < 100 || expectCode > 599 {
+			return false, fmt.Errorf("invalid expectStatus %s", item)
+		}
+		matched = matched || code == expectCode
+	}
+	return matched, nil
+}
+
+// GetRetryDelay returns the delay before the retry, retry starts from 1
+func (s *Step) GetRetryDelay(retry int) time.Duration {
+	delay := time.Duration(s.RetryDelay) * time.Second
+	for i := 1; i < retry && s.RetryBackoff > 1; i++ {
+		delay *= time.Duration(s.RetryBackoff)
+		if s.MaxRetryDelay > 0 && delay > time.Duration(s.MaxRetryDelay)*time.Second {
+			break
+		}
+	}
+	if s.MaxRetryDelay > 0 && delay > time.Duration(s.MaxRetryDelay)*time.Second {
+		delay = time.Duration(s.MaxRetryDelay) * time.Second
+	}
+	return delay
+}
+
+// Result is a named value captured from the output of a step or a result file.
+// Without regex and jsonPath, the whole trimmed output is used.
+type Result struct {
+	Name string `json:"name" yaml:"name"`
+	Desc string `json:"desc,omitempty" yaml:"desc,omitempty"`
+	// Step is the step name to capture from, default the last run step
+	Step string `json:"step,omitempty" yaml:"step,omitempty"`
+	// File is read on the target after all steps, instead of step output
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// Regex captures the first submatch, or the whole match without group
+	Regex string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	// JsonPath is a kubectl style jsonpath, such as {.items[0].metadata.name}
+	JsonPath string `json:"jsonPath,omitempty" yaml:"jsonPath,omitempty"`
+	// Type is checked on capture as the type of variables, list, map and json of jsonPath are printed in JSON
+	// +kubebuilder:validation:Enum=string;int;bool;duration;list;map;json
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+// ValidateType checks the captured value is the type of result
+func (r Result) ValidateType(value string) error {
+	return Variable{Type: r.Type}.ValidateType(value)
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,347 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var tasklog = logf.Log.WithName("task-resource")
+
+func (obj *Task) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-task,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=tasks,verbs=create;update,versions=v1,name=mtask.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Task{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *Task) Default() {
+	tasklog.Info("default", "name", obj.Name)
+	for i := range obj.Spec.Steps {
+		if obj.Spec.Steps[i].Name == "" {
+			obj.Spec.Steps[i].Name = fmt.Sprintf("step-%d", i+1)
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-task,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=tasks,verbs=create;update,versions=v1,name=vtask.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Task{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Task) ValidateCreate() error {
+	tasklog.Info("validate create", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Task) ValidateUpdate(old runtime.Object) error {
+	tasklog.Info("validate update", "name", obj.Name)
+	return obj.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *Task) ValidateDelete() error {
+	return nil
+}
+
+func (obj *Task) validate() error {
+	if err := validateHostSelector(obj.Spec.Host); err != nil {
+		return err
+	}
+	if err := validateRollout(obj.Spec.Rollout); err != nil {
+		return err
+	}
+	if err := validateVariableDefinitions(obj.Spec.Variables); err != nil {
+		return err
+	}
+	for name, script := range obj.Spec.Facts {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid fact name %s", name)
+		}
+		if strings.TrimSpace(script) == "" {
+			return fmt.Errorf("fact %s: script is required", name)
+		}
+	}
+	stepNames := make(map[string]bool)
+	var usesNames []string
+	for i, s := range obj.Spec.Steps {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("step %d %s: %v", i+1, s.Name, err)
+		}
+		if s.Uses != "" {
+			if stepNames[s.Name] {
+				return fmt.Errorf("step %d %s: the name of uses must be unique", i+1, s.Name)
+			}
+			usesNames = append(usesNames, s.Name)
+		}
+		stepNames[s.Name] = true
+	}
+	for _, r := range obj.Spec.Results {
+		if r.Name == "" {
+			return fmt.Errorf("result name is required")
+		}
+		if r.Step != "" && !stepNames[r.Step] && !isUsesStep(usesNames, r.Step) {
+			return fmt.Errorf("result %s: unknown step %s", r.Name, r.Step)
+		}
+		if !isVariableType(r.Type) {
+			return fmt.Errorf("result %s: unknown type %s", r.Name, r.Type)
+		}
+		if r.Regex != "" {
+			if _, err := regexp.Compile(r.Regex); err != nil {
+				return fmt.Errorf("result %s: invalid regex %s: %v", r.Name, r.Regex, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isUsesStep checks the step is inlined by one of the uses steps, named <uses>.<step>
+func isUsesStep(usesNames []string, step string) bool {
+	for _, name := range usesNames {
+		if strings.HasPrefix(step, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validate checks the step is either a script with content or a file transfer
+func (s *Step) validate() error {
+	isFile := s.LocalFile != "" || s.RemoteFile != ""
+	kinds := 0
+	for _, ok := range []bool{s.Content != "", isFile, s.Kube != nil, s.HTTP != nil, s.Ensure != nil, s.Uses != ""} {
+		if ok {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return fmt.Errorf("content, localfile/remotefile, kube, http, ensure and uses are exclusive")
+	}
+	if kinds == 0 {
+		return fmt.Errorf("content, localfile/remotefile, kube, http, ensure or uses is required")
+	}
+	if s.Uses != "" {
+		return s.validateUses()
+	}
+	if len(s.With) > 0 {
+		return fmt.Errorf("with is only for uses")
+	}
+	if s.Content == "" && (s.Executor != "" || len(s.Env) > 0 || s.WorkDir != "") {
+		return fmt.Errorf("executor, env and workdir are only for content")
+	}
+	if s.Template != "" && s.Template != opsconstants.StepTemplateGo {
+		return fmt.Errorf("invalid template %s, must be %s", s.Template, opsconstants.StepTemplateGo)
+	}
+	if s.Template != "" && s.Content == "" && !isFile && (s.Ensure == nil || s.Ensure.File == nil) {
+		return fmt.Errorf("template is only for content, localfile, remotefile and ensure file")
+	}
+	if s.Kube != nil {
+		if err := s.Kube.validate(); err != nil {
+			return fmt.Errorf("kube: %s", err)
+		}
+	}
+	if s.HTTP != nil {
+		if err := s.HTTP.validate(); err != nil {
+			return fmt.Errorf("http: %s", err)
+		}
+	}
+	if s.Ensure != nil {
+		if err := s.Ensure.validate(); err != nil {
+			return fmt.Errorf("ensure: %s", err)
+		}
+	}
+	for key := range s.Env {
+		if !envNameRegexp.MatchString(key) {
+			return fmt.Errorf("invalid env name %s", key)
+		}
+	}
+	direction := strings.ToLower(s.Direction)
+	if isFile && !strings.Contains(direction, "up") && !strings.Contains(direction, "down") {
+		return fmt.Errorf("direction must be upload or download")
+	}
+	if s.TimeOutSeconds < 0 || s.Retries < 0 || s.RetryDelay < 0 || s.RetryBackoff < 0 || s.MaxRetryDelay < 0 {
+		return fmt.Errorf("timeoutSeconds, retries and retry delays must not be negative")
+	}
+	return nil
+}
+
+// validateUses checks the uses step only sets the fields applied to the inlined steps
+func (s *Step) validateUses() error {
+	if s.Name == "" || strings.Contains(s.Name, "${") {
+		return fmt.Errorf("name of uses is required and must not contain variables")
+	}
+	parts := strings.Split(s.Uses, "/")
+	if len(parts) > 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+		return fmt.Errorf("invalid uses %s, must be name or namespace/name", s.Uses)
+	}
+	if s.Direction != "" || s.Template != "" || s.Executor != "" || len(s.Env) > 0 || s.WorkDir != "" || s.TimeOutSeconds != 0 ||
+		s.Retries != 0 || s.RetryDelay != 0 || s.RetryBackoff != 0 || s.MaxRetryDelay != 0 {
+		return fmt.Errorf("only name, when, allowfailure and with are allowed with uses")
+	}
+	return nil
+}
+
+// validate checks one module is set with the fields it requires
+func (e *EnsureStep) validate() error {
+	modules := 0
+	for _, ok := range []bool{e.File != nil, e.Line != nil, e.Package != nil, e.Service != nil} {
+		if ok {
+			modules++
+		}
+	}
+	if modules != 1 {
+		return fmt.Errorf("one of file, line, package and service is required")
+	}
+	switch {
+	case e.File != nil:
+		if e.File.Path == "" {
+			return fmt.Errorf("file: path is required")
+		}
+		if e.File.State != "" && e.File.State != opsconstants.EnsureStatePresent && e.File.State != opsconstants.EnsureStateAbsent {
+			return fmt.Errorf("file: state must be present or absent")
+		}
+		if e.File.Mode != "" && !strings.Contains(e.File.Mode, "${") {
+			if _, err := strconv.ParseUint(e.File.Mode, 8, 32); err != nil {
+				return fmt.Errorf("file: mode %s is not octal", e.File.Mode)
+			}
+		}
+	case e.Line != nil:
+		if e.Line.Path == "" {
+			return fmt.Errorf("line: path is required")
+		}
+		if e.Line.State != "" && e.Line.State != opsconstants.EnsureStatePresent && e.Line.State != opsconstants.EnsureStateAbsent {
+			return fmt.Errorf("line: state must be present or absent")
+		}
+		if e.Line.State == opsconstants.EnsureStateAbsent && e.Line.Line == "" && e.Line.Regexp == "" {
+			return fmt.Errorf("line: line or regexp is required")
+		}
+		if e.Line.State != opsconstants.EnsureStateAbsent && e.Line.Line == "" {
+			return fmt.Errorf("line: line is required")
+		}
+	case e.Package != nil:
+		if strings.TrimSpace(e.Package.Name) == "" {
+			return fmt.Errorf("package: name is required")
+		}
+		if e.Package.State != "" && e.Package.State != opsconstants.EnsureStatePresent && e.Package.State != opsconstants.EnsureStateAbsent {
+			return fmt.Errorf("package: state must be present or absent")
+		}
+	case e.Service != nil:
+		if e.Service.Name == "" {
+			return fmt.Errorf("service: name is required")
+		}
+		if e.Service.State != "" && e.Service.State != opsconstants.EnsureStateStarted && e.Service.State != opsconstants.EnsureStateStopped {
+			return fmt.Errorf("service: state must be started or stopped")
+		}
+		if e.Service.State == "" && e.Service.Enabled == nil {
+			return fmt.Errorf("service: state or enabled is required")
+		}
+	}
+	return nil
+}
+
+// validate checks the fields required by the action
+func (k *KubeStep) validate() error {
+	switch k.Action {
+	case opsconstants.KubeActionApply:
+		if k.Manifest == "" {
+			return fmt.Errorf("manifest is required by apply")
+		}
+	case opsconstants.KubeActionGet, opsconstants.KubeActionDelete:
+		if k.Kind == "" {
+			return fmt.Errorf("kind is required by %s", k.Action)
+		}
+	case opsconstants.KubeActionPatch:
+		if k.Kind == "" || k.Name == "" || k.Patch == "" {
+			return fmt.Errorf("kind, name and patch are required by patch")
+		}
+		switch k.PatchType {
+		case "", opsconstants.KubePatchTypeMerge, opsconstants.KubePatchTypeJson, opsconstants.KubePatchTypeStrategic:
+		default:
+			return fmt.Errorf("patchType must be merge, json or strategic")
+		}
+	case opsconstants.KubeActionWait:
+		if k.Kind == "" {
+			return fmt.Errorf("kind is required by wait")
+		}
+		if k.Condition == "" && k.JsonPath == "" {
+			return fmt.Errorf("condition or jsonPath is required by wait")
+		}
+	case opsconstants.KubeActionRolloutRestart:
+		if k.Name == "" {
+			return fmt.Errorf("name is required by rollout-restart")
+		}
+		switch strings.ToLower(k.Kind) {
+		case "deployment", "statefulset", "daemonset":
+		default:
+			return fmt.Errorf("kind of rollout-restart must be Deployment, StatefulSet or DaemonSet")
+		}
+	case opsconstants.KubeActionCordon, opsconstants.KubeActionUncordon, opsconstants.KubeActionDrain:
+		if k.Name == "" {
+			return fmt.Errorf("name of node is required by %s", k.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action %s", k.Action)
+	}
+	return nil
+}
+
+// validate checks the request and the assertions, the fields with variables are checked when running
+func (h *HTTPStep) validate() error {
+	if h.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if h.Body != "" && len(h.JSON) > 0 {
+		return fmt.Errorf("body and json are exclusive")
+	}
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		return fmt.Errorf("clientCert and clientKey must be set together")
+	}
+	if !strings.Contains(h.ExpectStatus, "${") {
+		if _, err := h.IsExpectedStatus(0); err != nil {
+			return err
+		}
+	}
+	if h.ExpectBody != "" && !strings.Contains(h.ExpectBody, "${") {
+		if _, err := regexp.Compile(h.ExpectBody); err != nil {
+			return fmt.Errorf("invalid expectBody %s: %v", h.ExpectBody, err)
+		}
+	}
+	for name, path := range h.Extract {
+		if path == "" {
+			return fmt.Errorf("jsonPath of extract %s is required", name)
+		}
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ package v1
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
@@ -36,6 +38,28 @@ type TaskRunSpec struct {
 	Crontab   string            `json:"crontab,omitempty" yaml:"crontab,omitempty"`
 	Variables map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
 	TaskRef   string            `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
+	// Cancelled interrupts the running steps and skips the remaining ones
+	Cancelled bool `json:"cancelled,omitempty" yaml:"cancelled,omitempty"`
+	// Rollout overrides the one of task if set
+	Rollout `json:",inline" yaml:",inline"`
+}
+
+// GetRollout returns the rollout of task, overridden by the taskrun
+func (obj *TaskRun) GetRollout(t *Task) Rollout {
+	rollout := t.Spec.Rollout
+	if obj.Spec.Parallelism > 0 {
+		rollout.Parallelism = obj.Spec.Parallelism
+	}
+	if obj.Spec.BatchSize > 0 {
+		rollout.BatchSize = obj.Spec.BatchSize
+	}
+	if obj.Spec.MaxUnavailable != nil {
+		rollout.MaxUnavailable = obj.Spec.MaxUnavailable
+	}
+	if obj.Spec.FailureThreshold > 0 {
+		rollout.FailureThreshold = obj.Spec.FailureThreshold
+	}
+	return rollout
 }
 
 func (obj *TaskRun) MergeVariables(t *Task) {
@@ -47,6 +71,10 @@ func (obj *TaskRun) MergeVariables(t *Task) {
 			obj.Spec.Variables[k] = v.Value
 			continue
 		}
+		// the value of ref is read when running, not saved to the taskrun
+		if v.HasRef() {
+			continue
+		}
 		if _, ok := obj.Spec.Variables[k]; !ok {
 			obj.Spec.Variables[k] = v.GetValue()
 			continue
@@ -125,9 +153,16 @@ func NewTaskRunWithPipelineRun(pr *PipelineRun, t *Task, tRef TaskRef) *TaskRun
 		},
 		Spec: TaskRunSpec{
 			TaskRef:   t.ObjectMeta.GetName(),
-			Variables: pr.Spec.Variables,
+			Variables: make(map[string]string),
 		},
 	}
+	// taskRef variables > pipelinerun variables
+	for k, v := range pr.Spec.Variables {
+		tr.Spec.Variables[k] = v
+	}
+	for k, v := range tRef.Variables {
+		tr.Spec.Variables[k] = v
+	}
 	return tr
 }
 
@@ -138,39 +173,186 @@ type TaskRunStatus struct {
 	TaskRunNodeStatus map[string]*TaskRunNodeStatus `json:"taskrunNodeStatus,omitempty" yaml:"taskrunNodeStatus,omitempty"`
 	RunStatus         string                        `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
 	StartTime         *metav1.Time                  `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	Results           map[string]string             `json:"results,omitempty" yaml:"results,omitempty"`
+	Batches           []*TaskRunBatchStatus         `json:"batches,omitempty" yaml:"batches,omitempty"`
+	// HistoryRef is the id of the run in the history store, which keeps the full step outputs
+	HistoryRef string `json:"historyRef,omitempty" yaml:"historyRef,omitempty"`
+	// Message explains the run status, such as the results a pipeline task refers to are not found
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// TaskRunBatchStatus is the progress of a rollout batch
+type TaskRunBatchStatus struct {
+	Nodes     []string     `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	RunStatus string       `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	StartTime *metav1.Time `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	Successed int          `json:"successed,omitempty" yaml:"successed,omitempty"`
+	Failed    int          `json:"failed,omitempty" yaml:"failed,omitempty"`
 }
 
 type TaskRunNodeStatus struct {
-	NodeName    string         `json:"nodeName,omitempty" yaml:"nodeName,omitempty"`
-	TaskRunStep []*TaskRunStep `json:"taskRunStep,omitempty" yaml:"taskRunStep,omitempty"`
-	RunStatus   string         `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
-	StartTime   *metav1.Time   `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	NodeName    string            `json:"nodeName,omitempty" yaml:"nodeName,omitempty"`
+	TaskRunStep []*TaskRunStep    `json:"taskRunStep,omitempty" yaml:"taskRunStep,omitempty"`
+	RunStatus   string            `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	StartTime   *metav1.Time      `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	Results     map[string]string `json:"results,omitempty" yaml:"results,omitempty"`
 }
 
 type TaskRunStep struct {
 	StepName   string `json:"stepName,omitempty" yaml:"stepName,omitempty"`
 	StepOutput string `json:"stepOutput,omitempty" yaml:"stepOutput,omitempty"`
 	StepStatus string `json:"stepStatus,omitempty" yaml:"stepStatus,omitempty"`
+	// Attempt starts from 1, only set when the step has retries
+	Attempt    int `json:"attempt,omitempty" yaml:"attempt,omitempty"`
+	ExecResult `json:",inline" yaml:",inline"`
+}
+
+// ExecResult is the result of a command run on a host, locally or in a pod
+type ExecResult struct {
+	// ExitCode is empty if the command is not run or killed, eg: file steps, timeout
+	ExitCode  *int             `json:"exitCode,omitempty" yaml:"exitCode,omitempty"`
+	Stdout    string           `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr    string           `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	StartTime *metav1.Time     `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	EndTime   *metav1.Time     `json:"endTime,omitempty" yaml:"endTime,omitempty"`
+	Duration  *metav1.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// StatusCode is the response status of http steps
+	StatusCode int `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	// Values are extracted from the JSON response of http steps
+	Values map[string]string `json:"values,omitempty" yaml:"values,omitempty"`
+	// Changed is changed or unchanged, reported by ensure steps
+	Changed string `json:"changed,omitempty" yaml:"changed,omitempty"`
 }
 
-func (tr *TaskRunStatus) AddOutputStep(nodeName string, stepName, stepCmd, stepOutput, stepStatus string) {
+// SetExitCode sets the exit code of the command
+func (obj *ExecResult) SetExitCode(code int) {
+	obj.ExitCode = &code
+}
+
+// SetTime sets the start and end time of the command and the duration between them
+func (obj *ExecResult) SetTime(start, end time.Time) {
+	obj.StartTime = &metav1.Time{Time: start}
+	obj.EndTime = &metav1.Time{Time: end}
+	obj.Duration = &metav1.Duration{Duration: end.Sub(start)}
+}
+
+// IsSuccessed returns true if the command exits with 0
+func (obj *ExecResult) IsSuccessed() bool {
+	return obj != nil && obj.ExitCode != nil && *obj.ExitCode == 0
+}
+
+// GetOutput is the stdout, followed by the stderr if the command is failed
+func (obj *ExecResult) GetOutput() string {
+	if obj == nil {
+		return ""
+	}
+	output := strings.TrimRight(obj.Stdout, "\r\n")
+	if obj.IsSuccessed() {
+		return output
+	}
+	stderr := strings.TrimRight(obj.Stderr, "\r\n")
+	if output == "" {
+		return stderr
+	}
+	if stderr == "" {
+		return output
+	}
+	return output + "\n" + stderr
+}
+
+func (tr *TaskRunStatus) AddOutputStep(nodeName string, stepName, stepCmd, stepOutput, stepStatus string) *TaskRunStep {
 	if tr.TaskRunNodeStatus == nil {
 		tr.TaskRunNodeStatus = make(map[string]*TaskRunNodeStatus)
 	}
 	if _, ok := tr.TaskRunNodeStatus[nodeName]; !ok {
 		tr.TaskRunNodeStatus[nodeName] = &TaskRunNodeStatus{}
 	}
-	tr.TaskRunNodeStatus[nodeName].TaskRunStep = append(tr.TaskRunNodeStatus[nodeName].TaskRunStep, &TaskRunStep{
+	step := &TaskRunStep{
 		StepName:   stepName,
 		StepOutput: stepOutput,
 		StepStatus: stepStatus,
-	})
+	}
+	tr.TaskRunNodeStatus[nodeName].TaskRunStep = append(tr.TaskRunNodeStatus[nodeName].TaskRunStep, step)
 	tr.TaskRunNodeStatus[nodeName].StartTime = &metav1.Time{Time: time.Now()}
 	tr.TaskRunNodeStatus[nodeName].RunStatus = stepStatus
+	return step
+}
+
+// AddResult records a result of node, the task level result is the one of the first node by name,
+// so it doesn't depend on the order the nodes finish in
+func (tr *TaskRunStatus) AddResult(nodeName, key, value string) {
+	if tr.TaskRunNodeStatus == nil {
+		tr.TaskRunNodeStatus = make(map[string]*TaskRunNodeStatus)
+	}
+	if _, ok := tr.TaskRunNodeStatus[nodeName]; !ok {
+		tr.TaskRunNodeStatus[nodeName] = &TaskRunNodeStatus{}
+	}
+	if tr.TaskRunNodeStatus[nodeName].Results == nil {
+		tr.TaskRunNodeStatus[nodeName].Results = make(map[string]string)
+	}
+	tr.TaskRunNodeStatus[nodeName].Results[key] = value
+	tr.mergeResults()
+}
+
+// MergeNodeStatus copies the node status and results of other, which runs on other nodes
+func (tr *TaskRunStatus) MergeNodeStatus(other *TaskRunStatus) {
+	for nodeName, nodeStatus := range other.TaskRunNodeStatus {
+		if tr.TaskRunNodeStatus == nil {
+			tr.TaskRunNodeStatus = make(map[string]*TaskRunNodeStatus)
+		}
+		tr.TaskRunNodeStatus[nodeName] = nodeStatus
+	}
+	tr.mergeResults()
+}
+
+// mergeResults sets the task level results, the result of the first node by name wins
+func (tr *TaskRunStatus) mergeResults() {
+	nodeNames := make([]string, 0, len(tr.TaskRunNodeStatus))
+	for nodeName := range tr.TaskRunNodeStatus {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(nodeNames)))
+	for _, nodeName := range nodeNames {
+		for k, v := range tr.TaskRunNodeStatus[nodeName].Results {
+			if tr.Results == nil {
+				tr.Results = make(map[string]string)
+			}
+			tr.Results[k] = v
+		}
+	}
+}
+
+// GetNodeRunStatus returns the run status of node, a node whose steps are all skipped by when is successed
+func (tr *TaskRunStatus) GetNodeRunStatus(nodeName string) string {
+	if nodeStatus, ok := tr.TaskRunNodeStatus[nodeName]; ok && nodeStatus.RunStatus != "" && nodeStatus.RunStatus != opsconstants.StatusSkipped {
+		return nodeStatus.RunStatus
+	}
+	return opsconstants.StatusSuccessed
 }
 
 func (tr *TaskRunStatus) ClearNodeStatus() {
 	tr.TaskRunNodeStatus = nil
+	tr.Results = nil
+	tr.Batches = nil
+	tr.HistoryRef = ""
+}
+
+// TruncateOutputs keeps the last max bytes of every step output, stdout and stderr
+func (tr *TaskRunStatus) TruncateOutputs(max int) {
+	for _, nodeStatus := range tr.TaskRunNodeStatus {
+		for _, step := range nodeStatus.TaskRunStep {
+			step.StepOutput = truncateOutput(step.StepOutput, max)
+			step.Stdout = truncateOutput(step.Stdout, max)
+			step.Stderr = truncateOutput(step.Stderr, max)
+		}
+	}
+}
+
+func truncateOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	return fmt.Sprintf("...(truncated %d bytes)\n", len(output)-max) + output[len(output)-max:]
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+func TestTaskRunStatusAddResult(t *testing.T) {
+	orders := [][]string{{"node-a", "node-b", "node-c"}, {"node-c", "node-b", "node-a"}, {"node-b", "node-c", "node-a"}}
+	for _, order := range orders {
+		tr := &TaskRunStatus{}
+		for _, nodeName := range order {
+			tr.AddResult(nodeName, "version", nodeName)
+			if nodeName != "node-a" {
+				tr.AddResult(nodeName, "only", nodeName)
+			}
+		}
+		want := map[string]string{"version": "node-a", "only": "node-b"}
+		if !reflect.DeepEqual(tr.Results, want) {
+			t.Errorf("order %v: Results = %v, want %v", order, tr.Results, want)
+		}
+		if got := tr.TaskRunNodeStatus["node-c"].Results["version"]; got != "node-c" {
+			t.Errorf("order %v: node-c version = %s, want node-c", order, got)
+		}
+	}
+}
+
+func TestTaskRunStatusMergeNodeStatus(t *testing.T) {
+	tr := &TaskRunStatus{}
+	tr.AddResult("node-b", "version", "b")
+	other := &TaskRunStatus{}
+	other.AddResult("node-a", "version", "a")
+	tr.MergeNodeStatus(other)
+	if tr.Results["version"] != "a" {
+		t.Errorf("Results[version] = %s, want a", tr.Results["version"])
+	}
+	if len(tr.TaskRunNodeStatus) != 2 {
+		t.Errorf("len(TaskRunNodeStatus) = %d, want 2", len(tr.TaskRunNodeStatus))
+	}
+}
+
+func TestResultValidateType(t *testing.T) {
+	tests := []struct {
+		typ     string
+		value   string
+		wantErr bool
+	}{
+		{typ: "", value: "anything"},
+		{typ: "int", value: "42"},
+		{typ: "int", value: "4.2", wantErr: true},
+		{typ: "bool", value: "true"},
+		{typ: "bool", value: "yes please", wantErr: true},
+		{typ: "duration", value: "1m30s"},
+		{typ: "duration", value: "soon", wantErr: true},
+		{typ: "list", value: `["a","b"]`},
+		{typ: "map", value: `{"a":"b"}`},
+		{typ: "json", value: `{"a":[1,2]}`},
+		{typ: "json", value: `{"a":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := Result{Name: "r", Type: tt.typ}.ValidateType(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: err = %v, wantErr %v", tt.typ, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTaskRunStatusGetNodeRunStatus(t *testing.T) {
+	tr := &TaskRunStatus{}
+	tr.AddOutputStep("failed", "step", "false", "", opsconstants.StatusFailed)
+	tr.AddOutputStep("successed", "step", "true", "", opsconstants.StatusSuccessed)
+	tr.AddOutputStep("skipped", "step", "true", "", opsconstants.StatusSkipped)
+	tests := map[string]string{
+		"failed":    opsconstants.StatusFailed,
+		"successed": opsconstants.StatusSuccessed,
+		"skipped":   opsconstants.StatusSuccessed,
+		// all steps are skipped by when
+		"not run": opsconstants.StatusSuccessed,
+	}
+	for nodeName, want := range tests {
+		if got := tr.GetNodeRunStatus(nodeName); got != want {
+			t.Errorf("%s: GetNodeRunStatus() = %s, want %s", nodeName, got, want)
+		}
+	}
+}
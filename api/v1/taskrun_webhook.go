@@ -0,0 +1,108 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var taskrunlog = logf.Log.WithName("taskrun-resource")
+
+func (obj *TaskRun) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-taskrun,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=taskruns,verbs=create;update,versions=v1,name=mtaskrun.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &TaskRun{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *TaskRun) Default() {
+	taskrunlog.Info("default", "name", obj.Name)
+	if obj.Spec.TaskRef == "" {
+		return
+	}
+	if obj.Labels == nil {
+		obj.Labels = make(map[string]string)
+	}
+	if _, ok := obj.Labels[opsconstants.LabelTaskRefKey]; !ok {
+		obj.Labels[opsconstants.LabelTaskRefKey] = obj.Spec.TaskRef
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-taskrun,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=taskruns,verbs=create;update,versions=v1,name=vtaskrun.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &TaskRun{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *TaskRun) ValidateCreate() error {
+	taskrunlog.Info("validate create", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	return obj.validateTaskRef()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type,
+// the task is only checked when changed, the running one can be cancelled after the task deleted
+func (obj *TaskRun) ValidateUpdate(old runtime.Object) error {
+	taskrunlog.Info("validate update", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	if oldTr, ok := old.(*TaskRun); ok && oldTr.Spec.TaskRef == obj.Spec.TaskRef {
+		return nil
+	}
+	return obj.validateTaskRef()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *TaskRun) ValidateDelete() error {
+	return nil
+}
+
+func (obj *TaskRun) validate() error {
+	if obj.Spec.TaskRef == "" {
+		return fmt.Errorf("taskRef is required")
+	}
+	if err := validateCrontab(obj.Spec.Crontab); err != nil {
+		return err
+	}
+	return validateRollout(obj.Spec.Rollout)
+}
+
+// validateTaskRef checks the task exists and the variables run with
+func (obj *TaskRun) validateTaskRef() error {
+	t := &Task{}
+	if err := getWebhookObject(obj.Namespace, obj.Spec.TaskRef, t); err != nil {
+		return fmt.Errorf("get task %s: %v", obj.Spec.TaskRef, err)
+	}
+	if err := validateHostSelector(obj.GetHost(t)); err != nil {
+		return err
+	}
+	return validateVariableValues(t.Spec.Variables, obj.Spec.Variables)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// TriggerSpec defines the desired state of Trigger
+type TriggerSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+	Desc string `json:"desc,omitempty" yaml:"desc,omitempty"`
+	// Subject is the event subject pattern to subscribe, supports * and >
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	// Type filters the CloudEvent type, such as TaskRunReport
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Extensions filters the CloudEvent extensions, such as cluster
+	Extensions map[string]string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	// Filters filters the payload fields, nested fields are joined by dot
+	Filters map[string]string `json:"filters,omitempty" yaml:"filters,omitempty"`
+	// Variables of the PipelineRun, payload fields are referenced by ${field}
+	Variables   map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+	PipelineRef string            `json:"pipelineRef,omitempty" yaml:"pipelineRef,omitempty"`
+	// RateLimit is the max PipelineRuns created in RateLimitSeconds, 0 is unlimited
+	RateLimit        int `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+	RateLimitSeconds int `json:"rateLimitSeconds,omitempty" yaml:"rateLimitSeconds,omitempty"`
+	// DedupSeconds drops events with the same dedup key in the window, 0 is disabled
+	DedupSeconds int `json:"dedupSeconds,omitempty" yaml:"dedupSeconds,omitempty"`
+	// DedupKeys are the variables to build dedup key, default all variables
+	DedupKeys []string `json:"dedupKeys,omitempty" yaml:"dedupKeys,omitempty"`
+}
+
+// TriggerStatus defines the observed state of Trigger
+type TriggerStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+	TriggeredCount  int64        `json:"triggeredCount,omitempty" yaml:"triggeredCount,omitempty"`
+	DroppedCount    int64        `json:"droppedCount,omitempty" yaml:"droppedCount,omitempty"`
+	LastPipelineRun string       `json:"lastPipelineRun,omitempty" yaml:"lastPipelineRun,omitempty"`
+	LastTriggerTime *metav1.Time `json:"lastTriggerTime,omitempty" yaml:"lastTriggerTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Subject",type=string,JSONPath=`.spec.subject`
+// +kubebuilder:printcolumn:name="PipelineRef",type=string,JSONPath=`.spec.pipelineRef`
+// +kubebuilder:printcolumn:name="Triggered",type=integer,JSONPath=`.status.triggeredCount`
+// +kubebuilder:printcolumn:name="LastTriggerTime",type=date,JSONPath=`.status.lastTriggerTime`
+// Trigger is the Schema for the triggers API
+type Trigger struct {
+	metav1.TypeMeta   `json:",inline" yaml:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+
+	Spec   TriggerSpec   `json:"spec,omitempty" yaml:"spec,omitempty"`
+	Status TriggerStatus `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+func (obj *Trigger) GetUniqueKey() string {
+	return types.NamespacedName{
+		Namespace: obj.Namespace,
+		Name:      obj.Name,
+	}.String()
+}
+
+func (obj *Trigger) GetRateLimitDuration() time.Duration {
+	if obj.Spec.RateLimitSeconds > 0 {
+		return time.Duration(obj.Spec.RateLimitSeconds) * time.Second
+	}
+	return time.Minute
+}
+
+// GetDedupKey builds the dedup key from the rendered variables
+func (obj *Trigger) GetDedupKey(vars map[string]string) string {
+	keys := obj.Spec.DedupKeys
+	if len(keys) == 0 {
+		for k := range vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, k+"="+vars[k])
+	}
+	return strings.Join(values, ",")
+}
+
+// NewPipelineRunWithTrigger builds a PipelineRun of p with variables, variables override the pipeline defaults
+func NewPipelineRunWithTrigger(tg *Trigger, p *Pipeline, vars map[string]string) *PipelineRun {
+	pr := NewPipelineRun(p)
+	pr.Spec.Desc = tg.Spec.Desc
+	for k, v := range vars {
+		pr.Spec.Variables[k] = v
+	}
+	pr.Labels = map[string]string{
+		opsconstants.LabelTriggerKey: tg.Name,
+	}
+	return pr
+}
+
+//+kubebuilder:object:root=true
+
+// TriggerList contains a list of Trigger
+type TriggerList struct {
+	metav1.TypeMeta `json:",inline" yaml:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Items           []Trigger `json:"items" yaml:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Trigger{}, &TriggerList{})
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var triggerlog = logf.Log.WithName("trigger-resource")
+
+func (obj *Trigger) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	setWebhookReader(mgr.GetAPIReader())
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-crd-chenshaowen-com-v1-trigger,mutating=true,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=triggers,verbs=create;update,versions=v1,name=mtrigger.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Trigger{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (obj *Trigger) Default() {
+	triggerlog.Info("default", "name", obj.Name)
+	if obj.Spec.RateLimit > 0 && obj.Spec.RateLimitSeconds == 0 {
+		obj.Spec.RateLimitSeconds = int(obj.GetRateLimitDuration().Seconds())
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-crd-chenshaowen-com-v1-trigger,mutating=false,failurePolicy=fail,sideEffects=None,groups=crd.chenshaowen.com,resources=triggers,verbs=create;update,versions=v1,name=vtrigger.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Trigger{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Trigger) ValidateCreate() error {
+	triggerlog.Info("validate create", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	return obj.validatePipelineRef()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (obj *Trigger) ValidateUpdate(old runtime.Object) error {
+	triggerlog.Info("validate update", "name", obj.Name)
+	if err := obj.validate(); err != nil {
+		return err
+	}
+	if oldTg, ok := old.(*Trigger); ok && oldTg.Spec.PipelineRef == obj.Spec.PipelineRef {
+		return nil
+	}
+	return obj.validatePipelineRef()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (obj *Trigger) ValidateDelete() error {
+	return nil
+}
+
+func (obj *Trigger) validate() error {
+	if obj.Spec.Subject == "" {
+		return fmt.Errorf("subject is required")
+	}
+	if obj.Spec.PipelineRef == "" {
+		return fmt.Errorf("pipelineRef is required")
+	}
+	if obj.Spec.RateLimit < 0 || obj.Spec.RateLimitSeconds < 0 || obj.Spec.DedupSeconds < 0 {
+		return fmt.Errorf("rateLimit, rateLimitSeconds and dedupSeconds must not be negative")
+	}
+	return nil
+}
+
+func (obj *Trigger) validatePipelineRef() error {
+	p := &Pipeline{}
+	if err := getWebhookObject(obj.Namespace, obj.Spec.PipelineRef, p); err != nil {
+		return fmt.Errorf("get pipeline %s: %v", obj.Spec.PipelineRef, err)
+	}
+	return nil
+}
@@ -16,10 +16,22 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 )
 
 type Variable struct {
+	// Type of the value, default string, a list is comma separated or a JSON array,
+	// a map is k1=v1,k2=v2 or a JSON object
+	// +kubebuilder:validation:Enum=string;int;bool;duration;list;map;json
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Default may refer to other variables, such as ${namespace}-backup
 	Default  string   `json:"default,omitempty" yaml:"default,omitempty"`
 	Display  string   `json:"display,omitempty" yaml:"display,omitempty"`
 	Value    string   `json:"value,omitempty" yaml:"value,omitempty"`
@@ -28,6 +40,77 @@ type Variable struct {
 	Required bool     `json:"required,omitempty" yaml:"required,omitempty"`
 	Enums    []string `json:"enums,omitempty" yaml:"enums,omitempty"`
 	Examples []string `json:"examples,omitempty" yaml:"examples,omitempty"`
+	// SecretRef reads the value from a Secret in the namespace of the task when running, the value is sensitive
+	SecretRef *VariableRef `json:"secretRef,omitempty" yaml:"secretRef,omitempty"`
+	// ConfigMapRef reads the value from a ConfigMap in the namespace of the task when running
+	ConfigMapRef *VariableRef `json:"configMapRef,omitempty" yaml:"configMapRef,omitempty"`
+	// Sensitive masks the value in the status and logs
+	Sensitive bool `json:"sensitive,omitempty" yaml:"sensitive,omitempty"`
+}
+
+// VariableRef is a key of a Secret or ConfigMap
+type VariableRef struct {
+	Name string `json:"name" yaml:"name"`
+	Key  string `json:"key" yaml:"key"`
+}
+
+// HasRef checks the value is read from a Secret or ConfigMap
+func (v Variable) HasRef() bool {
+	return v.SecretRef != nil || v.ConfigMapRef != nil
+}
+
+func (v Variable) IsSensitive() bool {
+	return v.Sensitive || v.SecretRef != nil
+}
+
+func isVariableType(t string) bool {
+	switch t {
+	case "", opsconstants.VariableTypeString, opsconstants.VariableTypeInt, opsconstants.VariableTypeBool, opsconstants.VariableTypeDuration,
+		opsconstants.VariableTypeList, opsconstants.VariableTypeMap, opsconstants.VariableTypeJson:
+		return true
+	}
+	return false
+}
+
+// ValidateType checks the value is the type of variable
+func (v Variable) ValidateType(value string) error {
+	if !isVariableType(v.Type) {
+		return fmt.Errorf("unknown type %s", v.Type)
+	}
+	var err error
+	switch v.Type {
+	case opsconstants.VariableTypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case opsconstants.VariableTypeBool:
+		_, err = strconv.ParseBool(value)
+	case opsconstants.VariableTypeDuration:
+		_, err = time.ParseDuration(value)
+	case opsconstants.VariableTypeList:
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var list []interface{}
+			err = json.Unmarshal([]byte(value), &list)
+		}
+	case opsconstants.VariableTypeMap:
+		if strings.HasPrefix(strings.TrimSpace(value), "{") {
+			var m map[string]interface{}
+			err = json.Unmarshal([]byte(value), &m)
+			break
+		}
+		for _, item := range strings.Split(value, ",") {
+			if !strings.Contains(item, "=") {
+				err = fmt.Errorf("%s is not k=v", item)
+				break
+			}
+		}
+	case opsconstants.VariableTypeJson:
+		if !json.Valid([]byte(value)) {
+			err = fmt.Errorf("invalid json")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not a %s", value, v.Type)
+	}
+	return nil
 }
 
 func (v Variable) GetValue() string {
@@ -62,6 +145,18 @@ func (v Variable) MergeLowPriorityVariable(others Variable) Variable {
 	if len(v.Examples) == 0 {
 		v.Examples = others.Examples
 	}
+	if v.Type == "" {
+		v.Type = others.Type
+	}
+	if v.SecretRef == nil {
+		v.SecretRef = others.SecretRef
+	}
+	if v.ConfigMapRef == nil {
+		v.ConfigMapRef = others.ConfigMapRef
+	}
+	if v.Sensitive == false {
+		v.Sensitive = others.Sensitive
+	}
 	return v
 }
 
@@ -90,6 +185,18 @@ func (v Variable) MergeHighPriorityVariable(others Variable) Variable {
 	if len(others.Examples) > 0 {
 		v.Examples = others.Examples
 	}
+	if others.Type != "" {
+		v.Type = others.Type
+	}
+	if others.SecretRef != nil {
+		v.SecretRef = others.SecretRef
+	}
+	if others.ConfigMapRef != nil {
+		v.ConfigMapRef = others.ConfigMapRef
+	}
+	if others.Sensitive != false {
+		v.Sensitive = others.Sensitive
+	}
 	return v
 }
 
@@ -98,6 +205,10 @@ func (v *Variable) Validate() bool {
 	if v.Required && v.Value == "" {
 		return false
 	}
+	//validate type
+	if v.Value != "" && v.ValidateType(v.Value) != nil {
+		return false
+	}
 	//validate enum
 	if len(v.Enums) > 0 {
 		found := false
@@ -124,6 +235,33 @@ func (v *Variable) Validate() bool {
 	return true
 }
 
+// ValidateValue checks value of the variable named key, the value with ${} is rendered at run time and skipped,
+// so is the empty value read from the ref
+func (v Variable) ValidateValue(key, value string) error {
+	if strings.Contains(value, "${") || (value == "" && (!v.Required || v.HasRef())) {
+		return nil
+	}
+	v.Value = value
+	if v.Validate() {
+		return nil
+	}
+	if value == "" {
+		return fmt.Errorf("variable %s is required", key)
+	}
+	if err := v.ValidateType(value); err != nil {
+		return fmt.Errorf("variable %s: %v", key, err)
+	}
+	for _, e := range v.Enums {
+		if value == e {
+			return fmt.Errorf("variable %s: %s does not match %s", key, value, v.Regex)
+		}
+	}
+	if len(v.Enums) > 0 {
+		return fmt.Errorf("variable %s: %s is not one of %s", key, value, strings.Join(v.Enums, ", "))
+	}
+	return fmt.Errorf("variable %s: %s does not match %s", key, value, v.Regex)
+}
+
 type Variables map[string]Variable
 
 func (objs Variables) MergeLowPriorityVariables(others Variables) Variables {
@@ -148,6 +286,21 @@ func (objs Variables) MergeHighPriorityVariables(others Variables) Variables {
 	return objs
 }
 
+// MaskSensitive returns a copy of values, the sensitive ones are masked
+func (objs Variables) MaskSensitive(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		if objs[k].IsSensitive() && v != "" {
+			v = opsconstants.MaskedValue
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
 func (objs Variables) GetVariables() (result map[string]string) {
 	result = make(map[string]string)
 	for k, v := range objs {
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	cron "github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// webhookReader reads the referenced objects without cache, which may lag behind the objects just applied
+var webhookReader client.Reader
+
+func setWebhookReader(reader client.Reader) {
+	if webhookReader == nil {
+		webhookReader = reader
+	}
+}
+
+// getWebhookObject gets the referenced object in namespace
+func getWebhookObject(namespace, name string, obj client.Object) error {
+	if webhookReader == nil {
+		return fmt.Errorf("webhook client is not ready")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return webhookReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, obj)
+}
+
+// validateCrontab checks the standard cron spec used by the controllers
+func validateCrontab(crontab string) error {
+	if crontab == "" {
+		return nil
+	}
+	_, err := cron.ParseStandard(crontab)
+	if err != nil {
+		return fmt.Errorf("invalid crontab %s: %v", crontab, err)
+	}
+	return nil
+}
+
+// validateHostSelector checks the host is a name or a label selector, eg: az=cn-hangzhou
+func validateHostSelector(host string) error {
+	if !strings.Contains(host, "=") {
+		return nil
+	}
+	selector, err := metav1.ParseToLabelSelector(host)
+	if err != nil {
+		return fmt.Errorf("invalid host selector %s: %v", host, err)
+	}
+	_, err = metav1.LabelSelectorAsMap(selector)
+	if err != nil {
+		return fmt.Errorf("invalid host selector %s: %v", host, err)
+	}
+	return nil
+}
+
+func validateRollout(r Rollout) error {
+	if r.Parallelism < 0 || r.BatchSize < 0 || r.FailureThreshold < 0 {
+		return fmt.Errorf("parallelism, batchSize and failureThreshold must not be negative")
+	}
+	if r.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(r.MaxUnavailable, 100, true)
+		if err != nil || maxUnavailable < 1 {
+			return fmt.Errorf("maxUnavailable %s must be a positive number or percentage", r.MaxUnavailable.String())
+		}
+	}
+	return nil
+}
+
+// validateVariableDefinitions checks the regex compiles and the default is valid
+func validateVariableDefinitions(vars Variables) error {
+	for _, k := range sortedVariableKeys(vars) {
+		v := vars[k]
+		if v.Regex != "" {
+			if _, err := regexp.Compile(v.Regex); err != nil {
+				return fmt.Errorf("variable %s: invalid regex %s: %v", k, v.Regex, err)
+			}
+		}
+		if err := v.validateRefs(); err != nil {
+			return fmt.Errorf("variable %s: %v", k, err)
+		}
+		if v.GetValue() == "" {
+			continue
+		}
+		if err := v.ValidateValue(k, v.GetValue()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRefs checks the type is known, and the value is read from one ref at most
+func (v Variable) validateRefs() error {
+	if !isVariableType(v.Type) {
+		return fmt.Errorf("unknown type %s", v.Type)
+	}
+	if v.SecretRef != nil && v.ConfigMapRef != nil {
+		return fmt.Errorf("secretRef and configMapRef are exclusive")
+	}
+	if !v.HasRef() {
+		return nil
+	}
+	if v.GetValue() != "" {
+		return fmt.Errorf("value and default are not allowed with secretRef or configMapRef")
+	}
+	for _, ref := range []*VariableRef{v.SecretRef, v.ConfigMapRef} {
+		if ref != nil && (ref.Name == "" || ref.Key == "") {
+			return fmt.Errorf("name and key of ref are required")
+		}
+	}
+	return nil
+}
+
+// validateVariableValues checks the values run with against the definitions,
+// a fixed value of definition overrides the submitted one, then the default is used
+func validateVariableValues(vars Variables, values map[string]string) error {
+	for _, k := range sortedVariableKeys(vars) {
+		v := vars[k]
+		value := v.Value
+		if value == "" {
+			if submitted, ok := values[k]; ok {
+				value = submitted
+			} else {
+				value = v.Default
+			}
+		}
+		if err := v.ValidateValue(k, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedVariableKeys(vars Variables) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
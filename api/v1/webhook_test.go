@@ -0,0 +1,301 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeWebhookReader gets the tasks and pipelines by namespace/name
+type fakeWebhookReader struct {
+	tasks     map[string]*Task
+	pipelines map[string]*Pipeline
+}
+
+func (r *fakeWebhookReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	switch o := obj.(type) {
+	case *Task:
+		if t, ok := r.tasks[key.String()]; ok {
+			t.DeepCopyInto(o)
+			return nil
+		}
+		return apierrors.NewNotFound(GroupVersion.WithResource("tasks").GroupResource(), key.Name)
+	case *Pipeline:
+		if p, ok := r.pipelines[key.String()]; ok {
+			p.DeepCopyInto(o)
+			return nil
+		}
+		return apierrors.NewNotFound(GroupVersion.WithResource("pipelines").GroupResource(), key.Name)
+	}
+	return fmt.Errorf("unexpected object %T", obj)
+}
+
+func (r *fakeWebhookReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return fmt.Errorf("unexpected list %T", list)
+}
+
+// useWebhookReader replaces the webhook reader until the test finished
+func useWebhookReader(t *testing.T, reader client.Reader) {
+	old := webhookReader
+	webhookReader = reader
+	t.Cleanup(func() {
+		webhookReader = old
+	})
+}
+
+// checkErr checks err contains want, an empty want is no error
+func checkErr(t *testing.T, name string, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("%s: error = %v, want containing %q", name, err, want)
+	}
+}
+
+func TestValidateCrontab(t *testing.T) {
+	cases := []struct {
+		crontab string
+		wantErr string
+	}{
+		{"", ""},
+		{"*/5 * * * *", ""},
+		{"@every 1h", ""},
+		{"* * *", "invalid crontab"},
+		{"61 * * * *", "invalid crontab"},
+	}
+	for _, c := range cases {
+		checkErr(t, c.crontab, validateCrontab(c.crontab), c.wantErr)
+	}
+}
+
+func TestValidateHostSelector(t *testing.T) {
+	cases := []struct {
+		host    string
+		wantErr string
+	}{
+		{"", ""},
+		{"node1", ""},
+		{"az=cn-hangzhou", ""},
+		{"az=cn-hangzhou,role=db", ""},
+		{"az!=cn-hangzhou", "invalid host selector"},
+		{"az=cn hangzhou", "invalid host selector"},
+	}
+	for _, c := range cases {
+		checkErr(t, c.host, validateHostSelector(c.host), c.wantErr)
+	}
+}
+
+func TestValidateRollout(t *testing.T) {
+	maxUnavailable := func(v intstr.IntOrString) *intstr.IntOrString {
+		return &v
+	}
+	cases := []struct {
+		name    string
+		rollout Rollout
+		wantErr string
+	}{
+		{"empty", Rollout{}, ""},
+		{"batched", Rollout{Parallelism: 2, BatchSize: 3, FailureThreshold: 1}, ""},
+		{"negative", Rollout{BatchSize: -1}, "must not be negative"},
+		{"percent", Rollout{MaxUnavailable: maxUnavailable(intstr.FromString("25%"))}, ""},
+		{"number", Rollout{MaxUnavailable: maxUnavailable(intstr.FromInt(2))}, ""},
+		{"zero", Rollout{MaxUnavailable: maxUnavailable(intstr.FromInt(0))}, "maxUnavailable 0"},
+		{"zero percent", Rollout{MaxUnavailable: maxUnavailable(intstr.FromString("0%"))}, "maxUnavailable 0%"},
+		{"not percent", Rollout{MaxUnavailable: maxUnavailable(intstr.FromString("half"))}, "maxUnavailable half"},
+	}
+	for _, c := range cases {
+		checkErr(t, c.name, validateRollout(c.rollout), c.wantErr)
+	}
+}
+
+func TestValidateVariableDefinitions(t *testing.T) {
+	ref := &VariableRef{Name: "db", Key: "password"}
+	cases := []struct {
+		name    string
+		vars    Variables
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"valid", Variables{
+			"image":    {Default: "v1", Regex: `^v[0-9]+$`},
+			"replicas": {Type: "int", Default: "3"},
+			"level":    {Default: "info", Enums: []string{"debug", "info"}},
+			"password": {SecretRef: ref},
+			"backup":   {Default: "${namespace}-backup", Regex: `^[a-z-]+$`},
+		}, ""},
+		{"invalid regex", Variables{"image": {Regex: "v[0-9"}}, "variable image: invalid regex"},
+		{"unknown type", Variables{"ratio": {Type: "float"}}, "variable ratio: unknown type float"},
+		{"two refs", Variables{"password": {SecretRef: ref, ConfigMapRef: ref}}, "secretRef and configMapRef are exclusive"},
+		{"ref with default", Variables{"password": {SecretRef: ref, Default: "123"}}, "value and default are not allowed"},
+		{"ref without key", Variables{"password": {ConfigMapRef: &VariableRef{Name: "db"}}}, "name and key of ref are required"},
+		{"default not in enums", Variables{"level": {Default: "trace", Enums: []string{"debug", "info"}}}, "variable level: trace is not one of debug, info"},
+		{"default not match regex", Variables{"image": {Default: "latest", Regex: `^v[0-9]+$`}}, "variable image: latest does not match"},
+		{"default not type", Variables{"replicas": {Type: "int", Default: "three"}}, "variable replicas: three is not a int"},
+		{"value not type", Variables{"enabled": {Type: "bool", Value: "yes"}}, "variable enabled: yes is not a bool"},
+	}
+	for _, c := range cases {
+		checkErr(t, c.name, validateVariableDefinitions(c.vars), c.wantErr)
+	}
+}
+
+func TestValidateVariableValues(t *testing.T) {
+	vars := Variables{
+		"name":     {Required: true},
+		"level":    {Default: "info", Enums: []string{"debug", "info"}},
+		"replicas": {Type: "int", Default: "1"},
+		"image":    {Regex: `^v[0-9]+$`},
+		"mode":     {Value: "fixed", Enums: []string{"fixed"}},
+		"password": {Required: true, SecretRef: &VariableRef{Name: "db", Key: "password"}},
+	}
+	cases := []struct {
+		name    string
+		values  map[string]string
+		wantErr string
+	}{
+		{"defaults", map[string]string{"name": "a"}, ""},
+		{"submitted", map[string]string{"name": "a", "level": "debug", "replicas": "2", "image": "v2"}, ""},
+		{"reference", map[string]string{"name": "${host}", "replicas": "${count}"}, ""},
+		{"fixed value", map[string]string{"name": "a", "mode": "other"}, ""},
+		{"required", map[string]string{}, "variable name is required"},
+		{"enum", map[string]string{"name": "a", "level": "trace"}, "variable level: trace is not one of debug, info"},
+		{"type", map[string]string{"name": "a", "replicas": "two"}, "variable replicas: two is not a int"},
+		{"regex", map[string]string{"name": "a", "image": "latest"}, "variable image: latest does not match"},
+	}
+	for _, c := range cases {
+		checkErr(t, c.name, validateVariableValues(vars, c.values), c.wantErr)
+	}
+}
+
+func TestTaskValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    TaskSpec
+		wantErr string
+	}{
+		{"content", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname -a", Env: map[string]string{"LANG": "C"}}}}, ""},
+		{"file", TaskSpec{Steps: []Step{{Name: "s1", LocalFile: "/tmp/a", RemoteFile: "/tmp/a", Direction: "upload"}}}, ""},
+		{"host selector", TaskSpec{Host: "az!=a", Steps: []Step{{Content: "uname"}}}, "invalid host selector"},
+		{"rollout", TaskSpec{Rollout: Rollout{Parallelism: -1}, Steps: []Step{{Content: "uname"}}}, "must not be negative"},
+		{"variables", TaskSpec{Variables: Variables{"a": {Regex: "["}}}, "variable a: invalid regex"},
+		{"fact name", TaskSpec{Facts: map[string]string{"os-release": "cat /etc/os-release"}}, "invalid fact name os-release"},
+		{"fact script", TaskSpec{Facts: map[string]string{"kernel": " "}}, "fact kernel: script is required"},
+		{"content and file", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", LocalFile: "/tmp/a", Direction: "upload"}}}, "step 1 s1: content, localfile/remotefile, kube, http, ensure and uses are exclusive"},
+		{"content and uses", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", Uses: "other"}}}, "are exclusive"},
+		{"empty step", TaskSpec{Steps: []Step{{Name: "s1"}}}, "step 1 s1: content, localfile/remotefile, kube, http, ensure or uses is required"},
+		{"file direction", TaskSpec{Steps: []Step{{Name: "s1", LocalFile: "/tmp/a", RemoteFile: "/tmp/a"}}}, "direction must be upload or download"},
+		{"with without uses", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", With: map[string]string{"a": "b"}}}}, "with is only for uses"},
+		{"env for file", TaskSpec{Steps: []Step{{Name: "s1", RemoteFile: "/tmp/a", Direction: "download", Env: map[string]string{"A": "b"}}}}, "executor, env and workdir are only for content"},
+		{"env name", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", Env: map[string]string{"1A": "b"}}}}, "invalid env name 1A"},
+		{"template", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", Template: "jinja"}}}, "invalid template jinja"},
+		{"retries", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname", Retries: -1}}}, "must not be negative"},
+		{"uses", TaskSpec{Steps: []Step{{Name: "prepare", Uses: "ops-system/prepare", With: map[string]string{"a": "b"}}}}, ""},
+		{"uses without name", TaskSpec{Steps: []Step{{Uses: "prepare"}}}, "name of uses is required"},
+		{"uses name", TaskSpec{Steps: []Step{{Name: "prepare", Uses: "a/b/c"}}}, "invalid uses a/b/c"},
+		{"uses fields", TaskSpec{Steps: []Step{{Name: "prepare", Uses: "prepare", Retries: 1}}}, "only name, when, allowfailure and with are allowed with uses"},
+		{"uses unique", TaskSpec{Steps: []Step{{Name: "prepare", Content: "uname"}, {Name: "prepare", Uses: "prepare"}}}, "step 2 prepare: the name of uses must be unique"},
+		{"result", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname"}}, Results: []Result{{Name: "os", Step: "s1", Regex: `(\w+)`}}}, ""},
+		{"result of uses", TaskSpec{Steps: []Step{{Name: "prepare", Uses: "prepare"}}, Results: []Result{{Name: "os", Step: "prepare.s1"}}}, ""},
+		{"result name", TaskSpec{Results: []Result{{Step: "s1"}}}, "result name is required"},
+		{"result step", TaskSpec{Steps: []Step{{Name: "s1", Content: "uname"}}, Results: []Result{{Name: "os", Step: "s2"}}}, "result os: unknown step s2"},
+		{"result type", TaskSpec{Results: []Result{{Name: "os", Type: "float"}}}, "result os: unknown type float"},
+		{"result regex", TaskSpec{Results: []Result{{Name: "os", Regex: "("}}}, "result os: invalid regex"},
+	}
+	for _, c := range cases {
+		task := &Task{Spec: c.spec}
+		checkErr(t, c.name, task.validate(), c.wantErr)
+	}
+}
+
+func TestTaskRunValidateCreate(t *testing.T) {
+	useWebhookReader(t, &fakeWebhookReader{tasks: map[string]*Task{
+		"ops-system/uname": {Spec: TaskSpec{
+			Variables: Variables{"level": {Default: "info", Enums: []string{"debug", "info"}}},
+			Steps:     []Step{{Content: "uname"}},
+		}},
+		"ops-system/selector": {Spec: TaskSpec{Host: "az!=a", Steps: []Step{{Content: "uname"}}}},
+	}})
+	cases := []struct {
+		name    string
+		spec    TaskRunSpec
+		wantErr string
+	}{
+		{"valid", TaskRunSpec{TaskRef: "uname", Variables: map[string]string{"level": "debug"}}, ""},
+		{"no task ref", TaskRunSpec{}, "taskRef is required"},
+		{"crontab", TaskRunSpec{TaskRef: "uname", Crontab: "bad"}, "invalid crontab bad"},
+		{"missing task", TaskRunSpec{TaskRef: "missing"}, "get task missing"},
+		{"task host", TaskRunSpec{TaskRef: "selector"}, "invalid host selector az!=a"},
+		{"run host", TaskRunSpec{TaskRef: "uname", Variables: map[string]string{"host": "az!=a"}}, "invalid host selector az!=a"},
+		{"variable", TaskRunSpec{TaskRef: "uname", Variables: map[string]string{"level": "trace"}}, "variable level: trace is not one of debug, info"},
+	}
+	for _, c := range cases {
+		tr := &TaskRun{Spec: c.spec}
+		tr.Namespace = "ops-system"
+		checkErr(t, c.name, tr.ValidateCreate(), c.wantErr)
+	}
+
+	// the task is not checked again when the taskRef is not changed
+	old := &TaskRun{Spec: TaskRunSpec{TaskRef: "missing"}}
+	tr := old.DeepCopy()
+	tr.Namespace = "ops-system"
+	checkErr(t, "update", tr.ValidateUpdate(old), "")
+	tr.Spec.TaskRef = "other"
+	checkErr(t, "update task ref", tr.ValidateUpdate(old), "get task other")
+}
+
+func TestPipelineRunValidateCreate(t *testing.T) {
+	useWebhookReader(t, &fakeWebhookReader{
+		tasks: map[string]*Task{
+			"ops-system/uname": {Spec: TaskSpec{
+				Variables: Variables{"level": {Default: "info", Enums: []string{"debug", "info"}}},
+				Steps:     []Step{{Content: "uname"}},
+			}},
+		},
+		pipelines: map[string]*Pipeline{
+			"ops-system/inspect": {Spec: PipelineSpec{
+				Variables: Variables{"cluster": {Required: true}},
+				Tasks:     []TaskRef{{Name: "uname", TaskRef: "uname"}},
+			}},
+			"ops-system/fixed": {Spec: PipelineSpec{
+				Tasks: []TaskRef{{Name: "uname", TaskRef: "uname", Variables: map[string]string{"level": "trace"}}},
+			}},
+			"ops-system/broken": {Spec: PipelineSpec{
+				Tasks: []TaskRef{{Name: "first", TaskRef: "uname"}, {Name: "second", TaskRef: "missing"}},
+			}},
+		},
+	})
+	cases := []struct {
+		name    string
+		spec    PipelineRunSpec
+		wantErr string
+	}{
+		{"valid", PipelineRunSpec{PipelineRef: "inspect", Variables: map[string]string{"cluster": "dev", "level": "debug"}}, ""},
+		{"no pipeline ref", PipelineRunSpec{}, "pipelineRef is required"},
+		{"crontab", PipelineRunSpec{PipelineRef: "inspect", Crontab: "bad"}, "invalid crontab bad"},
+		{"missing pipeline", PipelineRunSpec{PipelineRef: "missing"}, "get pipeline missing"},
+		{"missing task", PipelineRunSpec{PipelineRef: "broken"}, "task second: get task missing"},
+		{"pipeline variable", PipelineRunSpec{PipelineRef: "inspect"}, "variable cluster is required"},
+		{"task variable", PipelineRunSpec{PipelineRef: "inspect", Variables: map[string]string{"cluster": "dev", "level": "trace"}}, "task uname: variable level: trace is not one of debug, info"},
+		{"task ref variable", PipelineRunSpec{PipelineRef: "fixed", Variables: map[string]string{"level": "debug"}}, "task uname: variable level: trace"},
+	}
+	for _, c := range cases {
+		pr := &PipelineRun{Spec: c.spec}
+		pr.Namespace = "ops-system"
+		checkErr(t, c.name, pr.ValidateCreate(), c.wantErr)
+	}
+}
+
+func TestGetWebhookObjectNotReady(t *testing.T) {
+	useWebhookReader(t, nil)
+	tr := &TaskRun{Spec: TaskRunSpec{TaskRef: "uname"}}
+	checkErr(t, "not ready", tr.ValidateCreate(), "webhook client is not ready")
+}
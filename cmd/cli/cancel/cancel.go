@@ -0,0 +1,73 @@
+package cancel
+
+import (
+	"context"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/kube"
+	"github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+	"github.com/shaowenchen/ops/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var clusterOpt option.ClusterOption
+var verbose string
+
+var CancelCmd = &cobra.Command{
+	Use:   "cancel",
+	Short: "cancel running TaskRun or PipelineRun",
+}
+
+var taskRunCmd = &cobra.Command{
+	Use:   "taskrun",
+	Short: "cancel taskrun",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.NewLogger().SetVerbose(verbose).SetStd().SetFile().Build()
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		defer cancel()
+		restConfig, err := utils.GetRestConfig(utils.GetAbsoluteFilePath(clusterOpt.Kubeconfig))
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		err = kube.CancelTaskRun(ctx, logger, restConfig, clusterOpt.Namespace, clusterOpt.Name)
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		logger.Info.Println("cancelled taskrun " + clusterOpt.Name)
+	},
+}
+
+var pipelineRunCmd = &cobra.Command{
+	Use:   "pipelinerun",
+	Short: "cancel pipelinerun",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.NewLogger().SetVerbose(verbose).SetStd().SetFile().Build()
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		defer cancel()
+		restConfig, err := utils.GetRestConfig(utils.GetAbsoluteFilePath(clusterOpt.Kubeconfig))
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		err = kube.CancelPipelineRun(ctx, logger, restConfig, clusterOpt.Namespace, clusterOpt.Name)
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		logger.Info.Println("cancelled pipelinerun " + clusterOpt.Name)
+	},
+}
+
+func init() {
+	CancelCmd.PersistentFlags().StringVarP(&verbose, "verbose", "v", "", "")
+	CancelCmd.PersistentFlags().StringVarP(&clusterOpt.Kubeconfig, "kubeconfig", "", constants.GetCurrentUserKubeConfigPath(), "")
+	CancelCmd.PersistentFlags().StringVarP(&clusterOpt.Namespace, "namespace", "", constants.OpsNamespace, "")
+	CancelCmd.PersistentFlags().StringVarP(&clusterOpt.Name, "name", "", "", "")
+	CancelCmd.MarkPersistentFlagRequired("name")
+
+	CancelCmd.AddCommand(taskRunCmd)
+	CancelCmd.AddCommand(pipelineRunCmd)
+}
@@ -48,7 +48,16 @@ func CreateHost(ctx context.Context, logger *log.Logger, clusterOpt option.Clust
 		}
 	}
 	hostOpt.Password = utils.EncodingStringToBase64(hostOpt.Password)
-	hs := host.GetHosts(logger, clusterOpt, hostOpt, inventory)
+	err = host.LoadAuthOption(&hostOpt)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	hs, err := host.GetHosts(logger, clusterOpt, hostOpt, inventory)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
 
 	for _, h := range hs {
 		h.Namespace = clusterOpt.Namespace
@@ -78,6 +87,10 @@ func init() {
 	hostCmd.Flags().StringVarP(&hHostOpt.Username, "username", "", "root", "")
 	hostCmd.Flags().StringVarP(&hHostOpt.Password, "password", "", "", "")
 	hostCmd.Flags().StringVarP(&hHostOpt.PrivateKeyPath, "privatekeypath", "", constants.GetCurrentUserPrivateKeyPath(), "")
+	hostCmd.Flags().StringVarP(&hHostOpt.Passphrase, "passphrase", "", "", "passphrase of the private key")
+	hostCmd.Flags().StringVarP(&hHostOpt.CertificatePath, "certificatepath", "", "", "OpenSSH user certificate, default <privatekeypath>-cert.pub")
+	hostCmd.Flags().StringVarP(&hHostOpt.HostKeyPolicy, "hostkeypolicy", "", "", "strict, tofu or insecure, default tofu")
+	hostCmd.Flags().StringVarP(&hHostOpt.Bastion, "bastion", "", "", "jump hosts, eg: user@1.1.1.1:22,2.2.2.2")
 	hostCmd.Flags().StringVarP(&hHostOpt.SecretRef, "secretref", "", "", "")
 	hostCmd.Flags().StringVarP(&hInventory, "inventory", "i", "", "")
 	hostCmd.MarkFlagRequired("inventory")
@@ -2,6 +2,7 @@ package file
 
 import (
 	"context"
+	"os"
 
 	"github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/host"
@@ -26,11 +27,17 @@ var FileCmd = &cobra.Command{
 		hostOpt.Password = utils.EncodingStringToBase64(hostOpt.Password)
 		privateKey, _ := utils.ReadFile(hostOpt.PrivateKeyPath)
 		hostOpt.PrivateKey = utils.EncodingStringToBase64(privateKey)
+		if err := host.LoadAuthOption(&hostOpt); err != nil {
+			logger.Error.Println(err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
 		defer cancel()
 		inventoryType := utils.GetInventoryType(inventory)
 		if inventoryType == constants.InventoryTypeHosts {
-			HostFile(ctx, logger, fileOpt, hostOpt, inventory)
+			if err := HostFile(ctx, logger, fileOpt, hostOpt, inventory); err != nil {
+				os.Exit(1)
+			}
 		} else if inventoryType == constants.InventoryTypeKubernetes {
 			KubeFile(ctx, logger, fileOpt, inventory)
 		}
@@ -38,7 +45,11 @@ var FileCmd = &cobra.Command{
 }
 
 func HostFile(ctx context.Context, logger *log.Logger, fileOpt option.FileOption, hostOpt option.HostOption, inventory string) (err error) {
-	hs := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+	hs, err := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
 	for _, h := range hs {
 		output, err := host.File(ctx, logger, h, hostOpt, fileOpt)
 		if err != nil {
@@ -91,6 +102,13 @@ func init() {
 	FileCmd.Flags().StringVarP(&hostOpt.Password, "password", "", "", "")
 	FileCmd.Flags().StringVarP(&hostOpt.PrivateKey, "privatekey", "", "", "")
 	FileCmd.Flags().StringVarP(&hostOpt.PrivateKeyPath, "privatekeypath", "", constants.GetCurrentUserPrivateKeyPath(), "")
+	FileCmd.Flags().StringVarP(&hostOpt.Passphrase, "passphrase", "", "", "passphrase of the private key")
+	FileCmd.Flags().StringVarP(&hostOpt.CertificatePath, "certificatepath", "", "", "OpenSSH user certificate, default <privatekeypath>-cert.pub")
+	FileCmd.Flags().BoolVarP(&hostOpt.Agent, "agent", "", false, "authenticate with ssh-agent")
+	FileCmd.Flags().BoolVarP(&hostOpt.ForwardAgent, "forwardagent", "", false, "forward the ssh-agent to the hosts, requires --agent")
+	FileCmd.Flags().StringVarP(&hostOpt.HostKeyPolicy, "hostkeypolicy", "", "", "strict, tofu or insecure, default tofu")
+	FileCmd.Flags().StringVarP(&hostOpt.Bastion, "bastion", "", "", "jump hosts, eg: user@1.1.1.1:22,2.2.2.2")
+	FileCmd.Flags().StringVarP(&hostOpt.Limit, "limit", "", "", "groups or hosts of inventory, eg: group1,host3")
 	FileCmd.Flags().IntVar(&hostOpt.Port, "port", 22, "")
 
 	FileCmd.Flags().StringVarP(&fileOpt.NodeName, "nodename", "", "", "")
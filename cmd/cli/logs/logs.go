@@ -0,0 +1,117 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+var opsServer string
+var opsToken string
+var namespace string
+var name string
+var follow bool
+var verbose string
+
+var LogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "command about logs of TaskRun",
+}
+
+var taskRunCmd = &cobra.Command{
+	Use:   "taskrun",
+	Short: "print the step output of taskrun, --follow to stream the live output",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.NewLogger().SetVerbose(verbose).SetStd().Build()
+		err := TaskRunLogs(logger, opsServer, opsToken, namespace, name, follow)
+		if err != nil {
+			logger.Error.Println(err)
+		}
+	},
+}
+
+type taskRunLog struct {
+	NodeName string `json:"nodeName"`
+	StepName string `json:"stepName"`
+	Content  string `json:"content"`
+	Finished bool   `json:"finished"`
+}
+
+// TaskRunLogs reads the server-sent events of the taskrun logs api and prints them
+func TaskRunLogs(logger *log.Logger, server, token, namespace, name string, follow bool) (err error) {
+	api := fmt.Sprintf("%s/api/v1/namespaces/%s/taskruns/%s/logs?follow=%t", strings.TrimRight(server, "/"), url.PathEscape(namespace), url.PathEscape(name), follow)
+	req, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %s failed, status %s", api, resp.Status)
+	}
+	event := ""
+	current := ""
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		switch event {
+		case "log":
+			l := taskRunLog{}
+			if json.Unmarshal([]byte(data), &l) != nil {
+				continue
+			}
+			// print a header when the output comes from another step
+			if key := l.NodeName + "/" + l.StepName; key != current {
+				current = key
+				logger.Info.Println(fmt.Sprintf("> %s %s", l.NodeName, l.StepName))
+			}
+			fmt.Print(l.Content)
+			if l.Finished && len(l.Content) > 0 && !strings.HasSuffix(l.Content, "\n") {
+				fmt.Println()
+			}
+		case "end":
+			logger.Info.Println("> " + data)
+			return
+		case "error":
+			return errors.New(data)
+		default:
+			// a plain response such as an error of the api
+			logger.Error.Println(data)
+		}
+	}
+	return scanner.Err()
+}
+
+func init() {
+	LogsCmd.PersistentFlags().StringVarP(&verbose, "verbose", "v", "", "")
+	LogsCmd.PersistentFlags().StringVarP(&opsServer, "opsserver", "", "http://127.0.0.1", "")
+	LogsCmd.PersistentFlags().StringVarP(&opsToken, "opstoken", "", "ops", "")
+	LogsCmd.PersistentFlags().StringVarP(&namespace, "namespace", "", constants.OpsNamespace, "")
+	LogsCmd.PersistentFlags().StringVarP(&name, "name", "", "", "")
+	LogsCmd.MarkPersistentFlagRequired("name")
+	LogsCmd.PersistentFlags().BoolVarP(&follow, "follow", "f", false, "")
+
+	LogsCmd.AddCommand(taskRunCmd)
+}
@@ -0,0 +1,109 @@
+package rekey
+
+import (
+	"context"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/host"
+	"github.com/shaowenchen/ops/pkg/kube"
+	"github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+	"github.com/shaowenchen/ops/pkg/utils"
+	"github.com/spf13/cobra"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	runtimeClient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var clusterOpt option.ClusterOption
+var hostOpt option.HostOption
+var inventory string
+var verbose string
+
+var RekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "trust the current ssh host key, used when the host is reinstalled",
+}
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "rekey the Host with --name, or the hosts in known_hosts with -i",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.NewLogger().SetVerbose(verbose).SetStd().SetFile().Build()
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		defer cancel()
+		if clusterOpt.Name != "" {
+			err := RekeyHostResource(ctx, logger, clusterOpt)
+			if err != nil {
+				logger.Error.Println(err)
+			}
+			return
+		}
+		hostOpt.Password = utils.EncodingStringToBase64(hostOpt.Password)
+		privateKey, _ := utils.ReadFile(hostOpt.PrivateKeyPath)
+		hostOpt.PrivateKey = utils.EncodingStringToBase64(privateKey)
+		if err := host.LoadAuthOption(&hostOpt); err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		store := host.NewKnownHostsStore(constants.GetOpsKnownHostsPath())
+		hs, err := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		for _, h := range hs {
+			fingerprint, err := host.Rekey(h, store)
+			if err != nil {
+				logger.Error.Println(err)
+				continue
+			}
+			logger.Info.Println("trusted host key of " + h.Spec.Address + " " + fingerprint)
+		}
+	},
+}
+
+// RekeyHostResource records the current host key in the status of Host
+func RekeyHostResource(ctx context.Context, logger *log.Logger, clusterOpt option.ClusterOption) (err error) {
+	restConfig, err := utils.GetRestConfig(utils.GetAbsoluteFilePath(clusterOpt.Kubeconfig))
+	if err != nil {
+		return
+	}
+	client, err := kube.GetOpsClient(ctx, logger, restConfig)
+	if err != nil {
+		return
+	}
+	h := &opsv1.Host{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: clusterOpt.Namespace, Name: clusterOpt.Name}, h)
+	if err != nil {
+		return
+	}
+	host.SetBastionGetter(func(namespace, name string) (*opsv1.Host, error) {
+		bastion := &opsv1.Host{}
+		return bastion, client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: name}, bastion)
+	})
+	fingerprint, err := host.Rekey(h, host.NewHostStatusKeyStore(client))
+	if err != nil {
+		return
+	}
+	logger.Info.Println("trusted host key of " + h.GetUniqueKey() + " " + fingerprint)
+	return
+}
+
+func init() {
+	RekeyCmd.PersistentFlags().StringVarP(&verbose, "verbose", "v", "", "")
+
+	hostCmd.Flags().StringVarP(&clusterOpt.Kubeconfig, "kubeconfig", "", constants.GetCurrentUserKubeConfigPath(), "")
+	hostCmd.Flags().StringVarP(&clusterOpt.Namespace, "namespace", "", constants.OpsNamespace, "")
+	hostCmd.Flags().StringVarP(&clusterOpt.Name, "name", "", "", "name of Host resource")
+	hostCmd.Flags().StringVarP(&inventory, "inventory", "i", "", "")
+	hostCmd.Flags().StringVarP(&hostOpt.Username, "username", "", constants.GetCurrentUser(), "")
+	hostCmd.Flags().StringVarP(&hostOpt.Password, "password", "", "", "")
+	hostCmd.Flags().StringVarP(&hostOpt.PrivateKeyPath, "privatekeypath", "", constants.GetCurrentUserPrivateKeyPath(), "")
+	hostCmd.Flags().StringVarP(&hostOpt.Passphrase, "passphrase", "", "", "passphrase of the private key")
+	hostCmd.Flags().StringVarP(&hostOpt.CertificatePath, "certificatepath", "", "", "OpenSSH user certificate, default <privatekeypath>-cert.pub")
+	hostCmd.Flags().IntVar(&hostOpt.Port, "port", 22, "")
+	hostCmd.Flags().StringVarP(&hostOpt.Bastion, "bastion", "", "", "jump hosts, eg: user@1.1.1.1:22,2.2.2.2")
+
+	RekeyCmd.AddCommand(hostCmd)
+}
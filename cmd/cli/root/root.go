@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/shaowenchen/ops/cmd/cli/cancel"
 	"github.com/shaowenchen/ops/cmd/cli/copilot"
 	"github.com/shaowenchen/ops/cmd/cli/create"
 	"github.com/shaowenchen/ops/cmd/cli/file"
+	"github.com/shaowenchen/ops/cmd/cli/logs"
+	"github.com/shaowenchen/ops/cmd/cli/rekey"
 	"github.com/shaowenchen/ops/cmd/cli/shell"
 	"github.com/shaowenchen/ops/cmd/cli/task"
 	"github.com/shaowenchen/ops/cmd/cli/upgrade"
@@ -18,6 +21,9 @@ func Execute() {
 	RootCmd.AddCommand(file.FileCmd)
 	RootCmd.AddCommand(shell.ShellCmd)
 	RootCmd.AddCommand(create.CreateCmd)
+	RootCmd.AddCommand(cancel.CancelCmd)
+	RootCmd.AddCommand(logs.LogsCmd)
+	RootCmd.AddCommand(rekey.RekeyCmd)
 	RootCmd.AddCommand(task.TaskCmd)
 	RootCmd.AddCommand(copilot.CopilotCmd)
 	RootCmd.AddCommand(version.VersionCmd)
@@ -2,6 +2,7 @@ package shell
 
 import (
 	"context"
+	"os"
 
 	"github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/host"
@@ -27,6 +28,10 @@ var ShellCmd = &cobra.Command{
 		hostOpt.Password = utils.EncodingStringToBase64(hostOpt.Password)
 		privateKey, _ := utils.ReadFile(hostOpt.PrivateKeyPath)
 		hostOpt.PrivateKey = utils.EncodingStringToBase64(privateKey)
+		if err := host.LoadAuthOption(&hostOpt); err != nil {
+			logger.Error.Println(err)
+			return
+		}
 		inventory = utils.GetAbsoluteFilePath(inventory)
 		inventoryType := utils.GetInventoryType(inventory)
 		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
@@ -37,7 +42,9 @@ var ShellCmd = &cobra.Command{
 		if inventoryType == constants.InventoryTypeKubernetes {
 			KubeShell(ctx, logger, shellOpt, kubeOpt, inventory)
 		} else if inventoryType == constants.InventoryTypeHosts {
-			HostShell(ctx, logger, shellOpt, hostOpt, inventory)
+			if err := HostShell(ctx, logger, shellOpt, hostOpt, inventory); err != nil {
+				os.Exit(1)
+			}
 		}
 	},
 }
@@ -63,7 +70,12 @@ func KubeShell(ctx context.Context, logger *log.Logger, shellOpt option.ShellOpt
 }
 
 func HostShell(ctx context.Context, logger *log.Logger, shellOpt option.ShellOption, hostOpt option.HostOption, inventory string) (err error) {
-	for _, h := range host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory) {
+	hs, err := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	for _, h := range hs {
 		host.Shell(ctx, logger, h, shellOpt, hostOpt)
 	}
 	return
@@ -86,5 +98,12 @@ func init() {
 	ShellCmd.Flags().StringVarP(&hostOpt.Password, "password", "", "", "")
 	ShellCmd.Flags().StringVarP(&hostOpt.PrivateKey, "privatekey", "", "", "")
 	ShellCmd.Flags().StringVarP(&hostOpt.PrivateKeyPath, "privatekeypath", "", constants.GetCurrentUserPrivateKeyPath(), "")
+	ShellCmd.Flags().StringVarP(&hostOpt.Passphrase, "passphrase", "", "", "passphrase of the private key")
+	ShellCmd.Flags().StringVarP(&hostOpt.CertificatePath, "certificatepath", "", "", "OpenSSH user certificate, default <privatekeypath>-cert.pub")
+	ShellCmd.Flags().BoolVarP(&hostOpt.Agent, "agent", "", false, "authenticate with ssh-agent")
+	ShellCmd.Flags().BoolVarP(&hostOpt.ForwardAgent, "forwardagent", "", false, "forward the ssh-agent to the hosts, requires --agent")
+	ShellCmd.Flags().StringVarP(&hostOpt.HostKeyPolicy, "hostkeypolicy", "", "", "strict, tofu or insecure, default tofu")
+	ShellCmd.Flags().StringVarP(&hostOpt.Bastion, "bastion", "", "", "jump hosts, eg: user@1.1.1.1:22,2.2.2.2")
+	ShellCmd.Flags().StringVarP(&hostOpt.Limit, "limit", "", "", "groups or hosts of inventory, eg: group1,host3")
 	ShellCmd.Flags().IntVar(&hostOpt.Port, "port", 22, "")
 }
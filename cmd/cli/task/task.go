@@ -2,8 +2,13 @@ package task
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	"github.com/shaowenchen/ops/pkg/constants"
@@ -14,6 +19,7 @@ import (
 	opstask "github.com/shaowenchen/ops/pkg/task"
 	"github.com/shaowenchen/ops/pkg/utils"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var taskOpt option.TaskOption
@@ -37,38 +43,88 @@ var TaskCmd = &cobra.Command{
 		hostOpt.Password = utils.EncodingStringToBase64(hostOpt.Password)
 		privateKey, _ := utils.ReadFile(hostOpt.PrivateKeyPath)
 		hostOpt.PrivateKey = utils.EncodingStringToBase64(privateKey)
+		if err := host.LoadAuthOption(&hostOpt); err != nil {
+			logger.Error.Println(err)
+			return
+		}
 		inventoryType := utils.GetInventoryType(inventory)
-		tasks, err := opstask.ReadTaskYaml(utils.GetTaskAbsoluteFilePath(taskOpt.Proxy, taskOpt.FilePath))
-		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		taskPath := utils.GetTaskAbsoluteFilePath(taskOpt.Proxy, taskOpt.FilePath)
+		tasks, err := opstask.ReadTaskYaml(taskPath)
+		timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		defer timeoutCancel()
+		// Ctrl-C interrupts the running step and skips the remaining ones
+		ctx, cancel := signal.NotifyContext(timeoutCtx, os.Interrupt, syscall.SIGTERM)
 		defer cancel()
 		if err != nil {
 			logger.Error.Println(err)
 			return
 		}
+		tasks, err = resolveTasks(tasks, taskPath)
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
 		if inventoryType == constants.InventoryTypeHosts {
-			HostTask(ctx, logger, tasks, taskOpt, hostOpt, inventory)
+			if err = HostTask(ctx, logger, tasks, taskOpt, hostOpt, inventory); err != nil {
+				os.Exit(1)
+			}
 		} else if inventoryType == constants.InventoryTypeKubernetes {
 			KubeTask(ctx, logger, tasks, taskOpt, kubeOpt, inventory)
 		}
 	},
 }
 
-func HostTask(ctx context.Context, logger *log.Logger, tasks []opsv1.Task, taskOpt option.TaskOption, hostOpt option.HostOption, inventory string) (err error) {
-	hs := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
-	for _, h := range hs {
+// resolveTasks inlines the uses and snippets, found next to the task file first
+func resolveTasks(tasks []opsv1.Task, taskPath string) ([]opsv1.Task, error) {
+	taskDir := taskPath
+	if info, err := os.Stat(taskPath); err == nil && !info.IsDir() {
+		taskDir = filepath.Dir(taskPath)
+	}
+	lib := opstask.NewFileTaskLibrary(taskOpt.Proxy, taskDir)
+	resolved := make([]opsv1.Task, 0, len(tasks))
+	for _, t := range tasks {
+		rt, err := opstask.ResolveTask(&t, lib)
 		if err != nil {
-			logger.Error.Println(err)
-			continue
+			return nil, fmt.Errorf("task %s: %v", t.GetUniqueKey(), err)
 		}
-		for _, t := range tasks {
+		resolved = append(resolved, *rt)
+	}
+	return resolved, nil
+}
+
+func HostTask(ctx context.Context, logger *log.Logger, tasks []opsv1.Task, taskOpt option.TaskOption, hostOpt option.HostOption, inventory string) (err error) {
+	refVars, err := getRefVariables(ctx, constants.GetCurrentUserKubeConfigPath(), tasks)
+	if err != nil {
+		logger.Error.Println(err)
+		return err
+	}
+	ihs, err := host.GetInventoryHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+	if err != nil {
+		logger.Error.Println(err)
+		return err
+	}
+	for _, ih := range ihs {
+		h := ih.Host
+		for i, t := range tasks {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// cli > inventory
+			newTaskOpt := taskOpt
+			newTaskOpt.RefVariables = refVars[i]
+			newTaskOpt.Variables = utils.MergeMap(utils.MergeMap(make(map[string]string), ih.Variables), taskOpt.Variables)
+			newTaskOpt.Variables["hostname"] = h.GetHostname()
+			if taskOpt.DryRun {
+				newTaskOpt.Facts = opstask.HostFacts(h.Status)
+				printPlan(logger, &t, h.Spec.Address, newTaskOpt)
+				continue
+			}
 			tr := opsv1.NewTaskRun(&t)
 			hc, err := host.NewHostConnBase64(h)
 			if err != nil {
 				logger.Error.Println(err)
 				continue
 			}
-			newTaskOpt := taskOpt
-			newTaskOpt.Variables["hostname"] = h.GetHostname()
 			err = opstask.RunTaskOnHost(ctx, logger, &t, &tr, hc, newTaskOpt)
 			if err != nil {
 				logger.Error.Println(err)
@@ -85,9 +141,18 @@ func KubeTask(ctx context.Context, logger *log.Logger, tasks []opsv1.Task, taskO
 		logger.Error.Println(err)
 		return err
 	}
+	refVars, err := getRefVariables(ctx, inventory, tasks)
+	if err != nil {
+		logger.Error.Println(err)
+		return err
+	}
 	nodes, err := kube.GetNodes(ctx, logger, kc.Client, kubeOpt)
 	for _, node := range nodes {
-		for _, t := range tasks {
+		for i, t := range tasks {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			taskOpt.RefVariables = refVars[i]
 			newKubeOpt := kubeOpt
 			if t.Spec.RuntimeImage != "" {
 				newKubeOpt.RuntimeImage = t.Spec.RuntimeImage
@@ -97,8 +162,14 @@ func KubeTask(ctx context.Context, logger *log.Logger, tasks []opsv1.Task, taskO
 					taskOpt.Variables[k] = v.GetValue()
 				}
 			}
+			if taskOpt.DryRun {
+				planOpt := taskOpt
+				planOpt.Facts = opstask.NodeFacts(&node)
+				printPlan(logger, &t, node.Name, planOpt)
+				continue
+			}
 			tr := opsv1.NewTaskRun(&t)
-			err = opstask.RunTaskOnKube(logger, &t, &tr, kc, &node, taskOpt, newKubeOpt)
+			err = opstask.RunTaskOnKube(ctx, logger, &t, &tr, kc, &node, taskOpt, newKubeOpt)
 			if err != nil {
 				logger.Error.Println(err)
 			}
@@ -107,6 +178,58 @@ func KubeTask(ctx context.Context, logger *log.Logger, tasks []opsv1.Task, taskO
 	return
 }
 
+// getRefVariables reads the secretRef and configMapRef variables of the tasks from the cluster of kubeconfig,
+// in the namespace of the task, default ops-system, the cluster is not connected if no variable has a ref
+func getRefVariables(ctx context.Context, kubeconfig string, tasks []opsv1.Task) ([]map[string]string, error) {
+	refVars := make([]map[string]string, len(tasks))
+	var kc *kube.KubeConnection
+	for i, t := range tasks {
+		hasRef := false
+		for _, v := range t.Spec.Variables {
+			hasRef = hasRef || v.HasRef()
+		}
+		if !hasRef {
+			continue
+		}
+		if kc == nil {
+			var err error
+			kc, err = kube.NewKubeConnection(kubeconfig)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %v", t.GetUniqueKey(), err)
+			}
+			if kc.OpsClient == nil {
+				return nil, fmt.Errorf("task %s: kube client is nil", t.GetUniqueKey())
+			}
+		}
+		rt := t.DeepCopy()
+		if rt.Namespace == "" {
+			rt.Namespace = constants.OpsNamespace
+		}
+		values, err := opstask.GetVariableRefValues(ctx, *kc.OpsClient, rt)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %v", t.GetUniqueKey(), err)
+		}
+		refVars[i] = values
+	}
+	return refVars, nil
+}
+
+// printPlan prints the rendered steps of task on the target, nothing is run
+func printPlan(logger *log.Logger, t *opsv1.Task, target string, taskOpt option.TaskOption) {
+	plan, err := opstask.PlanTask(t, target, taskOpt)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	data, err := yaml.Marshal(plan)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	logger.Info.Println("> Dry Run Task ", t.GetUniqueKey(), " on ", target)
+	logger.Info.Println(string(data))
+}
+
 func parseArgs(args []string) (taskOption option.TaskOption) {
 	taskOption.Variables = make(map[string]string)
 	for i := 0; i < len(args); i++ {
@@ -123,6 +246,8 @@ func parseArgs(args []string) (taskOption option.TaskOption) {
 			}
 			if fieldName == "sudo" {
 				taskOption.Sudo = fieldValue == "true"
+			} else if fieldName == "dry-run" {
+				taskOption.DryRun = fieldValue == "true"
 			} else if fieldName == "filepath" || fieldName == "f" {
 				taskOption.FilePath = fieldValue
 			} else if fieldName == "proxy" {
@@ -145,6 +270,20 @@ func parseArgs(args []string) (taskOption option.TaskOption) {
 				hostOpt.Password = fieldValue
 			} else if fieldName == "privatekeypath" {
 				hostOpt.PrivateKeyPath = fieldValue
+			} else if fieldName == "hostkeypolicy" {
+				hostOpt.HostKeyPolicy = fieldValue
+			} else if fieldName == "bastion" {
+				hostOpt.Bastion = fieldValue
+			} else if fieldName == "limit" {
+				hostOpt.Limit = fieldValue
+			} else if fieldName == "passphrase" {
+				hostOpt.Passphrase = fieldValue
+			} else if fieldName == "certificatepath" {
+				hostOpt.CertificatePath = fieldValue
+			} else if fieldName == "agent" {
+				hostOpt.Agent = fieldValue != "false"
+			} else if fieldName == "forwardagent" {
+				hostOpt.ForwardAgent = fieldValue != "false"
 			} else {
 				taskOption.Variables[fieldName] = fieldValue
 			}
@@ -169,6 +308,7 @@ func init() {
 	TaskCmd.Flags().StringVarP(&inventory, "inventory", "i", "", "")
 
 	TaskCmd.Flags().StringVarP(&taskOpt.FilePath, "filepath", "", "", "")
+	TaskCmd.Flags().BoolVarP(&taskOpt.DryRun, "dry-run", "", false, "print the rendered steps without running")
 	TaskCmd.MarkFlagRequired("filepath")
 
 	TaskCmd.Flags().StringVarP(&kubeOpt.NodeName, "nodename", "", "", "")
@@ -180,4 +320,11 @@ func init() {
 	TaskCmd.Flags().StringVarP(&hostOpt.Password, "password", "", "", "")
 	TaskCmd.Flags().StringVarP(&hostOpt.PrivateKey, "privatekey", "", "", "")
 	TaskCmd.Flags().StringVarP(&hostOpt.PrivateKeyPath, "privatekeypath", "", constants.GetCurrentUserPrivateKeyPath(), "")
+	TaskCmd.Flags().StringVarP(&hostOpt.Passphrase, "passphrase", "", "", "passphrase of the private key")
+	TaskCmd.Flags().StringVarP(&hostOpt.CertificatePath, "certificatepath", "", "", "OpenSSH user certificate, default <privatekeypath>-cert.pub")
+	TaskCmd.Flags().BoolVarP(&hostOpt.Agent, "agent", "", false, "authenticate with ssh-agent")
+	TaskCmd.Flags().BoolVarP(&hostOpt.ForwardAgent, "forwardagent", "", false, "forward the ssh-agent to the hosts, requires --agent")
+	TaskCmd.Flags().StringVarP(&hostOpt.HostKeyPolicy, "hostkeypolicy", "", "", "strict, tofu or insecure, default tofu")
+	TaskCmd.Flags().StringVarP(&hostOpt.Bastion, "bastion", "", "", "jump hosts, eg: user@1.1.1.1:22,2.2.2.2")
+	TaskCmd.Flags().StringVarP(&hostOpt.Limit, "limit", "", "", "groups or hosts of inventory, eg: group1,host3")
 }
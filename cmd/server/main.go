@@ -11,6 +11,7 @@ func init() {
 	configpath := flag.String("c", "", "")
 	flag.Parse()
 	server.LoadConfig(*configpath)
+	server.SetupHistory()
 }
 
 func main() {
@@ -45,6 +45,8 @@ type HostReconciler struct {
 	Scheme              *runtime.Scheme
 	timeTickerStopChans map[string]chan bool
 	tickerMutex         sync.RWMutex
+	// observedGenerations evicts the connections when the spec is changed
+	observedGenerations sync.Map
 }
 
 //+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=hosts,verbs=get;list;watch;create;update;patch;delete
@@ -81,6 +83,13 @@ func (r *HostReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, err
 	}
 
+	// the cached connections use the old spec
+	observed, ok := r.observedGenerations.Load(h.GetUniqueKey())
+	if ok && observed.(int64) != h.Generation {
+		opshost.EvictHost(h.Namespace, h.Name)
+	}
+	r.observedGenerations.Store(h.GetUniqueKey(), h.Generation)
+
 	// add timeticker
 	r.addTimeTicker(logger, ctx, h)
 
@@ -89,6 +98,8 @@ func (r *HostReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HostReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// resolve bastionRef of host connections
+	opshost.SetBastionGetter(r.getBastion)
 	// push event
 	namespace, err := opsconstants.GetCurrentNamespace()
 	if err == nil {
@@ -103,7 +114,25 @@ func (r *HostReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+// getBastion gets the Host referred by bastionRef, with credentials filled from secretRef
+func (r *HostReconciler) getBastion(namespace, name string) (*opsv1.Host, error) {
+	h := &opsv1.Host{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, h)
+	if err != nil {
+		return nil, err
+	}
+	if h.Spec.SecretRef != "" {
+		err = filledHostFromSecret(h, r.Client, h.Spec.SecretRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
 func (r *HostReconciler) deleteHost(ctx context.Context, namespacedName types.NamespacedName) error {
+	opshost.EvictHost(namespacedName.Namespace, namespacedName.Name)
+	r.observedGenerations.Delete(namespacedName.String())
 	r.tickerMutex.RLock()
 	_, ok := r.timeTickerStopChans[namespacedName.String()]
 	r.tickerMutex.RUnlock()
@@ -149,7 +178,14 @@ func (r *HostReconciler) addTimeTicker(logger *opslog.Logger, ctx context.Contex
 				return
 			case <-ticker.C:
 				logger.Info.Println(fmt.Sprintf("run ticker for host %s", h.GetUniqueKey()))
-				r.updateStatus(logger, ctx, h)
+				// the spec may be changed since the ticker started
+				latest := &opsv1.Host{}
+				err := r.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: h.Name}, latest)
+				if err != nil {
+					logger.Error.Println(err, "failed to get host")
+					continue
+				}
+				r.updateStatus(logger, ctx, latest)
 			}
 		}
 	}()
@@ -170,6 +206,15 @@ func filledHostFromSecret(h *opsv1.Host, client client.Client, secretRef string)
 		password := secret.Data["passsword"]
 		h.Spec.Password = base64.StdEncoding.EncodeToString(password)
 	}
+	if secret.Data["password"] != nil {
+		h.Spec.Password = base64.StdEncoding.EncodeToString(secret.Data["password"])
+	}
+	if secret.Data["passphrase"] != nil {
+		h.Spec.Passphrase = base64.StdEncoding.EncodeToString(secret.Data["passphrase"])
+	}
+	if secret.Data["certificate"] != nil {
+		h.Spec.Certificate = base64.StdEncoding.EncodeToString(secret.Data["certificate"])
+	}
 	return nil
 }
 
@@ -209,7 +254,10 @@ func (r *HostReconciler) commitStatus(logger *opslog.Logger, ctx context.Context
 		return
 	}
 	if overrideStatus != nil {
+		// the host key is recorded by the connection, not collected
+		hostKey, hopKeys := lastH.Status.HostKey, lastH.Status.HopKeys
 		lastH.Status = *overrideStatus
+		lastH.Status.HostKey, lastH.Status.HopKeys = hostKey, hopKeys
 	}
 	if status != "" {
 		lastH.Status.HeartStatus = status
@@ -76,6 +76,11 @@ func (r *PipelineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (r
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	// invalid dag, skip
+	if err = obj.ValidateTasks(); err != nil {
+		logger.Error.Println(err, "invalid pipeline", obj.GetUniqueKey())
+		return ctrl.Result{}, nil
+	}
 	// filled variables
 	changed := r.filledVariables(logger, ctx, obj)
 	if changed {
@@ -30,6 +30,7 @@ import (
 	opsevent "github.com/shaowenchen/ops/pkg/event"
 	opskube "github.com/shaowenchen/ops/pkg/kube"
 	opslog "github.com/shaowenchen/ops/pkg/log"
+	opstask "github.com/shaowenchen/ops/pkg/task"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -99,6 +100,17 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if opsconstants.IsFinishedStatus(pr.Status.RunStatus) {
 		return ctrl.Result{}, nil
 	}
+	// the running one is also stopped by watchCancelled
+	if pr.Spec.Cancelled {
+		// the dispatched one runs in others cluster, cancel it there too
+		if pr.Status.RunStatus == opsconstants.StatusDispatched {
+			if err = r.cancelDispatched(logger, pr); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		r.commitStatus(logger, ctx, pr, opsconstants.StatusAborted, "", "", nil)
+		return ctrl.Result{}, nil
+	}
 	// insert env
 	pr.SetEnv()
 	// if is others cluster, send and just sync status
@@ -181,6 +193,27 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	return ctrl.Result{}, err
 }
 
+// cancelDispatched propagates spec.cancelled to the pipelinerun dispatched to others cluster
+func (r *PipelineRunReconciler) cancelDispatched(logger *opslog.Logger, pr *opsv1.PipelineRun) error {
+	cluster := r.isOtherCluster(pr)
+	if cluster == nil {
+		// nothing to cancel when the cluster is gone
+		logger.Error.Printf("cluster %s of dispatched pipelinerun %s is not found", pr.GetCluster(), pr.GetUniqueKey())
+		return nil
+	}
+	logger.Info.Printf("Cancel PipelineRun %s in cluster %s", pr.Name, cluster.Name)
+	kc, err := opskube.NewClusterConnection(cluster)
+	if err != nil {
+		logger.Error.Println(err, "failed to create cluster connection")
+		return err
+	}
+	err = kc.CancelPipelineRun(pr)
+	if err != nil {
+		logger.Error.Println(err, "failed to cancel others pr")
+	}
+	return err
+}
+
 func (r *PipelineRunReconciler) isOtherCluster(pr *opsv1.PipelineRun) *opsv1.Cluster {
 	cluster := pr.GetCluster()
 	if cluster == "" {
@@ -236,6 +269,9 @@ func (r *PipelineRunReconciler) addCronTab(logger *opslog.Logger, ctx context.Co
 			logger.Error.Println(err)
 			return
 		}
+		if objRun.Spec.Cancelled {
+			return
+		}
 		obj := &opsv1.Pipeline{}
 		err = r.Client.Get(ctx, types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Spec.PipelineRef}, obj)
 		if err != nil {
@@ -275,61 +311,111 @@ func (r *PipelineRunReconciler) registerClearCron() {
 	r.clearCron.Start()
 }
 
+type pipelineTaskResult struct {
+	tRef     opsv1.TaskRef
+	status   *opsv1.TaskRunStatus
+	finished bool
+}
+
 func (r *PipelineRunReconciler) run(logger *opslog.Logger, ctx context.Context, p *opsv1.Pipeline, pr *opsv1.PipelineRun) (err error) {
-	runAlways := false
-	for _, tRef := range p.Spec.Tasks {
-		if runAlways && !tRef.RunAlways {
-			continue
+	if err = p.ValidateTasks(); err != nil {
+		logger.Error.Println(err)
+		r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, "", "", nil)
+		return
+	}
+	runAfter := p.GetRunAfter()
+	// finished task name -> run status
+	finished := make(map[string]string)
+	// upstreamFailed marks tasks with a failed task in their upstream, only runAlways tasks run after them
+	upstreamFailed := make(map[string]bool)
+	started := make(map[string]bool)
+	// results of finished tasks, referenced by ${tasks.<name>.results.<key>}
+	taskResults := make(map[string]map[string]string)
+	results := make(chan pipelineTaskResult)
+	running := 0
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.watchCancelled(logger, runCtx, cancel, pr)
+	for {
+		// launch all ready tasks, skipping a task may make others ready
+		for launched := true; launched; {
+			launched = false
+			for _, tRef := range p.Spec.Tasks {
+				name := tRef.GetName()
+				if started[name] || !isPipelineTaskReady(runAfter[name], finished) {
+					continue
+				}
+				started[name] = true
+				launched = true
+				for _, dep := range runAfter[name] {
+					if upstreamFailed[dep] {
+						upstreamFailed[name] = true
+					}
+				}
+				// cancelled pipelinerun skips all the remaining tasks
+				if (upstreamFailed[name] && !tRef.RunAlways) || runCtx.Err() != nil {
+					finished[name] = opsconstants.StatusSkipped
+					r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, name, tRef.TaskRef, &opsv1.TaskRunStatus{
+						RunStatus: opsconstants.StatusSkipped,
+					})
+					continue
+				}
+				vars, err := renderTaskRefVariables(tRef.Variables, taskResults)
+				if err != nil {
+					logger.Error.Println(fmt.Sprintf("pipelinerun %s task %s: %s", pr.GetUniqueKey(), name, err))
+					finished[name] = opsconstants.StatusFailed
+					if !tRef.AllowFailure {
+						upstreamFailed[name] = true
+					}
+					r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, name, tRef.TaskRef, &opsv1.TaskRunStatus{
+						RunStatus: opsconstants.StatusFailed,
+						Message:   err.Error(),
+					})
+					continue
+				}
+				running++
+				tRef.Variables = vars
+				go r.runPipelineTask(logger, ctx, runCtx, pr, tRef, results)
+			}
 		}
-		// create taskrun
-		t := &opsv1.Task{}
-		err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: tRef.TaskRef}, t)
-		if err != nil {
-			logger.Error.Println(err)
-			runAlways = true
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, tRef.Name, tRef.TaskRef, &opsv1.TaskRunStatus{
-				RunStatus: opsconstants.StatusDataInValid,
-			})
-			continue
+		if running == 0 {
+			break
 		}
-		tr := opsv1.NewTaskRunWithPipelineRun(pr, t, tRef)
-		err = r.Client.Create(ctx, tr)
-		if err != nil {
-			logger.Error.Println(err)
-			runAlways = true
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, tRef.Name, tRef.TaskRef, nil)
+		result := <-results
+		name := result.tRef.GetName()
+		r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, name, result.tRef.TaskRef, result.status)
+		if !result.finished {
 			continue
 		}
-		// run task and commit status
-		for {
-			time.Sleep(time.Second * 3)
-			trRunning := &opsv1.TaskRun{}
-			if err = r.Client.Get(ctx, types.NamespacedName{Namespace: tr.Namespace, Name: tr.Name}, trRunning); err != nil {
-				logger.Error.Println(err)
-				break
-			}
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, tRef.Name, trRunning.Spec.TaskRef, &trRunning.Status)
-			if trRunning.Status.RunStatus == opsconstants.StatusRunning || trRunning.Status.RunStatus == opsconstants.StatusEmpty {
-				continue
-			} else if trRunning.Status.RunStatus == opsconstants.StatusSuccessed {
-				break
-			} else {
-				runAlways = true
-				break
-			}
+		running--
+		finished[name] = result.status.RunStatus
+		taskResults[name] = result.status.Results
+		if result.status.RunStatus != opsconstants.StatusSuccessed && !result.tRef.AllowFailure {
+			upstreamFailed[name] = true
 		}
 	}
 	finallyStatus := opsconstants.StatusSuccessed
-	for _, status := range pr.Status.PipelineRunStatus {
-		if status.TaskRunStatus.RunStatus == opsconstants.StatusFailed {
+	for _, tRef := range p.Spec.Tasks {
+		if tRef.AllowFailure {
+			continue
+		}
+		status := finished[tRef.GetName()]
+		if status == opsconstants.StatusFailed || status == opsconstants.StatusAborted {
 			finallyStatus = opsconstants.StatusFailed
 			break
-		} else if status.TaskRunStatus.RunStatus == opsconstants.StatusDataInValid {
+		} else if status == opsconstants.StatusDataInValid {
 			finallyStatus = opsconstants.StatusDataInValid
 			break
 		}
 	}
+	if runCtx.Err() != nil {
+		logger.Info.Println(fmt.Sprintf("pipelinerun %s is cancelled", pr.GetUniqueKey()))
+		finallyStatus = opsconstants.StatusAborted
+	}
 	r.commitStatus(logger, ctx, pr, finallyStatus, "", "", nil)
+	if err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, pr); err != nil {
+		logger.Error.Println(err)
+	}
 	// push event
 	go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, opsevent.EventPipelineRun{
 		PipelineRef:       pr.Spec.PipelineRef,
@@ -340,6 +426,94 @@ func (r *PipelineRunReconciler) run(logger *opslog.Logger, ctx context.Context,
 	return
 }
 
+func renderTaskRefVariables(vars map[string]string, taskResults map[string]map[string]string) (map[string]string, error) {
+	rendered := make(map[string]string)
+	for k, v := range vars {
+		value, err := opstask.RenderTaskResults(v, taskResults)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %v", k, err)
+		}
+		rendered[k] = value
+	}
+	return rendered, nil
+}
+
+func isPipelineTaskReady(runAfter []string, finished map[string]string) bool {
+	for _, dep := range runAfter {
+		if _, ok := finished[dep]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// watchCancelled cancels the run once spec.cancelled of the pipelinerun is set, it returns when the run is done
+func (r *PipelineRunReconciler) watchCancelled(logger *opslog.Logger, ctx context.Context, cancel context.CancelFunc, pr *opsv1.PipelineRun) {
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			latest := &opsv1.PipelineRun{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, latest)
+			if apierrors.IsNotFound(err) || (err == nil && latest.Spec.Cancelled) {
+				logger.Info.Println(fmt.Sprintf("cancel pipelinerun %s", pr.GetUniqueKey()))
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// runPipelineTask creates the taskrun of tRef and reports its status until finished,
+// the taskrun is cancelled when runCtx is done
+func (r *PipelineRunReconciler) runPipelineTask(logger *opslog.Logger, ctx context.Context, runCtx context.Context, pr *opsv1.PipelineRun, tRef opsv1.TaskRef, results chan<- pipelineTaskResult) {
+	if runCtx.Err() != nil {
+		results <- pipelineTaskResult{tRef: tRef, status: &opsv1.TaskRunStatus{RunStatus: opsconstants.StatusSkipped}, finished: true}
+		return
+	}
+	t := &opsv1.Task{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: tRef.TaskRef}, t)
+	if err != nil {
+		logger.Error.Println(err)
+		results <- pipelineTaskResult{tRef: tRef, status: &opsv1.TaskRunStatus{RunStatus: opsconstants.StatusDataInValid}, finished: true}
+		return
+	}
+	tr := opsv1.NewTaskRunWithPipelineRun(pr, t, tRef)
+	err = r.Client.Create(ctx, tr)
+	if err != nil {
+		logger.Error.Println(err)
+		results <- pipelineTaskResult{tRef: tRef, status: &opsv1.TaskRunStatus{RunStatus: opsconstants.StatusDataInValid}, finished: true}
+		return
+	}
+	cancelled := false
+	for {
+		time.Sleep(time.Second * 3)
+		trRunning := &opsv1.TaskRun{}
+		if err = r.Client.Get(ctx, types.NamespacedName{Namespace: tr.Namespace, Name: tr.Name}, trRunning); err != nil {
+			logger.Error.Println(err)
+			results <- pipelineTaskResult{tRef: tRef, status: &opsv1.TaskRunStatus{RunStatus: opsconstants.StatusFailed}, finished: true}
+			return
+		}
+		finished := opsconstants.IsFinishedStatus(trRunning.Status.RunStatus)
+		if runCtx.Err() != nil && !cancelled && !finished {
+			patch := client.MergeFrom(trRunning.DeepCopy())
+			trRunning.Spec.Cancelled = true
+			if err = r.Client.Patch(ctx, trRunning, patch); err != nil {
+				logger.Error.Println(err)
+			} else {
+				cancelled = true
+			}
+		}
+		results <- pipelineTaskResult{tRef: tRef, status: trRunning.Status.DeepCopy(), finished: finished}
+		if finished {
+			return
+		}
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PipelineRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	// push event
@@ -19,8 +19,9 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -28,6 +29,7 @@ import (
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	opsevent "github.com/shaowenchen/ops/pkg/event"
+	"github.com/shaowenchen/ops/pkg/history"
 	opshost "github.com/shaowenchen/ops/pkg/host"
 	opskube "github.com/shaowenchen/ops/pkg/kube"
 	opslog "github.com/shaowenchen/ops/pkg/log"
@@ -55,6 +57,8 @@ type TaskRunReconciler struct {
 	cron              *cron.Cron
 	clearCron         *cron.Cron
 	opsserverEndpoint string
+	// endpointMu guards opsserverEndpoint, which is read by concurrent hosts
+	endpointMu sync.Mutex
 }
 
 //+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=taskruns,verbs=get;list;watch;create;update;patch;delete
@@ -109,6 +113,13 @@ func (r *TaskRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 	// add crontab
 	r.addCronTab(logger, ctx, tr)
+	// the running one is also interrupted by watchCancelled
+	if tr.Spec.Cancelled {
+		if !opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+			r.commitStatus(logger, ctx, tr, opsconstants.StatusAborted)
+		}
+		return ctrl.Result{}, nil
+	}
 	// check run status
 	if tr.Status.RunStatus != opsconstants.StatusEmpty {
 		// abort running taskrun if restart or modified
@@ -155,6 +166,9 @@ func (r *TaskRunReconciler) addCronTab(logger *opslog.Logger, ctx context.Contex
 			logger.Error.Println(err)
 			return
 		}
+		if objRun.Spec.Cancelled {
+			return
+		}
 		obj := &opsv1.Task{}
 		err = r.Client.Get(ctx, types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Spec.TaskRef}, obj)
 		if err != nil {
@@ -199,27 +213,49 @@ func (r *TaskRunReconciler) registerClearCron() {
 
 func (r *TaskRunReconciler) run(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) (err error) {
 	tr.Status.ClearNodeStatus()
+	// inline the uses and snippets, the task is invalid if they are not found
+	t, err = opstask.ResolveTask(t, opstask.NewClientTaskLibrary(ctx, r.Client))
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("resolve task %s error: %v", tr.Spec.TaskRef, err))
+		r.commitStatus(logger, ctx, tr, opsconstants.StatusDataInValid)
+		return nil
+	}
+	// the full status of this run is kept in the history store, only if ops-server is told to keep it
+	if opsconstants.GetEnvHistoryEnabled() && opsconstants.GetEnvEventEndpoint() != "" {
+		tr.Status.HistoryRef = fmt.Sprintf("%d-%06x", time.Now().Unix(), rand.Intn(1<<24))
+	}
 	r.commitStatus(logger, ctx, tr, opsconstants.StatusRunning)
 
 	tr.MergeVariables(t)
+	// the values of secretRef and configMapRef are only passed to the run
+	refVars, err := opstask.GetVariableRefValues(ctx, r.Client, t)
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("read variables of task %s error: %v", tr.Spec.TaskRef, err))
+		r.commitStatus(logger, ctx, tr, opsconstants.StatusDataInValid)
+		return nil
+	}
 	hosts := r.getAvaliableHosts(logger, ctx, t, tr)
 
 	cliLogger := opslog.NewLogger().SetStd().WaitFlush().Build()
 
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.watchCancelled(logger, runCtx, cancel, tr)
+
 	// only run script
 	if len(hosts) > 0 && t.OnlyScript() {
+		hostNames := make([]string, 0, len(hosts))
 		for _, h := range hosts {
-			logger.Info.Println(fmt.Sprintf("run task %s on host %s", t.GetUniqueKey(), t.Spec.Host))
-			err = r.runTaskOnHost(cliLogger, ctx, r.Client, t, tr, &h)
-			if err != nil {
-				logger.Error.Println(err)
-			}
-			cliLogger.Flush()
+			hostNames = append(hostNames, h.Name)
 		}
+		r.runRollout(logger, ctx, runCtx, t, tr, hostNames, func(hostLogger *opslog.Logger, hostTr *opsv1.TaskRun, index int) error {
+			logger.Info.Println(fmt.Sprintf("run task %s on host %s", t.GetUniqueKey(), hosts[index].Name))
+			return r.runTaskOnHost(hostLogger, runCtx, r.Client, t, hostTr, &hosts[index], refVars)
+		})
 	} else {
 		cluster := opsv1.NewCurrentCluster()
 		logger.Info.Println(fmt.Sprintf("run task %s on cluster %s", t.GetUniqueKey(), cluster.Name))
-		err = r.runTaskOnKube(cliLogger, ctx, t, tr, &cluster)
+		err = r.runTaskOnKube(cliLogger, ctx, runCtx, t, tr, &cluster, refVars)
 		if err != nil {
 			logger.Error.Println(err)
 		}
@@ -232,18 +268,109 @@ func (r *TaskRunReconciler) run(logger *opslog.Logger, ctx context.Context, t *o
 			finallyStatus = opsconstants.StatusFailed
 		}
 	}
+	if runCtx.Err() != nil {
+		logger.Info.Println(fmt.Sprintf("taskrun %s is cancelled", tr.GetUniqueKey()))
+		finallyStatus = opsconstants.StatusAborted
+	}
+	// the outputs are only truncated in the status once the full ones are sent to the history
+	tr.Status.RunStatus = finallyStatus
+	if err := publishHistory(ctx, t, tr); err != nil {
+		logger.Error.Println(fmt.Sprintf("publish history of taskrun %s error: %v", tr.GetUniqueKey(), err))
+		tr.Status.HistoryRef = ""
+	}
 	r.commitStatus(logger, ctx, tr, finallyStatus)
 	// push event
 	go opsevent.FactoryTaskRun(tr.Namespace, tr.Name, opsconstants.Status).Publish(ctx, opsevent.EventTaskRun{
 		TaskRef:       tr.Spec.TaskRef,
 		Desc:          tr.Spec.Desc,
-		Variables:     tr.Spec.Variables,
+		Variables:     t.Spec.Variables.MaskSensitive(tr.Spec.Variables),
 		TaskRunStatus: tr.Status,
 	})
 	return
 }
 
-func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Context, client client.Client, t *opsv1.Task, tr *opsv1.TaskRun, h *opsv1.Host) (err error) {
+// publishHistory sends the full status of the run to the history store, split into events under the max payload of the bus
+func publishHistory(ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) error {
+	if tr.Status.HistoryRef == "" {
+		return nil
+	}
+	record := history.Record{
+		Id:            tr.Status.HistoryRef,
+		Namespace:     tr.Namespace,
+		TaskRun:       tr.Name,
+		TaskRef:       tr.Spec.TaskRef,
+		Desc:          tr.Spec.Desc,
+		Variables:     t.Spec.Variables.MaskSensitive(tr.Spec.Variables),
+		EndTime:       time.Now(),
+		TaskRunStatus: tr.Status,
+	}
+	bus := opsevent.FactoryTaskRun(tr.Namespace, tr.Name, opsconstants.History)
+	for _, part := range history.Split(&record, opsconstants.HistoryChunkSize) {
+		if err := bus.Publish(ctx, opsevent.EventTaskRunHistory(*part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchCancelled cancels the run once spec.cancelled of the taskrun is set, it returns when the run is done
+func (r *TaskRunReconciler) watchCancelled(logger *opslog.Logger, ctx context.Context, cancel context.CancelFunc, tr *opsv1.TaskRun) {
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			latest := &opsv1.TaskRun{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: tr.Namespace, Name: tr.Name}, latest)
+			if apierrors.IsNotFound(err) || (err == nil && latest.Spec.Cancelled) {
+				logger.Info.Println(fmt.Sprintf("cancel taskrun %s", tr.GetUniqueKey()))
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// runRollout runs fn on the nodes concurrently by the rollout of taskrun, every fn gets its own
+// logger and copy of taskrun, whose node status is merged into tr after finished
+func (r *TaskRunReconciler) runRollout(logger *opslog.Logger, ctx context.Context, runCtx context.Context, t *opsv1.Task, tr *opsv1.TaskRun, nodeNames []string, fn func(nodeLogger *opslog.Logger, nodeTr *opsv1.TaskRun, index int) error) {
+	rollout := tr.GetRollout(t)
+	var mu sync.Mutex
+	batches := opstask.RunRollout(runCtx, rollout, nodeNames, func(index int) string {
+		nodeLogger := opslog.NewLogger().SetStd().WaitFlush().Build()
+		nodeTr := &opsv1.TaskRun{
+			ObjectMeta: *tr.ObjectMeta.DeepCopy(),
+			Spec:       *tr.Spec.DeepCopy(),
+		}
+		err := fn(nodeLogger, nodeTr, index)
+		if err != nil {
+			nodeLogger.Error.Println(err)
+		}
+		nodeLogger.Flush()
+		mu.Lock()
+		defer mu.Unlock()
+		tr.Status.MergeNodeStatus(&nodeTr.Status)
+		status := nodeTr.Status.GetNodeRunStatus(nodeNames[index])
+		// failed before any step, such as the connection
+		if err != nil && status == opsconstants.StatusSuccessed {
+			status = opsconstants.StatusFailed
+		}
+		return status
+	}, func(batches []*opsv1.TaskRunBatchStatus) {
+		if !rollout.IsBatched() {
+			return
+		}
+		tr.Status.Batches = batches
+		r.commitStatus(logger, ctx, tr, "")
+	})
+	if rollout.IsBatched() {
+		tr.Status.Batches = batches
+	}
+}
+
+func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Context, client client.Client, t *opsv1.Task, tr *opsv1.TaskRun, h *opsv1.Host, refVars map[string]string) (err error) {
 	// fill variables
 	vars := tr.Spec.Variables
 	vars["TASK"] = t.Name
@@ -272,12 +399,14 @@ func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Con
 		return err
 	}
 	err = opstask.RunTaskOnHost(ctx, logger, t, tr, hc, opsoption.TaskOption{
-		Variables: vars,
+		Variables:    vars,
+		RefVariables: refVars,
+		Stream:       newStepStream(tr),
 	})
 	return err
 }
 
-func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun, cluster *opsv1.Cluster) (err error) {
+func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Context, runCtx context.Context, t *opsv1.Task, tr *opsv1.TaskRun, cluster *opsv1.Cluster, refVars map[string]string) (err error) {
 	// connecting
 	kc, err := opskube.NewClusterConnection(cluster)
 	if err != nil {
@@ -315,25 +444,51 @@ func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Con
 		return err
 	}
 	r.commitStatus(logger, ctx, tr, opsconstants.StatusRunning)
+	nodeNames := make([]string, 0, len(nodes))
 	for _, node := range nodes {
-		vars := tr.Spec.Variables
-		vars["HOSTNAME"] = node.Name
+		nodeNames = append(nodeNames, node.Name)
+	}
+	r.runRollout(logger, ctx, runCtx, t, tr, nodeNames, func(nodeLogger *opslog.Logger, nodeTr *opsv1.TaskRun, index int) error {
+		vars := nodeTr.Spec.Variables
+		vars["HOSTNAME"] = nodes[index].Name
 		vars["NAMESPACE"] = tr.Namespace
 		vars["OPSSERVER_ENDPOINT"] = r.getOpsServerEndpoint(t.Namespace)
 		vars["TASK"] = t.Name
 		vars["TASKRUN"] = tr.Name
-		opstask.RunTaskOnKube(logger, t, tr, kc, &node,
+		return opstask.RunTaskOnKube(runCtx, nodeLogger, t, nodeTr, kc, &nodes[index],
 			opsoption.TaskOption{
-				Variables: vars,
+				Variables:    vars,
+				RefVariables: refVars,
+				Stream:       newStepStream(tr),
 			}, kubeOpt)
-	}
+	})
 	return
 }
 
+// newStepStream publishes the live output of steps to the logs subject of taskrun, nil without event endpoint
+func newStepStream(tr *opsv1.TaskRun) func(nodeName, stepName string) io.WriteCloser {
+	if opsconstants.GetEnvEventEndpoint() == "" {
+		return nil
+	}
+	bus := opsevent.FactoryTaskRun(tr.Namespace, tr.Name, opsconstants.Logs)
+	return func(nodeName, stepName string) io.WriteCloser {
+		return opslog.NewStreamBuffer(time.Second, func(chunk string, finished bool) {
+			bus.Publish(context.TODO(), opsevent.EventTaskRunLog{
+				NodeName: nodeName,
+				StepName: stepName,
+				Content:  chunk,
+				Finished: finished,
+			})
+		})
+	}
+}
+
 func (r *TaskRunReconciler) getOpsServerEndpoint(namespace string) string {
 	// get app.kubernetes.io/name service under current namespace
 	// if svc no nodeport, set to nodeport
 	// get nodeport address and node ip address and return
+	r.endpointMu.Lock()
+	defer r.endpointMu.Unlock()
 	if len(r.opsserverEndpoint) > 0 {
 		return r.opsserverEndpoint
 	}
@@ -385,7 +540,11 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 		if tr.Status.TaskRunNodeStatus != nil {
 			latestTr.Status.TaskRunNodeStatus = tr.Status.TaskRunNodeStatus
 		}
-		latestTr.Status = tr.Status
+		latestTr.Status = *tr.Status.DeepCopy()
+		// the full outputs are kept in the status without the history store
+		if latestTr.Status.HistoryRef != "" {
+			latestTr.Status.TruncateOutputs(opsconstants.MaxStepOutputLength)
+		}
 		err = r.Client.Status().Update(ctx, latestTr)
 		if err == nil {
 			//need to improve
@@ -404,7 +563,7 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 }
 
 func (r *TaskRunReconciler) getAvaliableHosts(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) (hosts []opsv1.Host) {
-	selectHosts := r.getHosts(logger, ctx, t, tr)
+	selectHosts := opshost.GetTaskHosts(ctx, logger, r.Client, t, tr.GetHost(t))
 	for _, host := range selectHosts {
 		if host.Status.HeartStatus == opsconstants.StatusSuccessed {
 			hosts = append(hosts, host)
@@ -413,84 +572,6 @@ func (r *TaskRunReconciler) getAvaliableHosts(logger *opslog.Logger, ctx context
 	return
 }
 
-func (r *TaskRunReconciler) getHosts(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) (hosts []opsv1.Host) {
-	if t.Spec.RuntimeImage != "" {
-		return
-	}
-	hostStr := tr.GetHost(t)
-	// empty host
-	if len(hostStr) == 0 {
-		return
-	}
-	// anynode
-	if opsconstants.IsAnyKubeNode(hostStr) {
-		nodes := &corev1.NodeList{}
-		nodes, err := opsutils.GetAllReadyNodesByReconcileClient(r.Client)
-		if err != nil {
-			logger.Error.Println(err, "failed to list nodes")
-		}
-		hosts := &opsv1.HostList{}
-		err = r.Client.List(ctx, hosts)
-		if err != nil {
-			logger.Error.Println(err, "failed to list hosts")
-		}
-		logger.Info.Println("any: ", len(nodes.Items), len(hosts.Items))
-		// find node
-		var targetNode *corev1.Node
-		for _, node := range nodes.Items {
-			if opsutils.IsMasterNode(&node) && opsconstants.IsAnyMaster(hostStr) {
-				targetNode = &node
-			} else if !opsutils.IsMasterNode(&node) && opsconstants.IsAnyWorker(hostStr) {
-				targetNode = &node
-			} else if opsconstants.IsAnyNode(hostStr) {
-				targetNode = &node
-			}
-			if targetNode != nil {
-				break
-			}
-		}
-		logger.Info.Println(targetNode.Name)
-		// find host
-		for _, host := range hosts.Items {
-			if host.Spec.Address == opsutils.GetNodeInternalIp(targetNode) {
-				return []opsv1.Host{host}
-			}
-		}
-
-	}
-	// single host
-	if !strings.Contains(t.Spec.Host, "=") {
-		host := opsv1.Host{}
-		err := r.Client.Get(ctx, types.NamespacedName{Namespace: t.GetNamespace(), Name: hostStr}, &host)
-		if err != nil {
-			return
-		}
-		hosts = append(hosts, host)
-		return
-	}
-	// selector host, eg: az=cn-hangzhou
-	hostList := &opsv1.HostList{}
-	selector, err := metav1.ParseToLabelSelector(t.Spec.Host)
-	if err != nil {
-		logger.Error.Println(err, "failed to parse label selector")
-		return
-	}
-	labelMap, err := metav1.LabelSelectorAsMap(selector)
-	if err != nil {
-		logger.Error.Println(err, "failed to convert label selector to map")
-		return
-	}
-	err = r.Client.List(ctx, hostList, client.MatchingLabels(labelMap))
-	if err != nil {
-		logger.Error.Println(err, "failed to list hosts")
-		return
-	}
-	for _, h := range hostList.Items {
-		hosts = append(hosts, h)
-	}
-	return
-}
-
 // SetupWithManager sets up the controller with the Manager.
 func (r *TaskRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	// push event
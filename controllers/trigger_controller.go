@@ -0,0 +1,375 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsevent "github.com/shaowenchen/ops/pkg/event"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	opstask "github.com/shaowenchen/ops/pkg/task"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const TriggerResubscribeSeconds = 10
+
+// TriggerStatusFlushSeconds is the interval to commit the counters of triggered and dropped events to the status
+const TriggerStatusFlushSeconds = 10
+
+// TriggerReconciler reconciles a Trigger object
+type TriggerReconciler struct {
+	client.Client
+	Scheme      *runtime.Scheme
+	mutex       sync.Mutex
+	subscribers map[string]*triggerSubscriber
+}
+
+type triggerSubscriber struct {
+	generation int64
+	cancel     context.CancelFunc
+	mutex      sync.Mutex
+	// dedup key -> last trigger time
+	dedup map[string]time.Time
+	// trigger times in the rate limit window
+	history []time.Time
+	// counters not committed to the status yet
+	pending triggerCounters
+}
+
+type triggerCounters struct {
+	dropped         int64
+	triggered       int64
+	lastPipelineRun string
+	lastTriggerTime time.Time
+}
+
+// admit checks the event with vars against the dedup window and rate limit, and returns why it's dropped,
+// an admitted event is recorded in both
+func (sub *triggerSubscriber) admit(tg *opsv1.Trigger, vars map[string]string, now time.Time) (dropped string) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	dedupKey := tg.GetDedupKey(vars)
+	if last, ok := sub.dedup[dedupKey]; ok && tg.Spec.DedupSeconds > 0 && now.Sub(last) < time.Duration(tg.Spec.DedupSeconds)*time.Second {
+		dropped = "duplicated"
+	}
+	history := []time.Time{}
+	for _, t := range sub.history {
+		if now.Sub(t) < tg.GetRateLimitDuration() {
+			history = append(history, t)
+		}
+	}
+	sub.history = history
+	if dropped == "" && tg.Spec.RateLimit > 0 && len(sub.history) >= tg.Spec.RateLimit {
+		dropped = "rate limited"
+	}
+	if dropped != "" {
+		sub.pending.dropped++
+		return
+	}
+	for k, t := range sub.dedup {
+		if now.Sub(t) >= time.Duration(tg.Spec.DedupSeconds)*time.Second {
+			delete(sub.dedup, k)
+		}
+	}
+	sub.dedup[dedupKey] = now
+	sub.history = append(sub.history, now)
+	return
+}
+
+func (sub *triggerSubscriber) addTriggered(pipelineRun string, now time.Time) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	sub.pending.triggered++
+	sub.pending.lastPipelineRun = pipelineRun
+	sub.pending.lastTriggerTime = now
+}
+
+// takeCounters returns the counters not committed yet and resets them
+func (sub *triggerSubscriber) takeCounters() (counters triggerCounters) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	counters = sub.pending
+	sub.pending = triggerCounters{}
+	return
+}
+
+// restoreCounters adds back the counters failed to commit
+func (sub *triggerSubscriber) restoreCounters(counters triggerCounters) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	sub.pending.dropped += counters.dropped
+	sub.pending.triggered += counters.triggered
+	if sub.pending.lastPipelineRun == "" {
+		sub.pending.lastPipelineRun = counters.lastPipelineRun
+		sub.pending.lastTriggerTime = counters.lastTriggerTime
+	}
+}
+
+//+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=triggers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=triggers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=triggers/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+// TODO(user): Modify the Reconcile function to compare the state specified by
+// the Trigger object against the actual cluster state, and then
+// perform operations to make the cluster state reflect the state specified by
+// the user.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
+func (r *TriggerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// only reconcile active namespace
+	actionNs := opsconstants.GetEnvActiveNamespace()
+	if actionNs != "" && actionNs != req.Namespace {
+		return ctrl.Result{}, nil
+	}
+	logger := opslog.NewLogger().SetStd().SetFlag().Build()
+	if opsconstants.GetEnvDebug() {
+		logger.SetVerbose("debug").Build()
+	}
+
+	tg := &opsv1.Trigger{}
+	err := r.Client.Get(ctx, req.NamespacedName, tg)
+	if apierrors.IsNotFound(err) {
+		r.unsubscribe(logger, req.NamespacedName.String())
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	r.mutex.Lock()
+	sub, ok := r.subscribers[tg.GetUniqueKey()]
+	r.mutex.Unlock()
+	if ok && sub.generation == tg.Generation {
+		return ctrl.Result{}, nil
+	}
+	r.unsubscribe(logger, tg.GetUniqueKey())
+	r.subscribe(logger, tg)
+	return ctrl.Result{}, nil
+}
+
+func (r *TriggerReconciler) subscribe(logger *opslog.Logger, tg *opsv1.Trigger) {
+	if tg.Spec.Subject == "" || tg.Spec.PipelineRef == "" {
+		logger.Error.Println(fmt.Sprintf("trigger %s need subject and pipelineRef", tg.GetUniqueKey()))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &triggerSubscriber{
+		generation: tg.Generation,
+		cancel:     cancel,
+		dedup:      make(map[string]time.Time),
+	}
+	r.mutex.Lock()
+	if r.subscribers == nil {
+		r.subscribers = make(map[string]*triggerSubscriber)
+	}
+	r.subscribers[tg.GetUniqueKey()] = sub
+	r.mutex.Unlock()
+	tg = tg.DeepCopy()
+	// the counters are committed together, a noisy subject doesn't update the status on every event
+	go func() {
+		ticker := time.NewTicker(TriggerStatusFlushSeconds * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				r.commitStatus(logger, context.Background(), tg, sub)
+				return
+			case <-ticker.C:
+				r.commitStatus(logger, ctx, tg, sub)
+			}
+		}
+	}()
+	go func() {
+		for {
+			logger.Info.Println(fmt.Sprintf("trigger %s subscribe %s", tg.GetUniqueKey(), tg.Spec.Subject))
+			err := opsevent.FactorySubject(tg.Spec.Subject).SubscribeWithNewClient(ctx, func(eventCtx context.Context, e cloudevents.Event) {
+				r.handleEvent(logger, ctx, sub, tg, e)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Error.Println(err, "trigger subscribe error", tg.GetUniqueKey())
+			}
+			time.Sleep(TriggerResubscribeSeconds * time.Second)
+		}
+	}()
+}
+
+func (r *TriggerReconciler) unsubscribe(logger *opslog.Logger, key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sub, ok := r.subscribers[key]
+	if !ok {
+		return
+	}
+	sub.cancel()
+	delete(r.subscribers, key)
+	logger.Info.Println(fmt.Sprintf("trigger %s unsubscribe", key))
+}
+
+func (r *TriggerReconciler) handleEvent(logger *opslog.Logger, ctx context.Context, sub *triggerSubscriber, tg *opsv1.Trigger, e cloudevents.Event) {
+	fields, ok := matchTriggerEvent(tg, e)
+	if !ok {
+		return
+	}
+	vars := make(map[string]string)
+	for k, v := range tg.Spec.Variables {
+		vars[k] = opstask.RenderString(v, fields)
+	}
+	// dedup and rate limit
+	if dropped := sub.admit(tg, vars, time.Now()); dropped != "" {
+		logger.Info.Println(fmt.Sprintf("trigger %s drop event %s, %s", tg.GetUniqueKey(), e.ID(), dropped))
+		return
+	}
+	// create pipelinerun
+	p := &opsv1.Pipeline{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: tg.Namespace, Name: tg.Spec.PipelineRef}, p)
+	if err != nil {
+		logger.Error.Println(err, "failed to get pipeline", tg.Spec.PipelineRef)
+		return
+	}
+	pr := opsv1.NewPipelineRunWithTrigger(tg, p, vars)
+	err = r.Client.Create(ctx, pr)
+	if err != nil {
+		logger.Error.Println(err, "failed to create pipelinerun")
+		return
+	}
+	logger.Info.Println(fmt.Sprintf("trigger %s create pipelinerun %s by event %s", tg.GetUniqueKey(), pr.Name, e.ID()))
+	sub.addTriggered(pr.Name, time.Now())
+}
+
+// matchTriggerEvent filters the event and returns the fields to render variables,
+// payload fields by path, event.type, event.id, event.source and event.<extension>
+func matchTriggerEvent(tg *opsv1.Trigger, e cloudevents.Event) (fields map[string]string, ok bool) {
+	if tg.Spec.Type != "" && tg.Spec.Type != e.Type() {
+		return nil, false
+	}
+	extensions := e.Extensions()
+	for k, v := range tg.Spec.Extensions {
+		if fmt.Sprint(extensions[k]) != v {
+			return nil, false
+		}
+	}
+	fields = make(map[string]string)
+	var payload interface{}
+	if err := json.Unmarshal(e.Data(), &payload); err == nil {
+		flattenTriggerPayload("", payload, fields)
+	}
+	for k, v := range tg.Spec.Filters {
+		if fields[k] != v {
+			return nil, false
+		}
+	}
+	fields["event.type"] = e.Type()
+	fields["event.id"] = e.ID()
+	fields["event.source"] = e.Source()
+	for k, v := range extensions {
+		fields["event."+k] = fmt.Sprint(v)
+	}
+	return fields, true
+}
+
+func flattenTriggerPayload(prefix string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenTriggerPayload(key, item, fields)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenTriggerPayload(fmt.Sprintf("%s.%d", prefix, i), item, fields)
+		}
+		if raw, err := json.Marshal(v); err == nil && prefix != "" {
+			fields[prefix] = string(raw)
+		}
+	case nil:
+		if prefix != "" {
+			fields[prefix] = ""
+		}
+	default:
+		if prefix != "" {
+			fields[prefix] = fmt.Sprint(v)
+		}
+	}
+}
+
+// commitStatus adds the counters of sub to the status, they are added back to sub if failed
+func (r *TriggerReconciler) commitStatus(logger *opslog.Logger, ctx context.Context, tg *opsv1.Trigger, sub *triggerSubscriber) (err error) {
+	counters := sub.takeCounters()
+	if counters.dropped == 0 && counters.triggered == 0 {
+		return
+	}
+	defer func() {
+		if err != nil {
+			sub.restoreCounters(counters)
+		}
+	}()
+	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
+		latestTg := &opsv1.Trigger{}
+		err = r.Client.Get(ctx, types.NamespacedName{Namespace: tg.GetNamespace(), Name: tg.GetName()}, latestTg)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		latestTg.Status.DroppedCount += counters.dropped
+		latestTg.Status.TriggeredCount += counters.triggered
+		if counters.lastPipelineRun != "" {
+			latestTg.Status.LastPipelineRun = counters.lastPipelineRun
+			latestTg.Status.LastTriggerTime = &metav1.Time{Time: counters.lastTriggerTime}
+		}
+		err = r.Client.Status().Update(ctx, latestTg)
+		if err == nil {
+			return
+		}
+		if !apierrors.IsConflict(err) {
+			logger.Error.Println(err, "update trigger status error")
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	logger.Error.Println("update trigger status failed after retries", err)
+	return
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TriggerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&opsv1.Trigger{}).
+		Complete(r)
+}
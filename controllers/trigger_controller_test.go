@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+func newTriggerTestEvent(t *testing.T, eventType string, extensions map[string]string, data string) cloudevents.Event {
+	e := cloudevents.NewEvent()
+	e.SetID("1")
+	e.SetSource("ops")
+	e.SetType(eventType)
+	for k, v := range extensions {
+		e.SetExtension(k, v)
+	}
+	if err := e.SetData(cloudevents.ApplicationJSON, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestMatchTriggerEvent(t *testing.T) {
+	data := `{"status":{"runStatus":"Failed"},"host":"node-1","tags":["a","b"]}`
+	tests := []struct {
+		name   string
+		spec   opsv1.TriggerSpec
+		event  cloudevents.Event
+		wantOk bool
+	}{
+		{name: "no filter", spec: opsv1.TriggerSpec{}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data), wantOk: true},
+		{name: "type", spec: opsv1.TriggerSpec{Type: "ops.taskrun"}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data), wantOk: true},
+		{name: "other type", spec: opsv1.TriggerSpec{Type: "ops.host"}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data)},
+		{name: "extension", spec: opsv1.TriggerSpec{Extensions: map[string]string{"cluster": "prod"}}, event: newTriggerTestEvent(t, "ops.taskrun", map[string]string{"cluster": "prod"}, data), wantOk: true},
+		{name: "other extension", spec: opsv1.TriggerSpec{Extensions: map[string]string{"cluster": "prod"}}, event: newTriggerTestEvent(t, "ops.taskrun", map[string]string{"cluster": "dev"}, data)},
+		{name: "missing extension", spec: opsv1.TriggerSpec{Extensions: map[string]string{"cluster": "prod"}}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data)},
+		{name: "nested filter", spec: opsv1.TriggerSpec{Filters: map[string]string{"status.runStatus": "Failed", "host": "node-1"}}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data), wantOk: true},
+		{name: "other filter", spec: opsv1.TriggerSpec{Filters: map[string]string{"status.runStatus": "Successed"}}, event: newTriggerTestEvent(t, "ops.taskrun", nil, data)},
+		{name: "filter without payload", spec: opsv1.TriggerSpec{Filters: map[string]string{"host": "node-1"}}, event: newTriggerTestEvent(t, "ops.taskrun", nil, "not json")},
+	}
+	for _, tt := range tests {
+		fields, ok := matchTriggerEvent(&opsv1.Trigger{Spec: tt.spec}, tt.event)
+		if ok != tt.wantOk {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.wantOk)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if fields["event.type"] != "ops.taskrun" || fields["event.id"] != "1" || fields["event.source"] != "ops" || fields["host"] != "node-1" {
+			t.Errorf("%s: fields = %v", tt.name, fields)
+		}
+	}
+	fields, _ := matchTriggerEvent(&opsv1.Trigger{}, newTriggerTestEvent(t, "ops.taskrun", map[string]string{"cluster": "prod"}, data))
+	if fields["event.cluster"] != "prod" {
+		t.Errorf("event.cluster = %q, want prod", fields["event.cluster"])
+	}
+}
+
+func TestFlattenTriggerPayload(t *testing.T) {
+	fields := make(map[string]string)
+	flattenTriggerPayload("", map[string]interface{}{
+		"a":     map[string]interface{}{"b": "c", "n": float64(3), "ok": true},
+		"list":  []interface{}{"x", map[string]interface{}{"y": "z"}},
+		"empty": nil,
+	}, fields)
+	want := map[string]string{
+		"a.b":      "c",
+		"a.n":      "3",
+		"a.ok":     "true",
+		"list":     `["x",{"y":"z"}]`,
+		"list.0":   "x",
+		"list.1.y": "z",
+		"empty":    "",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+}
+
+func TestTriggerSubscriberAdmit(t *testing.T) {
+	now := time.Now()
+	// an event comes seconds after now with the variable host
+	type event struct {
+		after int
+		host  string
+	}
+	tests := []struct {
+		name   string
+		spec   opsv1.TriggerSpec
+		events []event
+		want   []string
+	}{
+		{
+			name:   "no limit",
+			spec:   opsv1.TriggerSpec{},
+			events: []event{{0, "a"}, {0, "a"}, {1, "a"}},
+			want:   []string{"", "", ""},
+		},
+		{
+			name:   "dedup in window",
+			spec:   opsv1.TriggerSpec{DedupSeconds: 60},
+			events: []event{{0, "a"}, {10, "a"}, {10, "b"}, {61, "a"}},
+			want:   []string{"", "duplicated", "", ""},
+		},
+		{
+			name:   "rate limit",
+			spec:   opsv1.TriggerSpec{RateLimit: 2, RateLimitSeconds: 60},
+			events: []event{{0, "a"}, {1, "b"}, {2, "c"}, {61, "d"}},
+			want:   []string{"", "", "rate limited", ""},
+		},
+		{
+			name:   "dropped events are not rate limited",
+			spec:   opsv1.TriggerSpec{RateLimit: 2, DedupSeconds: 60},
+			events: []event{{0, "a"}, {1, "a"}, {2, "a"}, {3, "b"}},
+			want:   []string{"", "duplicated", "duplicated", ""},
+		},
+	}
+	for _, tt := range tests {
+		tg := &opsv1.Trigger{Spec: tt.spec}
+		sub := &triggerSubscriber{dedup: make(map[string]time.Time)}
+		dropped := int64(0)
+		for i, e := range tt.events {
+			got := sub.admit(tg, map[string]string{"host": e.host}, now.Add(time.Duration(e.after)*time.Second))
+			if got != tt.want[i] {
+				t.Errorf("%s: event %d = %q, want %q", tt.name, i, got, tt.want[i])
+			}
+			if got != "" {
+				dropped++
+			}
+		}
+		if counters := sub.takeCounters(); counters.dropped != dropped {
+			t.Errorf("%s: dropped = %d, want %d", tt.name, counters.dropped, dropped)
+		}
+	}
+}
+
+func TestTriggerSubscriberCounters(t *testing.T) {
+	sub := &triggerSubscriber{dedup: make(map[string]time.Time)}
+	now := time.Now()
+	sub.addTriggered("pr-1", now)
+	sub.addTriggered("pr-2", now)
+	counters := sub.takeCounters()
+	if counters.triggered != 2 || counters.lastPipelineRun != "pr-2" {
+		t.Errorf("counters = %+v", counters)
+	}
+	if empty := sub.takeCounters(); empty != (triggerCounters{}) {
+		t.Errorf("counters after take = %+v", empty)
+	}
+	// failed to commit, a newer pipelinerun is kept
+	sub.addTriggered("pr-3", now)
+	sub.restoreCounters(counters)
+	if got := sub.takeCounters(); got.triggered != 3 || got.lastPipelineRun != "pr-3" {
+		t.Errorf("counters after restore = %+v", got)
+	}
+}
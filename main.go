@@ -35,6 +35,7 @@ import (
 
 	crdv1 "github.com/shaowenchen/ops/api/v1"
 	"github.com/shaowenchen/ops/controllers"
+	opshost "github.com/shaowenchen/ops/pkg/host"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -92,6 +93,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	// record the ssh host keys in the status of Host
+	opshost.SetHostKeyStore(opshost.NewHostStatusKeyStore(mgr.GetClient()))
+
 	if err = (&controllers.HostReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
@@ -141,6 +145,43 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "Event")
 		os.Exit(1)
 	}
+	if err = (&controllers.TriggerReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Trigger")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = (&crdv1.Host{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Host")
+			os.Exit(1)
+		}
+		if err = (&crdv1.Task{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Task")
+			os.Exit(1)
+		}
+		if err = (&crdv1.Cluster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Cluster")
+			os.Exit(1)
+		}
+		if err = (&crdv1.TaskRun{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TaskRun")
+			os.Exit(1)
+		}
+		if err = (&crdv1.Pipeline{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Pipeline")
+			os.Exit(1)
+		}
+		if err = (&crdv1.PipelineRun{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PipelineRun")
+			os.Exit(1)
+		}
+		if err = (&crdv1.Trigger{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Trigger")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
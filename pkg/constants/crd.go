@@ -19,6 +19,8 @@ const (
 	Pipelines     = "Pipelines"
 	PipelineRun   = "PipelineRun"
 	PipelineRuns  = "PipelineRuns"
+	Trigger       = "Trigger"
+	Triggers      = "Triggers"
 	Namespace     = "Namespace"
 	Namespaces    = "Namespaces"
 	Webhook       = "Webhook"
@@ -26,6 +28,7 @@ const (
 	Event         = "Event"
 	Events        = "Events"
 	TaskRunReport = "TaskRunReport"
+	TaskRunLog    = "TaskRunLog"
 	Default       = "Default"
 	Deployments   = "Deployments"
 	Deployment    = "Deployment"
@@ -38,6 +41,7 @@ const StatusRunning = "Running"
 const StatusAborted = "Aborted"
 const StatusDataInValid = "DataInValid"
 const StatusDispatched = "Dispatched"
+const StatusSkipped = "Skipped"
 const StatusEmpty = ""
 
 func IsFinishedStatus(status string) bool {
@@ -50,6 +54,17 @@ const (
 	LabelCronPipelineValue         = "pipeline"
 	LabelTaskRefKey                = "ops/taskref"
 	LabelPipelineRefKey            = "ops/pipelineref"
+	LabelTriggerKey                = "ops/trigger"
+	AnnotationProxyJumpOfKey       = "ops/proxyjump-of"
 	DefaultTTLSecondsAfterFinished = 60 * 60
 	ClearCronTab                   = "*/30 * * * *"
 )
+
+const TaskRunHistory = "TaskRunHistory"
+
+// MaxStepOutputLength is the max bytes of a step output kept in the status, the full one is in the history
+const MaxStepOutputLength = 4096
+
+// HistoryChunkSize is the max bytes of step outputs in a history event, json escaping may grow it
+// up to 6 times, still under the default max payload 1MB of nats
+const HistoryChunkSize = 128 * 1024
@@ -3,6 +3,7 @@ package constants
 import (
 	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -11,6 +12,8 @@ const (
 	EnvDefaultRuntimeImage = "DEFAULT_RUNTIME_IMAGE"
 	EnvEventClusterKey     = "EVENT_CLUSTER"
 	EnvEventEndpointKey    = "EVENT_ENDPOINT"
+	EnvFactsMaxAgeKey      = "FACTS_MAX_AGE"
+	EnvHistoryEnabledKey   = "HISTORY_ENABLED"
 )
 
 // just for controller
@@ -31,6 +34,20 @@ func GetEnvEventEndpoint() string {
 	return os.Getenv(EnvEventEndpointKey)
 }
 
+// GetEnvHistoryEnabled tells ops-server keeps the history store, the outputs in the status are truncated then
+func GetEnvHistoryEnabled() bool {
+	return strings.ToLower(os.Getenv(EnvHistoryEnabledKey)) == "true"
+}
+
 func GetEnvDefaultRuntimeImage() string {
 	return os.Getenv(EnvDefaultRuntimeImage)
 }
+
+// GetEnvFactsMaxAge is how long the gathered facts are reused, default DefaultFactsMaxAge, 0 gathers every time
+func GetEnvFactsMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(os.Getenv(EnvFactsMaxAgeKey))
+	if err != nil {
+		return DefaultFactsMaxAge
+	}
+	return maxAge
+}
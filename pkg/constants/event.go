@@ -9,6 +9,8 @@ const OpsStreamName = "ops"
 
 const Setup = "setup"
 const Status = "status"
+const Logs = "logs"
+const History = "history"
 
 const Source = "https://github.com/shaowenchen/ops"
 
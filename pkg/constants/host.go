@@ -13,6 +13,9 @@ import (
 const LocalHostIP = "127.0.0.1"
 const DefaultSSHTimeoutSeconds = 30
 const DefaultShellTimeoutSeconds = 30
+const DefaultSSHMaxSessions = 8
+const SSHKeepAliveIntervalSeconds = 30
+const SSHIdleTimeoutSeconds = 600
 const DefaultShellTimeoutDuration = DefaultShellTimeoutSeconds * time.Second
 
 const (
@@ -20,6 +23,15 @@ const (
 	InventoryTypeHosts      = "hosts"
 )
 
+const (
+	// HostKeyPolicyStrict only accepts the host key already recorded
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyTofu records the host key on first use and verifies it afterwards
+	HostKeyPolicyTofu = "tofu"
+	// HostKeyPolicyInsecure skips the host key verification
+	HostKeyPolicyInsecure = "insecure"
+)
+
 const (
 	RemoteStorageTypeS3     = "s3"
 	RemoteStorageTypeImage  = "image"
@@ -73,6 +85,10 @@ func GetOpsLogsDir() string {
 	return filepath.Join(GetOpsDir(), "logs")
 }
 
+func GetOpsKnownHostsPath() string {
+	return filepath.Join(GetOpsDir(), "known_hosts")
+}
+
 func GetCurrentUserPrivateKeyPath() string {
 	return filepath.Join(GetCurrentUserHomeDir(), ".ssh", "id_rsa")
 }
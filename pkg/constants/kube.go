@@ -26,6 +26,9 @@ const LabelCopilotPipelineEnabledValue = "enabled"
 
 const KubeAdminConfigPath = "/etc/kubernetes/admin.conf"
 
+// PodStderrPrefix starts the lines of stderr in the logs of the shell pods, they are split from the stdout by it
+const PodStderrPrefix = "ops-stderr: "
+
 const DefaultRuntimeImage = "registry.cn-hangzhou.aliyuncs.com/opshub/ubuntu:22.04"
 const OpsCliRuntimeImage = "registry.cn-hangzhou.aliyuncs.com/shaowenchen/opscli:latest"
 
@@ -1,3 +1,99 @@
 package constants
 
+import "time"
+
 const NoOutput = "no output"
+
+// actions of kube steps, run through the Kubernetes API
+const (
+	KubeActionApply          = "apply"
+	KubeActionGet            = "get"
+	KubeActionPatch          = "patch"
+	KubeActionDelete         = "delete"
+	KubeActionWait           = "wait"
+	KubeActionRolloutRestart = "rollout-restart"
+	KubeActionCordon         = "cordon"
+	KubeActionUncordon       = "uncordon"
+	KubeActionDrain          = "drain"
+)
+
+const (
+	KubePatchTypeMerge     = "merge"
+	KubePatchTypeJson      = "json"
+	KubePatchTypeStrategic = "strategic"
+)
+
+// KubeWaitDelete is the condition of wait action for the deletion
+const KubeWaitDelete = "delete"
+
+const KubeFieldManager = "ops"
+const DefaultKubeWaitSeconds = 300
+const KubeWaitIntervalSeconds = 2
+
+const DefaultHTTPTimeoutSeconds = 30
+
+// MaxHTTPBodyBytes limits the response body read by http steps
+const MaxHTTPBodyBytes = 1 << 20
+
+// DefaultFactsMaxAge is how long the facts of a host or node are reused
+const DefaultFactsMaxAge = 10 * time.Minute
+
+// FactsVariablePrefix names the facts in the variables, such as ${facts.arch}
+const FactsVariablePrefix = "facts."
+
+// TaskResultsVariablePrefix names the results of pipeline tasks, such as ${tasks.get-pod.results.podName}
+const TaskResultsVariablePrefix = "tasks."
+
+// the states of ensure steps
+const (
+	EnsureStatePresent = "present"
+	EnsureStateAbsent  = "absent"
+	EnsureStateStarted = "started"
+	EnsureStateStopped = "stopped"
+)
+
+// the changes reported by ensure steps
+const (
+	EnsureChanged   = "changed"
+	EnsureUnchanged = "unchanged"
+)
+
+// EnsureResultPrefix starts the last line printed by the scripts of ensure steps, such as ops-ensure: changed
+const EnsureResultPrefix = "ops-ensure: "
+
+// StepTemplateGo renders the step as a Go template
+const StepTemplateGo = "go"
+
+// run of steps in the plan of dry-run
+const (
+	PlanRunTrue    = "true"
+	PlanRunFalse   = "false"
+	PlanRunUnknown = "unknown"
+)
+
+// SnippetsConfigMap keeps the snippets included by tasks in the controller, one key per snippet
+const SnippetsConfigMap = "ops-snippets"
+
+// SnippetsDir is the directory of snippets next to the tasks, used by opscli
+const SnippetsDir = "snippets"
+
+// types of variables
+const (
+	VariableTypeString   = "string"
+	VariableTypeInt      = "int"
+	VariableTypeBool     = "bool"
+	VariableTypeDuration = "duration"
+	VariableTypeList     = "list"
+	VariableTypeMap      = "map"
+	VariableTypeJson     = "json"
+)
+
+// MaskedValue replaces the values of sensitive variables in the status and logs
+const MaskedValue = "******"
+
+// filters of variables, such as ${name|shell}
+const (
+	RenderFilterShell = "shell"
+	RenderFilterJson  = "json"
+	RenderFilterRaw   = "raw"
+)
@@ -106,3 +106,17 @@ func (bus *EventBus) Subscribe(ctx context.Context, fn interface{}) error {
 	}
 	return (*client.Consumer).StartReceiver(ctx, fn)
 }
+
+// SubscribeWithNewClient subscribes with a dedicated consumer, not shared with the cached clients
+func (bus *EventBus) SubscribeWithNewClient(ctx context.Context, fn interface{}) error {
+	consumerP, err := cenats.NewConsumer(bus.Server, bus.Subject, cenats.NatsOptions())
+	if err != nil {
+		return err
+	}
+	defer consumerP.Close(context.Background())
+	consumerClient, err := cloudevents.NewClient(consumerP)
+	if err != nil {
+		return err
+	}
+	return consumerClient.StartReceiver(ctx, fn)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/history"
 )
 
 type EventController struct {
@@ -37,6 +38,17 @@ type EventTaskRun struct {
 	opsv1.TaskRunStatus
 }
 
+// EventTaskRunLog is a chunk of the live output of a step
+type EventTaskRunLog struct {
+	NodeName string `json:"nodeName"`
+	StepName string `json:"stepName"`
+	Content  string `json:"content"`
+	Finished bool   `json:"finished"`
+}
+
+// EventTaskRunHistory is a finished run sent to the history store, with the status of one node or a chunk of a step output
+type EventTaskRunHistory history.Record
+
 type EventPipeline struct {
 	opsv1.PipelineSpec
 	Status opsv1.PipelineStatus `json:"status,omitempty" yaml:"status,omitempty"`
@@ -103,6 +115,10 @@ func builderEvent(data interface{}) (cloudevents.Event, error) {
 		eventType = opsconstants.Webhook
 	case *EventTaskRunReport, EventTaskRunReport:
 		eventType = opsconstants.TaskRunReport
+	case *EventTaskRunLog, EventTaskRunLog:
+		eventType = opsconstants.TaskRunLog
+	case *EventTaskRunHistory, EventTaskRunHistory:
+		eventType = opsconstants.TaskRunHistory
 	case *EventKube, EventKube:
 		eventType = opsconstants.Kube
 	default:
@@ -4,6 +4,7 @@ import (
 	"github.com/nats-io/nats.go"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	"strings"
+	"sync"
 )
 
 // for controller
@@ -74,6 +75,11 @@ func FactoryKube(namespace string, subs ...string) *EventBus {
 	return (&EventBus{}).WithEndpoint(endpoint).WithSubject(subject)
 }
 
+// FactorySubject subscribes or publishes a raw subject, supports * and > when subscribe
+func FactorySubject(subject string) *EventBus {
+	return (&EventBus{}).WithEndpoint(endpoint).WithSubject(subject)
+}
+
 // for endpoint
 func FactoryWebhook(endpoint, cluster, namespace string, subs ...string) *EventBus {
 	subject := opsconstants.GetClusterSubject(cluster, namespace, opsconstants.SubjectWebhook)
@@ -92,15 +98,23 @@ func Factory(endpoint, cluster, namespace string, subs ...string) *EventBus {
 }
 
 var jetCache = make(map[string]*nats.JetStreamContext)
+var jetCacheMutex sync.Mutex
 
 func FactoryJetStreamClient(endpoint, cluster string) (*nats.JetStreamContext, error) {
-	if _, ok := jetCache[cluster]; !ok {
-		nc, err := nats.Connect(endpoint)
-		if err != nil {
-			return nil, err
-		}
-		js, _ := nc.JetStream()
-		jetCache[cluster] = &js
+	jetCacheMutex.Lock()
+	defer jetCacheMutex.Unlock()
+	if js, ok := jetCache[cluster]; ok {
+		return js, nil
+	}
+	nc, err := nats.Connect(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
 	}
-	return jetCache[cluster], nil
+	jetCache[cluster] = &js
+	return &js, nil
 }
@@ -49,6 +49,43 @@ func QueryStartTime(client nats.JetStreamContext, subject string, startTime time
 	return data, nil
 }
 
+// SubscribeStartTime delivers the events of subject since startTime to fn, until the subscription is unsubscribed
+func SubscribeStartTime(client nats.JetStreamContext, subject string, startTime time.Time, fn func(EventData)) (*nats.Subscription, error) {
+	return client.Subscribe(subject, func(msg *nats.Msg) {
+		e := event.Event{}
+		err := json.Unmarshal(msg.Data, &e)
+		if err != nil {
+			return
+		}
+		fn(EventData{
+			Subject: msg.Subject,
+			Event:   e,
+		})
+	}, nats.StartTime(startTime), nats.OrderedConsumer())
+}
+
+// SubscribeDurable delivers the events of subject to fn with a durable consumer, which resumes after restart.
+// The event is acked if fn returns nil, else redelivered
+func SubscribeDurable(client nats.JetStreamContext, subject, durable string, fn func(EventData) error) (*nats.Subscription, error) {
+	return client.Subscribe(subject, func(msg *nats.Msg) {
+		e := event.Event{}
+		err := json.Unmarshal(msg.Data, &e)
+		if err != nil {
+			msg.Term()
+			return
+		}
+		err = fn(EventData{
+			Subject: msg.Subject,
+			Event:   e,
+		})
+		if err != nil {
+			msg.Nak()
+			return
+		}
+		msg.Ack()
+	}, nats.Durable(durable), nats.DeliverAll(), nats.ManualAck())
+}
+
 func ListSubjects(url, streamName, search string) (results []string, err error) {
 	nc, err := nats.Connect(url)
 	if err != nil {
@@ -0,0 +1,120 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var recordsBucket = []byte("taskruns")
+
+// BoltStore keeps records in an embedded BoltDB file, keyed by namespace/taskrun/id
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Save(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(r.GetUniqueKey()), data)
+	})
+}
+
+func (s *BoltStore) Get(namespace, taskRun, id string) (r *Record, err error) {
+	key := (&Record{Namespace: namespace, TaskRun: taskRun, Id: id}).GetUniqueKey()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(recordsBucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		r = &Record{}
+		return json.Unmarshal(data, r)
+	})
+	return
+}
+
+func (s *BoltStore) List(q Query) (records []*Record, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return s.scan(tx, q, func(_ []byte, r *Record) error {
+			if q.Match(r) {
+				records = append(records, r)
+			}
+			return nil
+		})
+	})
+	sortLatestFirst(records)
+	return
+}
+
+func (s *BoltStore) Prune(before time.Time) (count int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		keys := make([][]byte, 0)
+		err := s.scan(tx, Query{}, func(key []byte, r *Record) error {
+			if r.EndTime.Before(before) {
+				keys = append(keys, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tx.Bucket(recordsBucket).Delete(key); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	return
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// scan walks the records, only the ones under namespace/taskrun if set in q
+func (s *BoltStore) scan(tx *bolt.Tx, q Query, fn func(key []byte, r *Record) error) error {
+	prefix := []byte{}
+	if q.Namespace != "" && q.TaskRun != "" {
+		prefix = []byte(q.Namespace + "/" + q.TaskRun + "/")
+	}
+	c := tx.Bucket(recordsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		r := &Record{}
+		if err := json.Unmarshal(v, r); err != nil {
+			continue
+		}
+		if err := fn(k, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in memory, lost after restart
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+func (s *MemoryStore) Save(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[r.GetUniqueKey()] = r
+	return nil
+}
+
+func (s *MemoryStore) Get(namespace, taskRun, id string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.records[(&Record{Namespace: namespace, TaskRun: taskRun, Id: id}).GetUniqueKey()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *MemoryStore) List(q Query) (records []*Record, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.records {
+		if q.Match(r) {
+			records = append(records, r)
+		}
+	}
+	sortLatestFirst(records)
+	return
+}
+
+func (s *MemoryStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for key, r := range s.records {
+		if r.EndTime.Before(before) {
+			delete(s.records, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func sortLatestFirst(records []*Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].EndTime.After(records[j].EndTime)
+	})
+}
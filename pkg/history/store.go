@@ -0,0 +1,220 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+var ErrNotFound = errors.New("history record not found")
+
+// Record is a finished run of taskrun with full step outputs, a crontab taskrun has one record per run
+type Record struct {
+	Id        string            `json:"id"`
+	Namespace string            `json:"namespace"`
+	TaskRun   string            `json:"taskRun"`
+	TaskRef   string            `json:"taskRef"`
+	Desc      string            `json:"desc"`
+	Variables map[string]string `json:"variables"`
+	EndTime   time.Time         `json:"endTime"`
+	// Chunk is set if the record is a part of a step output, see Split
+	Chunk *Chunk `json:"chunk,omitempty"`
+	opsv1.TaskRunStatus
+}
+
+// Chunk is a part of the output of a step, appended at offset of the field by SaveMerged
+type Chunk struct {
+	NodeName string `json:"nodeName"`
+	Step     int    `json:"step"`
+	// Field is stepOutput, stdout or stderr
+	Field  string `json:"field"`
+	Offset int    `json:"offset"`
+	Data   string `json:"data"`
+}
+
+func (r *Record) GetUniqueKey() string {
+	return strings.Join([]string{r.Namespace, r.TaskRun, r.Id}, "/")
+}
+
+// Summary returns a copy with step outputs truncated to max bytes
+func (r *Record) Summary(max int) *Record {
+	summary := *r
+	summary.TaskRunStatus = *r.TaskRunStatus.DeepCopy()
+	summary.TruncateOutputs(max)
+	return &summary
+}
+
+// Query filters records, the empty field matches all
+type Query struct {
+	Namespace string
+	TaskRun   string
+	TaskRef   string
+	RunStatus string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (q Query) Match(r *Record) bool {
+	if q.Namespace != "" && q.Namespace != r.Namespace {
+		return false
+	}
+	if q.TaskRun != "" && q.TaskRun != r.TaskRun {
+		return false
+	}
+	if q.TaskRef != "" && q.TaskRef != r.TaskRef {
+		return false
+	}
+	if q.RunStatus != "" && q.RunStatus != r.RunStatus {
+		return false
+	}
+	if !q.Since.IsZero() && r.EndTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.EndTime.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Store keeps run records out of the TaskRun status
+type Store interface {
+	// Save creates or replaces the record
+	Save(r *Record) error
+	Get(namespace, taskRun, id string) (*Record, error)
+	// List returns the matched records, the latest first
+	List(q Query) ([]*Record, error)
+	// Prune deletes the records ended before, returns the number deleted
+	Prune(before time.Time) (int, error)
+	Close() error
+}
+
+// NewStore opens the store by dsn, eg: bolt:///data/history.db, memory://
+func NewStore(dsn string) (Store, error) {
+	scheme, path, found := strings.Cut(dsn, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid history store %s", dsn)
+	}
+	switch scheme {
+	case "bolt":
+		return NewBoltStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unsupported history store %s", scheme)
+}
+
+// Split splits r to be sent over the event bus, the first record has no node status, then one record per node,
+// the outputs of a node over size bytes are sent in chunks of size bytes after it
+func Split(r *Record, size int) []*Record {
+	header := *r
+	header.TaskRunStatus = *r.TaskRunStatus.DeepCopy()
+	header.TaskRunNodeStatus = nil
+	records := []*Record{&header}
+	for _, nodeName := range sortedNodeNames(r.TaskRunNodeStatus) {
+		node := r.TaskRunNodeStatus[nodeName].DeepCopy()
+		record := header
+		record.TaskRunStatus.TaskRunNodeStatus = map[string]*opsv1.TaskRunNodeStatus{nodeName: node}
+		records = append(records, &record)
+		if outputSize(node) <= size {
+			continue
+		}
+		for i, step := range node.TaskRunStep {
+			for _, field := range []string{"stepOutput", "stdout", "stderr"} {
+				output := stepField(step, field)
+				for offset := 0; offset < len(*output); {
+					end := offset + size
+					if end >= len(*output) {
+						end = len(*output)
+					} else {
+						// keep the runes whole, the invalid utf-8 is replaced in json
+						for end > offset+1 && !utf8.RuneStart((*output)[end]) {
+							end--
+						}
+					}
+					chunk := header
+					chunk.Chunk = &Chunk{NodeName: nodeName, Step: i, Field: field, Offset: offset, Data: (*output)[offset:end]}
+					records = append(records, &chunk)
+					offset = end
+				}
+				*output = ""
+			}
+		}
+	}
+	return records
+}
+
+func sortedNodeNames(nodes map[string]*opsv1.TaskRunNodeStatus) []string {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func outputSize(node *opsv1.TaskRunNodeStatus) int {
+	size := 0
+	for _, step := range node.TaskRunStep {
+		size += len(step.StepOutput) + len(step.Stdout) + len(step.Stderr)
+	}
+	return size
+}
+
+func stepField(step *opsv1.TaskRunStep, field string) *string {
+	switch field {
+	case "stdout":
+		return &step.Stdout
+	case "stderr":
+		return &step.Stderr
+	}
+	return &step.StepOutput
+}
+
+// saveChunk appends the chunk to the step output of the saved record, the chunks before it must be saved
+func saveChunk(s Store, r *Record) error {
+	existing, err := s.Get(r.Namespace, r.TaskRun, r.Id)
+	if err != nil {
+		return err
+	}
+	node, ok := existing.TaskRunNodeStatus[r.Chunk.NodeName]
+	if !ok || r.Chunk.Step >= len(node.TaskRunStep) {
+		return fmt.Errorf("node %s step %d of chunk not found", r.Chunk.NodeName, r.Chunk.Step)
+	}
+	output := stepField(node.TaskRunStep[r.Chunk.Step], r.Chunk.Field)
+	if len(*output) < r.Chunk.Offset {
+		return fmt.Errorf("chunk at %d of %s is out of order", r.Chunk.Offset, r.Chunk.Field)
+	}
+	*output = (*output)[:r.Chunk.Offset] + r.Chunk.Data
+	return s.Save(existing)
+}
+
+// SaveMerged saves r, keeping the node status already saved for the run but not in r, the chunk is appended
+func SaveMerged(s Store, r *Record) error {
+	if r.Chunk != nil {
+		return saveChunk(s, r)
+	}
+	existing, err := s.Get(r.Namespace, r.TaskRun, r.Id)
+	if err == ErrNotFound {
+		return s.Save(r)
+	}
+	if err != nil {
+		return err
+	}
+	merged := *r
+	merged.TaskRunStatus = *r.TaskRunStatus.DeepCopy()
+	for nodeName, nodeStatus := range existing.TaskRunNodeStatus {
+		if _, ok := merged.TaskRunNodeStatus[nodeName]; ok {
+			continue
+		}
+		if merged.TaskRunNodeStatus == nil {
+			merged.TaskRunNodeStatus = make(map[string]*opsv1.TaskRunNodeStatus)
+		}
+		merged.TaskRunNodeStatus[nodeName] = nodeStatus
+	}
+	return s.Save(&merged)
+}
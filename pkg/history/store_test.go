@@ -0,0 +1,63 @@
+package history
+
+import (
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+func TestSplitSaveMerged(t *testing.T) {
+	output := strings.Repeat("ab中", 1000)
+	r := &Record{Id: "1", Namespace: "ops-system", TaskRun: "run"}
+	r.TaskRunNodeStatus = map[string]*opsv1.TaskRunNodeStatus{
+		"large": {TaskRunStep: []*opsv1.TaskRunStep{
+			{StepName: "s1", StepOutput: output, ExecResult: opsv1.ExecResult{Stdout: output + "x"}},
+		}},
+		"small": {TaskRunStep: []*opsv1.TaskRunStep{{StepName: "s1", StepOutput: "small"}}},
+	}
+	parts := Split(r, 100)
+	if len(parts) <= 3 {
+		t.Fatalf("got %d parts, want chunks", len(parts))
+	}
+	store := NewMemoryStore()
+	for _, part := range parts {
+		if part.Chunk != nil && len(part.Chunk.Data) > 100 {
+			t.Fatalf("chunk of %d bytes", len(part.Chunk.Data))
+		}
+		if err := SaveMerged(store, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := store.Get("ops-system", "run", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := got.TaskRunNodeStatus["large"].TaskRunStep[0]
+	if large.StepOutput != output || large.Stdout != output+"x" {
+		t.Errorf("large output is not merged")
+	}
+	if got.TaskRunNodeStatus["small"].TaskRunStep[0].StepOutput != "small" {
+		t.Errorf("small output is not saved")
+	}
+	if r.TaskRunNodeStatus["large"].TaskRunStep[0].StepOutput != output {
+		t.Errorf("record is changed by split")
+	}
+}
+
+func TestSaveChunkOutOfOrder(t *testing.T) {
+	r := &Record{Id: "1", Namespace: "ops-system", TaskRun: "run"}
+	r.TaskRunNodeStatus = map[string]*opsv1.TaskRunNodeStatus{
+		"node": {TaskRunStep: []*opsv1.TaskRunStep{{StepName: "s1", StepOutput: "0123456789"}}},
+	}
+	parts := Split(r, 4)
+	store := NewMemoryStore()
+	for _, part := range parts[:2] {
+		if err := SaveMerged(store, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveMerged(store, parts[3]); err == nil {
+		t.Errorf("chunk out of order is saved")
+	}
+}
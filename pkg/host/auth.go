@@ -0,0 +1,115 @@
+package host
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsoption "github.com/shaowenchen/ops/pkg/option"
+	opsutils "github.com/shaowenchen/ops/pkg/utils"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAgent authenticates and is forwarded to the hosts with forwardAgent, only opscli has it
+var sshAgent agent.ExtendedAgent
+
+// EnableAgent connects the ssh-agent of SSH_AUTH_SOCK
+func EnableAgent() error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("SSH_AUTH_SOCK is not set, start ssh-agent first")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return errors.Wrap(err, "connect ssh-agent failed")
+	}
+	sshAgent = agent.NewClient(conn)
+	return nil
+}
+
+// LoadAuthOption fills the passphrase and certificate of hostOpt, and enables the ssh-agent,
+// the certificate defaults to <privatekeypath>-cert.pub as OpenSSH does
+func LoadAuthOption(hostOpt *opsoption.HostOption) error {
+	if hostOpt.ForwardAgent && !hostOpt.Agent {
+		return errors.New("--forwardagent requires --agent")
+	}
+	hostOpt.Passphrase = opsutils.EncodingStringToBase64(hostOpt.Passphrase)
+	certificatePath := hostOpt.CertificatePath
+	if certificatePath == "" && hostOpt.PrivateKeyPath != "" {
+		certificatePath = hostOpt.PrivateKeyPath + "-cert.pub"
+	}
+	if hostOpt.Certificate == "" && opsutils.IsExistsFile(certificatePath) {
+		certificate, err := opsutils.ReadFile(certificatePath)
+		if err != nil {
+			return err
+		}
+		hostOpt.Certificate = opsutils.EncodingStringToBase64(certificate)
+	}
+	if hostOpt.Agent {
+		return EnableAgent()
+	}
+	return nil
+}
+
+// authSigners returns the signer of the certificate first, then the private key
+func authSigners(h *opsv1.Host) (signers []ssh.Signer, err error) {
+	privateKey, err := opsutils.DecodingBase64ToString(h.Spec.PrivateKey)
+	if err != nil || len(privateKey) == 0 {
+		return
+	}
+	passphrase, err := opsutils.DecodingBase64ToString(h.Spec.Passphrase)
+	if err != nil {
+		return
+	}
+	var signer ssh.Signer
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+	}
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, errors.New("The given SSH key is encrypted, passphrase is required")
+	}
+	if err != nil {
+		return nil, errors.New("The given SSH key could not be parsed")
+	}
+	certificate, err := opsutils.DecodingBase64ToString(h.Spec.Certificate)
+	if err != nil {
+		return
+	}
+	if len(certificate) > 0 {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+		if err != nil {
+			return nil, errors.Wrap(err, "The given SSH certificate could not be parsed")
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok {
+			return nil, errors.New("The given SSH certificate is not a certificate")
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, errors.Wrap(err, "The given SSH certificate does not match the key")
+		}
+		signers = append(signers, certSigner)
+	}
+	signers = append(signers, signer)
+	return
+}
+
+// forwardAgent serves the ssh-agent for the sessions of client, if the host opts in with forwardAgent
+func forwardAgent(h *opsv1.Host, client *ssh.Client) {
+	if sshAgent == nil || !h.Spec.ForwardAgent {
+		return
+	}
+	agent.ForwardToAgent(client, sshAgent)
+}
+
+// requestAgentForwarding asks the host to forward the agent in sess, if the host opts in with forwardAgent
+func requestAgentForwarding(h *opsv1.Host, sess *ssh.Session) {
+	if sshAgent == nil || !h.Spec.ForwardAgent {
+		return
+	}
+	agent.RequestAgentForwarding(sess)
+}
@@ -0,0 +1,34 @@
+package host
+
+import (
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsoption "github.com/shaowenchen/ops/pkg/option"
+)
+
+func TestLoadAuthOptionForwardAgent(t *testing.T) {
+	err := LoadAuthOption(&opsoption.HostOption{ForwardAgent: true})
+	if err == nil {
+		t.Errorf("ForwardAgent without Agent: want error")
+	}
+	if err := LoadAuthOption(&opsoption.HostOption{}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestForwardAgentOptIn(t *testing.T) {
+	h := &opsv1.Host{Spec: opsv1.HostSpec{Address: "10.0.0.1", Port: 22}}
+	h.FilledByOption(opsoption.HostOption{Agent: true})
+	if h.Spec.ForwardAgent {
+		t.Errorf("ForwardAgent is enabled by Agent")
+	}
+	key := connectionCacheKey(h)
+	h.FilledByOption(opsoption.HostOption{Agent: true, ForwardAgent: true})
+	if !h.Spec.ForwardAgent {
+		t.Errorf("ForwardAgent is not enabled by ForwardAgent")
+	}
+	if connectionCacheKey(h) == key {
+		t.Errorf("the connection forwarding agent shares the cache key %s", key)
+	}
+}
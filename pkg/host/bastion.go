@@ -0,0 +1,126 @@
+package host
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	"golang.org/x/crypto/ssh"
+)
+
+const maxBastionHops = 8
+
+// bastionGetter gets the Host referred by bastionRef with credentials filled
+var bastionGetter func(namespace, name string) (*opsv1.Host, error)
+
+// SetBastionGetter enables bastionRef, opscli only supports the inline proxyJump
+func SetBastionGetter(getter func(namespace, name string) (*opsv1.Host, error)) {
+	bastionGetter = getter
+}
+
+// connectionCacheKey distinguishes the same private address behind different bastions,
+// and the hosts of the same address with different credentials
+func connectionCacheKey(h *opsv1.Host) string {
+	credentials := sha256.Sum256([]byte(strings.Join([]string{h.Spec.Username, h.Spec.Password, h.Spec.PrivateKey, h.Spec.Passphrase, h.Spec.Certificate, h.GetHostKeyPolicy(), strconv.FormatBool(h.Spec.ForwardAgent)}, "\n")))
+	key := fmt.Sprintf("%s:%d#%x", h.Spec.Address, h.Spec.Port, credentials[:8])
+	if h.Spec.BastionRef != "" {
+		key = h.Namespace + "/" + h.Spec.BastionRef + "/" + key
+	}
+	if h.Spec.ProxyJump != "" {
+		key = h.Spec.ProxyJump + "/" + key
+	}
+	return key
+}
+
+// getBastions returns the jump hosts of h in the order to dial, the hosts of bastionRef first, then proxyJump
+func getBastions(h *opsv1.Host) ([]*opsv1.Host, error) {
+	return appendBastions(nil, h, map[string]bool{})
+}
+
+func appendBastions(bastions []*opsv1.Host, h *opsv1.Host, visited map[string]bool) ([]*opsv1.Host, error) {
+	if h.Spec.BastionRef != "" {
+		if bastionGetter == nil {
+			return nil, fmt.Errorf("bastionRef %s is not supported here, use proxyJump instead", h.Spec.BastionRef)
+		}
+		key := h.Namespace + "/" + h.Spec.BastionRef
+		if visited[key] {
+			return nil, fmt.Errorf("bastionRef %s is a loop", key)
+		}
+		visited[key] = true
+		bastion, err := bastionGetter(h.Namespace, h.Spec.BastionRef)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get bastion %s failed", key)
+		}
+		bastions, err = appendBastions(bastions, bastion, visited)
+		if err != nil {
+			return nil, err
+		}
+		bastions = append(bastions, bastion)
+	}
+	jumps, err := h.GetProxyJumps()
+	if err != nil {
+		return nil, err
+	}
+	bastions = append(bastions, jumps...)
+	if len(bastions) > maxBastionHops {
+		return nil, fmt.Errorf("too many bastions for %s, at most %d", h.Spec.Address, maxBastionHops)
+	}
+	return bastions, nil
+}
+
+// dialThroughBastions dials h with sshConfig, tunneled through the bastions of h
+func dialThroughBastions(h *opsv1.Host, sshConfig *ssh.ClientConfig) (client *ssh.Client, bastionClients []*ssh.Client, err error) {
+	bastions, err := getBastions(h)
+	if err != nil {
+		return
+	}
+	var last *ssh.Client
+	for _, bastion := range bastions {
+		config, err := sshClientConfig(bastion)
+		if err != nil {
+			closeClients(bastionClients)
+			return nil, nil, errors.Wrapf(err, "bastion %s", bastion.Spec.Address)
+		}
+		last, err = dialHop(last, bastion, config)
+		if err != nil {
+			closeClients(bastionClients)
+			return nil, nil, errors.Wrapf(err, "dial bastion %s failed", bastion.Spec.Address)
+		}
+		bastionClients = append(bastionClients, last)
+	}
+	client, err = dialHop(last, h, sshConfig)
+	if err != nil {
+		closeClients(bastionClients)
+		return nil, nil, err
+	}
+	return
+}
+
+// dialHop dials h directly if through is nil, otherwise over the connection of through
+func dialHop(through *ssh.Client, h *opsv1.Host, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	endpoint := net.JoinHostPort(h.Spec.Address, strconv.Itoa(h.Spec.Port))
+	if through == nil {
+		return ssh.Dial("tcp", endpoint, sshConfig)
+	}
+	conn, err := through.Dial("tcp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, endpoint, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// closeClients closes the tunneled clients from the nearest
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -6,20 +6,20 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"net"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	scp "github.com/bramvdbogaerde/go-scp"
 	"github.com/pkg/errors"
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opslog "github.com/shaowenchen/ops/pkg/log"
 	opsoption "github.com/shaowenchen/ops/pkg/option"
 	opsstorage "github.com/shaowenchen/ops/pkg/storage"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
@@ -44,10 +44,29 @@ func (c *HostConnectionCache) Set(key string, value *HostConnection) {
 	c.cache[key] = value
 }
 
+func (c *HostConnectionCache) Delete(key string) {
+	c.Mutex.Lock()
+	hc, ok := c.cache[key]
+	delete(c.cache, key)
+	c.Mutex.Unlock()
+	if ok {
+		hc.close()
+	}
+}
+
 type HostConnection struct {
 	Host      *opsv1.Host
-	scpclient *scp.Client
 	sshclient *ssh.Client
+	// bastionclients are the jump hosts the sshclient tunnels through, the nearest last
+	bastionclients []*ssh.Client
+	// mutex guards the clients replaced on reconnect
+	mutex sync.RWMutex
+	// sessions limits the concurrent sessions on the host
+	sessions chan struct{}
+	// active and lastUsed decide whether the connection is idle
+	active        int32
+	lastUsed      int64
+	keepaliveStop chan struct{}
 }
 
 var hcCache = HostConnectionCache{cache: make(map[string]*HostConnection), Mutex: &sync.RWMutex{}}
@@ -66,11 +85,18 @@ func NewHostConnBase64(h *opsv1.Host) (hc *HostConnection, err error) {
 	if h.Spec.Address == opsconstants.LocalHostIP {
 		return hc, nil
 	}
-	key := fmt.Sprintf("%s:%d", h.Spec.Address, h.Spec.Port)
-	if hc := hcCache.Get(key); hc != nil {
-		return hc, nil
+	key := connectionCacheKey(h)
+	if cached := hcCache.Get(key); cached != nil {
+		// the host is recreated or changed
+		sameHost := cached.Host.Namespace == h.Namespace && cached.Host.Name == h.Name
+		if !sameHost || (cached.Host.UID == h.UID && cached.Host.Generation >= h.Generation) {
+			return cached, nil
+		}
+		hcCache.Delete(key)
 	}
-	err = hc.connecting()
+	hc.sessions = make(chan struct{}, h.GetMaxSessions())
+	hc.touch()
+	err = hc.reconnect(nil)
 	if err != nil {
 		return nil, err
 	}
@@ -79,18 +105,27 @@ func NewHostConnBase64(h *opsv1.Host) (hc *HostConnection, err error) {
 }
 
 func (c *HostConnection) Shell(ctx context.Context, sudo bool, content string) (stdout string, err error) {
+	result, err := c.ShellResult(ctx, opsoption.ShellOption{Sudo: sudo, Content: content})
+	return result.GetOutput(), err
+}
+
+// ShellResult runs the content with the executor, env and workdir of shellOpt,
+// and returns the stdout, stderr and exit code separately
+func (c *HostConnection) ShellResult(ctx context.Context, shellOpt opsoption.ShellOption) (result *opsv1.ExecResult, err error) {
+	content := shellOpt.Content
 	reg := regexp.MustCompile(`\${[^\}]*}`)
 	funcStrList := reg.FindAllString(content, -1)
 	for _, callFunc := range funcStrList {
 		rawCallFunc := callFunc
 		callFunc = callFunc[2 : len(callFunc)-1]
-		stdout, err = c.shellFuncMap(ctx, sudo, callFunc)
+		stdout, err := c.shellFuncMap(ctx, shellOpt.Sudo, callFunc)
 		if err != nil {
-			return stdout, err
+			return &opsv1.ExecResult{Stdout: stdout}, err
 		}
 		content = strings.ReplaceAll(content, rawCallFunc, stdout)
 	}
-	return c.execScript(ctx, sudo, content)
+	shellOpt.Content = content
+	return c.RunWithExecutor(ctx, shellOpt)
 }
 
 func (c *HostConnection) shellFuncMap(ctx context.Context, sudo bool, funcFull string) (stdout string, err error) {
@@ -298,173 +333,299 @@ func (c *HostConnection) GetStatus(ctx context.Context, sudo bool) (status *opsv
 	return
 }
 
-func (c *HostConnection) session() (*ssh.Session, error) {
-	if c.sshclient == nil {
-		return nil, errors.New("connection closed")
+// session opens a session, with pty if required, reconnecting once if the connection is broken,
+// the slot of the host is released when the session is closed
+func (c *HostConnection) session(ctx context.Context, pty bool) (*hostSession, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := c.getSSHClient()
+	sess, err := newExecSession(client, pty)
+	if err != nil && isBrokenConnection(err) {
+		if err = c.reconnect(client); err == nil {
+			sess, err = newExecSession(c.getSSHClient(), pty)
+		}
 	}
-	sess, err := c.sshclient.NewSession()
 	if err != nil {
+		release()
 		return nil, err
 	}
+	requestAgentForwarding(c.Host, sess)
+	return &hostSession{Session: sess, release: release}, nil
+}
 
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+func newExecSession(client *ssh.Client, pty bool) (*ssh.Session, error) {
+	if client == nil {
+		return nil, errors.New("connection closed")
 	}
-
-	err = sess.RequestPty("xterm", 100, 50, modes)
+	sess, err := client.NewSession()
 	if err != nil {
 		return nil, err
 	}
+	if pty {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,     // disable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+		err = sess.RequestPty("xterm", 100, 50, modes)
+		if err != nil {
+			sess.Close()
+			return nil, err
+		}
+	}
 	return sess, nil
 }
 
+// connecting dials the host, the caller holds the mutex
 func (c *HostConnection) connecting() (err error) {
-	password, err := opsutils.DecodingBase64ToString(c.Host.Spec.Password)
+	sshConfig, err := sshClientConfig(c.Host)
 	if err != nil {
 		return err
 	}
-	privateKey, err := opsutils.DecodingBase64ToString(c.Host.Spec.PrivateKey)
+	c.sshclient, c.bastionclients, err = dialThroughBastions(c.Host, sshConfig)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "client.Dial failed %s", c.Host.Spec.Address)
+	}
+	forwardAgent(c.Host, c.sshclient)
+	c.keepaliveStop = make(chan struct{})
+	go c.keepalive(c.sshclient, c.keepaliveStop)
+	return nil
+}
+
+func sshClientConfig(h *opsv1.Host) (*ssh.ClientConfig, error) {
+	password, err := opsutils.DecodingBase64ToString(h.Spec.Password)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := authSigners(h)
+	if err != nil {
+		return nil, err
 	}
 	authMethods := make([]ssh.AuthMethod, 0)
+	if len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	}
+	if sshAgent != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(sshAgent.Signers))
+	}
 	if len(password) > 0 {
 		authMethods = append(authMethods, ssh.Password(password))
 	}
-
-	if len(privateKey) > 0 {
-		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
-		if err != nil {
-			return errors.New("The given SSH key could not be parsed")
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	}
-	sshConfig := &ssh.ClientConfig{
-		User:            c.Host.Spec.Username,
-		Timeout:         time.Duration(c.Host.Spec.TimeOutSeconds) * time.Second,
+	return &ssh.ClientConfig{
+		User:            h.Spec.Username,
+		Timeout:         time.Duration(h.Spec.TimeOutSeconds) * time.Second,
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback(h),
 		Config:          ssh.Config{},
-	}
-
-	endpointBehindBastion := net.JoinHostPort(c.Host.Spec.Address, strconv.Itoa(c.Host.Spec.Port))
-
-	c.sshclient, err = ssh.Dial("tcp", endpointBehindBastion, sshConfig)
-	if err != nil {
-		return errors.Wrapf(err, "client.Dial failed %s", c.Host.Spec.Address)
-	}
-	client, err := scp.NewClientBySSH(c.sshclient)
-	c.scpclient = &client
-	if err != nil {
-		return errors.Wrapf(err, "scp.NewClient failed")
-	}
-	return nil
+	}, nil
 }
 
 func (c *HostConnection) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closeClients()
+}
+
+// closeClients closes the clients and stops the keepalive, the caller holds the mutex
+func (c *HostConnection) closeClients() {
+	if c.keepaliveStop != nil {
+		close(c.keepaliveStop)
+		c.keepaliveStop = nil
+	}
 	if c.sshclient != nil {
 		c.sshclient.Close()
+		c.sshclient = nil
 	}
-	if c.scpclient != nil {
-		c.scpclient.Close()
-	}
+	closeClients(c.bastionclients)
+	c.bastionclients = nil
 }
 
-func (c *HostConnection) execSh(ctx context.Context, sudo bool, cmd string) (stdout string, err error) {
-	return c.ExecWithExecutor(ctx, sudo, "sh", "-c", cmd)
-}
-
-func (c *HostConnection) execPython(ctx context.Context, sudo bool, cmd string) (stdout string, err error) {
-	return c.ExecWithExecutor(ctx, sudo, "python3", "-c", cmd)
+func (c *HostConnection) execScript(ctx context.Context, sudo bool, cmd string) (stdout string, err error) {
+	result, err := c.execScriptResult(ctx, sudo, cmd)
+	return result.GetOutput(), err
 }
 
-func (c *HostConnection) execScript(ctx context.Context, sudo bool, cmd string) (stdout string, err error) {
-	lines := strings.Split(cmd, "\n")
-	if len(lines) > 1 && strings.Contains(lines[0], "python") {
-		return c.execPython(ctx, sudo, cmd)
-	}
-	return c.execSh(ctx, sudo, cmd)
+func (c *HostConnection) execScriptResult(ctx context.Context, sudo bool, cmd string) (*opsv1.ExecResult, error) {
+	return c.RunWithExecutor(ctx, opsoption.ShellOption{Sudo: sudo, Content: cmd})
 }
 
 func (c *HostConnection) ExecWithExecutor(ctx context.Context, sudo bool, executor, param, cmd string) (stdout string, err error) {
-	cmd = opsutils.BuildBase64CmdWithExecutor(sudo, cmd, executor)
+	result, err := c.RunWithExecutor(ctx, opsoption.ShellOption{Sudo: sudo, Content: cmd, Executor: executor})
+	return result.GetOutput(), err
+}
+
+// RunWithExecutor runs the content with the executor, env and workdir of shellOpt and keeps the stdout
+// and stderr separately, except that they are merged by the pty when sudo may prompt for the password.
+// Without the pty, the command runs in its own process group, killed from another session when ctx is done,
+// sshd ignores the signal of the session in many versions, the pty is closed with the session and hangs up the command
+func (c *HostConnection) RunWithExecutor(ctx context.Context, shellOpt opsoption.ShellOption) (result *opsv1.ExecResult, err error) {
+	executor := shellOpt.Executor
+	if executor == "" {
+		executor = opsutils.GetDefaultExecutor(shellOpt.Content)
+	}
+	sudo := shellOpt.Sudo
+	cmd := opsutils.BuildBase64CmdWithEnv(sudo, shellOpt.Content, executor, shellOpt.Env, shellOpt.WorkDir)
+	result = &opsv1.ExecResult{}
+	start := time.Now()
+	defer func() {
+		result.SetTime(start, time.Now())
+	}()
 	// run in localhost
 	if c.Host.Spec.Address == opsconstants.LocalHostIP {
-		runner := exec.Command("bash", "-c", cmd)
+		runner := exec.CommandContext(ctx, "bash", "-c", cmd)
 		if sudo {
-			runner = exec.Command("sudo", "bash", "-c", cmd)
+			runner = exec.CommandContext(ctx, "sudo", "bash", "-c", cmd)
+		}
+		var stdout, stderr bytes.Buffer
+		runner.Stdout = &stdout
+		runner.Stderr = &stderr
+		if stream := opslog.GetStream(ctx); stream != nil {
+			runner.Stdout = io.MultiWriter(&stdout, stream)
+			runner.Stderr = io.MultiWriter(&stderr, stream)
 		}
-		var out, errout bytes.Buffer
-		runner.Stdout = &out
-		runner.Stderr = &errout
 		err = runner.Run()
-		if err != nil {
-			stdout = errout.String()
-			return
+		result.Stdout, result.Stderr = stdout.String(), stderr.String()
+		setExitCode(result, err)
+		if ctx.Err() != nil {
+			result.ExitCode = nil
+			err = errors.Wrap(ctx.Err(), "command killed")
 		}
-		stdout = out.String()
 		return
 	}
-	sess, err := c.session()
+	pty := sudo && len(c.Host.Spec.Password) > 0
+	pgidFile := ""
+	if !pty {
+		pgidFile = fmt.Sprintf("/tmp/.ops-%d-%d.pgid", time.Now().UnixNano(), atomic.AddUint64(&execCounter, 1))
+		cmd = opsutils.ShellProcessGroup(cmd, pgidFile)
+	}
+	sess, err := c.session(ctx, pty)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get SSH session")
+		return result, errors.Wrap(err, "failed to get SSH session")
 	}
 	defer sess.Close()
+	// kill the session when ctx is done, unblock the reading
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if pgidFile != "" {
+				c.killProcessGroup(sudo, pgidFile)
+			}
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+		case <-done:
+		}
+	}()
 
+	var stdout, stderr bytes.Buffer
+	printer := &consolePrinter{start: start}
+	if pty {
+		err = c.runPty(sess, cmd, execWriter(ctx, &stdout, printer))
+	} else {
+		sess.Stdout = execWriter(ctx, &stdout, printer)
+		sess.Stderr = execWriter(ctx, &stderr, printer)
+		err = sess.Run(cmd)
+	}
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	setExitCode(result, err)
+	if ctx.Err() != nil {
+		result.ExitCode = nil
+		err = errors.Wrap(ctx.Err(), "session killed")
+	}
+	return
+}
+
+// execCounter makes the process group files of the concurrent commands unique
+var execCounter uint64
+
+// killProcessGroup kills the command of pgidFile in a new session, not waiting for a slot of the host
+func (c *HostConnection) killProcessGroup(sudo bool, pgidFile string) {
+	sess, err := newExecSession(c.getSSHClient(), false)
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+	timer := time.AfterFunc(opsconstants.SSHKeepAliveIntervalSeconds*time.Second, func() { sess.Close() })
+	defer timer.Stop()
+	sess.Run(opsutils.ShellKillProcessGroup(sudo, pgidFile))
+}
+
+// runPty runs the cmd in the pty session, answering the password prompt of sudo
+func (c *HostConnection) runPty(sess *hostSession, cmd string, output io.Writer) error {
 	in, _ := sess.StdinPipe()
 	out, _ := sess.StdoutPipe()
-	err = sess.Start(cmd)
+	err := sess.Start(cmd)
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	var (
-		output []byte
-		line   = ""
-		r      = bufio.NewReader(out)
-	)
-	printLogStream := false
-	hasPrintCache := false
-	time.AfterFunc(time.Second*3, func() {
-		printLogStream = true
-	})
+	line := ""
+	r := bufio.NewReader(out)
 	for {
-		select {
-		case <-ctx.Done():
-			goto END
-		default:
-			b, err := r.ReadByte()
+		b, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		output.Write([]byte{b})
+		if b == byte('\n') {
+			line = ""
+			continue
+		}
+		line += string(b)
+		if (strings.HasPrefix(line, "[sudo] password for ") || strings.HasPrefix(line, "Password")) && strings.HasSuffix(line, ": ") {
+			_, err = in.Write([]byte(c.Host.Spec.Password + "\n"))
 			if err != nil {
-				goto END
-			}
-			output = append(output, b)
-			if printLogStream && !hasPrintCache {
-				fmt.Print(string(output))
-				hasPrintCache = !hasPrintCache
-			}
-			if b == byte('\n') {
-				if printLogStream {
-					fmt.Print(line)
-				}
-				line = ""
-				continue
+				break
 			}
+		}
+	}
+	return sess.Wait()
+}
 
-			line += string(b)
+// setExitCode gets the exit code from the error of ssh session or local command,
+// it's left empty if the command is not exited normally
+func setExitCode(result *opsv1.ExecResult, err error) {
+	var sshErr *ssh.ExitError
+	var execErr *exec.ExitError
+	if err == nil {
+		result.SetExitCode(0)
+	} else if errors.As(err, &sshErr) {
+		result.SetExitCode(sshErr.ExitStatus())
+	} else if errors.As(err, &execErr) && execErr.ExitCode() >= 0 {
+		result.SetExitCode(execErr.ExitCode())
+	}
+}
 
-			if (strings.HasPrefix(line, "[sudo] password for ") || strings.HasPrefix(line, "Password")) && strings.HasSuffix(line, ": ") {
-				_, err = in.Write([]byte(c.Host.Spec.Password + "\n"))
-				if err != nil {
-					goto END
-				}
-			}
-		}
+// execWriter writes the output to buf, the stream of ctx and the console
+func execWriter(ctx context.Context, buf *bytes.Buffer, printer io.Writer) io.Writer {
+	if stream := opslog.GetStream(ctx); stream != nil {
+		return io.MultiWriter(buf, printer, stream)
+	}
+	return io.MultiWriter(buf, printer)
+}
+
+// consolePrinter prints the output to the console once the command runs longer than 3 seconds
+type consolePrinter struct {
+	start time.Time
+	cache []byte
+	mutex sync.Mutex
+}
+
+func (p *consolePrinter) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if time.Since(p.start) < 3*time.Second {
+		p.cache = append(p.cache, b...)
+		return len(b), nil
+	}
+	if p.cache != nil {
+		fmt.Print(string(p.cache))
+		p.cache = nil
 	}
-END:
-	err = sess.Wait()
-	return strings.TrimRight(string(output), "\r\n"), err
+	fmt.Print(string(b))
+	return len(b), nil
 }
 
 func (c *HostConnection) mv(ctx context.Context, sudo bool, src, dst string) (stdout string, err error) {
@@ -556,7 +717,12 @@ func (c *HostConnection) scpPull(ctx context.Context, sudo bool, src, dst string
 	defer dstFile.Close()
 	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
 	defer cancel()
-	err = c.scpclient.CopyFromRemote(ctx, dstFile, src)
+	scpclient, err := c.scpSession(ctx)
+	if err != nil {
+		return
+	}
+	err = scpclient.CopyFromRemote(ctx, dstFile, src)
+	scpclient.Close()
 
 	if err != nil {
 		return
@@ -595,7 +761,16 @@ func (c *HostConnection) scpPush(ctx context.Context, sudo bool, src, dst string
 	}
 	src = opsutils.GetAbsoluteFilePath(src)
 	srcFile, err := os.Open(src)
-	err = c.scpclient.CopyFromFile(context.Background(), *srcFile, dst, "0655")
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	scpclient, err := c.scpSession(ctx)
+	if err != nil {
+		return err
+	}
+	err = scpclient.CopyFromFile(context.Background(), *srcFile, dst, "0655")
+	scpclient.Close()
 
 	if err != nil {
 		return err
@@ -618,7 +793,7 @@ func (c *HostConnection) scpPush(ctx context.Context, sudo bool, src, dst string
 
 func (c *HostConnection) fileMd5(ctx context.Context, sudo bool, filepath string) (md5 string, err error) {
 	filepath = opsutils.GetAbsoluteFilePath(filepath)
-	cmd := fmt.Sprintf("md5sum %s | cut -d\" \" -f1", filepath)
+	cmd := opsutils.ShellFileMd5(filepath)
 	if sudo {
 		cmd = fmt.Sprintf("sudo %s", cmd)
 	}
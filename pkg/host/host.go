@@ -2,12 +2,17 @@ package host
 
 import (
 	"context"
+	"strings"
+
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	"github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/log"
 	"github.com/shaowenchen/ops/pkg/option"
 	"github.com/shaowenchen/ops/pkg/utils"
-	"strings"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func File(ctx context.Context, logger *log.Logger, h *opsv1.Host, hostOpt option.HostOption, fileOpt option.FileOption) (output string, err error) {
@@ -37,10 +42,152 @@ func Shell(ctx context.Context, logger *log.Logger, h *opsv1.Host, option option
 	return
 }
 
-func GetHosts(logger *log.Logger, clusterOpt option.ClusterOption, hostOpt option.HostOption, inventory string) (hosts []*opsv1.Host) {
-	hs, _ := utils.AnalysisHostsParameter(inventory)
-	for _, addr := range hs {
-		hosts = append(hosts, opsv1.NewHost(clusterOpt.Namespace, strings.ReplaceAll(addr, ".", "-"), addr, hostOpt.Port, hostOpt.Username, hostOpt.Password, hostOpt.PrivateKey, hostOpt.PrivateKeyPath, constants.DefaultSSHTimeoutSeconds, hostOpt.SecretRef))
+// InventoryHost is a host of inventory with its variables
+type InventoryHost struct {
+	Host      *opsv1.Host
+	Variables map[string]string
+}
+
+func GetHosts(logger *log.Logger, clusterOpt option.ClusterOption, hostOpt option.HostOption, inventory string) (hosts []*opsv1.Host, err error) {
+	ihs, err := GetInventoryHosts(logger, clusterOpt, hostOpt, inventory)
+	if err != nil {
+		return
+	}
+	for _, ih := range ihs {
+		hosts = append(hosts, ih.Host)
+	}
+	return
+}
+
+// GetInventoryHosts returns the hosts of inventory selected by hostOpt.Limit,
+// the fields not set in inventory are filled by hostOpt, the inventory failed to load or parse is an error
+func GetInventoryHosts(logger *log.Logger, clusterOpt option.ClusterOption, hostOpt option.HostOption, inventory string) (hosts []InventoryHost, err error) {
+	inv, err := utils.LoadInventory(inventory)
+	if err != nil {
+		return
+	}
+	ihs, err := inv.GetHosts(hostOpt.Limit)
+	if err != nil {
+		return
+	}
+	for _, ih := range ihs {
+		h := opsv1.NewHost(clusterOpt.Namespace, strings.ReplaceAll(ih.GetName(), ".", "-"), ih.Address, hostOpt.Port, hostOpt.Username, hostOpt.Password, hostOpt.PrivateKey, hostOpt.PrivateKeyPath, constants.DefaultSSHTimeoutSeconds, hostOpt.SecretRef)
+		h.Spec.HostKeyPolicy = hostOpt.HostKeyPolicy
+		h.Spec.ProxyJump = hostOpt.Bastion
+		h.Spec.Passphrase = hostOpt.Passphrase
+		h.Spec.Certificate = hostOpt.Certificate
+		h.Spec.ForwardAgent = hostOpt.ForwardAgent
+		if ih.Port != 0 {
+			h.Spec.Port = ih.Port
+		}
+		if ih.Username != "" {
+			h.Spec.Username = ih.Username
+		}
+		if ih.Password != "" {
+			h.Spec.Password = utils.EncodingStringToBase64(ih.Password)
+		}
+		if ih.PrivateKeyPath != "" {
+			privateKey, err := utils.ReadFile(ih.PrivateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			h.Spec.PrivateKeyPath = ih.PrivateKeyPath
+			h.Spec.PrivateKey = utils.EncodingStringToBase64(privateKey)
+			// the certificate of flags is for another key
+			h.Spec.Certificate = ""
+			if certificate, err := utils.ReadFile(ih.PrivateKeyPath + "-cert.pub"); err == nil {
+				h.Spec.Certificate = utils.EncodingStringToBase64(certificate)
+			}
+		}
+		if ih.Bastion != "" {
+			h.Spec.ProxyJump = ih.Bastion
+		}
+		if len(ih.Labels) > 0 {
+			h.Labels = ih.Labels
+		}
+		hosts = append(hosts, InventoryHost{Host: h, Variables: ih.Vars})
+	}
+	return
+}
+
+// GetTaskHosts returns the Host objects selected by hostStr of the task: a host name, a label selector,
+// or any node of the cluster with a Host of the same address
+func GetTaskHosts(ctx context.Context, logger *log.Logger, c client.Client, t *opsv1.Task, hostStr string) (hosts []opsv1.Host) {
+	if t.Spec.RuntimeImage != "" {
+		return
+	}
+	// empty host
+	if len(hostStr) == 0 {
+		return
+	}
+	// anynode
+	if constants.IsAnyKubeNode(hostStr) {
+		nodes, err := utils.GetAllReadyNodesByReconcileClient(c)
+		if err != nil {
+			logger.Error.Println(err, "failed to list nodes")
+			return
+		}
+		allHosts := &opsv1.HostList{}
+		err = c.List(ctx, allHosts)
+		if err != nil {
+			logger.Error.Println(err, "failed to list hosts")
+		}
+		logger.Info.Println("any: ", len(nodes.Items), len(allHosts.Items))
+		// find node
+		var targetNode *corev1.Node
+		for _, node := range nodes.Items {
+			if utils.IsMasterNode(&node) && constants.IsAnyMaster(hostStr) {
+				targetNode = &node
+			} else if !utils.IsMasterNode(&node) && constants.IsAnyWorker(hostStr) {
+				targetNode = &node
+			} else if constants.IsAnyNode(hostStr) {
+				targetNode = &node
+			}
+			if targetNode != nil {
+				break
+			}
+		}
+		if targetNode == nil {
+			return
+		}
+		logger.Info.Println(targetNode.Name)
+		// find host
+		for _, host := range allHosts.Items {
+			if host.Spec.Address == utils.GetNodeInternalIp(targetNode) {
+				return []opsv1.Host{host}
+			}
+		}
+
+	}
+	// single host
+	if !strings.Contains(t.Spec.Host, "=") {
+		host := opsv1.Host{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: t.GetNamespace(), Name: hostStr}, &host)
+		if err != nil {
+			return
+		}
+		hosts = append(hosts, host)
+		return
+	}
+	// selector host, eg: az=cn-hangzhou
+	hostList := &opsv1.HostList{}
+	selector, err := metav1.ParseToLabelSelector(t.Spec.Host)
+	if err != nil {
+		logger.Error.Println(err, "failed to parse label selector")
+		return
+	}
+	labelMap, err := metav1.LabelSelectorAsMap(selector)
+	if err != nil {
+		logger.Error.Println(err, "failed to convert label selector to map")
+		return
+	}
+	err = c.List(ctx, hostList, client.MatchingLabels(labelMap))
+	if err != nil {
+		logger.Error.Println(err, "failed to list hosts")
+		return
+	}
+	for _, h := range hostList.Items {
+		hosts = append(hosts, h)
 	}
 	return
 }
@@ -0,0 +1,67 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+	"github.com/shaowenchen/ops/pkg/utils"
+)
+
+func TestGetInventoryHosts(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_web")
+	os.WriteFile(keyPath, []byte("private key"), 0600)
+	inventory := filepath.Join(dir, "hosts")
+	os.WriteFile(inventory, []byte(`[all:vars]
+ansible_user=root
+
+[web]
+web1 ansible_host=10.0.0.1
+web2 ansible_host=10.0.0.2 ansible_user=admin
+
+[web:vars]
+ansible_port=2222
+ansible_password=secret
+ansible_ssh_private_key_file=`+keyPath+`
+role=web
+`), 0600)
+	logger := log.NewLogger().Build()
+	hostOpt := option.HostOption{Port: 22, Username: "ops", Limit: "web"}
+	ihs, err := GetInventoryHosts(logger, option.ClusterOption{}, hostOpt, inventory)
+	if err != nil || len(ihs) != 2 {
+		t.Fatalf("GetInventoryHosts = %v, %v", ihs, err)
+	}
+	for i, username := range []string{"root", "admin"} {
+		spec := ihs[i].Host.Spec
+		if spec.Username != username || spec.Port != 2222 || spec.PrivateKeyPath != keyPath {
+			t.Errorf("%s: spec = %+v", spec.Address, spec)
+		}
+		if spec.Password != utils.EncodingStringToBase64("secret") || spec.PrivateKey != utils.EncodingStringToBase64("private key") {
+			t.Errorf("%s: password or private key is not set from the group vars", spec.Address)
+		}
+		if _, ok := ihs[i].Variables["ansible_user"]; ok || ihs[i].Variables["role"] != "web" {
+			t.Errorf("%s: variables = %v", spec.Address, ihs[i].Variables)
+		}
+	}
+
+	errors := map[string]string{
+		"[web:meta]\n": "unknown section",
+		"[web]\n10.0.0.1\n[web:vars]\nansible_port=ssh\n":                                      "invalid port ssh",
+		"[web]\n10.0.0.1 ansible_ssh_private_key_file=" + filepath.Join(dir, "missing") + "\n": "missing",
+	}
+	for content, want := range errors {
+		os.WriteFile(inventory, []byte(content), 0600)
+		_, err := GetInventoryHosts(logger, option.ClusterOption{}, option.HostOption{}, inventory)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("inventory %q: error = %v, want %q", content, err, want)
+		}
+	}
+	_, err = GetHosts(logger, option.ClusterOption{}, option.HostOption{Limit: "db"}, "10.0.0.1,10.0.0.2")
+	if err == nil || !strings.Contains(err.Error(), "no host matches limit db") {
+		t.Errorf("limit db: error = %v", err)
+	}
+}
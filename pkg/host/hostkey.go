@@ -0,0 +1,250 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HostKeyStore keeps the trusted host keys in authorized_keys format
+type HostKeyStore interface {
+	// GetHostKey returns empty if the host key is not recorded
+	GetHostKey(h *opsv1.Host) (string, error)
+	SetHostKey(h *opsv1.Host, key string) error
+}
+
+var hostKeyStore HostKeyStore = NewKnownHostsStore(opsconstants.GetOpsKnownHostsPath())
+
+// SetHostKeyStore replaces the default known_hosts file store, the controller records keys in HostStatus
+func SetHostKeyStore(store HostKeyStore) {
+	hostKeyStore = store
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// hostKeyCallback verifies the host key by the policy of host
+func hostKeyCallback(h *opsv1.Host) ssh.HostKeyCallback {
+	if h.GetHostKeyPolicy() == opsconstants.HostKeyPolicyInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		trusted, err := hostKeyStore.GetHostKey(h)
+		if err != nil {
+			return errors.Wrapf(err, "get host key of %s failed", h.Spec.Address)
+		}
+		if trusted == "" {
+			if h.GetHostKeyPolicy() == opsconstants.HostKeyPolicyStrict {
+				return fmt.Errorf("host key of %s is unknown, fingerprint %s, trust it with `opscli rekey host`", h.Spec.Address, ssh.FingerprintSHA256(key))
+			}
+			return hostKeyStore.SetHostKey(h, MarshalHostKey(key))
+		}
+		trustedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(trusted))
+		if err != nil {
+			return errors.Wrapf(err, "parse host key of %s failed", h.Spec.Address)
+		}
+		if !bytes.Equal(trustedKey.Marshal(), key.Marshal()) {
+			return fmt.Errorf("host key of %s mismatch, got fingerprint %s, want %s, if the host is reinstalled, trust the new key with `opscli rekey host`", h.Spec.Address, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(trustedKey))
+		}
+		return nil
+	}
+}
+
+// MarshalHostKey formats the key as a line of authorized_keys without newline
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// ScanHostKey returns the host key presented by the host, without authentication
+func ScanHostKey(h *opsv1.Host) (key ssh.PublicKey, err error) {
+	timeout := time.Duration(h.Spec.TimeOutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = opsconstants.DefaultSSHTimeoutSeconds * time.Second
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:    h.Spec.Username,
+		Timeout: timeout,
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyScanned
+		},
+	}
+	conn, bastions, err := dialThroughBastions(h, sshConfig)
+	if conn != nil {
+		conn.Close()
+	}
+	closeClients(bastions)
+	if key != nil {
+		return key, nil
+	}
+	return nil, errors.Wrapf(err, "scan host key of %s failed", h.Spec.Address)
+}
+
+// Rekey trusts the host key presented now, the cached connection is dropped,
+// it's used when the host is reinstalled
+func Rekey(h *opsv1.Host, store HostKeyStore) (fingerprint string, err error) {
+	key, err := ScanHostKey(h)
+	if err != nil {
+		return
+	}
+	err = store.SetHostKey(h, MarshalHostKey(key))
+	if err != nil {
+		return
+	}
+	hcCache.Delete(connectionCacheKey(h))
+	return ssh.FingerprintSHA256(key), nil
+}
+
+// KnownHostsStore keeps the host keys in a known_hosts file, used by opscli
+type KnownHostsStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func NewKnownHostsStore(path string) *KnownHostsStore {
+	return &KnownHostsStore{Path: path}
+}
+
+// knownHostsAddress normalizes the address as known_hosts does, eg: 1.1.1.1, [1.1.1.1]:2222
+func knownHostsAddress(h *opsv1.Host) string {
+	if h.Spec.Port == 0 || h.Spec.Port == 22 {
+		return h.Spec.Address
+	}
+	return "[" + h.Spec.Address + "]:" + strconv.Itoa(h.Spec.Port)
+}
+
+func (s *KnownHostsStore) GetHostKey(h *opsv1.Host) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	address := knownHostsAddress(h)
+	for rest := data; len(rest) > 0; {
+		var hosts []string
+		var key ssh.PublicKey
+		_, hosts, key, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			break
+		}
+		for _, host := range hosts {
+			if host == address {
+				return MarshalHostKey(key), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (s *KnownHostsStore) SetHostKey(h *opsv1.Host, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	address := knownHostsAddress(h)
+	lines := make([]string, 0)
+	file, err := os.Open(s.Path)
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 && fields[0] == address {
+				continue
+			}
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	lines = append(lines, address+" "+key)
+	err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// HostStatusKeyStore keeps the host key in the status of Host, used by the controller,
+// the key of a proxyJump hop is kept in the status of the host declaring it,
+// and the host not saved, eg: created from inventory, falls back to the known_hosts file
+type HostStatusKeyStore struct {
+	Client client.Client
+	Files  *KnownHostsStore
+}
+
+func NewHostStatusKeyStore(c client.Client) *HostStatusKeyStore {
+	return &HostStatusKeyStore{Client: c, Files: NewKnownHostsStore(opsconstants.GetOpsKnownHostsPath())}
+}
+
+// hopAddress is the key of hop in HopKeys, eg: 1.1.1.1:22
+func hopAddress(h *opsv1.Host) string {
+	return net.JoinHostPort(h.Spec.Address, strconv.Itoa(h.Spec.Port))
+}
+
+func (s *HostStatusKeyStore) GetHostKey(h *opsv1.Host) (string, error) {
+	if parent := h.Annotations[opsconstants.AnnotationProxyJumpOfKey]; parent != "" {
+		latest := &opsv1.Host{}
+		err := s.Client.Get(context.TODO(), types.NamespacedName{Namespace: h.Namespace, Name: parent}, latest)
+		if err != nil {
+			return "", err
+		}
+		return latest.Status.HopKeys[hopAddress(h)], nil
+	}
+	if h.Name == "" {
+		return s.Files.GetHostKey(h)
+	}
+	latest := &opsv1.Host{}
+	err := s.Client.Get(context.TODO(), types.NamespacedName{Namespace: h.Namespace, Name: h.Name}, latest)
+	if err != nil {
+		return "", err
+	}
+	return latest.Status.HostKey, nil
+}
+
+func (s *HostStatusKeyStore) SetHostKey(h *opsv1.Host, key string) error {
+	if parent := h.Annotations[opsconstants.AnnotationProxyJumpOfKey]; parent != "" {
+		return s.updateStatus(h.Namespace, parent, func(status *opsv1.HostStatus) {
+			if status.HopKeys == nil {
+				status.HopKeys = make(map[string]string)
+			}
+			status.HopKeys[hopAddress(h)] = key
+		})
+	}
+	h.Status.HostKey = key
+	if h.Name == "" {
+		return s.Files.SetHostKey(h, key)
+	}
+	return s.updateStatus(h.Namespace, h.Name, func(status *opsv1.HostStatus) {
+		status.HostKey = key
+	})
+}
+
+func (s *HostStatusKeyStore) updateStatus(namespace, name string, update func(status *opsv1.HostStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &opsv1.Host{}
+		err := s.Client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, latest)
+		if err != nil {
+			return err
+		}
+		update(&latest.Status)
+		return s.Client.Status().Update(context.TODO(), latest)
+	})
+}
@@ -0,0 +1,186 @@
+package host
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"golang.org/x/crypto/ssh"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// memoryKeyStore keeps the host keys by address
+type memoryKeyStore map[string]string
+
+func (s memoryKeyStore) GetHostKey(h *opsv1.Host) (string, error) {
+	return s[knownHostsAddress(h)], nil
+}
+
+func (s memoryKeyStore) SetHostKey(h *opsv1.Host, key string) error {
+	s[knownHostsAddress(h)] = key
+	return nil
+}
+
+func useHostKeyStore(t *testing.T, store HostKeyStore) {
+	old := hostKeyStore
+	SetHostKeyStore(store)
+	t.Cleanup(func() {
+		SetHostKeyStore(old)
+	})
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	trusted, other := newTestHostKey(t), newTestHostKey(t)
+	cases := []struct {
+		name    string
+		policy  string
+		known   ssh.PublicKey
+		key     ssh.PublicKey
+		wantErr string
+		// wantKey is the key recorded after the callback
+		wantKey ssh.PublicKey
+	}{
+		{"insecure unknown", opsconstants.HostKeyPolicyInsecure, nil, other, "", nil},
+		{"insecure mismatch", opsconstants.HostKeyPolicyInsecure, trusted, other, "", trusted},
+		{"tofu unknown", "", nil, trusted, "", trusted},
+		{"tofu trusted", opsconstants.HostKeyPolicyTofu, trusted, trusted, "", trusted},
+		{"tofu mismatch", opsconstants.HostKeyPolicyTofu, trusted, other, "mismatch", trusted},
+		{"strict unknown", opsconstants.HostKeyPolicyStrict, nil, trusted, "is unknown", nil},
+		{"strict trusted", opsconstants.HostKeyPolicyStrict, trusted, trusted, "", trusted},
+		{"strict mismatch", opsconstants.HostKeyPolicyStrict, trusted, other, "mismatch", trusted},
+	}
+	for _, c := range cases {
+		store := memoryKeyStore{}
+		useHostKeyStore(t, store)
+		h := &opsv1.Host{Spec: opsv1.HostSpec{Address: "10.0.0.1", Port: 22, HostKeyPolicy: c.policy}}
+		if c.known != nil {
+			store.SetHostKey(h, MarshalHostKey(c.known))
+		}
+		err := hostKeyCallback(h)("10.0.0.1:22", nil, c.key)
+		if c.wantErr == "" && err != nil || c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", c.name, err, c.wantErr)
+		}
+		wantKey := ""
+		if c.wantKey != nil {
+			wantKey = MarshalHostKey(c.wantKey)
+		}
+		if got, _ := store.GetHostKey(h); got != wantKey {
+			t.Errorf("%s: recorded key = %q, want %q", c.name, got, wantKey)
+		}
+	}
+}
+
+func TestKnownHostsStore(t *testing.T) {
+	store := NewKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts"))
+	h22 := &opsv1.Host{Spec: opsv1.HostSpec{Address: "10.0.0.1", Port: 22}}
+	h2222 := &opsv1.Host{Spec: opsv1.HostSpec{Address: "10.0.0.1", Port: 2222}}
+	if got, err := store.GetHostKey(h22); got != "" || err != nil {
+		t.Fatalf("GetHostKey of missing file = %q, %v", got, err)
+	}
+	first, second, third := MarshalHostKey(newTestHostKey(t)), MarshalHostKey(newTestHostKey(t)), MarshalHostKey(newTestHostKey(t))
+	for _, set := range []struct {
+		h   *opsv1.Host
+		key string
+	}{{h22, first}, {h2222, second}, {h22, third}} {
+		if err := store.SetHostKey(set.h, set.key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := store.GetHostKey(h22); got != third {
+		t.Errorf("key of port 22 = %q, want %q", got, third)
+	}
+	if got, _ := store.GetHostKey(h2222); got != second {
+		t.Errorf("key of port 2222 = %q, want %q", got, second)
+	}
+}
+
+// fakeHostClient gets and updates the status of Hosts in memory
+type fakeHostClient struct {
+	client.Client
+	hosts map[string]*opsv1.Host
+}
+
+func (c *fakeHostClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	h, ok := c.hosts[key.String()]
+	if !ok {
+		return apierrors.NewNotFound(opsv1.GroupVersion.WithResource("hosts").GroupResource(), key.Name)
+	}
+	h.DeepCopyInto(obj.(*opsv1.Host))
+	return nil
+}
+
+func (c *fakeHostClient) Status() client.SubResourceWriter {
+	return &fakeHostStatusWriter{c}
+}
+
+type fakeHostStatusWriter struct {
+	*fakeHostClient
+}
+
+func (w *fakeHostStatusWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return nil
+}
+
+func (w *fakeHostStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	w.hosts[client.ObjectKeyFromObject(obj).String()].Status = *obj.(*opsv1.Host).Status.DeepCopy()
+	return nil
+}
+
+func (w *fakeHostStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return nil
+}
+
+func TestHostStatusKeyStore(t *testing.T) {
+	target := &opsv1.Host{Spec: opsv1.HostSpec{Address: "192.168.0.1", Port: 22, Username: "root", ProxyJump: "ops@10.0.0.1:2222,10.0.0.2"}}
+	target.Namespace, target.Name = "ops-system", "target"
+	c := &fakeHostClient{hosts: map[string]*opsv1.Host{"ops-system/target": target.DeepCopy()}}
+	store := NewHostStatusKeyStore(c)
+	store.Files = NewKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts"))
+
+	hops, err := target.GetProxyJumps()
+	if err != nil || len(hops) != 2 {
+		t.Fatalf("GetProxyJumps = %v, %v", hops, err)
+	}
+	inventory := &opsv1.Host{Spec: opsv1.HostSpec{Address: "192.168.0.2", Port: 22}}
+	keys := map[*opsv1.Host]string{}
+	for _, h := range []*opsv1.Host{target, hops[0], hops[1], inventory} {
+		keys[h] = MarshalHostKey(newTestHostKey(t))
+		if err := store.SetHostKey(h, keys[h]); err != nil {
+			t.Fatalf("SetHostKey of %s: %v", h.Spec.Address, err)
+		}
+	}
+
+	// read by another store as the next connection does
+	other := NewHostStatusKeyStore(c)
+	other.Files = store.Files
+	for h, want := range keys {
+		if got, err := other.GetHostKey(h); got != want || err != nil {
+			t.Errorf("GetHostKey of %s = %q, %v, want %q", h.Spec.Address, got, err, want)
+		}
+	}
+	saved := c.hosts["ops-system/target"].Status
+	if saved.HostKey != keys[target] {
+		t.Errorf("HostKey = %q, want %q", saved.HostKey, keys[target])
+	}
+	if saved.HopKeys["10.0.0.1:2222"] != keys[hops[0]] || saved.HopKeys["10.0.0.2:22"] != keys[hops[1]] {
+		t.Errorf("HopKeys = %v", saved.HopKeys)
+	}
+}
@@ -0,0 +1,187 @@
+package host
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	scp "github.com/bramvdbogaerde/go-scp"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostSession releases the slot of host when closed
+type hostSession struct {
+	*ssh.Session
+	release func()
+	once    sync.Once
+}
+
+func (s *hostSession) Close() error {
+	err := s.Session.Close()
+	s.once.Do(s.release)
+	return err
+}
+
+// scpSession is a scp client over a new session, releases the slot of host when closed
+type scpSession struct {
+	scp.Client
+	release func()
+	once    sync.Once
+}
+
+func (s *scpSession) Close() {
+	s.Client.Close()
+	s.once.Do(s.release)
+}
+
+func (c *HostConnection) scpSession(ctx context.Context) (*scpSession, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := c.getSSHClient()
+	sess, err := newSession(client)
+	if err != nil && isBrokenConnection(err) {
+		if err = c.reconnect(client); err == nil {
+			sess, err = newSession(c.getSSHClient())
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &scpSession{Client: scp.NewConfigurer("", nil).Session(sess).Create(), release: release}, nil
+}
+
+func newSession(client *ssh.Client) (*ssh.Session, error) {
+	if client == nil {
+		return nil, io.EOF
+	}
+	return client.NewSession()
+}
+
+// acquire waits for a free session slot of the host
+func (c *HostConnection) acquire(ctx context.Context) (release func(), err error) {
+	if c.sessions != nil {
+		select {
+		case c.sessions <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	atomic.AddInt32(&c.active, 1)
+	c.touch()
+	return func() {
+		atomic.AddInt32(&c.active, -1)
+		c.touch()
+		if c.sessions != nil {
+			<-c.sessions
+		}
+	}, nil
+}
+
+func (c *HostConnection) touch() {
+	atomic.StoreInt64(&c.lastUsed, time.Now().Unix())
+}
+
+func (c *HostConnection) isIdle() bool {
+	if atomic.LoadInt32(&c.active) > 0 {
+		return false
+	}
+	return time.Since(time.Unix(atomic.LoadInt64(&c.lastUsed), 0)) > opsconstants.SSHIdleTimeoutSeconds*time.Second
+}
+
+func (c *HostConnection) getSSHClient() *ssh.Client {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.sshclient
+}
+
+// reconnect replaces the broken client, skipped if it's replaced by others already
+func (c *HostConnection) reconnect(broken *ssh.Client) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.sshclient != nil && c.sshclient != broken {
+		return nil
+	}
+	c.closeClients()
+	return c.connecting()
+}
+
+// isBrokenConnection tells the connection is gone, not the channel rejected by the server, eg: MaxSessions
+func isBrokenConnection(err error) bool {
+	_, rejected := err.(*ssh.OpenChannelError)
+	return !rejected
+}
+
+// keepalive probes the client, reconnects if it's broken and closes it when idle
+func (c *HostConnection) keepalive(client *ssh.Client, stop chan struct{}) {
+	ticker := time.NewTicker(opsconstants.SSHKeepAliveIntervalSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if c.isIdle() {
+				hcCache.Remove(c)
+				c.close()
+				return
+			}
+			if sendKeepalive(client) == nil {
+				continue
+			}
+			// the new client has its own keepalive
+			if err := c.reconnect(client); err != nil {
+				hcCache.Remove(c)
+				c.close()
+			}
+			return
+		}
+	}
+}
+
+// sendKeepalive fails if no reply in time, the request blocks on a dead tcp connection
+func sendKeepalive(client *ssh.Client) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(opsconstants.SSHKeepAliveIntervalSeconds * time.Second):
+		return context.DeadlineExceeded
+	}
+}
+
+// Remove drops hc from the cache without closing it
+func (c *HostConnectionCache) Remove(hc *HostConnection) {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	for key, value := range c.cache {
+		if value == hc {
+			delete(c.cache, key)
+		}
+	}
+}
+
+// EvictHost closes the cached connections of the Host, eg: deleted or changed
+func EvictHost(namespace, name string) {
+	hcCache.Mutex.Lock()
+	evicted := make([]*HostConnection, 0)
+	for key, value := range hcCache.cache {
+		if value.Host.Namespace == namespace && value.Host.Name == name {
+			evicted = append(evicted, value)
+			delete(hcCache.cache, key)
+		}
+	}
+	hcCache.Mutex.Unlock()
+	for _, hc := range evicted {
+		hc.close()
+	}
+}
@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"context"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+
+	"k8s.io/client-go/rest"
+	runtimeClient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CancelTaskRun sets spec.cancelled, the controller interrupts the running steps
+func CancelTaskRun(ctx context.Context, logger *opslog.Logger, restConfig *rest.Config, namespace, name string) (err error) {
+	client, err := GetOpsClient(ctx, logger, restConfig)
+	if err != nil {
+		return
+	}
+	tr := &opsv1.TaskRun{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: name}, tr)
+	if err != nil {
+		return
+	}
+	patch := runtimeClient.MergeFrom(tr.DeepCopy())
+	tr.Spec.Cancelled = true
+	return client.Patch(ctx, tr, patch)
+}
+
+// CancelPipelineRun sets spec.cancelled, the controller cancels the running tasks and skips the remaining ones
+func CancelPipelineRun(ctx context.Context, logger *opslog.Logger, restConfig *rest.Config, namespace, name string) (err error) {
+	client, err := GetOpsClient(ctx, logger, restConfig)
+	if err != nil {
+		return
+	}
+	pr := &opsv1.PipelineRun{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: name}, pr)
+	if err != nil {
+		return
+	}
+	patch := runtimeClient.MergeFrom(pr.DeepCopy())
+	pr.Spec.Cancelled = true
+	return client.Patch(ctx, pr, patch)
+}
@@ -13,6 +13,7 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"math/rand"
@@ -21,10 +22,11 @@ import (
 )
 
 type KubeConnection struct {
-	Cluster    *opsv1.Cluster
-	Client     *kubernetes.Clientset
-	RestConfig *rest.Config
-	OpsClient  *runtimeClient.Client
+	Cluster       *opsv1.Cluster
+	Client        *kubernetes.Clientset
+	DynamicClient dynamic.Interface
+	RestConfig    *rest.Config
+	OpsClient     *runtimeClient.Client
 }
 
 func NewClusterConnection(c *opsv1.Cluster) (kc *KubeConnection, err error) {
@@ -134,6 +136,24 @@ func (kc *KubeConnection) GetPipelineRun(pr *opsv1.PipelineRun) (err error) {
 	return (*kc.OpsClient).Get(context.TODO(), types.NamespacedName{Name: pr.Name, Namespace: pr.Namespace}, pr)
 }
 
+// CancelPipelineRun sets spec.cancelled of the dispatched pipelinerun, a missing or finished one is skipped
+func (kc *KubeConnection) CancelPipelineRun(pr *opsv1.PipelineRun) (err error) {
+	othersPr := &opsv1.PipelineRun{}
+	err = (*kc.OpsClient).Get(context.TODO(), types.NamespacedName{Name: pr.Name, Namespace: pr.Namespace}, othersPr)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if othersPr.Spec.Cancelled || opsconstants.IsFinishedStatus(othersPr.Status.RunStatus) {
+		return nil
+	}
+	patch := runtimeClient.MergeFrom(othersPr.DeepCopy())
+	othersPr.Spec.Cancelled = true
+	return (*kc.OpsClient).Patch(context.TODO(), othersPr, patch)
+}
+
 func (kc *KubeConnection) GetHost(namespace, hostname string) (host *opsv1.Host, err error) {
 	hostList := &opsv1.HostList{}
 	err = (*kc.OpsClient).List(context.TODO(), hostList, runtimeClient.InNamespace(namespace))
@@ -154,6 +174,10 @@ func (kc *KubeConnection) BuildClients() (err error) {
 	if err != nil {
 		return
 	}
+	kc.DynamicClient, err = dynamic.NewForConfig(kc.RestConfig)
+	if err != nil {
+		return
+	}
 	scheme, err := opsv1.SchemeBuilder.Build()
 	if err != nil {
 		return
@@ -268,18 +292,25 @@ func (kc *KubeConnection) GetExpiredDays() (days int, err error) {
 	return opsutils.GetCertNotAfterDays(kc.RestConfig)
 }
 
-func (kc *KubeConnection) ShellOnNode(logger *opslog.Logger, node *corev1.Node, shellOpt opsopt.ShellOption, kubeOpt opsopt.KubeOption) (stdout string, err error) {
+func (kc *KubeConnection) ShellOnNode(ctx context.Context, logger *opslog.Logger, node *corev1.Node, shellOpt opsopt.ShellOption, kubeOpt opsopt.KubeOption) (stdout string, err error) {
+	result, err := kc.ShellResultOnNode(ctx, logger, node, shellOpt, kubeOpt)
+	return result.GetOutput(), err
+}
+
+// ShellResultOnNode runs the shell in a pod on node and returns the stdout, stderr and exit code separately
+func (kc *KubeConnection) ShellResultOnNode(ctx context.Context, logger *opslog.Logger, node *corev1.Node, shellOpt opsopt.ShellOption, kubeOpt opsopt.KubeOption) (result *opsv1.ExecResult, err error) {
 	namespacedName, err := opsutils.GetOrCreateNamespacedName(kc.Client, kubeOpt.Namespace, fmt.Sprintf("ops-shell-%s-%d", time.Now().Format("2006-01-02-15-04-05"), rand.Intn(10000)))
 	if err != nil {
 		return
 	}
 
-	pod, err := RunShellOnNode(kc.Client, node, namespacedName, kubeOpt.RuntimeImage, shellOpt.Mode, shellOpt.Content)
+	pod, err := RunShellOnNode(kc.Client, node, namespacedName, kubeOpt.RuntimeImage, shellOpt)
 	if err != nil {
 		return
 	}
-	stdout, err = GetPodLog(logger, context.TODO(), kubeOpt.Debug, kc.Client, pod)
-	return
+	shellCtx, cancel := shellOpt.GetContext(ctx)
+	defer cancel()
+	return GetPodResult(logger, shellCtx, kubeOpt.Debug, kc.Client, pod)
 }
 
 func (kc *KubeConnection) Shell(logger *opslog.Logger, shellOpt opsopt.ShellOption, kubeOpt opsopt.KubeOption) (err error) {
@@ -292,7 +323,7 @@ func (kc *KubeConnection) Shell(logger *opslog.Logger, shellOpt opsopt.ShellOpti
 		nodes, err = kc.GetNodes()
 	}
 	for _, node := range nodes.Items {
-		kc.ShellOnNode(logger, &node, shellOpt, kubeOpt)
+		kc.ShellOnNode(context.TODO(), logger, &node, shellOpt, kubeOpt)
 	}
 
 	return
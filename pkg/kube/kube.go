@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
+	"github.com/shaowenchen/ops/pkg/constants"
 	opslog "github.com/shaowenchen/ops/pkg/log"
 	opsoption "github.com/shaowenchen/ops/pkg/option"
 	"github.com/shaowenchen/ops/pkg/utils"
@@ -25,11 +27,13 @@ func Shell(logger *opslog.Logger, client *kubernetes.Clientset, node v1.Node, sh
 	if err != nil {
 		logger.Error.Println(err)
 	}
-	pod, err := RunShellOnNode(client, &node, namespacedName, kubeOpt.RuntimeImage, shellOpt.Mode, shellOpt.Content)
+	pod, err := RunShellOnNode(client, &node, namespacedName, kubeOpt.RuntimeImage, shellOpt)
 	if err != nil {
 		logger.Error.Println(err)
 	}
-	stdout, err := GetPodLog(logger, context.TODO(), kubeOpt.Debug, client, pod)
+	ctx, cancel := shellOpt.GetContext(context.Background())
+	defer cancel()
+	stdout, err := GetPodLog(logger, ctx, kubeOpt.Debug, client, pod)
 	if err != nil {
 		logger.Error.Println(err)
 	} else {
@@ -54,26 +58,53 @@ func File(logger *opslog.Logger, client *kubernetes.Clientset, node v1.Node, fil
 }
 
 func GetPodLog(logger *opslog.Logger, ctx context.Context, debug bool, client *kubernetes.Clientset, pod *v1.Pod) (logs string, err error) {
+	result, err := GetPodResult(logger, ctx, debug, client, pod)
+	return result.GetOutput(), err
+}
+
+// GetPodResult waits for the pod to finish, the logs are split into the stdout and the stderr by PodStderrPrefix
+func GetPodResult(logger *opslog.Logger, ctx context.Context, debug bool, client *kubernetes.Clientset, pod *v1.Pod) (result *opsv1.ExecResult, err error) {
+	result = &opsv1.ExecResult{}
+	start := time.Now()
 	defer func() {
-		if !debug {
-			client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		// always delete the pod killed by ctx
+		if !debug || ctx.Err() != nil {
+			client.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{})
 		}
 	}()
+	// stream the new part of logs every tick
+	stream := opslog.GetStream(ctx)
+	streamed := 0
 	for range time.Tick(time.Second * 1) {
 		select {
+		case <-ctx.Done():
+			logs, _ := utils.GetPodLog(context.Background(), client, pod.Namespace, pod.Name)
+			result.Stdout, result.Stderr = splitPodLogs(logs)
+			result.SetTime(start, time.Now())
+			err = fmt.Errorf("pod %s killed: %s", pod.Name, ctx.Err())
+			return
 		default:
 			pod, err = client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 			if utils.IsPendingPod(pod) {
 				continue
 			}
+			var logs string
 			logs, err = utils.GetPodLog(ctx, client, pod.Namespace, pod.Name)
 			if err != nil {
 				return
 			}
+			result.Stdout, result.Stderr = splitPodLogs(logs)
+			if output := stripPodLogs(logs); stream != nil && len(output) > streamed {
+				stream.Write([]byte(output[streamed:]))
+				streamed = len(output)
+			}
 			if utils.IsSucceededPod(pod) {
+				setPodResult(result, pod, start)
 				return
 			}
 			if utils.IsFailedPod(pod) {
+				setPodResult(result, pod, start)
+				logs := result.GetOutput()
 				if len(logs) == 0 && err != nil {
 					logs = err.Error()
 				}
@@ -85,6 +116,40 @@ func GetPodLog(logger *opslog.Logger, ctx context.Context, debug bool, client *k
 	return
 }
 
+// splitPodLogs splits the lines of logs starting with PodStderrPrefix as the stderr
+func splitPodLogs(logs string) (stdout, stderr string) {
+	var out, errOut strings.Builder
+	for _, line := range strings.SplitAfter(logs, "\n") {
+		if strings.HasPrefix(line, constants.PodStderrPrefix) {
+			errOut.WriteString(strings.TrimPrefix(line, constants.PodStderrPrefix))
+		} else {
+			out.WriteString(line)
+		}
+	}
+	return out.String(), errOut.String()
+}
+
+// stripPodLogs removes PodStderrPrefix from the lines of logs, keeping the order of stdout and stderr
+func stripPodLogs(logs string) string {
+	return strings.TrimPrefix(strings.ReplaceAll("\n"+logs, "\n"+constants.PodStderrPrefix, "\n"), "\n")
+}
+
+// setPodResult sets the exit code and time from the terminated state of the first container
+func setPodResult(result *opsv1.ExecResult, pod *v1.Pod, start time.Time) {
+	result.SetTime(start, time.Now())
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			continue
+		}
+		result.SetExitCode(int(terminated.ExitCode))
+		if !terminated.StartedAt.IsZero() && !terminated.FinishedAt.IsZero() {
+			result.SetTime(terminated.StartedAt.Time, terminated.FinishedAt.Time)
+		}
+		return
+	}
+}
+
 func GetNodes(ctx context.Context, logger *opslog.Logger, client *kubernetes.Clientset, kubeOpt opsoption.KubeOption) (nodeList []v1.Node, err error) {
 	nodes, err := utils.GetAllReadyNodesByClient(client)
 	if err != nil {
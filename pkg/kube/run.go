@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/option"
@@ -16,15 +15,14 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-func RunShellOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName types.NamespacedName, image string, mode string, shell string) (pod *corev1.Pod, err error) {
+func RunShellOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName types.NamespacedName, image string, shellOpt option.ShellOption) (pod *corev1.Pod, err error) {
 	if image == "" {
 		image = constants.DefaultRuntimeImage
 	}
 	// choose interpreter
-	usePython := false
-	lines := strings.Split(shell, "\n")
-	if len(lines) > 0 && strings.Contains(lines[0], "python") {
-		usePython = true
+	executor := shellOpt.Executor
+	if executor == "" && utils.IsPythonScript(shellOpt.Content) {
+		executor = utils.GetDefaultExecutor(shellOpt.Content)
 	}
 	priviBool := true
 	tolerations := []v1.Toleration{}
@@ -38,19 +36,34 @@ func RunShellOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName
 	}
 	automountSA := false
 	pull := corev1.PullIfNotPresent
-	cmdArg := []string{}
-	shellBase64 := utils.EncodingStringToBase64(shell)
+	cmd := "echo " + utils.EncodingStringToBase64(shellOpt.Content) + " | base64 -d | "
 	// mode
-	if mode == constants.ModeContainer {
-		cmdArg = []string{"-c", "echo " + shellBase64 + " | base64 -d | bash"}
+	if shellOpt.Mode == constants.ModeContainer {
+		if executor == "" {
+			executor = "bash"
+		}
+		cmd += utils.BuildExecutorCmd(false, executor, shellOpt.Env, shellOpt.WorkDir)
 		pull = corev1.PullAlways
 	} else {
-		cmdArg = []string{"-c", "echo " + shellBase64 + " | base64 -d | nsenter -t 1 -m -u -i -n"}
-	}
-	if usePython {
-		cmdArg[1] = cmdArg[1] + " -- python3 /dev/stdin"
+		// the default shell of host without executor, env and workdir
+		cmd += "nsenter -t 1 -m -u -i -n"
+		if executor != "" || len(shellOpt.Env) > 0 || shellOpt.WorkDir != "" {
+			if executor == "" {
+				executor = "sh"
+			}
+			cmd += " -- sh -c " + utils.ShellQuote(utils.BuildExecutorCmd(false, executor, shellOpt.Env, shellOpt.WorkDir))
+		}
 	}
+	// the lines of stderr are prefixed in the logs, so they are split from the stdout but still in kubectl logs
+	cmdArg := []string{"-c", fmt.Sprintf(`set -o pipefail
+{ (set +o pipefail; %s) 2>&1 1>&3 3>&- | while IFS= read -r line || [ -n "$line" ]; do printf '%%s%%s\n' '%s' "$line"; done >&2; } 3>&1`, cmd, constants.PodStderrPrefix)}
 	hostFlag := true
+	// kubelet kills the pod after deadline
+	var activeDeadlineSeconds *int64
+	if shellOpt.TimeoutSeconds > 0 {
+		deadline := int64(shellOpt.TimeoutSeconds)
+		activeDeadlineSeconds = &deadline
+	}
 	pod, err = client.CoreV1().Pods(namespacedName.Namespace).Create(
 		context.TODO(),
 		&corev1.Pod{
@@ -63,6 +76,7 @@ func RunShellOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName
 			},
 			Spec: corev1.PodSpec{
 				AutomountServiceAccountToken: &automountSA,
+				ActiveDeadlineSeconds:        activeDeadlineSeconds,
 				NodeName:                     node.Name,
 				Containers: []corev1.Container{
 					{
@@ -0,0 +1,412 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// RunKubeStep runs the action through the Kubernetes API, the stdout is the JSON of the resources,
+// the exit code is 0 if succeeded, otherwise 1 with the error in stderr
+func (kc *KubeConnection) RunKubeStep(ctx context.Context, step opsv1.KubeStep) (result *opsv1.ExecResult, err error) {
+	result = &opsv1.ExecResult{}
+	start := time.Now()
+	defer func() {
+		result.SetTime(start, time.Now())
+	}()
+	var output interface{}
+	switch step.Action {
+	case opsconstants.KubeActionApply:
+		output, err = kc.kubeApply(ctx, step)
+	case opsconstants.KubeActionGet:
+		output, err = kc.kubeGet(ctx, step)
+	case opsconstants.KubeActionPatch:
+		output, err = kc.kubePatch(ctx, step)
+	case opsconstants.KubeActionDelete:
+		output, err = kc.kubeDelete(ctx, step)
+	case opsconstants.KubeActionWait:
+		output, err = kc.kubeWait(ctx, step)
+	case opsconstants.KubeActionRolloutRestart:
+		output, err = kc.kubeRolloutRestart(ctx, step)
+	case opsconstants.KubeActionCordon:
+		output, err = kc.kubeCordon(ctx, step.Name, true)
+	case opsconstants.KubeActionUncordon:
+		output, err = kc.kubeCordon(ctx, step.Name, false)
+	case opsconstants.KubeActionDrain:
+		output, err = kc.kubeDrain(ctx, step)
+	default:
+		err = fmt.Errorf("unknown kube action %s", step.Action)
+	}
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(output, "", "  ")
+		result.Stdout = string(data)
+	}
+	if err != nil {
+		result.Stderr = err.Error()
+		if ctx.Err() == nil {
+			result.SetExitCode(1)
+		}
+		return
+	}
+	result.SetExitCode(0)
+	return
+}
+
+// getResource maps the kind to the resource, the kind without apiVersion is looked up in all groups
+func (kc *KubeConnection) getResource(apiVersion, kind, namespace string) (dynamic.ResourceInterface, error) {
+	groupResources, err := restmapper.GetAPIGroupResources(kc.Client.Discovery())
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+	gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+	if apiVersion == "" {
+		gvk, err = mapper.KindFor(schema.GroupVersionResource{Resource: strings.ToLower(kind)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return kc.DynamicClient.Resource(mapping.Resource), nil
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return kc.DynamicClient.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// getObjects gets the resource by name, or lists the resources by labelSelector
+func getObjects(ctx context.Context, ri dynamic.ResourceInterface, step opsv1.KubeStep) ([]unstructured.Unstructured, error) {
+	if step.Name != "" {
+		obj, err := ri.Get(ctx, step.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []unstructured.Unstructured{*obj}, nil
+	}
+	list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: step.LabelSelector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// toOutput is the object if single, otherwise a List of objects
+func toOutput(objs []unstructured.Unstructured, single bool) interface{} {
+	if single && len(objs) == 1 {
+		return &objs[0]
+	}
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+	list.Items = objs
+	return list
+}
+
+func (kc *KubeConnection) kubeApply(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	objs := make([]unstructured.Unstructured, 0)
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewBufferString(step.Manifest), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// empty document
+		if len(obj.Object) == 0 {
+			continue
+		}
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = step.Namespace
+		}
+		ri, err := kc.getResource(obj.GetAPIVersion(), obj.GetKind(), namespace)
+		if err != nil {
+			return nil, err
+		}
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		force := true
+		applied, err := ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: opsconstants.KubeFieldManager, Force: &force})
+		if err != nil {
+			return nil, fmt.Errorf("apply %s %s failed: %s", obj.GetKind(), obj.GetName(), err)
+		}
+		objs = append(objs, *applied)
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("no resource in manifest")
+	}
+	return toOutput(objs, true), nil
+}
+
+func (kc *KubeConnection) kubeGet(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	ri, err := kc.getResource(step.APIVersion, step.Kind, step.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := getObjects(ctx, ri, step)
+	if err != nil {
+		return nil, err
+	}
+	return toOutput(objs, step.Name != ""), nil
+}
+
+func (kc *KubeConnection) kubePatch(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	ri, err := kc.getResource(step.APIVersion, step.Kind, step.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	patchType := types.MergePatchType
+	switch step.PatchType {
+	case opsconstants.KubePatchTypeJson:
+		patchType = types.JSONPatchType
+	case opsconstants.KubePatchTypeStrategic:
+		patchType = types.StrategicMergePatchType
+	}
+	data, err := yaml.YAMLToJSON([]byte(step.Patch))
+	if err != nil {
+		return nil, err
+	}
+	return ri.Patch(ctx, step.Name, patchType, data, metav1.PatchOptions{FieldManager: opsconstants.KubeFieldManager})
+}
+
+func (kc *KubeConnection) kubeDelete(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	ri, err := kc.getResource(step.APIVersion, step.Kind, step.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := getObjects(ctx, ri, step)
+	if err != nil {
+		return nil, err
+	}
+	propagation := metav1.DeletePropagationBackground
+	deleteOpt := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if step.Force {
+		grace := int64(0)
+		deleteOpt.GracePeriodSeconds = &grace
+	}
+	for _, obj := range objs {
+		err = ri.Delete(ctx, obj.GetName(), deleteOpt)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return toOutput(objs, step.Name != ""), nil
+}
+
+// kubeWait waits until the condition of all resources is True or the jsonPath matches,
+// the step without timeout waits for DefaultKubeWaitSeconds at most
+func (kc *KubeConnection) kubeWait(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opsconstants.DefaultKubeWaitSeconds*time.Second)
+		defer cancel()
+	}
+	ri, err := kc.getResource(step.APIVersion, step.Kind, step.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		objs, err := getObjects(ctx, ri, step)
+		if apierrors.IsNotFound(err) {
+			objs, err = nil, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if err == nil {
+			done, err := isWaitDone(step, objs)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return toOutput(objs, step.Name != ""), nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for %s %s%s failed: %s", step.Kind, step.Name, step.LabelSelector, ctx.Err())
+		case <-time.After(opsconstants.KubeWaitIntervalSeconds * time.Second):
+		}
+	}
+}
+
+func isWaitDone(step opsv1.KubeStep, objs []unstructured.Unstructured) (bool, error) {
+	if step.Condition == opsconstants.KubeWaitDelete {
+		return len(objs) == 0, nil
+	}
+	if len(objs) == 0 {
+		return false, nil
+	}
+	for _, obj := range objs {
+		if step.Condition != "" && !isConditionTrue(obj, step.Condition) {
+			return false, nil
+		}
+		if step.JsonPath != "" {
+			value, err := getJsonPathValue(obj, step.JsonPath)
+			if err != nil {
+				return false, err
+			}
+			if (step.Value == "" && value == "") || (step.Value != "" && value != step.Value) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func isConditionTrue(obj unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(fmt.Sprint(condition["type"]), conditionType) {
+			return fmt.Sprint(condition["status"]) == opsconstants.ConditionTrue
+		}
+	}
+	return false
+}
+
+func getJsonPathValue(obj unstructured.Unstructured, path string) (string, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	jp := jsonpath.New("wait").AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := jp.Execute(buf, obj.Object); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func (kc *KubeConnection) kubeRolloutRestart(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	ri, err := kc.getResource(step.APIVersion, step.Kind, step.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	// the same annotation as kubectl rollout restart
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, time.Now().Format(time.RFC3339))
+	return ri.Patch(ctx, step.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: opsconstants.KubeFieldManager})
+}
+
+func (kc *KubeConnection) kubeCordon(ctx context.Context, nodeName string, unschedulable bool) (*unstructured.Unstructured, error) {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	return kc.DynamicClient.Resource(corev1.SchemeGroupVersion.WithResource("nodes")).Patch(ctx, nodeName, types.MergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: opsconstants.KubeFieldManager})
+}
+
+// kubeDrain cordons the node and evicts the pods except the ones of DaemonSets and the mirror pods,
+// the pods not managed by controllers are only evicted with force
+func (kc *KubeConnection) kubeDrain(ctx context.Context, step opsv1.KubeStep) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opsconstants.DefaultKubeWaitSeconds*time.Second)
+		defer cancel()
+	}
+	_, err := kc.kubeCordon(ctx, step.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	podList, err := kc.Client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + step.Name})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]corev1.Pod, 0)
+	unmanaged := make([]string, 0)
+	for _, pod := range podList.Items {
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+		controller := metav1.GetControllerOf(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		if controller == nil {
+			unmanaged = append(unmanaged, pod.Namespace+"/"+pod.Name)
+		}
+		pods = append(pods, pod)
+	}
+	if len(unmanaged) > 0 && !step.Force {
+		return nil, fmt.Errorf("pods not managed by controllers: %s, drain with force to delete them", strings.Join(unmanaged, ", "))
+	}
+	evicted := make([]string, 0)
+	for _, pod := range pods {
+		err = kc.evictPod(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		evicted = append(evicted, pod.Namespace+"/"+pod.Name)
+	}
+	for _, pod := range pods {
+		err = kc.waitPodDeleted(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"node": step.Name, "evicted": evicted}, nil
+}
+
+// evictPod retries the eviction refused by PodDisruptionBudgets
+func (kc *KubeConnection) evictPod(ctx context.Context, pod corev1.Pod) error {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	for {
+		err := kc.Client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			return nil
+		}
+		if !apierrors.IsTooManyRequests(err) {
+			return fmt.Errorf("evict pod %s/%s failed: %s", pod.Namespace, pod.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("evict pod %s/%s failed: %s", pod.Namespace, pod.Name, err)
+		case <-time.After(opsconstants.KubeWaitIntervalSeconds * time.Second):
+		}
+	}
+}
+
+func (kc *KubeConnection) waitPodDeleted(ctx context.Context, pod corev1.Pod) error {
+	for {
+		latest, err := kc.Client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && latest.UID != pod.UID) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for pod %s/%s deleted failed: %s", pod.Namespace, pod.Name, ctx.Err())
+		case <-time.After(opsconstants.KubeWaitIntervalSeconds * time.Second):
+		}
+	}
+}
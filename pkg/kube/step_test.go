@@ -0,0 +1,257 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves the discovery and the objects by path, records the patches and evictions
+type fakeAPIServer struct {
+	mutex   sync.Mutex
+	objects map[string]map[string]interface{}
+	patches map[string]string
+	evicted []string
+}
+
+var fakeDiscovery = map[string]string{
+	"/api":  `{"kind":"APIVersions","versions":["v1"]}`,
+	"/apis": `{"kind":"APIGroupList","apiVersion":"v1","groups":[{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}],"preferredVersion":{"groupVersion":"apps/v1","version":"v1"}}]}`,
+	"/api/v1": `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+		{"name":"pods","singularName":"pod","namespaced":true,"kind":"Pod","verbs":["get","list","patch","delete"]},
+		{"name":"nodes","singularName":"node","namespaced":false,"kind":"Node","verbs":["get","list","patch"]}]}`,
+	"/apis/apps/v1": `{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[
+		{"name":"deployments","singularName":"deployment","namespaced":true,"kind":"Deployment","verbs":["get","list","patch","delete"]}]}`,
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if body, ok := fakeDiscovery[r.URL.Path]; ok {
+		w.Write([]byte(body))
+		return
+	}
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/eviction"):
+		pod := strings.TrimSuffix(path, "/eviction")
+		s.evicted = append(s.evicted, pod)
+		delete(s.objects, pod)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+		return
+	case r.Method == http.MethodGet && (path == "/api/v1/pods" || strings.HasSuffix(path, "/pods")):
+		s.writeList(w, r, path)
+		return
+	}
+	obj, ok := s.objects[path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"` + path + ` not found","reason":"NotFound","code":404}`))
+		return
+	}
+	switch r.Method {
+	case http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		s.patches[path] = string(body)
+	case http.MethodDelete:
+		delete(s.objects, path)
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+// writeList lists the pods under path, filtered by the nodeName of fieldSelector
+func (s *fakeAPIServer) writeList(w http.ResponseWriter, r *http.Request, path string) {
+	nodeName := strings.TrimPrefix(r.URL.Query().Get("fieldSelector"), "spec.nodeName=")
+	items := make([]interface{}, 0)
+	for p, obj := range s.objects {
+		if !strings.Contains(p, "/pods/") || (path != "/api/v1/pods" && !strings.HasPrefix(p, path+"/")) {
+			continue
+		}
+		if node, _, _ := unstructured.NestedString(obj, "spec", "nodeName"); nodeName != "" && node != nodeName {
+			continue
+		}
+		items = append(items, obj)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"kind": "PodList", "apiVersion": "v1", "metadata": map[string]interface{}{}, "items": items})
+}
+
+func newFakePod(namespace, name, nodeName, controllerKind string) map[string]interface{} {
+	metadata := map[string]interface{}{"name": name, "namespace": namespace, "uid": namespace + "-" + name}
+	if controllerKind != "" {
+		metadata["ownerReferences"] = []interface{}{map[string]interface{}{
+			"apiVersion": "apps/v1", "kind": controllerKind, "name": name, "uid": "owner", "controller": true,
+		}}
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1", "kind": "Pod", "metadata": metadata,
+		"spec":   map[string]interface{}{"nodeName": nodeName},
+		"status": map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}},
+	}
+}
+
+func newTestKubeConnection(t *testing.T, objects map[string]map[string]interface{}) (*KubeConnection, *fakeAPIServer) {
+	fake := &fakeAPIServer{objects: objects, patches: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	config := &rest.Config{Host: server.URL}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &KubeConnection{Client: client, DynamicClient: dynamicClient, RestConfig: config}, fake
+}
+
+func TestRunKubeStepGet(t *testing.T) {
+	kc, _ := newTestKubeConnection(t, map[string]map[string]interface{}{
+		"/api/v1/namespaces/ops/pods/web": newFakePod("ops", "web", "node1", "ReplicaSet"),
+		"/api/v1/namespaces/ops/pods/db":  newFakePod("ops", "db", "node2", "StatefulSet"),
+	})
+	result, err := kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: opsconstants.KubeActionGet, Kind: "Pod", Namespace: "ops", Name: "web"})
+	if err != nil || !result.IsSuccessed() {
+		t.Fatalf("get pod: %v, %+v", err, result)
+	}
+	pod := map[string]interface{}{}
+	json.Unmarshal([]byte(result.Stdout), &pod)
+	if name, _, _ := unstructured.NestedString(pod, "metadata", "name"); name != "web" {
+		t.Errorf("get pod = %s", result.Stdout)
+	}
+
+	result, err = kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: opsconstants.KubeActionGet, Kind: "pod", Namespace: "ops"})
+	if err != nil || !strings.Contains(result.Stdout, `"kind": "List"`) || !strings.Contains(result.Stdout, `"db"`) {
+		t.Errorf("list pods: %v, %s", err, result.Stdout)
+	}
+
+	result, err = kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: opsconstants.KubeActionGet, Kind: "Pod", Namespace: "ops", Name: "missing"})
+	if err == nil || result.ExitCode == nil || *result.ExitCode != 1 || !strings.Contains(result.Stderr, "not found") {
+		t.Errorf("get missing pod: %v, %+v", err, result)
+	}
+
+	_, err = kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: "scale"})
+	if err == nil || !strings.Contains(err.Error(), "unknown kube action scale") {
+		t.Errorf("unknown action: %v", err)
+	}
+}
+
+func TestRunKubeStepPatch(t *testing.T) {
+	kc, fake := newTestKubeConnection(t, map[string]map[string]interface{}{
+		"/api/v1/nodes/node1":                          {"apiVersion": "v1", "kind": "Node", "metadata": map[string]interface{}{"name": "node1"}},
+		"/apis/apps/v1/namespaces/ops/deployments/web": {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": map[string]interface{}{"name": "web", "namespace": "ops"}},
+	})
+	steps := []struct {
+		step  opsv1.KubeStep
+		path  string
+		patch string
+	}{
+		{opsv1.KubeStep{Action: opsconstants.KubeActionCordon, Name: "node1"}, "/api/v1/nodes/node1", `{"spec":{"unschedulable":true}}`},
+		{opsv1.KubeStep{Action: opsconstants.KubeActionUncordon, Name: "node1"}, "/api/v1/nodes/node1", `{"spec":{"unschedulable":false}}`},
+		{opsv1.KubeStep{Action: opsconstants.KubeActionPatch, Kind: "Node", Name: "node1", Patch: "metadata:\n  labels:\n    role: db"}, "/api/v1/nodes/node1", `{"metadata":{"labels":{"role":"db"}}}`},
+		{opsv1.KubeStep{Action: opsconstants.KubeActionRolloutRestart, Kind: "Deployment", Namespace: "ops", Name: "web"}, "/apis/apps/v1/namespaces/ops/deployments/web", `kubectl.kubernetes.io/restartedAt`},
+	}
+	for _, s := range steps {
+		result, err := kc.RunKubeStep(context.Background(), s.step)
+		if err != nil || !result.IsSuccessed() {
+			t.Errorf("%s: %v, %+v", s.step.Action, err, result)
+			continue
+		}
+		if !strings.Contains(fake.patches[s.path], s.patch) {
+			t.Errorf("%s: patch = %s, want %s", s.step.Action, fake.patches[s.path], s.patch)
+		}
+	}
+}
+
+func TestRunKubeStepDrain(t *testing.T) {
+	objects := map[string]map[string]interface{}{
+		"/api/v1/nodes/node1":                         {"apiVersion": "v1", "kind": "Node", "metadata": map[string]interface{}{"name": "node1"}},
+		"/api/v1/namespaces/ops/pods/web":             newFakePod("ops", "web", "node1", "ReplicaSet"),
+		"/api/v1/namespaces/ops/pods/agent":           newFakePod("ops", "agent", "node1", "DaemonSet"),
+		"/api/v1/namespaces/ops/pods/other":           newFakePod("ops", "other", "node2", "ReplicaSet"),
+		"/api/v1/namespaces/kube-system/pods/unowned": newFakePod("kube-system", "unowned", "node1", ""),
+	}
+	kc, fake := newTestKubeConnection(t, objects)
+	result, err := kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: opsconstants.KubeActionDrain, Name: "node1"})
+	if err == nil || !strings.Contains(result.Stderr, "pods not managed by controllers: kube-system/unowned") {
+		t.Fatalf("drain without force: %v, %+v", err, result)
+	}
+	if !strings.Contains(fake.patches["/api/v1/nodes/node1"], `"unschedulable":true`) {
+		t.Errorf("node is not cordoned before drain")
+	}
+	if len(fake.evicted) != 0 {
+		t.Errorf("evicted %v without force", fake.evicted)
+	}
+
+	result, err = kc.RunKubeStep(context.Background(), opsv1.KubeStep{Action: opsconstants.KubeActionDrain, Name: "node1", Force: true})
+	if err != nil || !result.IsSuccessed() {
+		t.Fatalf("drain with force: %v, %+v", err, result)
+	}
+	want := []string{"/api/v1/namespaces/kube-system/pods/unowned", "/api/v1/namespaces/ops/pods/web"}
+	evicted := append([]string{}, fake.evicted...)
+	if len(evicted) == 2 && evicted[0] > evicted[1] {
+		evicted[0], evicted[1] = evicted[1], evicted[0]
+	}
+	if !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted = %v, want %v", fake.evicted, want)
+	}
+	if _, ok := objects["/api/v1/namespaces/ops/pods/agent"]; !ok {
+		t.Errorf("the pod of DaemonSet is evicted")
+	}
+}
+
+func TestIsWaitDone(t *testing.T) {
+	ready := unstructured.Unstructured{Object: newFakePod("ops", "web", "node1", "")}
+	notReady := unstructured.Unstructured{Object: newFakePod("ops", "db", "node1", "")}
+	unstructured.SetNestedSlice(notReady.Object, []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}}, "status", "conditions")
+	cases := []struct {
+		name string
+		step opsv1.KubeStep
+		objs []unstructured.Unstructured
+		want bool
+	}{
+		{"no objects", opsv1.KubeStep{Condition: "Ready"}, nil, false},
+		{"deleted", opsv1.KubeStep{Condition: opsconstants.KubeWaitDelete}, nil, true},
+		{"not deleted", opsv1.KubeStep{Condition: opsconstants.KubeWaitDelete}, []unstructured.Unstructured{ready}, false},
+		{"ready", opsv1.KubeStep{Condition: "ready"}, []unstructured.Unstructured{ready}, true},
+		{"one not ready", opsv1.KubeStep{Condition: "Ready"}, []unstructured.Unstructured{ready, notReady}, false},
+		{"jsonpath value", opsv1.KubeStep{JsonPath: "{.spec.nodeName}", Value: "node1"}, []unstructured.Unstructured{ready}, true},
+		{"jsonpath other value", opsv1.KubeStep{JsonPath: ".spec.nodeName", Value: "node2"}, []unstructured.Unstructured{ready}, false},
+		{"jsonpath not empty", opsv1.KubeStep{JsonPath: ".spec.nodeName"}, []unstructured.Unstructured{ready}, true},
+		{"jsonpath missing", opsv1.KubeStep{JsonPath: ".status.podIP"}, []unstructured.Unstructured{ready}, false},
+	}
+	for _, c := range cases {
+		got, err := isWaitDone(c.step, c.objs)
+		if err != nil || got != c.want {
+			t.Errorf("%s: isWaitDone = %v, %v, want %v", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestToOutput(t *testing.T) {
+	objs := []unstructured.Unstructured{{Object: newFakePod("ops", "web", "node1", "")}}
+	if _, ok := toOutput(objs, true).(*unstructured.Unstructured); !ok {
+		t.Errorf("single object is not unwrapped")
+	}
+	if _, ok := toOutput(objs, false).(*unstructured.UnstructuredList); !ok {
+		t.Errorf("listed objects are not a List")
+	}
+	if list, ok := toOutput(nil, true).(*unstructured.UnstructuredList); !ok || len(list.Items) != 0 {
+		t.Errorf("no object is not an empty List")
+	}
+}
@@ -0,0 +1,79 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+type streamKey struct{}
+
+// WithStream returns a context, the output of commands run with it is also written to w as it comes
+func WithStream(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, streamKey{}, w)
+}
+
+// GetStream returns the writer set by WithStream, nil if not set
+func GetStream(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(streamKey{}).(io.Writer)
+	return w
+}
+
+// StreamBuffer buffers the written output and flushes it to fn every interval,
+// Close flushes the rest with finished true
+type StreamBuffer struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	fn     func(chunk string, finished bool)
+	ticker *time.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewStreamBuffer(interval time.Duration, fn func(chunk string, finished bool)) *StreamBuffer {
+	s := &StreamBuffer{
+		fn:     fn,
+		ticker: time.NewTicker(interval),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.ticker.C:
+				s.flush(false)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *StreamBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *StreamBuffer) flush(finished bool) {
+	s.mu.Lock()
+	chunk := s.buf.String()
+	s.buf.Reset()
+	s.mu.Unlock()
+	if len(chunk) == 0 && !finished {
+		return
+	}
+	s.fn(chunk, finished)
+}
+
+func (s *StreamBuffer) Close() error {
+	s.ticker.Stop()
+	close(s.done)
+	s.wg.Wait()
+	s.flush(true)
+	return nil
+}
@@ -1,7 +1,10 @@
 package option
 
 import (
+	"context"
+	"io"
 	"strings"
+	"time"
 
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 )
@@ -14,6 +17,19 @@ type HostOption struct {
 	PrivateKey     string
 	PrivateKeyPath string
 	SecretRef      string
+	HostKeyPolicy  string
+	Bastion        string
+	// Passphrase decrypts the private key
+	Passphrase string
+	// Certificate is the OpenSSH user certificate signed by CA, read from CertificatePath
+	Certificate     string
+	CertificatePath string
+	// Agent authenticates with ssh-agent
+	Agent bool
+	// ForwardAgent forwards the ssh-agent to the hosts, requires Agent
+	ForwardAgent bool
+	// Limit selects the groups or hosts of inventory, separated by comma
+	Limit string
 }
 
 type KubeOption struct {
@@ -52,13 +68,34 @@ type TaskOption struct {
 	FilePath  string
 	Proxy     string
 	Variables map[string]string
-	Clear     bool
+	// RefVariables are the values read from secretRef and configMapRef of variables, not saved to the taskrun
+	RefVariables map[string]string
+	// Facts are the facts of the target, referred to as ${facts.<name>} and .Facts of templates
+	Facts map[string]string
+	Clear bool
+	// DryRun prints the rendered steps without running
+	DryRun bool
+	// Stream returns the writer of live output of the step on node, nil is no streaming
+	Stream func(nodeName, stepName string) io.WriteCloser
 }
 
 type ShellOption struct {
-	Mode    string
-	Content string
-	Sudo    bool
+	Mode           string
+	Content        string
+	Sudo           bool
+	TimeoutSeconds int
+	// Executor runs the content read from stdin, default by the first line of content
+	Executor string
+	Env      map[string]string
+	WorkDir  string
+}
+
+// GetContext returns a context of parent canceled after TimeoutSeconds, no timeout if not set
+func (s *ShellOption) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.TimeoutSeconds > 0 {
+		return context.WithTimeout(parent, time.Duration(s.TimeoutSeconds)*time.Second)
+	}
+	return context.WithCancel(parent)
 }
 
 type CopilotOption struct {
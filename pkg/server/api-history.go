@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsevent "github.com/shaowenchen/ops/pkg/event"
+	"github.com/shaowenchen/ops/pkg/history"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+)
+
+const historyDurableName = "ops-server-history"
+
+var historyStore history.Store
+
+// SetupHistory opens the history store and receives the finished runs from the event bus
+func SetupHistory() {
+	if GlobalConfig.History.Store == "" {
+		return
+	}
+	logger := opslog.NewLogger().SetStd().SetFlag().Build()
+	store, err := history.NewStore(GlobalConfig.History.Store)
+	if err != nil {
+		logger.Error.Println("open history store error", err)
+		return
+	}
+	historyStore = store
+	if GlobalConfig.Event.Endpoint != "" {
+		js, err := opsevent.FactoryJetStreamClient(GlobalConfig.Event.Endpoint, GlobalConfig.Event.Cluster)
+		if err == nil {
+			subject := opsconstants.GetClusterSubject(GlobalConfig.Event.Cluster, "*", opsconstants.SubjectTaskRun) + ".*." + opsconstants.History
+			_, err = opsevent.SubscribeDurable(*js, strings.ToLower(subject), historyDurableName, func(data opsevent.EventData) error {
+				record := opsevent.EventTaskRunHistory{}
+				if err := data.Event.DataAs(&record); err != nil {
+					return nil
+				}
+				r := history.Record(record)
+				return history.SaveMerged(historyStore, &r)
+			})
+		}
+		if err != nil {
+			logger.Error.Println("subscribe history error", err)
+		}
+	}
+	if GlobalConfig.History.Retention > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				count, err := historyStore.Prune(time.Now().AddDate(0, 0, -GlobalConfig.History.Retention))
+				if err != nil {
+					logger.Error.Println("prune history error", err)
+				} else if count > 0 {
+					logger.Info.Println(fmt.Sprintf("pruned %d history records", count))
+				}
+				<-ticker.C
+			}
+		}()
+	}
+}
+
+// getTaskRunHistory returns the full record of the run, nil if not found
+func getTaskRunHistory(namespace, taskRun, id string) *history.Record {
+	if historyStore == nil || id == "" {
+		return nil
+	}
+	record, err := historyStore.Get(namespace, taskRun, id)
+	if err != nil {
+		return nil
+	}
+	return record
+}
+
+// @Summary List TaskRun History
+// @Tags TaskRun
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Param page query int false "page"
+// @Param page_size query int false "page_size"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/history [get]
+func ListTaskRunHistory(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+		Page      uint   `form:"page"`
+		PageSize  uint   `form:"page_size"`
+	}
+	var req = Params{
+		PageSize: 10,
+		Page:     1,
+	}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	err = c.ShouldBindQuery(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	listHistory(c, history.Query{Namespace: req.Namespace, TaskRun: req.Taskrun}, req.PageSize, req.Page)
+}
+
+// @Summary Get TaskRun History
+// @Tags TaskRun
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Param id path string true "id"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/history/{id} [get]
+func GetTaskRunHistory(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+		Id        string `uri:"id"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if historyStore == nil {
+		showError(c, "history store is disabled")
+		return
+	}
+	record, err := historyStore.Get(req.Namespace, req.Taskrun, req.Id)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, record)
+}
+
+// @Summary Query History
+// @Tags History
+// @Accept json
+// @Produce json
+// @Param namespace query string false "namespace"
+// @Param taskref query string false "taskref"
+// @Param status query string false "status"
+// @Param since query int false "since, unix seconds"
+// @Param until query int false "until, unix seconds"
+// @Param page query int false "page"
+// @Param page_size query int false "page_size"
+// @Success 200
+// @Router /api/v1/history [get]
+func QueryHistory(c *gin.Context) {
+	type Params struct {
+		Namespace string `form:"namespace"`
+		TaskRef   string `form:"taskref"`
+		Status    string `form:"status"`
+		Since     int64  `form:"since"`
+		Until     int64  `form:"until"`
+		Page      uint   `form:"page"`
+		PageSize  uint   `form:"page_size"`
+	}
+	var req = Params{
+		PageSize: 10,
+		Page:     1,
+	}
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	q := history.Query{
+		Namespace: req.Namespace,
+		TaskRef:   req.TaskRef,
+		RunStatus: req.Status,
+	}
+	if req.Namespace == opsconstants.AllNamespaces {
+		q.Namespace = ""
+	}
+	if req.Since > 0 {
+		q.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		q.Until = time.Unix(req.Until, 0)
+	}
+	listHistory(c, q, req.PageSize, req.Page)
+}
+
+// listHistory shows the page of matched records, with step outputs truncated
+func listHistory(c *gin.Context, q history.Query, pageSize, page uint) {
+	if historyStore == nil {
+		showError(c, "history store is disabled")
+		return
+	}
+	records, err := historyStore.List(q)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	pagination := paginator[*history.Record](records, pageSize, page)
+	for i, r := range pagination.List {
+		pagination.List[i] = r.Summary(opsconstants.MaxStepOutputLength)
+	}
+	showData(c, pagination)
+}
@@ -11,13 +11,19 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
 	"github.com/sashabaranov/go-openai"
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	opsevent "github.com/shaowenchen/ops/pkg/event"
+	opshost "github.com/shaowenchen/ops/pkg/host"
 	opskube "github.com/shaowenchen/ops/pkg/kube"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	opsoption "github.com/shaowenchen/ops/pkg/option"
+	opstask "github.com/shaowenchen/ops/pkg/task"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	runtimeClient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -400,6 +406,11 @@ func CreatePipeline(c *gin.Context) {
 		showError(c, err.Error())
 		return
 	}
+	err = pipeline.ValidateTasks()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := getRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
@@ -445,6 +456,124 @@ func PutTask(c *gin.Context) {
 	showSuccess(c)
 }
 
+// @Summary Render Task
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param task path string true "task"
+// @Param variables body map[string]string false "variables"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/tasks/{task}/render [post]
+func RenderTask(c *gin.Context) {
+	type Params struct {
+		Namespace string            `uri:"namespace"`
+		Task      string            `uri:"task"`
+		Variables map[string]string `json:"variables"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	// the body is optional
+	err = c.ShouldBindJSON(&req)
+	if err != nil && err != io.EOF {
+		showError(c, err.Error())
+		return
+	}
+	client, err := getRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	task := &opsv1.Task{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Task,
+	}, task)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	task, err = opstask.ResolveTask(task, opstask.NewClientTaskLibrary(context.TODO(), client))
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	taskRun := opsv1.NewTaskRun(task)
+	taskRun.Namespace = req.Namespace
+	taskRun.Spec.Variables = req.Variables
+	taskRun.MergeVariables(task)
+	plans, err := planTaskRun(client, task, &taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, plans)
+}
+
+// planTaskRun renders the task on the hosts or nodes selected as the controller does, nothing is run,
+// the variables of the run, such as TASKRUN, are kept as is
+func planTaskRun(client runtimeClient.Client, t *opsv1.Task, tr *opsv1.TaskRun) (plans []*opstask.TaskPlan, err error) {
+	logger := opslog.NewLogger().SetStd().Build()
+	refVars, err := opstask.GetVariableRefValues(context.TODO(), client, t)
+	if err != nil {
+		return
+	}
+	getVariables := func() map[string]string {
+		vars := make(map[string]string, len(tr.Spec.Variables))
+		for k, v := range tr.Spec.Variables {
+			vars[k] = v
+		}
+		vars["TASK"] = t.Name
+		vars["NAMESPACE"] = tr.Namespace
+		return vars
+	}
+	var hosts []opsv1.Host
+	for _, h := range opshost.GetTaskHosts(context.TODO(), logger, client, t, tr.GetHost(t)) {
+		if h.Status.HeartStatus == opsconstants.StatusSuccessed {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) > 0 && t.OnlyScript() {
+		for _, h := range hosts {
+			vars := getVariables()
+			vars["HOSTNAME"] = h.GetHostname()
+			vars["EVENT_CLUSTER"] = opsconstants.GetEnvEventCluster()
+			for k, v := range h.ObjectMeta.Labels {
+				vars[k] = v
+			}
+			plan, err := opstask.PlanTask(t, h.Name, opsoption.TaskOption{Variables: vars, RefVariables: refVars, Facts: opstask.HostFacts(h.Status)})
+			if err != nil {
+				return nil, err
+			}
+			plans = append(plans, plan)
+		}
+		return
+	}
+	cluster := opsv1.NewCurrentCluster()
+	kc, err := opskube.NewClusterConnection(&cluster)
+	if err != nil {
+		return
+	}
+	nodes, err := opskube.GetNodes(context.TODO(), logger, kc.Client, opsoption.KubeOption{NodeName: tr.GetHost(t)})
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		vars := getVariables()
+		vars["HOSTNAME"] = node.Name
+		plan, err := opstask.PlanTask(t, node.Name, opsoption.TaskOption{Variables: vars, RefVariables: refVars, Facts: opstask.NodeFacts(&node)})
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return
+}
+
 // @Summary Update Pipeline
 // @Tags Pipelines
 // @Accept json
@@ -464,6 +593,11 @@ func PutPipeline(c *gin.Context) {
 		showError(c, err.Error())
 		return
 	}
+	err = pipeline.ValidateTasks()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := getRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
@@ -1035,6 +1169,191 @@ func createTaskRun(c *gin.Context, sync bool) (latest opsv1.TaskRun, err error)
 	}
 }
 
+// @Summary Cancel TaskRun
+// @Tags TaskRun
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/cancel [post]
+func CancelTaskRun(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := getRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	taskRun := &opsv1.TaskRun{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Taskrun,
+	}, taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if opsconstants.IsFinishedStatus(taskRun.Status.RunStatus) {
+		showError(c, "taskrun is already "+taskRun.Status.RunStatus)
+		return
+	}
+	patch := runtimeClient.MergeFrom(taskRun.DeepCopy())
+	taskRun.Spec.Cancelled = true
+	err = client.Patch(context.TODO(), taskRun, patch)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, taskRun.CopyWithOutVersion())
+}
+
+// @Summary Get TaskRun Logs
+// @Tags TaskRun
+// @Produce text/event-stream
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Param follow query bool false "follow"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/logs [get]
+func GetTaskRunLogs(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+		Follow    bool   `form:"follow"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	err = c.ShouldBindQuery(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := getRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	taskRun := &opsv1.TaskRun{}
+	key := runtimeClient.ObjectKey{Namespace: req.Namespace, Name: req.Taskrun}
+	err = client.Get(context.TODO(), key, taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// node name -> steps sent from status
+	sent := make(map[string]int)
+	sendStatusLogs := func(taskRun *opsv1.TaskRun) {
+		for _, log := range getTaskRunStepLogs(taskRun, sent) {
+			c.SSEvent("log", log)
+		}
+	}
+	if !req.Follow || opsconstants.IsFinishedStatus(taskRun.Status.RunStatus) {
+		// the outputs in status may be truncated
+		if record := getTaskRunHistory(req.Namespace, req.Taskrun, taskRun.Status.HistoryRef); record != nil && opsconstants.IsFinishedStatus(taskRun.Status.RunStatus) {
+			taskRun.Status = record.TaskRunStatus
+		}
+		sendStatusLogs(taskRun)
+		c.SSEvent("end", taskRun.Status.RunStatus)
+		return
+	}
+	// replay the live output since the run started, fallback to the status without event bus
+	logs := make(chan opsevent.EventTaskRunLog, 1024)
+	var sub *nats.Subscription
+	js, err := opsevent.FactoryJetStreamClient(GlobalConfig.Event.Endpoint, GlobalConfig.Event.Cluster)
+	if err == nil {
+		subject := opsconstants.GetClusterSubject(GlobalConfig.Event.Cluster, req.Namespace, opsconstants.SubjectTaskRun) + "." + req.Taskrun + "." + opsconstants.Logs
+		startTime := taskRun.CreationTimestamp.Time
+		if taskRun.Spec.Crontab != "" && taskRun.Status.StartTime != nil {
+			startTime = taskRun.Status.StartTime.Time
+		}
+		sub, err = opsevent.SubscribeStartTime(*js, strings.ToLower(subject), startTime, func(data opsevent.EventData) {
+			log := opsevent.EventTaskRunLog{}
+			if data.Event.DataAs(&log) != nil {
+				return
+			}
+			select {
+			case logs <- log:
+			case <-c.Request.Context().Done():
+			}
+		})
+	}
+	if err != nil {
+		sub = nil
+	} else {
+		defer sub.Unsubscribe()
+	}
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case log := <-logs:
+			c.SSEvent("log", log)
+			return true
+		case <-ticker.C:
+			latest := &opsv1.TaskRun{}
+			if err := client.Get(context.TODO(), key, latest); err != nil {
+				c.SSEvent("error", err.Error())
+				return false
+			}
+			if sub == nil {
+				sendStatusLogs(latest)
+			}
+			if !opsconstants.IsFinishedStatus(latest.Status.RunStatus) {
+				return true
+			}
+			// drain the output published before finished
+			for {
+				select {
+				case log := <-logs:
+					c.SSEvent("log", log)
+				case <-time.After(time.Second):
+					c.SSEvent("end", latest.Status.RunStatus)
+					return false
+				}
+			}
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// getTaskRunStepLogs returns the step outputs in status not sent yet, sent is updated
+func getTaskRunStepLogs(taskRun *opsv1.TaskRun, sent map[string]int) (logs []opsevent.EventTaskRunLog) {
+	nodeNames := make([]string, 0, len(taskRun.Status.TaskRunNodeStatus))
+	for nodeName := range taskRun.Status.TaskRunNodeStatus {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+	for _, nodeName := range nodeNames {
+		steps := taskRun.Status.TaskRunNodeStatus[nodeName].TaskRunStep
+		for _, step := range steps[sent[nodeName]:] {
+			logs = append(logs, opsevent.EventTaskRunLog{
+				NodeName: nodeName,
+				StepName: step.StepName,
+				Content:  step.StepOutput,
+				Finished: true,
+			})
+		}
+		sent[nodeName] = len(steps)
+	}
+	return
+}
+
 // @Summary Create PipelineRun
 // @Tags PipelineRun
 // @Accept json
@@ -1141,6 +1460,53 @@ func createPipelineRun(c *gin.Context, sync bool) (latest opsv1.PipelineRun, err
 	}
 }
 
+// @Summary Cancel PipelineRun
+// @Tags PipelineRun
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param pipelinerun path string true "pipelinerun"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/cancel [post]
+func CancelPipelineRun(c *gin.Context) {
+	type Params struct {
+		Namespace   string `uri:"namespace"`
+		Pipelinerun string `uri:"pipelinerun"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := getRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	pipelineRun := &opsv1.PipelineRun{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Pipelinerun,
+	}, pipelineRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if opsconstants.IsFinishedStatus(pipelineRun.Status.RunStatus) {
+		showError(c, "pipelinerun is already "+pipelineRun.Status.RunStatus)
+		return
+	}
+	patch := runtimeClient.MergeFrom(pipelineRun.DeepCopy())
+	pipelineRun.Spec.Cancelled = true
+	err = client.Patch(context.TODO(), pipelineRun, patch)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, pipelineRun.CopyWithOutVersion())
+}
+
 // @Summary Create Event
 // @Tags Event
 // @Accept json
@@ -1332,6 +1698,11 @@ func getRuntimeClient(kubeconfigPath string) (client runtimeClient.Client, err e
 	if err != nil {
 		return
 	}
+	// nodes and configmaps are read by rendering tasks
+	err = clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return
+	}
 	restConfig, err := opsutils.GetRestConfig(kubeconfigPath)
 
 	if err != nil {
@@ -16,6 +16,7 @@ type ConfigOptions struct {
 	Server  ServerOptions        `mapstructure:"server"`
 	Copilot option.CopilotOption `mapstructure:"copilot"`
 	Event   EventOption          `mapstructure:"event"`
+	History HistoryOption        `mapstructure:"history"`
 }
 
 type ServerOptions struct {
@@ -28,6 +29,13 @@ type EventOption struct {
 	Cluster  string `mapstructure:"cluster"`
 }
 
+type HistoryOption struct {
+	// Store is the dsn of history store, eg: bolt:///data/history.db, empty to disable
+	Store string `mapstructure:"store"`
+	// Retention is the days to keep the records, 0 to keep forever
+	Retention int `mapstructure:"retention"`
+}
+
 func LoadConfig(configPath string) {
 	path, err := os.Getwd()
 	if err != nil {
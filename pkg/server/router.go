@@ -25,6 +25,7 @@ func SetupRouter(r *gin.Engine) {
 		v1Tasks.GET("/:task", GetTask)
 		v1Tasks.PUT("/:task", PutTask)
 		v1Tasks.DELETE("/:task", DeleteTask)
+		v1Tasks.POST("/:task/render", RenderTask)
 	}
 	v1Taskruns := r.Group("/api/v1/namespaces/:namespace/taskruns").Use(AuthMiddleware())
 	{
@@ -32,6 +33,10 @@ func SetupRouter(r *gin.Engine) {
 		v1Taskruns.POST("", CreateTaskRun)
 		v1Taskruns.POST("/sync", CreateTaskRunSync)
 		v1Taskruns.GET("/:taskrun", GetTaskRun)
+		v1Taskruns.POST("/:taskrun/cancel", CancelTaskRun)
+		v1Taskruns.GET("/:taskrun/logs", GetTaskRunLogs)
+		v1Taskruns.GET("/:taskrun/history", ListTaskRunHistory)
+		v1Taskruns.GET("/:taskrun/history/:id", GetTaskRunHistory)
 	}
 	v1Pipelines := r.Group("/api/v1/namespaces/:namespace/pipelines").Use(AuthMiddleware())
 	{
@@ -48,6 +53,11 @@ func SetupRouter(r *gin.Engine) {
 		v1Pipelineruns.POST("", CreatePipelineRun)
 		v1Pipelineruns.POST("/sync", CreatePipelineRunSync)
 		v1Pipelineruns.GET("/:pipelinerun", GetPipelineRun)
+		v1Pipelineruns.POST("/:pipelinerun/cancel", CancelPipelineRun)
+	}
+	v1History := r.Group("/api/v1/history").Use(AuthMiddleware())
+	{
+		v1History.GET("", QueryHistory)
 	}
 	v1Copilot := r.Group("/api/v1/copilot").Use(AuthMiddleware())
 	{
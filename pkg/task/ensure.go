@@ -0,0 +1,234 @@
+package task
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/utils"
+)
+
+// EnsureScript renders the script of the ensure step, run on hosts and nodes alike, the values are passed in base64,
+// so the quotes and ${} in them don't change the script, the last line of the output is the change
+func EnsureScript(e *opsv1.EnsureStep) (string, error) {
+	var module string
+	var err error
+	switch {
+	case e.File != nil:
+		module, err = ensureFileScript(e.File)
+	case e.Line != nil:
+		module = ensureLineScript(e.Line)
+	case e.Package != nil:
+		module = ensurePackageScript(e.Package)
+	case e.Service != nil:
+		module = ensureServiceScript(e.Service)
+	default:
+		return "", fmt.Errorf("one of file, line, package and service is required")
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`set -e
+changed=0
+%s
+if [ "$changed" = 1 ]; then
+  echo '%s%s'
+else
+  echo '%s%s'
+fi
+`, module, opsconstants.EnsureResultPrefix, opsconstants.EnsureChanged, opsconstants.EnsureResultPrefix, opsconstants.EnsureUnchanged), nil
+}
+
+// ParseEnsureResult sets the change of result from the last line of stdout, the line is replaced by the change
+func ParseEnsureResult(result *opsv1.ExecResult) {
+	if result == nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(result.Stdout, "\n"), "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, opsconstants.EnsureResultPrefix) {
+		return
+	}
+	result.Changed = strings.TrimPrefix(last, opsconstants.EnsureResultPrefix)
+	lines[len(lines)-1] = result.Changed
+	result.Stdout = strings.Join(lines, "\n")
+}
+
+// shellDecode is the shell expression of the value, decoded from base64
+func shellDecode(value string) string {
+	return fmt.Sprintf(`"$(echo '%s' | base64 -d)"`, base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+func ensureFileScript(f *opsv1.EnsureFile) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("path=%s\n", shellDecode(f.Path)))
+	if f.State == opsconstants.EnsureStateAbsent {
+		sb.WriteString(`if [ -e "$path" ]; then
+  rm -f "$path"
+  changed=1
+fi
+`)
+		return sb.String(), nil
+	}
+	if f.Content == "" {
+		// the content is not managed, the file is only created if missing
+		sb.WriteString(`if [ ! -e "$path" ]; then
+  mkdir -p "$(dirname "$path")"
+  touch "$path"
+  changed=1
+fi
+`)
+	} else {
+		sb.WriteString(fmt.Sprintf(`if [ ! -f "$path" ] || [ "$(%s)" != '%x' ]; then
+  mkdir -p "$(dirname "$path")"
+  echo '%s' | base64 -d > "$path"
+  changed=1
+fi
+`, utils.ShellFileMd5(`"$path"`), md5.Sum([]byte(f.Content)), base64.StdEncoding.EncodeToString([]byte(f.Content))))
+	}
+	if f.Mode != "" {
+		mode, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil {
+			return "", fmt.Errorf("mode %s is not octal", f.Mode)
+		}
+		sb.WriteString(fmt.Sprintf(`if [ "$(stat -c %%a "$path")" != '%o' ]; then
+  chmod %o "$path"
+  changed=1
+fi
+`, mode, mode))
+	}
+	if f.Owner != "" {
+		format := "%U"
+		if strings.Contains(f.Owner, ":") {
+			format = "%U:%G"
+		}
+		sb.WriteString(fmt.Sprintf(`owner=%s
+if [ "$(stat -c %s "$path")" != "$owner" ]; then
+  chown "$owner" "$path"
+  changed=1
+fi
+`, shellDecode(f.Owner), format))
+	}
+	return sb.String(), nil
+}
+
+// ensureLineAwk replaces the matching lines with the line, or removes them if absent, and appends the line if none matches
+const ensureLineAwk = `BEGIN { line = ENVIRON["OPS_LINE"]; re = ENVIRON["OPS_REGEXP"]; absent = ENVIRON["OPS_STATE"] == "absent" }
+{ matched = (re != "") ? ($0 ~ re) : ($0 == line) }
+matched && absent { next }
+matched { print line; found = 1; next }
+{ print }
+END { if (!found && !absent) print line }`
+
+func ensureLineScript(l *opsv1.EnsureLine) string {
+	state := l.State
+	if state == "" {
+		state = opsconstants.EnsureStatePresent
+	}
+	return fmt.Sprintf(`path=%s
+OPS_LINE=%s
+OPS_REGEXP=%s
+OPS_STATE='%s'
+export OPS_LINE OPS_REGEXP OPS_STATE
+if [ -f "$path" ]; then
+  tmp="$(mktemp)"
+  awk '%s' "$path" > "$tmp"
+  if ! cmp -s "$tmp" "$path"; then
+    cat "$tmp" > "$path"
+    changed=1
+  fi
+  rm -f "$tmp"
+elif [ "$OPS_STATE" = '%s' ]; then
+  mkdir -p "$(dirname "$path")"
+  printf '%%s\n' "$OPS_LINE" > "$path"
+  changed=1
+fi
+`, shellDecode(l.Path), shellDecode(l.Line), shellDecode(l.Regexp), state, ensureLineAwk, opsconstants.EnsureStatePresent)
+}
+
+// ensurePackageManagers defines pkg_installed, pkg_install and pkg_remove with the first package manager found
+const ensurePackageManagers = `if command -v apt-get >/dev/null 2>&1; then
+  pkg_installed() { dpkg -s "$1" 2>/dev/null | grep -q '^Status: install ok installed'; }
+  pkg_install() { apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq "$@"; }
+  pkg_remove() { DEBIAN_FRONTEND=noninteractive apt-get remove -y -qq "$@"; }
+elif command -v dnf >/dev/null 2>&1; then
+  pkg_installed() { rpm -q "$1" >/dev/null 2>&1; }
+  pkg_install() { dnf install -y -q "$@"; }
+  pkg_remove() { dnf remove -y -q "$@"; }
+elif command -v yum >/dev/null 2>&1; then
+  pkg_installed() { rpm -q "$1" >/dev/null 2>&1; }
+  pkg_install() { yum install -y -q "$@"; }
+  pkg_remove() { yum remove -y -q "$@"; }
+elif command -v zypper >/dev/null 2>&1; then
+  pkg_installed() { rpm -q "$1" >/dev/null 2>&1; }
+  pkg_install() { zypper --non-interactive install "$@"; }
+  pkg_remove() { zypper --non-interactive remove "$@"; }
+elif command -v apk >/dev/null 2>&1; then
+  pkg_installed() { apk info -e "$1" >/dev/null 2>&1; }
+  pkg_install() { apk add -q "$@"; }
+  pkg_remove() { apk del -q "$@"; }
+else
+  echo 'no package manager found' >&2
+  exit 1
+fi`
+
+func ensurePackageScript(p *opsv1.EnsurePackage) string {
+	pending := `if ! pkg_installed "$name"; then
+    pending="$pending $name"
+  fi`
+	action := "pkg_install"
+	if p.State == opsconstants.EnsureStateAbsent {
+		pending = `if pkg_installed "$name"; then
+    pending="$pending $name"
+  fi`
+		action = "pkg_remove"
+	}
+	return fmt.Sprintf(`%s
+names=%s
+pending=""
+for name in $names; do
+  %s
+done
+if [ -n "$pending" ]; then
+  %s $pending
+  changed=1
+fi
+`, ensurePackageManagers, shellDecode(p.Name), pending, action)
+}
+
+func ensureServiceScript(s *opsv1.EnsureService) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("name=%s\n", shellDecode(s.Name)))
+	switch s.State {
+	case opsconstants.EnsureStateStarted:
+		sb.WriteString(`if ! systemctl is-active --quiet "$name"; then
+  systemctl start "$name"
+  changed=1
+fi
+`)
+	case opsconstants.EnsureStateStopped:
+		sb.WriteString(`if systemctl is-active --quiet "$name"; then
+  systemctl stop "$name"
+  changed=1
+fi
+`)
+	}
+	if s.Enabled != nil && *s.Enabled {
+		sb.WriteString(`if ! systemctl is-enabled --quiet "$name"; then
+  systemctl enable --quiet "$name"
+  changed=1
+fi
+`)
+	} else if s.Enabled != nil {
+		sb.WriteString(`if systemctl is-enabled --quiet "$name"; then
+  systemctl disable --quiet "$name"
+  changed=1
+fi
+`)
+	}
+	return sb.String()
+}
@@ -0,0 +1,134 @@
+package task
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+func TestEnsureScript(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name    string
+		ensure  opsv1.EnsureStep
+		values  []string
+		wantErr bool
+	}{
+		{name: "file", ensure: opsv1.EnsureStep{File: &opsv1.EnsureFile{Path: "/etc/${x}'s.conf", Content: "a=$(id)\n", Mode: "0600", Owner: "root:root"}}, values: []string{"/etc/${x}'s.conf", "a=$(id)"}},
+		{name: "file bad mode", ensure: opsv1.EnsureStep{File: &opsv1.EnsureFile{Path: "/etc/a", Mode: "rw"}}, wantErr: true},
+		{name: "line", ensure: opsv1.EnsureStep{Line: &opsv1.EnsureLine{Path: "/etc/hosts", Line: "1.1.1.1 'a'", Regexp: "^1\\.1"}}, values: []string{"1.1.1.1 'a'"}},
+		{name: "package", ensure: opsv1.EnsureStep{Package: &opsv1.EnsurePackage{Name: "curl; reboot"}}, values: []string{"curl; reboot"}},
+		{name: "service", ensure: opsv1.EnsureStep{Service: &opsv1.EnsureService{Name: "nginx`id`", State: opsconstants.EnsureStateStarted, Enabled: &enabled}}, values: []string{"nginx`id`"}},
+		{name: "empty", ensure: opsv1.EnsureStep{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		script, err := EnsureScript(&tt.ensure)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !strings.HasPrefix(script, "set -e\n") {
+			t.Errorf("%s: script does not start with set -e", tt.name)
+		}
+		for _, change := range []string{opsconstants.EnsureChanged, opsconstants.EnsureUnchanged} {
+			if !strings.Contains(script, "echo '"+opsconstants.EnsureResultPrefix+change+"'") {
+				t.Errorf("%s: script does not print %s", tt.name, change)
+			}
+		}
+		// the values are passed in base64
+		for _, value := range tt.values {
+			if strings.Contains(script, value) {
+				t.Errorf("%s: script contains the value %q", tt.name, value)
+			}
+		}
+		if strings.Contains(script, "${") {
+			t.Errorf("%s: script contains ${", tt.name)
+		}
+	}
+}
+
+func TestEnsureScriptRun(t *testing.T) {
+	for _, cmd := range []string{"bash", "base64", "md5sum", "awk", "stat"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s not found", cmd)
+		}
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "a'b.conf")
+	run := func(e *opsv1.EnsureStep) string {
+		script, err := EnsureScript(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("bash", "-c", script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		result := &opsv1.ExecResult{Stdout: string(out)}
+		ParseEnsureResult(result)
+		return result.Changed
+	}
+	file := &opsv1.EnsureStep{File: &opsv1.EnsureFile{Path: path, Content: "a=${x}\n", Mode: "0600"}}
+	line := &opsv1.EnsureStep{Line: &opsv1.EnsureLine{Path: path, Line: "b='$(id)'", Regexp: "^b="}}
+	steps := []struct {
+		name string
+		step *opsv1.EnsureStep
+		want string
+	}{
+		{name: "create file", step: file, want: opsconstants.EnsureChanged},
+		{name: "file again", step: file, want: opsconstants.EnsureUnchanged},
+		{name: "add line", step: line, want: opsconstants.EnsureChanged},
+		{name: "line again", step: line, want: opsconstants.EnsureUnchanged},
+		{name: "replace line", step: &opsv1.EnsureStep{Line: &opsv1.EnsureLine{Path: path, Line: "b=2", Regexp: "^b="}}, want: opsconstants.EnsureChanged},
+		{name: "remove line", step: &opsv1.EnsureStep{Line: &opsv1.EnsureLine{Path: path, Regexp: "^b=", State: opsconstants.EnsureStateAbsent}}, want: opsconstants.EnsureChanged},
+		{name: "file after line removed", step: file, want: opsconstants.EnsureUnchanged},
+	}
+	for _, s := range steps {
+		if got := run(s.step); got != s.want {
+			t.Errorf("%s: changed = %q, want %q", s.name, got, s.want)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "a=${x}\n" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v", info.Mode().Perm(), err)
+	}
+	absent := &opsv1.EnsureStep{File: &opsv1.EnsureFile{Path: path, State: opsconstants.EnsureStateAbsent}}
+	if got := run(absent); got != opsconstants.EnsureChanged {
+		t.Errorf("remove file: changed = %q", got)
+	}
+	if got := run(absent); got != opsconstants.EnsureUnchanged {
+		t.Errorf("remove file again: changed = %q", got)
+	}
+}
+
+func TestParseEnsureResult(t *testing.T) {
+	tests := []struct {
+		stdout      string
+		wantStdout  string
+		wantChanged string
+	}{
+		{stdout: "installing\nops-ensure: changed\n", wantStdout: "installing\nchanged", wantChanged: "changed"},
+		{stdout: "ops-ensure: unchanged", wantStdout: "unchanged", wantChanged: "unchanged"},
+		{stdout: "ops-ensure: changed\nerror", wantStdout: "ops-ensure: changed\nerror"},
+		{stdout: "", wantStdout: ""},
+	}
+	for _, tt := range tests {
+		result := &opsv1.ExecResult{Stdout: tt.stdout}
+		ParseEnsureResult(result)
+		if result.Stdout != tt.wantStdout || result.Changed != tt.wantChanged {
+			t.Errorf("%q: stdout = %q, changed = %q, want %q, %q", tt.stdout, result.Stdout, result.Changed, tt.wantStdout, tt.wantChanged)
+		}
+	}
+	ParseEnsureResult(nil)
+}
@@ -0,0 +1,245 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/host"
+	"github.com/shaowenchen/ops/pkg/kube"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+	"github.com/shaowenchen/ops/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type cachedFact struct {
+	script string
+	value  string
+	time   time.Time
+}
+
+// factsCache keeps the gathered facts of every host and node, a fact is gathered again if it's stale or its script changed
+type factsCache struct {
+	mutex sync.Mutex
+	facts map[string]cachedFact
+}
+
+var gatheredFacts = factsCache{facts: make(map[string]cachedFact)}
+
+// GetHostFacts gets the built-in and custom facts of the host, only if the task refers to them,
+// the host status is used as the built-in facts if it's updated within the max age
+func GetHostFacts(ctx context.Context, hc *host.HostConnection, t *opsv1.Task, taskOpt option.TaskOption) (map[string]string, error) {
+	if !needFacts(t) {
+		return map[string]string{}, nil
+	}
+	maxAge := opsconstants.GetEnvFactsMaxAge()
+	facts := map[string]string{}
+	scripts := builtinFactScripts()
+	status := hc.Host.Status
+	if status.HeartStatus == opsconstants.StatusSuccessed && status.HeartTime != nil && time.Since(status.HeartTime.Time) < maxAge {
+		facts = HostFacts(status)
+		scripts = map[string]string{}
+	}
+	for name, script := range t.Spec.Facts {
+		scripts[name] = script
+	}
+	target := fmt.Sprintf("host/%s:%d", hc.Host.Spec.Address, hc.Host.Spec.Port)
+	gathered, err := gatherFacts(target, scripts, maxAge, func(script string) (string, error) {
+		result, err := hc.ShellResult(ctx, option.ShellOption{Sudo: taskOpt.Sudo, Content: script})
+		if result == nil {
+			return "", err
+		}
+		return result.Stdout, err
+	})
+	for k, v := range gathered {
+		facts[k] = v
+	}
+	return facts, err
+}
+
+// GetNodeFacts gets the built-in and custom facts of the node in a pod, only if the task refers to them,
+// the node info is used if the gathering failed
+func GetNodeFacts(ctx context.Context, logger *opslog.Logger, kc *kube.KubeConnection, node *corev1.Node, t *opsv1.Task, taskOpt option.TaskOption, kubeOpt option.KubeOption) (map[string]string, error) {
+	if !needFacts(t) {
+		return map[string]string{}, nil
+	}
+	scripts := builtinFactScripts()
+	for name, script := range t.Spec.Facts {
+		scripts[name] = script
+	}
+	target := fmt.Sprintf("node/%s/%s", node.Name, node.UID)
+	gathered, err := gatherFacts(target, scripts, opsconstants.GetEnvFactsMaxAge(), func(script string) (string, error) {
+		result, err := kc.ShellResultOnNode(ctx, logger, node, option.ShellOption{
+			Sudo:    taskOpt.Sudo,
+			Content: script,
+			Mode:    opsconstants.ModeHost,
+		}, kubeOpt)
+		if result == nil {
+			return "", err
+		}
+		return result.Stdout, err
+	})
+	facts := NodeFacts(node)
+	for k, v := range gathered {
+		if v != "" {
+			facts[k] = v
+		}
+	}
+	return facts, err
+}
+
+// needFacts checks the task refers to ${facts.<name>} or .Facts, the others don't pay for gathering
+func needFacts(t *opsv1.Task) bool {
+	if len(t.Spec.Facts) > 0 {
+		return true
+	}
+	data, err := json.Marshal(t.Spec)
+	if err != nil {
+		return true
+	}
+	return strings.Contains(string(data), "${"+opsconstants.FactsVariablePrefix) || strings.Contains(string(data), ".Facts")
+}
+
+// builtinFactScripts gather the facts named as the json fields of HostStatus, as the host controller does
+func builtinFactScripts() map[string]string {
+	timeout := opsconstants.DefaultShellTimeoutSeconds
+	return map[string]string{
+		"hostname":          utils.ShellHostname(),
+		"kernelVersion":     utils.ShellKernelVersion(),
+		"distribution":      utils.ShellDistribution(),
+		"arch":              utils.ShellArch(),
+		"diskTotal":         utils.ShellDiskTotal(timeout),
+		"diskUsagePercent":  utils.ShellDiskUsagePercent(timeout),
+		"cpuTotal":          utils.ShellCPUTotal(),
+		"cpuLoad1":          utils.ShellCPULoad1(),
+		"cpuUsagePercent":   utils.ShellCPUUsagePercent(),
+		"memTotal":          utils.ShellMemTotal(),
+		"memUsagePercent":   utils.ShellMemUsagePercent(),
+		"acceleratorVendor": utils.ShellAcceleratorVendor(),
+		"acceleratorModel":  utils.ShellAcceleratorModel(),
+		"acceleratorCount":  utils.ShellAcceleratorCount(),
+	}
+}
+
+// gatherFacts returns the facts of target, the cached ones younger than maxAge are reused,
+// the others are gathered by one script with run, the facts not in the output are not cached
+func gatherFacts(target string, scripts map[string]string, maxAge time.Duration, run func(script string) (string, error)) (map[string]string, error) {
+	facts := make(map[string]string)
+	missing := make(map[string]string)
+	now := time.Now()
+	gatheredFacts.mutex.Lock()
+	for name, script := range scripts {
+		cached, ok := gatheredFacts.facts[target+"/"+name]
+		if ok && cached.script == script && now.Sub(cached.time) < maxAge {
+			facts[name] = cached.value
+		} else {
+			missing[name] = script
+		}
+	}
+	gatheredFacts.mutex.Unlock()
+	if len(missing) == 0 {
+		return facts, nil
+	}
+	output, err := run(factsScript(missing))
+	values := parseFacts(output)
+	gatheredFacts.mutex.Lock()
+	defer gatheredFacts.mutex.Unlock()
+	for name, script := range missing {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		facts[name] = value
+		gatheredFacts.facts[target+"/"+name] = cachedFact{script: script, value: value, time: now}
+	}
+	return facts, err
+}
+
+// factsScript prints name=<base64 of the first line> of every script, so the output of one doesn't break the others
+func factsScript(scripts map[string]string) string {
+	var sb strings.Builder
+	for _, name := range sortedKeys(scripts) {
+		sb.WriteString(fmt.Sprintf("printf '%%s=' '%s'\n(\n%s\n) 2>/dev/null | head -n 1 | base64 | tr -d '\\n'\necho\n", name, scripts[name]))
+	}
+	return sb.String()
+}
+
+func parseFacts(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, encoded, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || name == "" {
+			continue
+		}
+		// base64 ends with =, only the first one separates the name
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		values[name] = strings.TrimSpace(string(value))
+	}
+	return values
+}
+
+// HostFacts are the facts of the host status, named as the json fields of HostStatus, the empty ones are omitted
+func HostFacts(status opsv1.HostStatus) map[string]string {
+	facts := map[string]string{
+		"hostname":          status.Hostname,
+		"kernelVersion":     status.KernelVersion,
+		"distribution":      status.Distribution,
+		"arch":              status.Arch,
+		"diskTotal":         status.DiskTotal,
+		"diskUsagePercent":  status.DiskUsagePercent,
+		"cpuTotal":          status.CPUTotal,
+		"cpuLoad1":          status.CPULoad1,
+		"cpuUsagePercent":   status.CPUUsagePercent,
+		"memTotal":          status.MemTotal,
+		"memUsagePercent":   status.MemUsagePercent,
+		"acceleratorVendor": status.AcceleratorVendor,
+		"acceleratorModel":  status.AcceleratorModel,
+		"acceleratorCount":  status.AcceleratorCount,
+	}
+	for k, v := range facts {
+		if v == "" {
+			delete(facts, k)
+		}
+	}
+	return facts
+}
+
+// NodeFacts are the facts of the node info, in the format of the host facts, such as x86_64 and ubuntu
+func NodeFacts(node *corev1.Node) map[string]string {
+	if node == nil {
+		return map[string]string{}
+	}
+	info := node.Status.NodeInfo
+	status := opsv1.HostStatus{
+		Hostname:      node.Labels[corev1.LabelHostname],
+		KernelVersion: info.KernelVersion,
+		Arch:          info.Architecture,
+	}
+	if status.Hostname == "" {
+		status.Hostname = node.Name
+	}
+	switch info.Architecture {
+	case "amd64":
+		status.Arch = "x86_64"
+	case "arm64":
+		status.Arch = "aarch64"
+	}
+	// the os image is such as Ubuntu 22.04.3 LTS, the first word is close to the ID of os-release
+	if fields := strings.Fields(info.OSImage); len(fields) > 0 {
+		status.Distribution = strings.ToLower(fields[0])
+	}
+	if cpu, ok := node.Status.Capacity[corev1.ResourceCPU]; ok {
+		status.CPUTotal = cpu.String()
+	}
+	return HostFacts(status)
+}
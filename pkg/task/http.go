@@ -0,0 +1,159 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+// RunHTTPStep sends the request from the current process, the stdout is the response body,
+// the exit code is 0 if the assertions passed, otherwise 1 with the error in stderr
+func RunHTTPStep(ctx context.Context, step opsv1.HTTPStep) (result *opsv1.ExecResult, err error) {
+	result = &opsv1.ExecResult{}
+	start := time.Now()
+	defer func() {
+		result.SetTime(start, time.Now())
+		if err != nil {
+			result.Stderr = err.Error()
+			if ctx.Err() == nil {
+				result.SetExitCode(1)
+			}
+			return
+		}
+		result.SetExitCode(0)
+	}()
+	reqCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, opsconstants.DefaultHTTPTimeoutSeconds*time.Second)
+		defer cancel()
+	}
+	client, err := newHTTPClient(step)
+	if err != nil {
+		return
+	}
+	req, err := newHTTPRequest(reqCtx, step)
+	if err != nil {
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, opsconstants.MaxHTTPBodyBytes))
+	if err != nil {
+		return
+	}
+	result.StatusCode = resp.StatusCode
+	result.Stdout = string(body)
+	expected, err := step.IsExpectedStatus(resp.StatusCode)
+	if err != nil {
+		return
+	}
+	if !expected {
+		expect := step.ExpectStatus
+		if expect == "" {
+			expect = "2xx"
+		}
+		err = fmt.Errorf("%s %s: status is %d, expected %s", req.Method, step.URL, resp.StatusCode, expect)
+		return
+	}
+	if step.ExpectBody != "" {
+		var re *regexp.Regexp
+		re, err = regexp.Compile(step.ExpectBody)
+		if err != nil {
+			return
+		}
+		if !re.Match(body) {
+			err = fmt.Errorf("%s %s: body not match %s", req.Method, step.URL, step.ExpectBody)
+			return
+		}
+	}
+	result.Values, err = extractHTTPValues(step.Extract, result.Stdout)
+	return
+}
+
+func newHTTPClient(step opsv1.HTTPStep) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: step.InsecureSkipVerify}
+	if step.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(step.CACert)) {
+			return nil, fmt.Errorf("no certificate found in caCert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if step.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(step.ClientCert), []byte(step.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+func newHTTPRequest(ctx context.Context, step opsv1.HTTPStep) (*http.Request, error) {
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	body := []byte(step.Body)
+	if len(step.JSON) > 0 {
+		data, err := json.Marshal(step.JSON)
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, step.URL, reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(step.JSON) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range step.Headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// extractHTTPValues gets the values of jsonPath from the JSON response
+func extractHTTPValues(extract map[string]string, body string) (map[string]string, error) {
+	if len(extract) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(extract))
+	for name := range extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make(map[string]string, len(extract))
+	for _, name := range names {
+		value, err := ExtractResult(opsv1.Result{Name: name, JsonPath: extract[name]}, body)
+		if err != nil {
+			return values, fmt.Errorf("extract %s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
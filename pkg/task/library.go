@@ -0,0 +1,139 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TaskLibrary gets the tasks referenced by uses and the snippets referenced by #include
+type TaskLibrary interface {
+	GetTask(namespace, name string) (*opsv1.Task, error)
+	// GetSnippet finds the snippet by name, or by name with any extension, such as send-event.py
+	GetSnippet(namespace, name string) (string, error)
+}
+
+type fileTaskLibrary struct {
+	proxy string
+	dirs  []string
+}
+
+// NewFileTaskLibrary finds <name>.yaml in dirs, and then the local and cloud tasks as opscli -f does,
+// the snippets are in the snippets directory of dirs and the local tasks, the namespace is ignored
+func NewFileTaskLibrary(proxy string, dirs ...string) TaskLibrary {
+	return &fileTaskLibrary{
+		proxy: proxy,
+		dirs:  append(dirs, opsconstants.GetOpsTaskDir()),
+	}
+}
+
+func (l *fileTaskLibrary) GetTask(namespace, name string) (*opsv1.Task, error) {
+	filePath := ""
+	for _, dir := range l.dirs {
+		for _, ext := range []string{".yaml", ".yml"} {
+			if info, err := os.Stat(filepath.Join(dir, name+ext)); err == nil && !info.IsDir() {
+				filePath = filepath.Join(dir, name+ext)
+				break
+			}
+		}
+		if filePath != "" {
+			break
+		}
+	}
+	if filePath == "" {
+		filePath = utils.GetTaskAbsoluteFilePath(l.proxy, name+".yaml")
+	}
+	tasks, err := ReadTaskYaml(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) != 1 {
+		return nil, fmt.Errorf("task %s not found", name)
+	}
+	return &tasks[0], nil
+}
+
+func (l *fileTaskLibrary) GetSnippet(namespace, name string) (string, error) {
+	for _, dir := range l.dirs {
+		files, err := os.ReadDir(filepath.Join(dir, opsconstants.SnippetsDir))
+		if err != nil {
+			continue
+		}
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			if !f.IsDir() {
+				names = append(names, f.Name())
+			}
+		}
+		if key, ok := findSnippetKey(names, name); ok {
+			content, err := os.ReadFile(filepath.Join(dir, opsconstants.SnippetsDir, key))
+			return string(content), err
+		}
+	}
+	return "", fmt.Errorf("snippet %s not found", name)
+}
+
+type clientTaskLibrary struct {
+	ctx    context.Context
+	client client.Client
+}
+
+// NewClientTaskLibrary gets the tasks from the cluster, the snippets are the keys of the ops-snippets ConfigMap
+// in the namespace of the task
+func NewClientTaskLibrary(ctx context.Context, c client.Client) TaskLibrary {
+	return &clientTaskLibrary{
+		ctx:    ctx,
+		client: c,
+	}
+}
+
+func (l *clientTaskLibrary) GetTask(namespace, name string) (*opsv1.Task, error) {
+	t := &opsv1.Task{}
+	err := l.client.Get(l.ctx, types.NamespacedName{Namespace: namespace, Name: name}, t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (l *clientTaskLibrary) GetSnippet(namespace, name string) (string, error) {
+	cm := &corev1.ConfigMap{}
+	err := l.client.Get(l.ctx, types.NamespacedName{Namespace: namespace, Name: opsconstants.SnippetsConfigMap}, cm)
+	if err != nil {
+		return "", fmt.Errorf("get snippet %s: %v", name, err)
+	}
+	keys := make([]string, 0, len(cm.Data))
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if key, ok := findSnippetKey(keys, name); ok {
+		return cm.Data[key], nil
+	}
+	return "", fmt.Errorf("snippet %s not found in configmap %s/%s", name, namespace, opsconstants.SnippetsConfigMap)
+}
+
+// findSnippetKey prefers the exact name, then the first one of name.<ext>
+func findSnippetKey(keys []string, name string) (string, bool) {
+	for _, key := range keys {
+		if key == name {
+			return key, true
+		}
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, name+".") && !strings.Contains(strings.TrimPrefix(key, name+"."), ".") {
+			return key, true
+		}
+	}
+	return "", false
+}
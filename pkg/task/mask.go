@@ -0,0 +1,149 @@
+package task
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+// Masker replaces the values of sensitive variables in the status and logs
+type Masker struct {
+	// values are sorted by length, the longer first
+	values   []string
+	replacer *strings.Replacer
+}
+
+// NewMasker masks the values in vars of the sensitive variables of task, nil if there is nothing to mask,
+// the forms quoted in the content of steps are masked as well
+func NewMasker(t *opsv1.Task, vars map[string]string) *Masker {
+	var values []string
+	seen := make(map[string]bool)
+	for k, v := range t.Spec.Variables {
+		if !v.IsSensitive() || vars[k] == "" {
+			continue
+		}
+		for _, value := range quotedForms(vars[k]) {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// the longer first, a value may contain another one
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	oldnew := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		oldnew = append(oldnew, value, opsconstants.MaskedValue)
+	}
+	return &Masker{values: values, replacer: strings.NewReplacer(oldnew...)}
+}
+
+// quotedForms are value and the forms of value quoted by renderShellContent and the json filter,
+// without the quotes around
+func quotedForms(value string) []string {
+	values := []string{value}
+	if data, err := json.Marshal(value); err == nil {
+		values = append(values, string(data[1:len(data)-1]))
+	}
+	var forms []string
+	for _, v := range values {
+		forms = append(forms, v)
+		for _, context := range []int{shellSingleQuoted, shellDoubleQuoted, shellHeredoc} {
+			quoted, _ := renderShellVariable("v", "", map[string]string{"v": v}, context)
+			forms = append(forms, quoted)
+		}
+	}
+	return forms
+}
+
+func (m *Masker) Mask(s string) string {
+	if m == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+// MaskResult masks the output of result in place
+func (m *Masker) MaskResult(result *opsv1.ExecResult) {
+	if m == nil || result == nil {
+		return
+	}
+	result.Stdout = m.Mask(result.Stdout)
+	result.Stderr = m.Mask(result.Stderr)
+	for k, v := range result.Values {
+		result.Values[k] = m.Mask(v)
+	}
+}
+
+// WrapWriter masks the writes, the end of a write which may be the start of a value is held back
+// until the next write or close, so a value split by writes is masked too
+func (m *Masker) WrapWriter(w io.WriteCloser) io.WriteCloser {
+	if m == nil {
+		return w
+	}
+	return &maskWriter{w: w, m: m}
+}
+
+type maskWriter struct {
+	w       io.WriteCloser
+	m       *Masker
+	pending []byte
+}
+
+func (mw *maskWriter) Write(p []byte) (int, error) {
+	mw.pending = append(mw.pending, p...)
+	masked, held := mw.m.maskPrefix(mw.pending)
+	mw.pending = append(mw.pending[:0], held...)
+	if len(masked) > 0 {
+		if _, err := io.WriteString(mw.w, masked); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (mw *maskWriter) Close() error {
+	if len(mw.pending) > 0 {
+		_, err := io.WriteString(mw.w, mw.m.Mask(string(mw.pending)))
+		mw.pending = nil
+		if err != nil {
+			mw.w.Close()
+			return err
+		}
+	}
+	return mw.w.Close()
+}
+
+// maskPrefix masks data up to the end or a position where a value may start but data ends,
+// the longer value wins as Mask, the rest is returned to be held back
+func (m *Masker) maskPrefix(data []byte) (masked string, held []byte) {
+	var sb strings.Builder
+	i := 0
+scan:
+	for i < len(data) {
+		rest := data[i:]
+		for _, value := range m.values {
+			if len(rest) >= len(value) {
+				if string(rest[:len(value)]) == value {
+					sb.WriteString(opsconstants.MaskedValue)
+					i += len(value)
+					continue scan
+				}
+			} else if strings.HasPrefix(value, string(rest)) {
+				break scan
+			}
+		}
+		sb.WriteByte(data[i])
+		i++
+	}
+	return sb.String(), data[i:]
+}
@@ -0,0 +1,109 @@
+package task
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func newTestMasker() *Masker {
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{Variables: opsv1.Variables{
+		"password": {Sensitive: true},
+		"token":    {SecretRef: &opsv1.VariableRef{Name: "s", Key: "k"}},
+		"short":    {Sensitive: true},
+		"plain":    {},
+	}}}
+	return NewMasker(task, map[string]string{
+		"password": "p@ssw0rd",
+		"token":    "p@ssw0rd-token",
+		"short":    "xy",
+		"plain":    "visible",
+	})
+}
+
+func TestMasker(t *testing.T) {
+	m := newTestMasker()
+	got := m.Mask("login p@ssw0rd with p@ssw0rd-token xy visible")
+	want := "login " + opsconstants.MaskedValue + " with " + opsconstants.MaskedValue + " " + opsconstants.MaskedValue + " visible"
+	if got != want {
+		t.Errorf("Mask() = %q, want %q", got, want)
+	}
+	if NewMasker(&opsv1.Task{}, nil) != nil {
+		t.Errorf("masker without sensitive variables is not nil")
+	}
+}
+
+func TestMaskerQuotedForms(t *testing.T) {
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{Variables: opsv1.Variables{"password": {Sensitive: true}}}}
+	vars := map[string]string{"password": `it's "s3cret" \ $1`}
+	m := NewMasker(task, vars)
+	for _, content := range []string{"echo ${password}", "echo '${password}'", `echo "${password}"`, "cat <<EOF\n${password}\nEOF", "echo ${password|json}", "echo ${password|shell}"} {
+		rendered := renderShellContent(content, vars)
+		if got := m.Mask(rendered); strings.Contains(got, "s3cret") {
+			t.Errorf("%q is masked as %q", rendered, got)
+		}
+	}
+}
+
+func TestMaskWriterSplitWrites(t *testing.T) {
+	m := newTestMasker()
+	for _, text := range []string{
+		"login p@ssw0rd ok\n",
+		"token p@ssw0rd-token\n",
+		"p@ssw0rd-tok is not the token\n",
+		"ends with p@ss",
+		"xyxxy p@ssw0rdp@ssw0rd",
+	} {
+		want := m.Mask(text)
+		// split the text into two writes at every position
+		for i := 0; i <= len(text); i++ {
+			buf := &closeBuffer{}
+			w := m.WrapWriter(buf)
+			w.Write([]byte(text[:i]))
+			w.Write([]byte(text[i:]))
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != want || !buf.closed {
+				t.Errorf("%q split at %d: %q, want %q", text, i, buf.String(), want)
+			}
+		}
+		// byte by byte
+		buf := &closeBuffer{}
+		w := m.WrapWriter(buf)
+		for i := range text {
+			w.Write([]byte{text[i]})
+		}
+		w.Close()
+		if buf.String() != want {
+			t.Errorf("%q byte by byte: %q, want %q", text, buf.String(), want)
+		}
+	}
+}
+
+func TestMaskWriterHoldsOnlyPrefix(t *testing.T) {
+	m := newTestMasker()
+	buf := &closeBuffer{}
+	w := m.WrapWriter(buf)
+	w.Write([]byte("line one\nsecret p@ss"))
+	if got := buf.String(); got != "line one\nsecret " {
+		t.Errorf("written = %q, want the text before the possible value", got)
+	}
+	w.Write([]byte("w0rd\n"))
+	if got := buf.String(); !strings.HasSuffix(got, opsconstants.MaskedValue+"\n") {
+		t.Errorf("written = %q", got)
+	}
+}
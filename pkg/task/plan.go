@@ -0,0 +1,85 @@
+package task
+
+import (
+	"regexp"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/option"
+	"github.com/shaowenchen/ops/pkg/utils"
+)
+
+// TaskPlan is what the task will do on a target, rendered without running anything
+type TaskPlan struct {
+	Task   string `json:"task" yaml:"task"`
+	Target string `json:"target" yaml:"target"`
+	// Variables are the variables of task and taskrun, after merged and rendered
+	Variables map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+	Steps     []StepPlan        `json:"steps" yaml:"steps"`
+}
+
+// StepPlan is the rendered step, the variables only known when running, such as ${result}, are kept as is
+type StepPlan struct {
+	opsv1.Step `json:",inline" yaml:",inline"`
+	// Run is the result of when: true, false, or unknown if it refers to the variables only known when running
+	Run string `json:"run" yaml:"run"`
+	// Reason is the error of when
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+var planVariableRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// PlanTask resolves the variables with the precedence of GetRealVariables, renders the steps with the known facts
+// and evaluates when conditions, the target is only used to name the plan, nothing is gathered
+func PlanTask(t *opsv1.Task, target string, taskOpt option.TaskOption) (*TaskPlan, error) {
+	allVars, err := GetRealVariables(t, taskOpt)
+	if err != nil {
+		return nil, err
+	}
+	plan := &TaskPlan{
+		Task:      t.GetUniqueKey(),
+		Target:    target,
+		Variables: make(map[string]string),
+	}
+	// the os env is not shown, it's too noisy and may be sensitive
+	for k := range t.Spec.Variables {
+		plan.Variables[k] = allVars[k]
+	}
+	for k := range taskOpt.Variables {
+		plan.Variables[k] = allVars[k]
+	}
+	plan.Variables = t.Spec.Variables.MaskSensitive(plan.Variables)
+	rendered, err := RenderTask(t.DeepCopy(), allVars)
+	if err != nil {
+		return nil, err
+	}
+	masker := NewMasker(t, allVars)
+	for _, s := range rendered.Spec.Steps {
+		step := StepPlan{Step: s}
+		step.Run, step.Reason = planWhen(s.When, allVars)
+		if err := RenderStepTemplate(&step.Step, allVars, taskOpt.Facts); err != nil {
+			step.Reason = err.Error()
+		}
+		eachStepField(&step.Step, masker.Mask)
+		step.Reason = masker.Mask(step.Reason)
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// planWhen evaluates when, the reason is the error if it's invalid
+func planWhen(when string, vars map[string]string) (run string, reason string) {
+	for _, match := range planVariableRegexp.FindAllStringSubmatch(when, -1) {
+		if _, ok := vars[match[1]]; !ok {
+			return opsconstants.PlanRunUnknown, ""
+		}
+	}
+	result, err := utils.LogicExpressionWithVars(when, vars, true)
+	if err != nil {
+		return opsconstants.PlanRunUnknown, err.Error()
+	}
+	if result {
+		return opsconstants.PlanRunTrue, ""
+	}
+	return opsconstants.PlanRunFalse, ""
+}
@@ -1,13 +1,17 @@
 package task
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"errors"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/option"
 	"github.com/shaowenchen/ops/pkg/utils"
 	"gopkg.in/yaml.v3"
@@ -15,17 +19,36 @@ import (
 
 func GetRealVariables(t *opsv1.Task, taskOpt option.TaskOption) (map[string]string, error) {
 	globalVariables := make(map[string]string)
-	// cli > env > yaml
-	utils.MergeMap(globalVariables, t.Spec.Variables.GetVariables())
+	// cli > env > ref > facts > yaml
+	yamlVariables := t.Spec.Variables.GetVariables()
+	utils.MergeMap(globalVariables, yamlVariables)
+	for k, v := range taskOpt.Facts {
+		globalVariables[opsconstants.FactsVariablePrefix+k] = v
+	}
+	utils.MergeMap(globalVariables, taskOpt.RefVariables)
 	utils.MergeMap(globalVariables, utils.GetAllOsEnv())
 	utils.MergeMap(globalVariables, taskOpt.Variables)
 
-	globalVariables = RenderVarsVariables(globalVariables)
+	// only the values of yaml may refer to other variables, the others are used as is
+	templates := make(map[string]bool)
+	for k, v := range yamlVariables {
+		if v != "" && globalVariables[k] == v {
+			templates[k] = true
+		}
+	}
+	if err := renderVariableTemplates(globalVariables, templates); err != nil {
+		return nil, err
+	}
 	// check variable in task is not empty
 	for k, v := range t.Spec.Variables {
 		if len(globalVariables[k]) == 0 && v.Required {
 			return nil, errors.New("please set variable: " + k)
 		}
+		if len(globalVariables[k]) > 0 {
+			if err := v.ValidateType(globalVariables[k]); err != nil {
+				return nil, fmt.Errorf("variable %s: %v", k, err)
+			}
+		}
 	}
 	return globalVariables, nil
 }
@@ -58,37 +81,410 @@ func ReadTaskYaml(filePath string) (tasks []opsv1.Task, err error) {
 	return
 }
 
+// RenderStepVariables renders the fields of step, the variables in the content of shell are quoted
+// by where they are unless ${name|raw}, see renderShellContent
 func RenderStepVariables(step *opsv1.Step, vars map[string]string) *opsv1.Step {
-	// replace all
-	// but in the case, result=${message} will cause error
-	// so we need to replace twice
-	// this implementation is not good
-	f := func() {
-		for key, value := range vars {
-			step.Name = strings.ReplaceAll(step.Name, fmt.Sprintf(`${%s}`, key), value)
-			step.Content = strings.ReplaceAll(step.Content, fmt.Sprintf(`${%s}`, key), value)
-			step.LocalFile = strings.ReplaceAll(step.LocalFile, fmt.Sprintf(`${%s}`, key), value)
-			step.RemoteFile = strings.ReplaceAll(step.RemoteFile, fmt.Sprintf(`${%s}`, key), value)
+	// the env is shared with the task
+	step.Env = copyStringMap(step.Env)
+	// the kube step is shared with the task
+	if step.Kube != nil {
+		kube := *step.Kube
+		step.Kube = &kube
+	}
+	// the ensure step is shared with the task
+	if step.Ensure != nil {
+		step.Ensure = step.Ensure.DeepCopy()
+	}
+	// the http step is shared with the task
+	if step.HTTP != nil {
+		httpStep := *step.HTTP
+		httpStep.Headers = copyStringMap(httpStep.Headers)
+		httpStep.JSON = copyStringMap(httpStep.JSON)
+		httpStep.Extract = copyStringMap(httpStep.Extract)
+		step.HTTP = &httpStep
+	}
+	// the fields of template are rendered by RenderStepTemplate
+	content, localFile, remoteFile := step.Content, step.LocalFile, step.RemoteFile
+	fileContent := ""
+	if step.Ensure != nil && step.Ensure.File != nil {
+		fileContent = step.Ensure.File.Content
+	}
+	// rendered in one pass, ${} in the values is not rendered again
+	eachStepField(step, func(field string) string {
+		return RenderString(field, vars)
+	})
+	if step.Template != "" {
+		step.Content, step.LocalFile, step.RemoteFile = content, localFile, remoteFile
+		if step.Ensure != nil && step.Ensure.File != nil {
+			step.Ensure.File.Content = fileContent
 		}
+	} else if isShellExecutor(step.Executor, content) {
+		step.Content = renderShellContent(content, vars)
 	}
-	f()
-	f()
-
 	return step
 }
 
-func RenderVarsVariables(vars map[string]string) map[string]string {
-	for key := range vars {
-		vars[key] = RenderString(vars[key], vars)
+// isShellExecutor checks the content runs in a shell, the others such as python are not quoted
+func isShellExecutor(executor, content string) bool {
+	if executor == "" {
+		executor = utils.GetDefaultExecutor(content)
+	}
+	fields := strings.Fields(executor)
+	if len(fields) == 0 {
+		return false
+	}
+	switch filepath.Base(fields[0]) {
+	case "sh", "bash", "zsh", "dash", "ash", "ksh":
+		return true
+	}
+	return false
+}
+
+// the shell contexts of the variables in the content
+const (
+	// shellWord is unquoted, in $(...) and `...` as well
+	shellWord = iota
+	shellSingleQuoted
+	shellDoubleQuoted
+	// shellArithmetic is in $((...)) and ((...))
+	shellArithmetic
+	// shellHeredoc is the body of <<EOF, expanded as double quotes without quoting "
+	shellHeredoc
+	// shellQuotedHeredoc is the body of <<'EOF', not expanded
+	shellQuotedHeredoc
+)
+
+type shellFrame struct {
+	context int
+	// closer ends the frame, ) of $(...) and $((...)), ` of `...`, the quote of quotes, 0 of the content
+	closer byte
+	// depth counts the parentheses of closer )
+	depth int
+}
+
+type shellHeredocBody struct {
+	delimiter string
+	stripTabs bool
+	quoted    bool
+}
+
+// renderShellContent renders content as RenderString, the values are quoted by the shell context of the variables,
+// so they are single words of the script: a single quoted word if unquoted, escaped in quotes and heredocs,
+// an integer in arithmetic. ${name|raw} is the value as is, ${name|shell} is always a single quoted word.
+func renderShellContent(content string, vars map[string]string) string {
+	var sb strings.Builder
+	stack := []shellFrame{{context: shellWord}}
+	var heredocs []shellHeredocBody
+	push := func(context int, closer byte, depth int) {
+		stack = append(stack, shellFrame{context: context, closer: closer, depth: depth})
+	}
+	for i := 0; i < len(content); {
+		top := &stack[len(stack)-1]
+		rest := content[i:]
+		if strings.HasPrefix(rest, "${") {
+			if end := strings.IndexByte(rest, '}'); end > 0 {
+				rendered := renderShellLine(rest[:end+1], vars, top.context)
+				// the shell removes a backslash before \ and ` in `...` first
+				for _, f := range stack {
+					if f.closer == '`' {
+						rendered = shellBacktickEscaper.Replace(rendered)
+					}
+				}
+				sb.WriteString(rendered)
+				i += end + 1
+				continue
+			}
+		}
+		c := content[i]
+		n := 1
+		switch top.context {
+		case shellSingleQuoted:
+			if c == '\'' {
+				stack = stack[:len(stack)-1]
+			}
+		case shellDoubleQuoted:
+			switch {
+			case c == '\\' && len(rest) > 1:
+				n = 2
+			case c == '"':
+				stack = stack[:len(stack)-1]
+			case c == '`':
+				push(shellWord, '`', 0)
+			case strings.HasPrefix(rest, "$(("):
+				push(shellArithmetic, ')', 2)
+				n = 3
+			case strings.HasPrefix(rest, "$("):
+				push(shellWord, ')', 1)
+				n = 2
+			}
+		default:
+			switch {
+			case c == '\\' && len(rest) > 1:
+				n = 2
+			case c == '\'':
+				push(shellSingleQuoted, '\'', 0)
+			case c == '"':
+				push(shellDoubleQuoted, '"', 0)
+			case c == '`' && top.closer == '`':
+				stack = stack[:len(stack)-1]
+			case c == '`':
+				push(shellWord, '`', 0)
+			case strings.HasPrefix(rest, "$(("):
+				push(shellArithmetic, ')', 2)
+				n = 3
+			case strings.HasPrefix(rest, "$("):
+				push(shellWord, ')', 1)
+				n = 2
+			case strings.HasPrefix(rest, "((") && top.context == shellWord && isShellCommandStart(content[:i]):
+				push(shellArithmetic, ')', 2)
+				n = 2
+			case c == '(' && top.closer == ')':
+				top.depth++
+			case c == ')' && top.closer == ')':
+				if top.depth--; top.depth == 0 {
+					stack = stack[:len(stack)-1]
+				}
+			case c == '#' && top.context == shellWord && isShellWordStart(content[:i]):
+				// the comment, a quote in it is not a quote
+				n = len(rest)
+				if end := strings.IndexByte(rest, '\n'); end >= 0 {
+					n = end
+				}
+				sb.WriteString(renderShellLine(rest[:n], vars, shellWord))
+				i += n
+				continue
+			case strings.HasPrefix(rest, "<<") && !strings.HasPrefix(rest, "<<<") && top.context == shellWord:
+				var h shellHeredocBody
+				h, n = parseShellHeredoc(rest)
+				if h.delimiter != "" {
+					heredocs = append(heredocs, h)
+				}
+			}
+		}
+		sb.WriteString(rest[:n])
+		i += n
+		// the bodies of heredocs start from the next line
+		if c == '\n' && len(heredocs) > 0 && top.context != shellSingleQuoted && top.context != shellDoubleQuoted {
+			for _, h := range heredocs {
+				i = renderShellHeredoc(&sb, content, i, h, vars)
+			}
+			heredocs = nil
+		}
+	}
+	return sb.String()
+}
+
+// renderShellVariable renders ${name|filter} in context, false if the variable or filter is unknown
+func renderShellVariable(name, filter string, vars map[string]string, context int) (string, bool) {
+	value, ok := vars[name]
+	if !ok {
+		return "", false
+	}
+	escaped, ok := escapeValue(value, filter)
+	if !ok || filter == opsconstants.RenderFilterRaw || filter == opsconstants.RenderFilterShell {
+		return escaped, ok
+	}
+	switch context {
+	case shellSingleQuoted:
+		return strings.ReplaceAll(escaped, "'", `'"'"'`), true
+	case shellDoubleQuoted:
+		return shellDoubleQuoteEscaper.Replace(escaped), true
+	case shellHeredoc:
+		return shellHeredocEscaper.Replace(escaped), true
+	case shellQuotedHeredoc:
+		return escaped, true
+	case shellArithmetic:
+		// the others are words, which are errors of arithmetic rather than expressions
+		if _, err := strconv.ParseInt(escaped, 10, 64); err == nil {
+			return escaped, true
+		}
+	}
+	return utils.ShellQuote(escaped), true
+}
+
+var (
+	shellDoubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	shellHeredocEscaper     = strings.NewReplacer(`\`, `\\`, `$`, `\$`, "`", "\\`")
+	shellBacktickEscaper    = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+)
+
+// parseShellHeredoc parses the heredoc operator at the start of s, <<EOF, <<-EOF, <<'EOF' or <<"EOF",
+// n is the length of the operator
+func parseShellHeredoc(s string) (h shellHeredocBody, n int) {
+	n = 2
+	if strings.HasPrefix(s[n:], "-") {
+		h.stripTabs = true
+		n++
+	}
+	for n < len(s) && (s[n] == ' ' || s[n] == '\t') {
+		n++
+	}
+	start := n
+	for n < len(s) && !strings.ContainsRune(" \t\n;&|<>()", rune(s[n])) {
+		n++
+	}
+	word := s[start:n]
+	h.delimiter = strings.NewReplacer(`'`, "", `"`, "", `\`, "").Replace(word)
+	h.quoted = h.delimiter != word
+	return h, n
+}
+
+// renderShellHeredoc renders the body of heredoc h from i to the delimiter line, returns the end of the body
+func renderShellHeredoc(sb *strings.Builder, content string, i int, h shellHeredocBody, vars map[string]string) int {
+	context := shellHeredoc
+	if h.quoted {
+		context = shellQuotedHeredoc
+	}
+	for i < len(content) {
+		end := strings.IndexByte(content[i:], '\n')
+		if end < 0 {
+			end = len(content) - i
+		} else {
+			end++
+		}
+		line := content[i : i+end]
+		trimmed := strings.TrimSuffix(line, "\n")
+		if h.stripTabs {
+			trimmed = strings.TrimLeft(trimmed, "\t")
+		}
+		if trimmed == h.delimiter {
+			sb.WriteString(line)
+			return i + end
+		}
+		sb.WriteString(renderShellLine(line, vars, context))
+		i += end
 	}
-	return vars
+	return i
+}
+
+// renderShellLine renders the variables of line in context, such as a comment or a line of heredoc
+func renderShellLine(line string, vars map[string]string, context int) string {
+	return scanVariables(line, func(name, filter string) (string, bool) {
+		return renderShellVariable(name, filter, vars, context)
+	})
+}
+
+// isShellWordStart checks a word starts after prefix
+func isShellWordStart(prefix string) bool {
+	return prefix == "" || strings.ContainsRune(" \t\n;&|(", rune(prefix[len(prefix)-1]))
 }
 
+// isShellCommandStart checks a command starts after prefix, (( is arithmetic rather than nested subshells
+func isShellCommandStart(prefix string) bool {
+	prefix = strings.TrimRight(prefix, " \t")
+	return prefix == "" || strings.ContainsRune("\n;&|(", rune(prefix[len(prefix)-1]))
+}
+
+// eachStepField replaces the rendered fields of step, when and allowfailure are not included,
+// they are evaluated with the variables
+func eachStepField(step *opsv1.Step, replace func(field string) string) {
+	for _, field := range []*string{&step.Name, &step.Content, &step.LocalFile, &step.RemoteFile, &step.Executor, &step.WorkDir} {
+		*field = replace(*field)
+	}
+	for k, v := range step.Env {
+		step.Env[k] = replace(v)
+	}
+	if step.Kube != nil {
+		for _, field := range []*string{&step.Kube.APIVersion, &step.Kube.Kind, &step.Kube.Namespace, &step.Kube.Name,
+			&step.Kube.LabelSelector, &step.Kube.Manifest, &step.Kube.Patch, &step.Kube.Condition, &step.Kube.JsonPath, &step.Kube.Value} {
+			*field = replace(*field)
+		}
+	}
+	if e := step.Ensure; e != nil {
+		var fields []*string
+		switch {
+		case e.File != nil:
+			fields = []*string{&e.File.Path, &e.File.Content, &e.File.Mode, &e.File.Owner}
+		case e.Line != nil:
+			fields = []*string{&e.Line.Path, &e.Line.Line, &e.Line.Regexp}
+		case e.Package != nil:
+			fields = []*string{&e.Package.Name}
+		case e.Service != nil:
+			fields = []*string{&e.Service.Name}
+		}
+		for _, field := range fields {
+			*field = replace(*field)
+		}
+	}
+	if step.HTTP != nil {
+		for _, field := range []*string{&step.HTTP.URL, &step.HTTP.Method, &step.HTTP.Body, &step.HTTP.CACert,
+			&step.HTTP.ClientCert, &step.HTTP.ClientKey, &step.HTTP.ExpectStatus, &step.HTTP.ExpectBody} {
+			*field = replace(*field)
+		}
+		for _, m := range []map[string]string{step.HTTP.Headers, step.HTTP.JSON, step.HTTP.Extract} {
+			for k, v := range m {
+				m[k] = replace(v)
+			}
+		}
+	}
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// RenderString replaces ${name} with the value of vars in one pass, the values are not rendered again,
+// ${name|shell} and ${name|json} escape the value as a single quoted shell word and a JSON string,
+// ${name|raw} is the value as is, the unknown variables are kept as is
 func RenderString(target string, vars map[string]string) string {
-	for key, value := range vars {
-		if strings.Contains(target, fmt.Sprintf(`${%s}`, key)) {
-			target = strings.ReplaceAll(target, fmt.Sprintf(`${%s}`, key), value)
+	return scanVariables(target, func(name, filter string) (string, bool) {
+		value, ok := vars[name]
+		if !ok {
+			return "", false
+		}
+		return escapeValue(value, filter)
+	})
+}
+
+// scanVariables replaces every ${name} or ${name|filter} in target with the result of replace,
+// it's kept as is if replace returns false
+func scanVariables(target string, replace func(name, filter string) (string, bool)) string {
+	if !strings.Contains(target, "${") {
+		return target
+	}
+	var sb strings.Builder
+	for {
+		start := strings.Index(target, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(target[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+		name, filter, _ := strings.Cut(target[start+2:end], "|")
+		sb.WriteString(target[:start])
+		if value, ok := replace(name, filter); ok {
+			sb.WriteString(value)
+		} else {
+			sb.WriteString(target[start : end+1])
+		}
+		target = target[end+1:]
+	}
+	sb.WriteString(target)
+	return sb.String()
+}
+
+func escapeValue(value, filter string) (string, bool) {
+	switch filter {
+	case "", opsconstants.RenderFilterRaw:
+		return value, true
+	case opsconstants.RenderFilterShell:
+		return utils.ShellQuote(value), true
+	case opsconstants.RenderFilterJson:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", false
 		}
+		return string(data), true
 	}
-	return target
+	return "", false
 }
@@ -0,0 +1,131 @@
+package task
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+func TestRenderString(t *testing.T) {
+	vars := map[string]string{
+		"name":   "world",
+		"quote":  `it's "quoted"`,
+		"nested": "${name}",
+	}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "hello ${name}", want: "hello world"},
+		{target: "${name}${name}", want: "worldworld"},
+		{target: "${nested}", want: "${name}"},
+		{target: "${unknown} ${name}", want: "${unknown} world"},
+		{target: "${name|unknown}", want: "${name|unknown}"},
+		{target: "${name", want: "${name"},
+		{target: "echo ${quote|shell}", want: `echo 'it'"'"'s "quoted"'`},
+		{target: "${quote|json}", want: `"it's \"quoted\""`},
+		{target: "${quote|raw}", want: `it's "quoted"`},
+	}
+	for _, tt := range tests {
+		if got := RenderString(tt.target, vars); got != tt.want {
+			t.Errorf("RenderString(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRenderStepVariables(t *testing.T) {
+	vars := map[string]string{
+		"plain":  "a b",
+		"secret": "x'; rm -rf /; echo '",
+	}
+	tests := []struct {
+		name string
+		step opsv1.Step
+		want string
+	}{
+		{name: "shell", step: opsv1.Step{Content: "echo ${plain} ${secret}"}, want: `echo 'a b' 'x'"'"'; rm -rf /; echo '"'"''`},
+		{name: "bash executor", step: opsv1.Step{Content: "echo ${secret}", Executor: "/bin/bash -e"}, want: `echo 'x'"'"'; rm -rf /; echo '"'"''`},
+		{name: "raw", step: opsv1.Step{Content: "echo ${secret|raw}"}, want: "echo x'; rm -rf /; echo '"},
+		{name: "shell filter", step: opsv1.Step{Content: `echo "${plain|shell}"`}, want: `echo "'a b'"`},
+		{name: "json filter", step: opsv1.Step{Content: "echo ${plain|json}"}, want: `echo '"a b"'`},
+		{name: "python", step: opsv1.Step{Content: "print('${secret}')", Executor: "python3"}, want: "print('x'; rm -rf /; echo '')"},
+	}
+	for _, tt := range tests {
+		step := tt.step
+		if got := RenderStepVariables(&step, vars).Content; got != tt.want {
+			t.Errorf("%s: content = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// the other fields are not quoted
+	step := &opsv1.Step{Name: "${secret}", Env: map[string]string{"SECRET": "${secret}"}}
+	env := step.Env
+	RenderStepVariables(step, vars)
+	if step.Name != vars["secret"] || step.Env["SECRET"] != vars["secret"] {
+		t.Errorf("name = %q, env = %q", step.Name, step.Env["SECRET"])
+	}
+	if env["SECRET"] != "${secret}" {
+		t.Errorf("env of task is changed to %q", env["SECRET"])
+	}
+}
+
+func TestRenderShellContent(t *testing.T) {
+	vars := map[string]string{"v": `it's "$x"`, "n": "3", "name": "web"}
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"echo ${v}", `echo 'it'"'"'s "$x"'`},
+		{"echo '${v}'", `echo 'it'"'"'s "$x"'`},
+		{`echo "${v}"`, `echo "it's \"\$x\""`},
+		{`echo "$(echo ${v})"`, `echo "$(echo 'it'"'"'s "$x"')"`},
+		{"echo `echo ${v}`", "echo `echo 'it'\"'\"'s \"$x\"'`"},
+		{"echo $((${n}+1)) $((${name}+1))", "echo $((3+1)) $(('web'+1))"},
+		{"(( ${n} > 1 )) && echo ${name}", "(( 3 > 1 )) && echo 'web'"},
+		{"# it's ${name}\necho ${name}", "# it's 'web'\necho 'web'"},
+		{"echo a#b'${name}'", "echo a#b'web'"},
+		{"echo ${unknown} ${name|unknown} $HOME ${HOME:-/}", "echo ${unknown} ${name|unknown} $HOME ${HOME:-/}"},
+		{"cat <<EOF\nit's ${v}\nEOF\necho ${name}", "cat <<EOF\nit's it's \"\\$x\"\nEOF\necho 'web'"},
+		{"cat <<-'EOF' | grep '${name}'\n\t${v}\n\tEOF\necho ${n}", "cat <<-'EOF' | grep 'web'\n\tit's \"$x\"\n\tEOF\necho '3'"},
+		{"echo $(( 1 << ${n} ))", "echo $(( 1 << 3 ))"},
+	}
+	for _, tt := range tests {
+		if got := renderShellContent(tt.content, vars); got != tt.want {
+			t.Errorf("renderShellContent(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRenderShellQuotedValue(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	contents := []string{
+		"printf %s ${v}",
+		"printf '%s' '${v}'",
+		`printf "%s" "${v}"`,
+		`printf %s "$(printf %s ${v})"`,
+		"printf %s \"`printf %s ${v}`\"",
+		"# it's a comment\nprintf %s ${v}",
+		"cat <<EOF\n${v}\nEOF",
+		"cat <<'EOF'\n${v}\nEOF",
+	}
+	for _, value := range []string{"", "a b", `it's`, `"$HOME" $(id) ${x} \ ;|&`, "line1\nline2", "`id`"} {
+		for _, content := range contents {
+			content = renderShellContent(content, map[string]string{"v": value})
+			out, err := exec.Command("sh", "-c", content).Output()
+			if err != nil {
+				t.Errorf("%q: %v", content, err)
+				continue
+			}
+			want := value
+			if strings.Contains(content, "EOF") {
+				want += "\n"
+			}
+			if string(out) != want {
+				t.Errorf("%q printed %q, want %q", content, out, want)
+			}
+		}
+	}
+}
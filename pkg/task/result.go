@@ -0,0 +1,166 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// CaptureResults extracts the results declared by task from the step outputs,
+// readFile is used to read result files on the target
+func CaptureResults(logger *opslog.Logger, t *opsv1.Task, stepOutputs map[string]string, lastOutput string, readFile func(path string) (string, error)) map[string]string {
+	results := make(map[string]string)
+	for _, r := range t.Spec.Results {
+		source := lastOutput
+		if r.File != "" {
+			output, err := readFile(r.File)
+			if err != nil {
+				logger.Error.Println(fmt.Sprintf("read result %s from file %s error: %s", r.Name, r.File, err))
+				continue
+			}
+			source = output
+		} else if r.Step != "" {
+			output, ok := stepOutputs[r.Step]
+			if !ok {
+				logger.Error.Println(fmt.Sprintf("result %s: step %s not run", r.Name, r.Step))
+				continue
+			}
+			source = output
+		}
+		value, err := ExtractResult(r, source)
+		if err != nil {
+			logger.Error.Println(fmt.Sprintf("capture result %s error: %s", r.Name, err))
+			continue
+		}
+		results[r.Name] = value
+	}
+	return results
+}
+
+// ExtractResult applies jsonPath and then regex to the output, the value must be the type of result
+func ExtractResult(r opsv1.Result, output string) (string, error) {
+	value := strings.TrimSpace(output)
+	if r.JsonPath != "" {
+		var data interface{}
+		if err := json.Unmarshal([]byte(value), &data); err != nil {
+			return "", err
+		}
+		path := r.JsonPath
+		if !strings.HasPrefix(path, "{") {
+			path = "{" + path + "}"
+		}
+		jp := jsonpath.New(r.Name)
+		jsonOutput := r.Type == opsconstants.VariableTypeList || r.Type == opsconstants.VariableTypeMap || r.Type == opsconstants.VariableTypeJson
+		jp.EnableJSONOutput(jsonOutput)
+		if err := jp.Parse(path); err != nil {
+			return "", err
+		}
+		buf := new(bytes.Buffer)
+		if err := jp.Execute(buf, data); err != nil {
+			return "", err
+		}
+		value = strings.TrimSpace(buf.String())
+		if jsonOutput {
+			value = compactJsonOutput(value)
+		}
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return "", err
+		}
+		match := re.FindStringSubmatch(value)
+		if match == nil {
+			return "", fmt.Errorf("regex %s not match", r.Regex)
+		}
+		value = match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+	}
+	if err := r.ValidateType(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// compactJsonOutput keeps the indented json output in one line, the output is an array of
+// the matched values, a single object or array matched is unwrapped
+func compactJsonOutput(value string) string {
+	var matched []json.RawMessage
+	if err := json.Unmarshal([]byte(value), &matched); err == nil && len(matched) == 1 {
+		if first := bytes.TrimSpace(matched[0]); len(first) > 0 && (first[0] == '{' || first[0] == '[') {
+			value = string(first)
+		}
+	}
+	compact := new(bytes.Buffer)
+	if json.Compact(compact, []byte(value)) == nil {
+		value = compact.String()
+	}
+	return value
+}
+
+// RenderTaskResults replaces ${tasks.<name>.results.<key>} with the results of finished tasks,
+// it fails if a result is referenced but not captured, such as a typo or a skipped or failed task
+func RenderTaskResults(target string, taskResults map[string]map[string]string) (string, error) {
+	vars := make(map[string]string)
+	for name, results := range taskResults {
+		for key, value := range results {
+			vars[fmt.Sprintf("tasks.%s.results.%s", name, key)] = value
+		}
+	}
+	var missing []string
+	rendered := scanVariables(target, func(name, filter string) (string, bool) {
+		value, ok := vars[name]
+		if !ok {
+			if strings.HasPrefix(name, opsconstants.TaskResultsVariablePrefix) {
+				missing = append(missing, name)
+			}
+			return "", false
+		}
+		return escapeValue(value, filter)
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("result %s is not found", strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
+
+// SetStepVariables exposes the step to later when and allowfailure expressions as
+// ${steps.<name>.result}, ${steps.<name>.status}, ${steps.<name>.exitcode}, ${steps.<name>.stdout},
+// ${steps.<name>.stderr}, ${steps.<name>.statuscode} and ${steps.<name>.values.<key>} of http steps,
+// and ${results.<key>} of the results captured from it
+func SetStepVariables(allVars map[string]string, t *opsv1.Task, stepName, stepOutput, stepStatus string, stepResult *opsv1.ExecResult) {
+	allVars[fmt.Sprintf("steps.%s.result", stepName)] = strings.TrimSpace(stepOutput)
+	allVars[fmt.Sprintf("steps.%s.status", stepName)] = stepStatus
+	allVars[fmt.Sprintf("steps.%s.exitcode", stepName)] = GetExitCodeVariable(stepResult)
+	if stepResult != nil {
+		allVars[fmt.Sprintf("steps.%s.stdout", stepName)] = strings.TrimSpace(stepResult.Stdout)
+		allVars[fmt.Sprintf("steps.%s.stderr", stepName)] = strings.TrimSpace(stepResult.Stderr)
+		if stepResult.StatusCode > 0 {
+			allVars[fmt.Sprintf("steps.%s.statuscode", stepName)] = strconv.Itoa(stepResult.StatusCode)
+		}
+		for key, value := range stepResult.Values {
+			allVars[fmt.Sprintf("steps.%s.values.%s", stepName, key)] = value
+		}
+		if stepResult.Changed != "" {
+			allVars[fmt.Sprintf("steps.%s.changed", stepName)] = stepResult.Changed
+		}
+	}
+	for _, r := range t.Spec.Results {
+		if r.File != "" || (r.Step != "" && r.Step != stepName) {
+			continue
+		}
+		if value, err := ExtractResult(r, stepOutput); err == nil {
+			allVars["results."+r.Name] = value
+		}
+	}
+}
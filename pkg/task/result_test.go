@@ -0,0 +1,136 @@
+package task
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+)
+
+func TestExtractResult(t *testing.T) {
+	json := `{"items":[{"metadata":{"name":"pod-a","labels":{"app":"web"}}},{"metadata":{"name":"pod-b"}}]}`
+	tests := []struct {
+		name    string
+		result  opsv1.Result
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{name: "trimmed output", result: opsv1.Result{}, output: "  v1.2.3\n", want: "v1.2.3"},
+		{name: "regex group", result: opsv1.Result{Regex: `version (\S+)`}, output: "app version 1.2.3 built", want: "1.2.3"},
+		{name: "regex whole match", result: opsv1.Result{Regex: `\d+%`}, output: "disk used 87% of /", want: "87%"},
+		{name: "regex not match", result: opsv1.Result{Regex: `\d+%`}, output: "no usage", wantErr: true},
+		{name: "bad regex", result: opsv1.Result{Regex: `(`}, output: "x", wantErr: true},
+		{name: "jsonpath", result: opsv1.Result{JsonPath: "{.items[0].metadata.name}"}, output: json, want: "pod-a"},
+		{name: "jsonpath without braces", result: opsv1.Result{JsonPath: ".items[1].metadata.name"}, output: json, want: "pod-b"},
+		{name: "jsonpath and regex", result: opsv1.Result{JsonPath: ".items[0].metadata.name", Regex: `-(\w+)$`}, output: json, want: "a"},
+		{name: "jsonpath map", result: opsv1.Result{JsonPath: ".items[0].metadata.labels", Type: "map"}, output: json, want: `{"app":"web"}`},
+		{name: "jsonpath list", result: opsv1.Result{JsonPath: ".items[*].metadata.name", Type: "list"}, output: json, want: `["pod-a","pod-b"]`},
+		{name: "jsonpath one item list", result: opsv1.Result{JsonPath: ".items[0].metadata.name", Type: "list"}, output: json, want: `["pod-a"]`},
+		{name: "jsonpath json", result: opsv1.Result{JsonPath: ".items[1]", Type: "json"}, output: json, want: `{"metadata":{"name":"pod-b"}}`},
+		{name: "jsonpath invalid json", result: opsv1.Result{JsonPath: ".a"}, output: "not json", wantErr: true},
+		{name: "jsonpath missing key", result: opsv1.Result{JsonPath: ".missing"}, output: json, wantErr: true},
+		{name: "int", result: opsv1.Result{Regex: `(\d+) pods`, Type: "int"}, output: "3 pods", want: "3"},
+		{name: "not int", result: opsv1.Result{Type: "int"}, output: "three", wantErr: true},
+		{name: "bool", result: opsv1.Result{Type: "bool"}, output: "true\n", want: "true"},
+	}
+	for _, tt := range tests {
+		got, err := ExtractResult(tt.result, tt.output)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: ExtractResult() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureResults(t *testing.T) {
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{Results: []opsv1.Result{
+		{Name: "last", Regex: `done (\w+)`},
+		{Name: "first", Step: "first", Regex: `id=(\d+)`},
+		{Name: "skipped", Step: "never"},
+		{Name: "file", File: "/tmp/ops result.txt"},
+		{Name: "missing", File: "/tmp/missing"},
+		{Name: "typed", Step: "first", Type: "int"},
+	}}}
+	stepOutputs := map[string]string{"first": "id=42", "second": "done ok"}
+	var readPaths []string
+	readFile := func(path string) (string, error) {
+		readPaths = append(readPaths, path)
+		if path == "/tmp/ops result.txt" {
+			return "from file\n", nil
+		}
+		return "", errors.New("no such file")
+	}
+	got := CaptureResults(opslog.NewLogger().Build(), task, stepOutputs, "done ok", readFile)
+	want := map[string]string{"last": "ok", "first": "42", "file": "from file"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CaptureResults() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(readPaths, []string{"/tmp/ops result.txt", "/tmp/missing"}) {
+		t.Errorf("read paths = %v", readPaths)
+	}
+}
+
+func TestRenderTaskResults(t *testing.T) {
+	taskResults := map[string]map[string]string{"get-pod": {"podName": "pod-a"}}
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "${tasks.get-pod.results.podName}", want: "pod-a"},
+		{target: "ns/${tasks.get-pod.results.podName|json}", want: `ns/"pod-a"`},
+		{target: "${namespace} ${tasks.get-pod.results.podName}", want: "${namespace} pod-a"},
+		{target: "${tasks.get-pod.results.podname}", wantErr: true},
+		{target: "${tasks.skipped.results.podName}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := RenderTaskResults(tt.target, taskResults)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTaskResultsQuotedInShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	podName := `pod-a'; echo injected; echo '$(id) ${namespace}`
+	taskResults := map[string]map[string]string{"get-pod": {"podName": podName}}
+	// the pipeline passes the result to the variable of the next task, which is rendered as the variables of the TaskRun
+	pod, err := RenderTaskResults("${tasks.get-pod.results.podName}", taskResults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{
+		Variables: opsv1.Variables{"pod": {Required: true}, "namespace": {Default: "default"}},
+		Steps: []opsv1.Step{
+			{Name: "bare", Content: "printf %s ${pod}"},
+			{Name: "double", Content: `printf %s "${pod}"`},
+			{Name: "single", Content: "printf %s '${pod}'"},
+		},
+	}}
+	vars, err := GetRealVariables(task, option.TaskOption{Variables: map[string]string{"pod": pod}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, _ = RenderTask(task, vars)
+	for _, s := range task.Spec.Steps {
+		out, err := exec.Command("sh", "-c", s.Content).Output()
+		if err != nil || string(out) != podName {
+			t.Errorf("%s: %q printed %q, %v, want %q", s.Name, s.Content, out, err, podName)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunRollout runs the nodes batch by batch, each batch with a pool of rollout.Parallelism workers.
+// run returns the run status of the node at index, onBatch is called when a batch starts and finishes,
+// no worker is running then. No more node starts after ctx is done or the failure threshold is exceeded.
+func RunRollout(ctx context.Context, rollout opsv1.Rollout, nodeNames []string, run func(index int) string, onBatch func(batches []*opsv1.TaskRunBatchStatus)) []*opsv1.TaskRunBatchStatus {
+	batchSize := rollout.GetBatchSize(len(nodeNames))
+	batches := make([]*opsv1.TaskRunBatchStatus, 0)
+	for start := 0; start < len(nodeNames); start += batchSize {
+		end := start + batchSize
+		if end > len(nodeNames) {
+			end = len(nodeNames)
+		}
+		batches = append(batches, &opsv1.TaskRunBatchStatus{Nodes: nodeNames[start:end]})
+	}
+	var mu sync.Mutex
+	failed := 0
+	isStopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctx.Err() != nil || rollout.IsFailureExceeded(failed)
+	}
+	for bi, batch := range batches {
+		if isStopped() {
+			batch.RunStatus = opsconstants.StatusSkipped
+			continue
+		}
+		batch.RunStatus = opsconstants.StatusRunning
+		batch.StartTime = &metav1.Time{Time: time.Now()}
+		onBatch(batches)
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < rollout.GetParallelism() && w < len(batch.Nodes); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range jobs {
+					status := run(index)
+					mu.Lock()
+					if status == opsconstants.StatusSuccessed {
+						batch.Successed++
+					} else {
+						batch.Failed++
+						failed++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for i := range batch.Nodes {
+			if isStopped() {
+				break
+			}
+			jobs <- bi*batchSize + i
+		}
+		close(jobs)
+		wg.Wait()
+		if batch.Failed > 0 {
+			batch.RunStatus = opsconstants.StatusFailed
+		} else if batch.Successed < len(batch.Nodes) {
+			batch.RunStatus = opsconstants.StatusAborted
+		} else {
+			batch.RunStatus = opsconstants.StatusSuccessed
+		}
+		onBatch(batches)
+	}
+	return batches
+}
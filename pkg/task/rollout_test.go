@@ -0,0 +1,173 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestRunRolloutBatches(t *testing.T) {
+	nodeNames := []string{"n0", "n1", "n2", "n3", "n4"}
+	tests := []struct {
+		name        string
+		rollout     opsv1.Rollout
+		wantBatches [][]string
+	}{
+		{name: "one batch by default", rollout: opsv1.Rollout{}, wantBatches: [][]string{nodeNames}},
+		{name: "batch size 2", rollout: opsv1.Rollout{BatchSize: 2, Parallelism: 2}, wantBatches: [][]string{{"n0", "n1"}, {"n2", "n3"}, {"n4"}}},
+		{name: "batch size over nodes", rollout: opsv1.Rollout{BatchSize: 10}, wantBatches: [][]string{nodeNames}},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		ran := make(map[int]int)
+		batches := RunRollout(context.Background(), tt.rollout, nodeNames, func(index int) string {
+			mu.Lock()
+			ran[index]++
+			mu.Unlock()
+			return opsconstants.StatusSuccessed
+		}, func([]*opsv1.TaskRunBatchStatus) {})
+		if len(batches) != len(tt.wantBatches) {
+			t.Errorf("%s: %d batches, want %d", tt.name, len(batches), len(tt.wantBatches))
+			continue
+		}
+		for i, batch := range batches {
+			if len(batch.Nodes) != len(tt.wantBatches[i]) || batch.Nodes[0] != tt.wantBatches[i][0] {
+				t.Errorf("%s: batch %d nodes = %v, want %v", tt.name, i, batch.Nodes, tt.wantBatches[i])
+			}
+			if batch.RunStatus != opsconstants.StatusSuccessed || batch.Successed != len(batch.Nodes) {
+				t.Errorf("%s: batch %d = %s with %d successed", tt.name, i, batch.RunStatus, batch.Successed)
+			}
+		}
+		for i := range nodeNames {
+			if ran[i] != 1 {
+				t.Errorf("%s: node %d ran %d times, want 1", tt.name, i, ran[i])
+			}
+		}
+	}
+}
+
+func TestRunRolloutParallelism(t *testing.T) {
+	nodeNames := []string{"n0", "n1", "n2", "n3", "n4", "n5"}
+	var running, maxRunning int32
+	release := make(chan struct{})
+	go func() {
+		// finish the nodes one by one
+		for range nodeNames {
+			release <- struct{}{}
+		}
+	}()
+	RunRollout(context.Background(), opsv1.Rollout{Parallelism: 3}, nodeNames, func(index int) string {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return opsconstants.StatusSuccessed
+	}, func([]*opsv1.TaskRunBatchStatus) {})
+	if maxRunning > 3 {
+		t.Errorf("max running = %d, want at most 3", maxRunning)
+	}
+}
+
+func TestRunRolloutFailureThreshold(t *testing.T) {
+	nodeNames := []string{"n0", "n1", "n2", "n3", "n4", "n5"}
+	tests := []struct {
+		name       string
+		rollout    opsv1.Rollout
+		failed     map[int]bool
+		wantStatus []string
+		wantRan    int
+	}{
+		{
+			name:       "stop after threshold",
+			rollout:    opsv1.Rollout{BatchSize: 2, FailureThreshold: 2},
+			failed:     map[int]bool{1: true, 2: true},
+			wantStatus: []string{opsconstants.StatusFailed, opsconstants.StatusFailed, opsconstants.StatusSkipped},
+			wantRan:    3,
+		},
+		{
+			name:       "below threshold",
+			rollout:    opsv1.Rollout{BatchSize: 2, FailureThreshold: 3},
+			failed:     map[int]bool{1: true, 2: true},
+			wantStatus: []string{opsconstants.StatusFailed, opsconstants.StatusFailed, opsconstants.StatusSuccessed},
+			wantRan:    6,
+		},
+		{
+			name:       "never stop without threshold",
+			rollout:    opsv1.Rollout{BatchSize: 3},
+			failed:     map[int]bool{0: true, 1: true, 2: true, 3: true},
+			wantStatus: []string{opsconstants.StatusFailed, opsconstants.StatusFailed},
+			wantRan:    6,
+		},
+	}
+	for _, tt := range tests {
+		ran := 0
+		batches := RunRollout(context.Background(), tt.rollout, nodeNames, func(index int) string {
+			ran++
+			if tt.failed[index] {
+				return opsconstants.StatusFailed
+			}
+			return opsconstants.StatusSuccessed
+		}, func([]*opsv1.TaskRunBatchStatus) {})
+		if ran != tt.wantRan {
+			t.Errorf("%s: ran %d nodes, want %d", tt.name, ran, tt.wantRan)
+		}
+		for i, batch := range batches {
+			if batch.RunStatus != tt.wantStatus[i] {
+				t.Errorf("%s: batch %d = %s, want %s", tt.name, i, batch.RunStatus, tt.wantStatus[i])
+			}
+		}
+	}
+}
+
+func TestRunRolloutCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := RunRollout(ctx, opsv1.Rollout{BatchSize: 2}, []string{"n0", "n1", "n2", "n3"}, func(index int) string {
+		cancel()
+		return opsconstants.StatusSuccessed
+	}, func([]*opsv1.TaskRunBatchStatus) {})
+	want := []string{opsconstants.StatusAborted, opsconstants.StatusSkipped}
+	for i, batch := range batches {
+		if batch.RunStatus != want[i] {
+			t.Errorf("batch %d = %s, want %s", i, batch.RunStatus, want[i])
+		}
+	}
+}
+
+func TestRunRolloutMaxUnavailable(t *testing.T) {
+	nodeNames := []string{"n0", "n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9"}
+	tests := []struct {
+		name     string
+		rollout  opsv1.Rollout
+		wantSize []int
+	}{
+		{name: "number", rollout: opsv1.Rollout{MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 4}}, wantSize: []int{4, 4, 2}},
+		{name: "percentage rounds up", rollout: opsv1.Rollout{MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "25%"}}, wantSize: []int{3, 3, 3, 1}},
+		{name: "smaller batchSize", rollout: opsv1.Rollout{BatchSize: 2, MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "50%"}}, wantSize: []int{2, 2, 2, 2, 2}},
+		{name: "smaller maxUnavailable", rollout: opsv1.Rollout{BatchSize: 6, MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 5}}, wantSize: []int{5, 5}},
+		{name: "at least one", rollout: opsv1.Rollout{MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "1%"}}, wantSize: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		batches := RunRollout(context.Background(), tt.rollout, nodeNames, func(index int) string {
+			return opsconstants.StatusSuccessed
+		}, func([]*opsv1.TaskRunBatchStatus) {})
+		if len(batches) != len(tt.wantSize) {
+			t.Errorf("%s: %d batches, want %d", tt.name, len(batches), len(tt.wantSize))
+			continue
+		}
+		for i, batch := range batches {
+			if len(batch.Nodes) != tt.wantSize[i] {
+				t.Errorf("%s: batch %d has %d nodes, want %d", tt.name, i, len(batch.Nodes), tt.wantSize[i])
+			}
+		}
+	}
+}
@@ -3,7 +3,9 @@ package task
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
@@ -26,106 +28,279 @@ func GetValidStatusError(status string, err error) string {
 }
 
 func RunTaskOnHost(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, hc *host.HostConnection, taskOpt option.TaskOption) error {
+	facts, err := GetHostFacts(ctx, hc, t, taskOpt)
+	if err != nil {
+		logger.Error.Println("gather facts: ", err)
+	}
+	taskOpt.Facts = utils.MergeMap(facts, taskOpt.Facts)
 	allVars, err := GetRealVariables(t, taskOpt)
 	if err != nil {
 		return err
 	}
 	logger.Info.Println("> Run Task ", t.GetUniqueKey(), " on ", hc.Host.Spec.Address)
+	masker := NewMasker(t, allVars)
+	stepOutputs := make(map[string]string)
+	lastOutput := ""
 	for si, s := range t.Spec.Steps {
+		if ctx.Err() != nil {
+			logger.Info.Println("Cancelled!")
+			return ctx.Err()
+		}
 		var sp = &s
 		sp = RenderStepVariables(sp, allVars)
-		logger.Info.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), s.Name))
-		s.When = RenderString(s.When, allVars)
-		result, err := utils.LogicExpression(s.When, true)
+		logger.Info.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), masker.Mask(s.Name)))
+		result, err := utils.LogicExpressionWithVars(s.When, allVars, true)
 		if err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(hc.Host.Name, s.Name, masker.Mask(s.Content), "when: "+masker.Mask(err.Error()), opsconstants.StatusDataInValid)
 			return err
 		}
 		if !result {
 			logger.Info.Println("Skip!")
 			continue
 		}
-		if err != nil {
+		if err := RenderStepTemplate(sp, allVars, taskOpt.Facts); err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(hc.Host.Name, s.Name, masker.Mask(s.Content), masker.Mask(err.Error()), opsconstants.StatusDataInValid)
+			return err
 		}
 		stepFunc := GetHostStepFunc(s)
-		stepStatus, stepOutput, stepErr := stepFunc(t, hc, s, taskOpt)
-		stepStatus = GetValidStatusError(stepStatus, stepErr)
-		tr.Status.AddOutputStep(hc.Host.Name, s.Name, s.Content, stepOutput, stepStatus)
+		streamCtx, closeStream := withStepStream(ctx, taskOpt, masker, hc.Host.Name, s.Name)
+		stepStatus, stepResult, stepErr := runStepWithRetry(ctx, logger, tr, masker, hc.Host.Name, s, func() (string, *opsv1.ExecResult, error) {
+			stepCtx, cancel := GetStepContext(streamCtx, s)
+			defer cancel()
+			return stepFunc(stepCtx, t, hc, s, taskOpt)
+		})
+		closeStream()
+		stepOutput := GetStepOutput(stepResult, stepErr)
+		stepOutputs[s.Name] = stepOutput
+		lastOutput = stepOutput
 		allVars["result"] = strings.ReplaceAll(stepOutput, "\"", "")
 		allVars["status"] = stepStatus
-		logger.Debug.Println("Content: ", s.Content)
+		allVars["exitcode"] = GetExitCodeVariable(stepResult)
+		SetStepVariables(allVars, t, s.Name, stepOutput, stepStatus, stepResult)
+		logger.Debug.Println("Content: ", masker.Mask(s.Content))
 		logger.Debug.Println("Status: ", stepStatus)
-		logger.Info.Println(stepOutput)
-		result, err = utils.LogicExpression(s.AllowFailure, false)
+		logger.Info.Println(masker.Mask(stepOutput))
+		result, err = utils.LogicExpressionWithVars(s.AllowFailure, allVars, false)
 		if err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(hc.Host.Name, s.Name, masker.Mask(s.Content), "allowfailure: "+masker.Mask(err.Error()), opsconstants.StatusDataInValid)
 			return err
 		}
 		if result == false && stepErr != nil {
 			break
 		}
 	}
+	results := CaptureResults(logger, t, stepOutputs, lastOutput, func(path string) (string, error) {
+		return hc.Shell(ctx, taskOpt.Sudo, "cat "+utils.ShellQuote(path))
+	})
+	for k, v := range results {
+		tr.Status.AddResult(hc.Host.Name, k, masker.Mask(v))
+	}
 	return err
 }
 
-func RunTaskOnKube(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *kube.KubeConnection, node *corev1.Node, taskOpt option.TaskOption, kubeOpt option.KubeOption) error {
+func RunTaskOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *kube.KubeConnection, node *corev1.Node, taskOpt option.TaskOption, kubeOpt option.KubeOption) error {
+	facts, err := GetNodeFacts(ctx, logger, kc, node, t, taskOpt, kubeOpt)
+	if err != nil {
+		logger.Error.Println("gather facts: ", err)
+	}
+	taskOpt.Facts = utils.MergeMap(facts, taskOpt.Facts)
 	allVars, err := GetRealVariables(t, taskOpt)
 	if err != nil {
 		return err
 	}
 	logger.Info.Println("> Run Task ", t.GetUniqueKey(), " on Node ", node.Name)
+	masker := NewMasker(t, allVars)
+	stepOutputs := make(map[string]string)
+	lastOutput := ""
 	for si, s := range t.Spec.Steps {
+		if ctx.Err() != nil {
+			logger.Info.Println("Cancelled!")
+			return ctx.Err()
+		}
 		var sp = &s
 		sp = RenderStepVariables(sp, allVars)
-		logger.Info.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), s.Name))
-		s.When = RenderString(s.When, allVars)
-		result, err := utils.LogicExpression(s.When, true)
+		logger.Info.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), masker.Mask(s.Name)))
+		result, err := utils.LogicExpressionWithVars(s.When, allVars, true)
 		if err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(node.Name, s.Name, masker.Mask(s.Content), "when: "+masker.Mask(err.Error()), opsconstants.StatusDataInValid)
 			return err
 		}
 		if !result {
 			logger.Info.Println("Skip!")
 			continue
 		}
-		if err != nil {
+		if err := RenderStepTemplate(sp, allVars, taskOpt.Facts); err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(node.Name, s.Name, masker.Mask(s.Content), masker.Mask(err.Error()), opsconstants.StatusDataInValid)
+			return err
 		}
 		stepFunc := GetKubeStepFunc(s)
-		stepStatus, stepOutput, stepErr := stepFunc(logger, t, kc, node, s, taskOpt, kubeOpt)
-		stepStatus = GetValidStatusError(stepStatus, stepErr)
-		tr.Status.AddOutputStep(node.Name, s.Name, s.Content, stepOutput, stepStatus)
+		streamCtx, closeStream := withStepStream(ctx, taskOpt, masker, node.Name, s.Name)
+		stepStatus, stepResult, stepErr := runStepWithRetry(ctx, logger, tr, masker, node.Name, s, func() (string, *opsv1.ExecResult, error) {
+			return stepFunc(streamCtx, logger, t, kc, node, s, taskOpt, kubeOpt)
+		})
+		closeStream()
+		stepOutput := GetStepOutput(stepResult, stepErr)
+		stepOutputs[s.Name] = stepOutput
+		lastOutput = stepOutput
 		allVars["result"] = strings.ReplaceAll(stepOutput, "\"", "")
 		allVars["status"] = stepStatus
-		logger.Debug.Println("Content: ", s.Content)
+		allVars["exitcode"] = GetExitCodeVariable(stepResult)
+		SetStepVariables(allVars, t, s.Name, stepOutput, stepStatus, stepResult)
+		logger.Debug.Println("Content: ", masker.Mask(s.Content))
 		logger.Debug.Println("Status: ", stepStatus)
-		logger.Info.Println(stepOutput)
-		result, err = utils.LogicExpression(s.AllowFailure, false)
+		logger.Info.Println(masker.Mask(stepOutput))
+		result, err = utils.LogicExpressionWithVars(s.AllowFailure, allVars, false)
 		if err != nil {
 			logger.Error.Println(err)
+			tr.Status.AddOutputStep(node.Name, s.Name, masker.Mask(s.Content), "allowfailure: "+masker.Mask(err.Error()), opsconstants.StatusDataInValid)
 			return err
 		}
 		if result == false && stepErr != nil {
 			break
 		}
 	}
+	results := CaptureResults(logger, t, stepOutputs, lastOutput, func(path string) (string, error) {
+		return kc.ShellOnNode(ctx, logger, node, option.ShellOption{
+			Sudo:    taskOpt.Sudo,
+			Content: "cat " + utils.ShellQuote(path),
+			Mode:    opsconstants.ModeHost,
+		}, kubeOpt)
+	})
+	for k, v := range results {
+		tr.Status.AddResult(node.Name, k, masker.Mask(v))
+	}
 	return err
 }
 
-func GetHostStepFunc(step opsv1.Step) func(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, to option.TaskOption) (status string, output string, err error) {
+// withStepStream sets the live output writer of the step to ctx, the returned func closes it
+func withStepStream(ctx context.Context, taskOpt option.TaskOption, masker *Masker, nodeName, stepName string) (context.Context, func()) {
+	if taskOpt.Stream == nil {
+		return ctx, func() {}
+	}
+	w := masker.WrapWriter(taskOpt.Stream(nodeName, stepName))
+	return opslog.WithStream(ctx, w), func() { w.Close() }
+}
+
+// GetStepContext returns a context canceled after the step timeout
+func GetStepContext(ctx context.Context, s opsv1.Step) (context.Context, context.CancelFunc) {
+	if s.TimeOutSeconds > 0 {
+		return context.WithTimeout(ctx, time.Duration(s.TimeOutSeconds)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+// runStepWithRetry runs the step until succeeded or out of retries, every attempt is recorded
+func runStepWithRetry(ctx context.Context, logger *opslog.Logger, tr *opsv1.TaskRun, masker *Masker, nodeName string, s opsv1.Step, run func() (string, *opsv1.ExecResult, error)) (stepStatus string, stepResult *opsv1.ExecResult, stepErr error) {
+	for attempt := 1; attempt <= s.Retries+1; attempt++ {
+		if attempt > 1 {
+			delay := s.GetRetryDelay(attempt - 1)
+			logger.Info.Println(fmt.Sprintf("Retry %d/%d after %s", attempt-1, s.Retries, delay))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		start := time.Now()
+		stepStatus, stepResult, stepErr = run()
+		if stepResult == nil {
+			stepResult = &opsv1.ExecResult{}
+		}
+		// file steps and the failures before running have no time
+		if stepResult.StartTime == nil {
+			stepResult.SetTime(start, time.Now())
+		}
+		stepStatus = GetValidStatusError(stepStatus, stepErr)
+		// interrupted by cancel, not by the step timeout
+		if ctx.Err() != nil {
+			stepStatus = opsconstants.StatusAborted
+		}
+		trStep := tr.Status.AddOutputStep(nodeName, s.Name, masker.Mask(s.Content), masker.Mask(GetStepOutput(stepResult, stepErr)), stepStatus)
+		trStep.ExecResult = *stepResult.DeepCopy()
+		masker.MaskResult(&trStep.ExecResult)
+		if s.Retries > 0 {
+			trStep.Attempt = attempt
+		}
+		if stepErr == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetStepOutput is the output of result, or the error if there is no output
+func GetStepOutput(result *opsv1.ExecResult, err error) string {
+	output := result.GetOutput()
+	if err != nil && len(output) == 0 {
+		output = err.Error()
+	}
+	return output
+}
+
+// GetExitCodeVariable is the exit code as a variable, empty if the command is not exited normally
+func GetExitCodeVariable(result *opsv1.ExecResult) string {
+	if result == nil || result.ExitCode == nil {
+		return ""
+	}
+	return strconv.Itoa(*result.ExitCode)
+}
+
+func GetHostStepFunc(step opsv1.Step) func(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, to option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
+	if step.Kube != nil {
+		return runStepKubeOnHost
+	}
+	if step.HTTP != nil {
+		return runStepHTTPOnHost
+	}
+	if step.Ensure != nil {
+		return runStepEnsureOnHost
+	}
 	if len(step.Content) > 0 {
 		return runStepShellOnHost
 	}
 	return runStepFileOnHost
 }
 
-func runStepShellOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, option option.TaskOption) (status, stdout string, err error) {
-	stdout, err = c.Shell(context.TODO(), option.Sudo, step.Content)
+func runStepKubeOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
+	return "", nil, fmt.Errorf("kube step %s only runs on clusters", step.Name)
+}
+
+// runStepHTTPOnHost sends the request locally from the controller or opscli, the connection of host is not used
+func runStepHTTPOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
+	result, err = RunHTTPStep(ctx, *step.HTTP)
+	return
+}
+
+func runStepEnsureOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
+	script, err := EnsureScript(step.Ensure)
+	if err != nil {
+		return "", nil, err
+	}
+	result, err = c.ShellResult(ctx, option.ShellOption{
+		Sudo:    taskOpt.Sudo,
+		Content: script,
+	})
+	ParseEnsureResult(result)
 	return
 }
 
-func runStepFileOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status, output string, err error) {
+func runStepShellOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
+	result, err = c.ShellResult(ctx, option.ShellOption{
+		Sudo:     taskOpt.Sudo,
+		Content:  step.Content,
+		Executor: step.Executor,
+		Env:      step.Env,
+		WorkDir:  step.WorkDir,
+	})
+	return
+}
+
+func runStepFileOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result *opsv1.ExecResult, err error) {
 	fileOpt := option.FileOption{
 		Sudo:       taskOpt.Sudo,
 		Direction:  step.Direction,
@@ -139,11 +314,20 @@ func runStepFileOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, t
 		AK:         taskOpt.Variables["ak"],
 		SK:         taskOpt.Variables["sk"],
 	}
-	output, err = c.File(context.Background(), fileOpt)
-	return
+	output, err := c.File(ctx, fileOpt)
+	return "", &opsv1.ExecResult{Stdout: output}, err
 }
 
-func GetKubeStepFunc(step opsv1.Step) func(logger *opslog.Logger, t *opsv1.Task, c *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (string, string, error) {
+func GetKubeStepFunc(step opsv1.Step) func(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, c *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (string, *opsv1.ExecResult, error) {
+	if step.Kube != nil {
+		return runStepKubeOnKube
+	}
+	if step.HTTP != nil {
+		return runStepHTTPOnKube
+	}
+	if step.Ensure != nil {
+		return runStepEnsureOnKube
+	}
 	if len(step.Content) > 0 {
 		return runStepShellOnKube
 	} else {
@@ -151,31 +335,63 @@ func GetKubeStepFunc(step opsv1.Step) func(logger *opslog.Logger, t *opsv1.Task,
 	}
 }
 
-func runStepShellOnKube(logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taksOpt option.TaskOption, kubeOpt option.KubeOption) (status, output string, err error) {
+func runStepKubeOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (status string, result *opsv1.ExecResult, err error) {
+	stepCtx, cancel := GetStepContext(ctx, step)
+	defer cancel()
+	result, err = kc.RunKubeStep(stepCtx, *step.Kube)
+	return
+}
+
+func runStepHTTPOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (status string, result *opsv1.ExecResult, err error) {
+	stepCtx, cancel := GetStepContext(ctx, step)
+	defer cancel()
+	result, err = RunHTTPStep(stepCtx, *step.HTTP)
+	return
+}
+
+func runStepEnsureOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (status string, result *opsv1.ExecResult, err error) {
+	script, err := EnsureScript(step.Ensure)
+	if err != nil {
+		return "", nil, err
+	}
+	result, err = kc.ShellResultOnNode(
+		ctx,
+		logger,
+		node,
+		option.ShellOption{
+			Sudo:           taskOpt.Sudo,
+			Content:        script,
+			Mode:           opsconstants.ModeHost,
+			TimeoutSeconds: step.TimeOutSeconds,
+		},
+		kubeOpt)
+	ParseEnsureResult(result)
+	return
+}
+
+func runStepShellOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taksOpt option.TaskOption, kubeOpt option.KubeOption) (status string, result *opsv1.ExecResult, err error) {
 	mode := opsconstants.ModeHost
 	if strings.Contains(step.Content, "/host") {
 		mode = opsconstants.ModeContainer
 	}
-	output, err = kc.ShellOnNode(
+	result, err = kc.ShellResultOnNode(
+		ctx,
 		logger,
 		node,
 		option.ShellOption{
-			Sudo:    taksOpt.Sudo,
-			Content: step.Content,
-			Mode:    mode,
+			Sudo:           taksOpt.Sudo,
+			Content:        step.Content,
+			Mode:           mode,
+			TimeoutSeconds: step.TimeOutSeconds,
+			Executor:       step.Executor,
+			Env:            step.Env,
+			WorkDir:        step.WorkDir,
 		},
 		kubeOpt)
-	if len(output) == 0 {
-		if err != nil {
-			output = err.Error()
-		} else {
-			output = opsconstants.NoOutput
-		}
-	}
 	return
 }
 
-func runStepFileOnKube(logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (status, output string, err error) {
+func runStepFileOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnection, node *corev1.Node, step opsv1.Step, taskOpt option.TaskOption, kubeOpt option.KubeOption) (status string, result *opsv1.ExecResult, err error) {
 	fileOpt := option.FileOption{
 		Sudo:       taskOpt.Sudo,
 		Direction:  step.Direction,
@@ -190,10 +406,10 @@ func runStepFileOnKube(logger *opslog.Logger, t *opsv1.Task, kc *kube.KubeConnec
 		Bucket:     taskOpt.Variables["bucket"],
 		KubeOption: kubeOpt,
 	}
-	output, err = kc.FileNode(
+	output, err := kc.FileNode(
 		logger,
 		node,
 		fileOpt,
 	)
-	return
+	return "", &opsv1.ExecResult{Stdout: output}, err
 }
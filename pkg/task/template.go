@@ -0,0 +1,174 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+// templateData is the data of the step templates
+type templateData struct {
+	// Vars are the variables, {{ .Vars.name }} or {{ index .Vars "steps.check.exitcode" }}
+	Vars map[string]string
+	// Facts are the facts of the host or node, such as {{ .Facts.arch }}
+	Facts map[string]string
+}
+
+// RenderStepTemplate renders content, localfile, remotefile and the content of ensure file of the step with template go,
+// with of the steps inlined by uses overrides the variables, the others are kept as is
+func RenderStepTemplate(step *opsv1.Step, vars, facts map[string]string) error {
+	if step.Template == "" {
+		return nil
+	}
+	data := templateData{Vars: vars, Facts: facts}
+	if len(step.With) > 0 {
+		data.Vars = copyStringMap(vars)
+		for k, v := range step.With {
+			data.Vars[k] = RenderString(v, vars)
+		}
+	}
+	if data.Facts == nil {
+		data.Facts = map[string]string{}
+	}
+	fields := []*string{&step.Content, &step.LocalFile, &step.RemoteFile}
+	if step.Ensure != nil && step.Ensure.File != nil {
+		fields = append(fields, &step.Ensure.File.Content)
+	}
+	for _, field := range fields {
+		if *field == "" {
+			continue
+		}
+		tmpl, err := template.New(step.Name).Funcs(templateFuncs).Option("missingkey=zero").Parse(*field)
+		if err != nil {
+			return err
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return err
+		}
+		*field = sb.String()
+	}
+	return nil
+}
+
+// templateFuncs are the functions of the step templates, the piped value is the last argument
+var templateFuncs = template.FuncMap{
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"required": func(message, value string) (string, error) {
+		if value == "" {
+			return "", errors.New(message)
+		}
+		return value, nil
+	},
+	"list":       templateList,
+	"map":        templateMap,
+	"fromJson":   templateFromJson,
+	"toJson":     templateToJson,
+	"quote":      templateQuote,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, items []string) string { return strings.Join(items, sep) },
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}
+
+// templateQuote quotes the value as a single quoted shell word
+func templateQuote(value string) string {
+	quoted, _ := escapeValue(value, opsconstants.RenderFilterShell)
+	return quoted
+}
+
+// templateList parses the variable of type list, a JSON array or separated by comma, empty is no item
+func templateList(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []string{}, nil
+	}
+	if strings.HasPrefix(value, "[") {
+		var items []interface{}
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return nil, err
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			} else {
+				list = append(list, templateToJson(item))
+			}
+		}
+		return list, nil
+	}
+	list := strings.Split(value, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list, nil
+}
+
+// templateMap parses the variable of type map, a JSON object or k=v separated by comma
+func templateMap(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	m := make(map[string]string)
+	if value == "" {
+		return m, nil
+	}
+	if strings.HasPrefix(value, "{") {
+		var items map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return nil, err
+		}
+		for k, item := range items {
+			if s, ok := item.(string); ok {
+				m[k] = s
+			} else {
+				m[k] = templateToJson(item)
+			}
+		}
+		return m, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s is not k=v", item)
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m, nil
+}
+
+func templateFromJson(value string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func templateToJson(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,219 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// includeRegexp matches the line `#include <name>`, it's a comment if the snippet is not included
+var includeRegexp = regexp.MustCompile(`^([ \t]*)#include[ \t]+(\S+)[ \t]*$`)
+
+// referenceRegexp matches a value which is a single variable, such as ${steps.check.stderr}
+var referenceRegexp = regexp.MustCompile(`^\$\{([^}|]+)\}$`)
+
+// ResolveTask returns a copy of the task, the uses steps are replaced by the steps of the used tasks,
+// and the #include lines of content are replaced by the snippets, the cycles are errors
+func ResolveTask(t *opsv1.Task, lib TaskLibrary) (*opsv1.Task, error) {
+	return resolveTask(t.DeepCopy(), lib, []string{t.GetUniqueKey()})
+}
+
+func resolveTask(t *opsv1.Task, lib TaskLibrary, stack []string) (*opsv1.Task, error) {
+	steps := make([]opsv1.Step, 0, len(t.Spec.Steps))
+	for _, s := range t.Spec.Steps {
+		if s.Uses == "" {
+			content, err := includeSnippets(s.Content, t.Namespace, lib, nil)
+			if err != nil {
+				return nil, fmt.Errorf("step %s: %v", s.Name, err)
+			}
+			s.Content = content
+			steps = append(steps, s)
+			continue
+		}
+		namespacedName := parseUses(s.Uses, t.Namespace)
+		for _, key := range stack {
+			if key == namespacedName.String() {
+				return nil, fmt.Errorf("uses cycle: %s -> %s", strings.Join(stack, " -> "), key)
+			}
+		}
+		used, err := lib.GetTask(namespacedName.Namespace, namespacedName.Name)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: uses %s: %v", s.Name, s.Uses, err)
+		}
+		used = used.DeepCopy()
+		if used.Namespace == "" {
+			used.Namespace = namespacedName.Namespace
+		}
+		// the stack is copied, the sibling uses must not share it
+		used, err = resolveTask(used, lib, append(stack[:len(stack):len(stack)], namespacedName.String()))
+		if err != nil {
+			return nil, err
+		}
+		inlined, results, variables := inlineTask(s, used)
+		steps = append(steps, inlined...)
+		t.Spec.Results = append(t.Spec.Results, results...)
+		// the variables of the used task are resolved and checked by the renderer as the variables of the task
+		for name, v := range variables {
+			if t.Spec.Variables == nil {
+				t.Spec.Variables = make(opsv1.Variables)
+			}
+			t.Spec.Variables[name] = v
+		}
+		// the facts of the used task are gathered as well, the task using it wins
+		for name, script := range used.Spec.Facts {
+			if _, ok := t.Spec.Facts[name]; !ok {
+				if t.Spec.Facts == nil {
+					t.Spec.Facts = make(map[string]string)
+				}
+				t.Spec.Facts[name] = script
+			}
+		}
+	}
+	t.Spec.Steps = steps
+	return t, nil
+}
+
+// parseUses gets the task of uses, name or namespace/name, default the namespace of the task using it
+func parseUses(uses, namespace string) types.NamespacedName {
+	if i := strings.Index(uses, "/"); i >= 0 {
+		return types.NamespacedName{Namespace: uses[:i], Name: uses[i+1:]}
+	}
+	return types.NamespacedName{Namespace: namespace, Name: uses}
+}
+
+// inlineTask namespaces the variables, steps and results of the used task by the name of uses,
+// ${<variable>}, ${steps.<step>.*} and ${results.<name>} are renamed as well. The variables are returned with
+// their definitions, the values of with are set as their values, except the values referring to other variables,
+// such as ${steps.check.stderr}, which are put into the steps as text, so the renderer resolves them when running.
+func inlineTask(s opsv1.Step, used *opsv1.Task) ([]opsv1.Step, []opsv1.Result, opsv1.Variables) {
+	var oldnew []string
+	for k := range used.Spec.Variables {
+		oldnew = append(oldnew, fmt.Sprintf(`${%s}`, k), fmt.Sprintf(`${%s.%s}`, s.Name, k), fmt.Sprintf(`${%s|`, k), fmt.Sprintf(`${%s.%s|`, s.Name, k))
+	}
+	// the variables not declared are passed as well, others, such as ${HOSTNAME}, are left to the task using it
+	for k := range s.With {
+		if _, ok := used.Spec.Variables[k]; !ok {
+			oldnew = append(oldnew, fmt.Sprintf(`${%s}`, k), fmt.Sprintf(`${%s.%s}`, s.Name, k), fmt.Sprintf(`${%s|`, k), fmt.Sprintf(`${%s.%s|`, s.Name, k))
+		}
+	}
+	for _, step := range used.Spec.Steps {
+		oldnew = append(oldnew, fmt.Sprintf(`${steps.%s.`, step.Name), fmt.Sprintf(`${steps.%s.%s.`, s.Name, step.Name))
+	}
+	for _, r := range used.Spec.Results {
+		oldnew = append(oldnew, fmt.Sprintf(`${results.%s}`, r.Name), fmt.Sprintf(`${results.%s.%s}`, s.Name, r.Name))
+	}
+	// replaced in one pass, the names are not replaced again
+	renamer := strings.NewReplacer(oldnew...)
+
+	variables := make(opsv1.Variables)
+	for k, v := range used.Spec.Variables {
+		v.Value, v.Default = renamer.Replace(v.Value), renamer.Replace(v.Default)
+		variables[s.Name+"."+k] = v
+	}
+	// the values of with referring to other variables are put into the steps after the variables are renamed,
+	// a single reference keeps the filter, ${message|raw} is ${steps.check.stderr|raw}
+	var refs []string
+	for k, value := range s.With {
+		name := s.Name + "." + k
+		if !strings.Contains(value, "${") {
+			v := variables[name]
+			v.Value = value
+			variables[name] = v
+			continue
+		}
+		delete(variables, name)
+		refs = append(refs, fmt.Sprintf(`${%s}`, name), value)
+		if match := referenceRegexp.FindStringSubmatch(value); match != nil {
+			refs = append(refs, fmt.Sprintf(`${%s|`, name), fmt.Sprintf(`${%s|`, match[1]))
+		}
+	}
+	replacer := strings.NewReplacer(refs...)
+	replace := func(field string) string {
+		return replacer.Replace(renamer.Replace(field))
+	}
+
+	steps := make([]opsv1.Step, 0, len(used.Spec.Steps))
+	for _, step := range used.Spec.Steps {
+		inlined := step.DeepCopy()
+		eachStepField(inlined, replace)
+		inlined.Name = s.Name + "." + inlined.Name
+		// the templates read the values from .Vars by the names of the used task, with of the inner uses is closer
+		if inlined.Template != "" {
+			with := make(map[string]string)
+			for k := range used.Spec.Variables {
+				with[k] = replace(fmt.Sprintf(`${%s}`, k))
+			}
+			for k := range s.With {
+				with[k] = replace(fmt.Sprintf(`${%s}`, k))
+			}
+			for k, v := range inlined.With {
+				with[k] = replace(v)
+			}
+			inlined.With = with
+		}
+		inlined.When = replace(inlined.When)
+		if s.When != "" && inlined.When != "" {
+			inlined.When = fmt.Sprintf("(%s) && (%s)", s.When, inlined.When)
+		} else if s.When != "" {
+			inlined.When = s.When
+		}
+		inlined.AllowFailure = replace(inlined.AllowFailure)
+		if inlined.AllowFailure == "" {
+			inlined.AllowFailure = s.AllowFailure
+		}
+		steps = append(steps, *inlined)
+	}
+	results := make([]opsv1.Result, 0, len(used.Spec.Results))
+	for _, r := range used.Spec.Results {
+		r.Name = s.Name + "." + r.Name
+		if r.File == "" {
+			// the last run step of the used task is its last step
+			if r.Step == "" && len(used.Spec.Steps) > 0 {
+				r.Step = used.Spec.Steps[len(used.Spec.Steps)-1].Name
+			}
+			r.Step = s.Name + "." + r.Step
+		}
+		results = append(results, r)
+	}
+	return steps, results, variables
+}
+
+// includeSnippets replaces the #include lines with the snippets, indented as the line,
+// stack is the snippets being included, to find the cycles
+func includeSnippets(content, namespace string, lib TaskLibrary, stack []string) (string, error) {
+	if !strings.Contains(content, "#include") {
+		return content, nil
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		match := includeRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		indent, name := match[1], match[2]
+		for _, included := range stack {
+			if included == name {
+				return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+		snippet, err := lib.GetSnippet(namespace, name)
+		if err != nil {
+			return "", err
+		}
+		snippet, err = includeSnippets(strings.TrimRight(snippet, "\n"), namespace, lib, append(stack[:len(stack):len(stack)], name))
+		if err != nil {
+			return "", err
+		}
+		snippetLines := strings.Split(snippet, "\n")
+		for j := range snippetLines {
+			if snippetLines[j] != "" {
+				snippetLines[j] = indent + snippetLines[j]
+			}
+		}
+		lines[i] = strings.Join(snippetLines, "\n")
+	}
+	return strings.Join(lines, "\n"), nil
+}
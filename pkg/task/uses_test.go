@@ -0,0 +1,196 @@
+package task
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/option"
+)
+
+// memoryTaskLibrary finds the tasks by namespace/name and the snippets by name
+type memoryTaskLibrary struct {
+	tasks    map[string]*opsv1.Task
+	snippets map[string]string
+}
+
+func (l *memoryTaskLibrary) GetTask(namespace, name string) (*opsv1.Task, error) {
+	t, ok := l.tasks[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("task %s/%s not found", namespace, name)
+	}
+	return t, nil
+}
+
+func (l *memoryTaskLibrary) GetSnippet(namespace, name string) (string, error) {
+	snippet, ok := l.snippets[name]
+	if !ok {
+		return "", fmt.Errorf("snippet %s not found", name)
+	}
+	return snippet, nil
+}
+
+func newTestTask(namespace, name string, steps ...opsv1.Step) *opsv1.Task {
+	t := &opsv1.Task{Spec: opsv1.TaskSpec{Steps: steps}}
+	t.Namespace, t.Name = namespace, name
+	return t
+}
+
+func TestResolveTaskUses(t *testing.T) {
+	report := newTestTask("ops-system", "report-event",
+		opsv1.Step{Name: "send", Content: "send --token ${token} --channel ${channel|raw} --message ${message}", When: "${level} != debug"},
+		opsv1.Step{Name: "check", Content: "echo ${steps.send.exitcode}"},
+	)
+	report.Spec.Variables = opsv1.Variables{
+		"token":   {SecretRef: &opsv1.VariableRef{Name: "ops-report", Key: "token"}},
+		"channel": {Required: true, Enums: []string{"ops", "dev"}},
+		"message": {Sensitive: true},
+		"level":   {Default: "info"},
+		"subject": {Default: "${channel}-report"},
+	}
+	report.Spec.Results = []opsv1.Result{{Name: "code", Regex: `\d+`}}
+	lib := &memoryTaskLibrary{tasks: map[string]*opsv1.Task{"ops-system/report-event": report}}
+
+	task := newTestTask("ops-system", "check-disk",
+		opsv1.Step{Name: "check", Content: "df -h"},
+		opsv1.Step{Name: "report", Uses: "report-event", When: "${steps.check.exitcode} != 0", With: map[string]string{
+			"channel": "ops",
+			"message": "${steps.check.stderr}",
+			"extra":   "${HOSTNAME}-disk",
+		}},
+	)
+	resolved, err := ResolveTask(task, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Spec.Steps) != 2 || task.Spec.Variables != nil {
+		t.Errorf("the task is changed: %+v", task.Spec)
+	}
+	steps := resolved.Spec.Steps
+	if len(steps) != 3 || steps[1].Name != "report.send" || steps[2].Name != "report.check" {
+		t.Fatalf("steps = %+v", steps)
+	}
+	if want := "send --token ${report.token} --channel ${report.channel|raw} --message ${steps.check.stderr}"; steps[1].Content != want {
+		t.Errorf("content = %q, want %q", steps[1].Content, want)
+	}
+	if want := "(${steps.check.exitcode} != 0) && (${report.level} != debug)"; steps[1].When != want {
+		t.Errorf("when = %q, want %q", steps[1].When, want)
+	}
+	if want := "echo ${steps.report.send.exitcode}"; steps[2].Content != want {
+		t.Errorf("content = %q, want %q", steps[2].Content, want)
+	}
+	wantVariables := opsv1.Variables{
+		"report.token":   {SecretRef: &opsv1.VariableRef{Name: "ops-report", Key: "token"}},
+		"report.channel": {Required: true, Enums: []string{"ops", "dev"}, Value: "ops"},
+		"report.level":   {Default: "info"},
+		"report.subject": {Default: "${report.channel}-report"},
+	}
+	if !reflect.DeepEqual(resolved.Spec.Variables, wantVariables) {
+		t.Errorf("variables = %+v, want %+v", resolved.Spec.Variables, wantVariables)
+	}
+	if want := []opsv1.Result{{Name: "report.code", Step: "report.check", Regex: `\d+`}}; !reflect.DeepEqual(resolved.Spec.Results, want) {
+		t.Errorf("results = %+v, want %+v", resolved.Spec.Results, want)
+	}
+
+	// the renderer resolves the inlined variables as the variables of the task
+	vars, err := GetRealVariables(resolved, option.TaskOption{RefVariables: map[string]string{"report.token": "t0ken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["report.token"] != "t0ken" || vars["report.subject"] != "ops-report" {
+		t.Errorf("vars = %v", vars)
+	}
+	if got := NewMasker(resolved, vars).Mask("token t0ken"); got != "token "+opsconstants.MaskedValue {
+		t.Errorf("the secret variable of the used task is not masked: %q", got)
+	}
+}
+
+func TestResolveTaskUsesRequired(t *testing.T) {
+	used := newTestTask("ops-system", "notify", opsv1.Step{Name: "send", Content: "send ${channel}"})
+	used.Spec.Variables = opsv1.Variables{"channel": {Required: true}}
+	lib := &memoryTaskLibrary{tasks: map[string]*opsv1.Task{"ops-system/notify": used}}
+	resolved, err := ResolveTask(newTestTask("ops-system", "task", opsv1.Step{Name: "notify", Uses: "notify"}), lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRealVariables(resolved, option.TaskOption{}); err == nil || !strings.Contains(err.Error(), "please set variable: notify.channel") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestResolveTaskUsesNested(t *testing.T) {
+	inner := newTestTask("lib", "inner", opsv1.Step{Name: "run", Content: "echo ${value}"})
+	inner.Spec.Variables = opsv1.Variables{"value": {Type: "int"}}
+	outer := newTestTask("lib", "outer", opsv1.Step{Name: "a", Uses: "inner", With: map[string]string{"value": "${count}"}})
+	outer.Spec.Variables = opsv1.Variables{"count": {Default: "1"}}
+	lib := &memoryTaskLibrary{tasks: map[string]*opsv1.Task{"lib/inner": inner, "lib/outer": outer}}
+	task := newTestTask("ops-system", "task",
+		opsv1.Step{Name: "first", Uses: "lib/outer", With: map[string]string{"count": "2"}},
+		opsv1.Step{Name: "second", Uses: "lib/outer"},
+	)
+	resolved, err := ResolveTask(task, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, s := range resolved.Spec.Steps {
+		contents = append(contents, s.Name+": "+s.Content)
+	}
+	if want := []string{"first.a.run: echo ${first.count}", "second.a.run: echo ${second.count}"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("steps = %v, want %v", contents, want)
+	}
+	if resolved.Spec.Variables["first.count"].Value != "2" || resolved.Spec.Variables["second.count"].Default != "1" {
+		t.Errorf("variables = %+v", resolved.Spec.Variables)
+	}
+}
+
+func TestResolveTaskUsesErrors(t *testing.T) {
+	lib := &memoryTaskLibrary{tasks: map[string]*opsv1.Task{
+		"ops-system/a":    newTestTask("ops-system", "a", opsv1.Step{Name: "b", Uses: "b"}),
+		"ops-system/b":    newTestTask("ops-system", "b", opsv1.Step{Name: "a", Uses: "a"}),
+		"ops-system/self": newTestTask("ops-system", "self", opsv1.Step{Name: "self", Uses: "self"}),
+	}}
+	cases := []struct {
+		task *opsv1.Task
+		want string
+	}{
+		{lib.tasks["ops-system/a"], "uses cycle: ops-system/a -> ops-system/b -> ops-system/a"},
+		{lib.tasks["ops-system/self"], "uses cycle: ops-system/self -> ops-system/self"},
+		{newTestTask("ops-system", "task", opsv1.Step{Name: "x", Uses: "missing"}), "step x: uses missing: task ops-system/missing not found"},
+	}
+	for _, c := range cases {
+		_, err := ResolveTask(c.task, lib)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("ResolveTask(%s): error = %v, want %q", c.task.Name, err, c.want)
+		}
+	}
+}
+
+func TestResolveTaskInclude(t *testing.T) {
+	lib := &memoryTaskLibrary{snippets: map[string]string{
+		"retry":  "for i in 1 2 3; do\n  #include wait\ndone\n",
+		"wait":   "sleep ${interval}",
+		"loop-a": "#include loop-b",
+		"loop-b": "#include loop-a",
+	}}
+	task := newTestTask("ops-system", "task", opsv1.Step{Name: "retry", Content: "echo start\n  #include retry\n# include retry"})
+	resolved, err := ResolveTask(task, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo start\n  for i in 1 2 3; do\n    sleep ${interval}\n  done\n# include retry"; resolved.Spec.Steps[0].Content != want {
+		t.Errorf("content = %q, want %q", resolved.Spec.Steps[0].Content, want)
+	}
+	for content, want := range map[string]string{
+		"#include loop-a":  "include cycle: loop-a -> loop-b -> loop-a",
+		"#include missing": "step x: snippet missing not found",
+	} {
+		_, err := ResolveTask(newTestTask("ops-system", "task", opsv1.Step{Name: "x", Content: content}), lib)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("include %q: error = %v, want %q", content, err, want)
+		}
+	}
+}
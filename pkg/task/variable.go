@@ -0,0 +1,91 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetVariableRefValues reads the values of secretRef and configMapRef of variables in the namespace of the task
+func GetVariableRefValues(ctx context.Context, c client.Client, t *opsv1.Task) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, k := range sortedKeys(t.Spec.Variables) {
+		v := t.Spec.Variables[k]
+		key := types.NamespacedName{Namespace: t.Namespace}
+		if v.SecretRef != nil {
+			key.Name = v.SecretRef.Name
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, key, secret); err != nil {
+				return nil, fmt.Errorf("variable %s: %v", k, err)
+			}
+			value, ok := secret.Data[v.SecretRef.Key]
+			if !ok {
+				return nil, fmt.Errorf("variable %s: key %s not found in secret %s", k, v.SecretRef.Key, key)
+			}
+			values[k] = string(value)
+		} else if v.ConfigMapRef != nil {
+			key.Name = v.ConfigMapRef.Name
+			cm := &corev1.ConfigMap{}
+			if err := c.Get(ctx, key, cm); err != nil {
+				return nil, fmt.Errorf("variable %s: %v", k, err)
+			}
+			value, ok := cm.Data[v.ConfigMapRef.Key]
+			if !ok {
+				return nil, fmt.Errorf("variable %s: key %s not found in configmap %s", k, v.ConfigMapRef.Key, key)
+			}
+			values[k] = value
+		}
+	}
+	return values, nil
+}
+
+// renderVariableTemplates renders the templates after the templates they refer to, such as default ${namespace}-backup,
+// the rendered values are not rendered again, the cycles are errors
+func renderVariableTemplates(vars map[string]string, templates map[string]bool) error {
+	const rendering, rendered = 1, 2
+	state := make(map[string]int)
+	var render func(key string, path []string) error
+	render = func(key string, path []string) error {
+		switch state[key] {
+		case rendered:
+			return nil
+		case rendering:
+			return fmt.Errorf("variable cycle: %s -> %s", strings.Join(path, " -> "), key)
+		}
+		state[key] = rendering
+		var err error
+		scanVariables(vars[key], func(name, filter string) (string, bool) {
+			if err == nil && templates[name] {
+				err = render(name, append(path[:len(path):len(path)], key))
+			}
+			return "", false
+		})
+		if err != nil {
+			return err
+		}
+		vars[key] = RenderString(vars[key], vars)
+		state[key] = rendered
+		return nil
+	}
+	for _, key := range sortedKeys(templates) {
+		if err := render(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
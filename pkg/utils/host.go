@@ -195,6 +195,10 @@ func GetFileArray(filePath string) (fileArray []string, err error) {
 		}
 
 		for _, f := range files {
+			// such as the snippets of tasks
+			if f.IsDir() {
+				continue
+			}
 			fileArray = append(fileArray, filepath.Join(filePath, f.Name()))
 		}
 	} else {
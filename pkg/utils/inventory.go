@@ -2,9 +2,17 @@ package utils
 
 import (
 	"bufio"
-	"github.com/shaowenchen/ops/pkg/constants"
+	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"gopkg.in/yaml.v3"
 )
 
 func GetInventoryType(inventory string) string {
@@ -20,34 +28,604 @@ func GetInventoryType(inventory string) string {
 	return constants.InventoryTypeHosts
 }
 
+// AnalysisHostsParameter returns the addresses of all hosts in the inventory
 func AnalysisHostsParameter(str string) (result []string, err error) {
-	isExist := IsExistsFile(GetAbsoluteFilePath(str))
-	if isExist {
-		// try kubeconfig
-		nodeIPs, err := GetAllNodesByKubeconfig(str)
-		if err == nil {
-			return nodeIPs, nil
-		}
-		//try readfile
-		readFile, err := os.Open(str)
+	inv, err := LoadInventory(str)
+	if err != nil {
+		return
+	}
+	hosts, err := inv.GetHosts("")
+	if err != nil {
+		return
+	}
+	for _, h := range hosts {
+		result = append(result, h.Address)
+	}
+	return RemoveDuplicates(result), nil
+}
+
+// Inventory is the hosts in groups with variables, the variables of all hosts, groups and the host
+// are merged in order, the later wins
+type Inventory struct {
+	// Vars are the variables of all hosts
+	Vars map[string]string `yaml:"vars,omitempty"`
+	// Hosts are the hosts without group
+	Hosts  []InventoryHost            `yaml:"hosts,omitempty"`
+	Groups map[string]*InventoryGroup `yaml:"groups,omitempty"`
+}
+
+type InventoryGroup struct {
+	Vars  map[string]string `yaml:"vars,omitempty"`
+	Hosts []InventoryHost   `yaml:"hosts,omitempty"`
+	// Children are the groups whose hosts are also in this group
+	Children []string `yaml:"children,omitempty"`
+}
+
+// InventoryHost is a host of inventory, the empty fields are filled by the flags of opscli
+type InventoryHost struct {
+	Name           string            `yaml:"name,omitempty"`
+	Address        string            `yaml:"address,omitempty"`
+	Port           int               `yaml:"port,omitempty"`
+	Username       string            `yaml:"username,omitempty"`
+	Password       string            `yaml:"password,omitempty"`
+	PrivateKeyPath string            `yaml:"privatekeypath,omitempty"`
+	Bastion        string            `yaml:"bastion,omitempty"`
+	Labels         map[string]string `yaml:"labels,omitempty"`
+	Vars           map[string]string `yaml:"vars,omitempty"`
+	// Groups are the groups of the host and their parents, filled by GetHosts
+	Groups []string `yaml:"-"`
+}
+
+// UnmarshalYAML accepts an address as the host
+func (h *InventoryHost) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Address = node.Value
+		return nil
+	}
+	type plain InventoryHost
+	return node.Decode((*plain)(h))
+}
+
+func (h *InventoryHost) GetName() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.Address
+}
+
+// LoadInventory reads the hosts from a comma list of addresses, a kubeconfig, a YAML or Ansible INI inventory,
+// or a file of IPs. Variable files in group_vars and host_vars next to the inventory file are merged.
+func LoadInventory(str string) (inv *Inventory, err error) {
+	filePath := GetAbsoluteFilePath(str)
+	if !IsExistsFile(filePath) {
+		inv = &Inventory{}
+		for _, addr := range SplitStrings(str) {
+			inv.Hosts = append(inv.Hosts, InventoryHost{Address: strings.TrimSpace(addr)})
+		}
+	} else {
+		content, err1 := os.ReadFile(filePath)
+		if err1 != nil {
+			return nil, err1
+		}
+		switch {
+		case isYamlInventory(filePath, content):
+			inv = &Inventory{}
+			err = yaml.Unmarshal(content, inv)
+		case isIniInventory(content):
+			inv, err = parseIniInventory(content)
+		default:
+			// try kubeconfig
+			if nodeIPs, err1 := GetAllNodesByKubeconfig(str); err1 == nil {
+				inv = &Inventory{}
+				for _, ip := range nodeIPs {
+					inv.Hosts = append(inv.Hosts, InventoryHost{Address: ip})
+				}
+			} else {
+				inv = parseIPInventory(content)
+			}
+		}
 		if err != nil {
-			return result, err
+			return nil, fmt.Errorf("parse inventory %s: %v", str, err)
+		}
+		err = inv.loadVarFiles(filepath.Dir(filePath))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(inv.Hosts) == 0 && len(inv.Groups) == 0 {
+		inv.Hosts = append(inv.Hosts, InventoryHost{Address: constants.LocalHostIP})
+	}
+	return inv, nil
+}
+
+func isYamlInventory(filePath string, content []byte) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext != ".yaml" && ext != ".yml" {
+		return false
+	}
+	keys := make(map[string]interface{})
+	if yaml.Unmarshal(content, &keys) != nil {
+		return false
+	}
+	_, hasHosts := keys["hosts"]
+	_, hasGroups := keys["groups"]
+	return hasHosts || hasGroups
+}
+
+var iniSectionRegexp = regexp.MustCompile(`^\[([^\]]+)\]$`)
+
+func isIniInventory(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		if iniSectionRegexp.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIPInventory(content []byte) *Inventory {
+	inv := &Inventory{}
+	fileScanner := bufio.NewScanner(strings.NewReader(string(content)))
+	fileScanner.Split(bufio.ScanLines)
+	for fileScanner.Scan() {
+		line := findIP(strings.TrimSpace(fileScanner.Text()))
+		if len(line) > 0 {
+			inv.Hosts = append(inv.Hosts, InventoryHost{Address: line})
+		}
+	}
+	return inv
+}
+
+// parseIniInventory imports the Ansible INI inventory, with [group], [group:vars] and [group:children] sections,
+// ansible_host, ansible_port, ansible_user, ansible_password and ansible_ssh_private_key_file of hosts
+func parseIniInventory(content []byte) (*Inventory, error) {
+	inv := &Inventory{Groups: make(map[string]*InventoryGroup)}
+	getGroup := func(name string) *InventoryGroup {
+		if inv.Groups[name] == nil {
+			inv.Groups[name] = &InventoryGroup{}
 		}
-		fileScanner := bufio.NewScanner(readFile)
-		fileScanner.Split(bufio.ScanLines)
-		for fileScanner.Scan() {
-			line := strings.TrimSpace(fileScanner.Text())
-			line = findIP(line)
-			if len(line) > 0 {
-				result = append(result, line)
+		return inv.Groups[name]
+	}
+	group, kind := "ungrouped", "hosts"
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if match := iniSectionRegexp.FindStringSubmatch(line); match != nil {
+			group, kind = match[1], "hosts"
+			if index := strings.Index(group, ":"); index > 0 {
+				group, kind = group[:index], group[index+1:]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: unknown section %s", i+1, line)
+			}
+			if group != "all" {
+				getGroup(group)
+			}
+			continue
+		}
+		fields := splitIniFields(line)
+		switch kind {
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid variable %s", i+1, line)
+			}
+			key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+			if group == "all" {
+				if inv.Vars == nil {
+					inv.Vars = make(map[string]string)
+				}
+				inv.Vars[key] = value
+			} else {
+				g := getGroup(group)
+				if g.Vars == nil {
+					g.Vars = make(map[string]string)
+				}
+				g.Vars[key] = value
+			}
+		case "children":
+			getGroup(group).Children = append(getGroup(group).Children, fields[0])
+		default:
+			h, err := parseIniHost(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			if group == "ungrouped" || group == "all" {
+				inv.Hosts = append(inv.Hosts, h)
+			} else {
+				getGroup(group).Hosts = append(getGroup(group).Hosts, h)
 			}
 		}
-		readFile.Close()
-	} else {
-		result = SplitStrings(str)
 	}
-	if len(result) == 0 {
-		result = append(result, constants.LocalHostIP)
+	delete(inv.Groups, "ungrouped")
+	return inv, nil
+}
+
+// parseIniHost leaves the address empty without ansible_host, so the host referred by name in other groups
+// gets the address where it's defined, the name is the address at last
+func parseIniHost(fields []string) (h InventoryHost, err error) {
+	h.Name = fields[0]
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return h, fmt.Errorf("invalid host variable %s", field)
+		}
+		switch key {
+		case "ansible_host", "ansible_ssh_host":
+			h.Address = value
+		case "ansible_port", "ansible_ssh_port":
+			h.Port, err = strconv.Atoi(value)
+			if err != nil {
+				return h, fmt.Errorf("invalid port %s", value)
+			}
+		case "ansible_user", "ansible_ssh_user":
+			h.Username = value
+		case "ansible_password", "ansible_ssh_pass":
+			h.Password = value
+		case "ansible_ssh_private_key_file":
+			h.PrivateKeyPath = value
+		default:
+			if h.Vars == nil {
+				h.Vars = make(map[string]string)
+			}
+			h.Vars[key] = value
+		}
 	}
-	return RemoveDuplicates(result), nil
+	return
+}
+
+// splitIniFields splits the line by spaces out of quotes, and removes the quotes
+func splitIniFields(line string) (fields []string) {
+	var field strings.Builder
+	var quote rune
+	inField := false
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote, inField = c, true
+		case quote == 0 && (c == ' ' || c == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return
+}
+
+// loadVarFiles merges group_vars/<group>.yaml and host_vars/<host>.yaml in dir, group_vars/all.yaml is for all hosts
+func (inv *Inventory) loadVarFiles(dir string) error {
+	readVars := func(subDir, name string) (map[string]string, error) {
+		for _, ext := range []string{".yaml", ".yml", ""} {
+			filePath := filepath.Join(dir, subDir, name+ext)
+			content, err := os.ReadFile(filePath)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			vars := make(map[string]string)
+			if err = yaml.Unmarshal(content, &vars); err != nil {
+				return nil, fmt.Errorf("parse %s: %v", filePath, err)
+			}
+			return vars, nil
+		}
+		return nil, nil
+	}
+	vars, err := readVars("group_vars", "all")
+	if err != nil {
+		return err
+	}
+	inv.Vars = MergeMap(copyMap(inv.Vars), vars)
+	for name, g := range inv.Groups {
+		if vars, err = readVars("group_vars", name); err != nil {
+			return err
+		}
+		g.Vars = MergeMap(copyMap(g.Vars), vars)
+	}
+	setHostVars := func(hosts []InventoryHost) error {
+		for i := range hosts {
+			vars, err := readVars("host_vars", hosts[i].GetName())
+			if err != nil {
+				return err
+			}
+			hosts[i].Vars = MergeMap(copyMap(hosts[i].Vars), vars)
+		}
+		return nil
+	}
+	if err = setHostVars(inv.Hosts); err != nil {
+		return err
+	}
+	for _, g := range inv.Groups {
+		if err = setHostVars(g.Hosts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// GetHosts returns the hosts selected by limit, with the groups and the merged variables.
+// limit is a comma list of groups, host names or addresses, supporting * wildcard and ! to exclude,
+// empty or all selects all hosts
+func (inv *Inventory) GetHosts(limit string) ([]InventoryHost, error) {
+	depths, err := inv.getGroupDepths()
+	if err != nil {
+		return nil, err
+	}
+	var hosts []*InventoryHost
+	index := make(map[string]*InventoryHost)
+	addHost := func(h InventoryHost, group string) {
+		existed, ok := index[h.GetName()]
+		if !ok {
+			h.Labels = copyMap(h.Labels)
+			h.Vars = copyMap(h.Vars)
+			existed = &h
+			index[h.GetName()] = existed
+			hosts = append(hosts, existed)
+		} else if existed.Address == "" && h.Address != "" {
+			// the definition with address wins, the others refer to it by name
+			primary := h
+			primary.Labels = copyMap(h.Labels)
+			primary.Vars = copyMap(h.Vars)
+			primary.fill(*existed)
+			primary.Groups = existed.Groups
+			*existed = primary
+		} else {
+			existed.fill(h)
+		}
+		if group != "" {
+			existed.Groups = append(existed.Groups, group)
+		}
+	}
+	for _, h := range inv.Hosts {
+		addHost(h, "")
+	}
+	groupNames := inv.getSortedGroups(depths)
+	for _, name := range groupNames {
+		for _, h := range inv.Groups[name].Hosts {
+			addHost(h, name)
+		}
+	}
+	parents := make(map[string][]string)
+	for name, g := range inv.Groups {
+		for _, child := range g.Children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+	selected := make([]InventoryHost, 0, len(hosts))
+	for _, h := range hosts {
+		groups := make(map[string]bool)
+		var addGroup func(name string)
+		addGroup = func(name string) {
+			if groups[name] {
+				return
+			}
+			groups[name] = true
+			for _, parent := range parents[name] {
+				addGroup(parent)
+			}
+		}
+		for _, name := range h.Groups {
+			addGroup(name)
+		}
+		h.Groups = h.Groups[:0]
+		vars := copyMap(inv.Vars)
+		for _, name := range groupNames {
+			if groups[name] {
+				h.Groups = append(h.Groups, name)
+				MergeMap(vars, inv.Groups[name].Vars)
+			}
+		}
+		h.Vars = MergeMap(vars, h.Vars)
+		if err := h.setConnectionVars(); err != nil {
+			return nil, err
+		}
+		if h.Address == "" {
+			h.Address = h.Name
+		}
+		selected = append(selected, *h)
+	}
+	return limitInventoryHosts(selected, limit)
+}
+
+// fill sets the empty fields by the same host defined in another group
+func (h *InventoryHost) fill(other InventoryHost) {
+	if h.Address == "" {
+		h.Address = other.Address
+	}
+	if h.Port == 0 {
+		h.Port = other.Port
+	}
+	if h.Username == "" {
+		h.Username = other.Username
+	}
+	if h.Password == "" {
+		h.Password = other.Password
+	}
+	if h.PrivateKeyPath == "" {
+		h.PrivateKeyPath = other.PrivateKeyPath
+	}
+	if h.Bastion == "" {
+		h.Bastion = other.Bastion
+	}
+	for k, v := range other.Labels {
+		if _, ok := h.Labels[k]; !ok {
+			h.Labels[k] = v
+		}
+	}
+	for k, v := range other.Vars {
+		if _, ok := h.Vars[k]; !ok {
+			h.Vars[k] = v
+		}
+	}
+}
+
+// setConnectionVars moves the connection variables to the empty fields, such as ansible_user of [group:vars],
+// so the fields set on the host win, then the variables of host_vars, the deeper groups and all hosts
+func (h *InventoryHost) setConnectionVars() (err error) {
+	for _, key := range []string{"ansible_port", "ansible_ssh_port", "ansible_user", "ansible_ssh_user",
+		"ansible_password", "ansible_ssh_pass", "ansible_ssh_private_key_file"} {
+		value, ok := h.Vars[key]
+		if !ok {
+			continue
+		}
+		delete(h.Vars, key)
+		switch key {
+		case "ansible_port", "ansible_ssh_port":
+			if h.Port == 0 {
+				h.Port, err = strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("host %s: invalid port %s", h.GetName(), value)
+				}
+			}
+		case "ansible_user", "ansible_ssh_user":
+			if h.Username == "" {
+				h.Username = value
+			}
+		case "ansible_password", "ansible_ssh_pass":
+			if h.Password == "" {
+				h.Password = value
+			}
+		case "ansible_ssh_private_key_file":
+			if h.PrivateKeyPath == "" {
+				h.PrivateKeyPath = value
+			}
+		}
+	}
+	return nil
+}
+
+// getGroupDepths returns the depth of groups, a child is deeper than its parents, so its variables win
+func (inv *Inventory) getGroupDepths() (map[string]int, error) {
+	parents := make(map[string][]string)
+	for name, g := range inv.Groups {
+		for _, child := range g.Children {
+			if inv.Groups[child] == nil {
+				return nil, fmt.Errorf("unknown child group %s of %s", child, name)
+			}
+			parents[child] = append(parents[child], name)
+		}
+	}
+	depths := make(map[string]int)
+	visiting := make(map[string]bool)
+	var getDepth func(name string) (int, error)
+	getDepth = func(name string) (int, error) {
+		if depth, ok := depths[name]; ok {
+			return depth, nil
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("group %s is a child of itself", name)
+		}
+		visiting[name] = true
+		depth := 0
+		for _, parent := range parents[name] {
+			parentDepth, err := getDepth(parent)
+			if err != nil {
+				return 0, err
+			}
+			if parentDepth+1 > depth {
+				depth = parentDepth + 1
+			}
+		}
+		depths[name] = depth
+		return depth, nil
+	}
+	for name := range inv.Groups {
+		if _, err := getDepth(name); err != nil {
+			return nil, err
+		}
+	}
+	return depths, nil
+}
+
+// getSortedGroups sorts the groups by depth and name
+func (inv *Inventory) getSortedGroups(depths map[string]int) []string {
+	names := make([]string, 0, len(inv.Groups))
+	for name := range inv.Groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if depths[names[i]] != depths[names[j]] {
+			return depths[names[i]] < depths[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func limitInventoryHosts(hosts []InventoryHost, limit string) ([]InventoryHost, error) {
+	patterns := SplitStrings(limit)
+	if len(patterns) == 0 {
+		return hosts, nil
+	}
+	match := func(h InventoryHost, pattern string) bool {
+		if pattern == "all" {
+			return true
+		}
+		for _, name := range append([]string{h.GetName(), h.Address}, h.Groups...) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	included := make([]bool, len(hosts))
+	onlyExclude := true
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		onlyExclude = false
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid limit %s", pattern)
+		}
+		matched := false
+		for i, h := range hosts {
+			if match(h, pattern) {
+				included[i] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no host matches limit %s", pattern)
+		}
+	}
+	var result []InventoryHost
+	for i, h := range hosts {
+		if !included[i] && !onlyExclude {
+			continue
+		}
+		excluded := false
+		for _, pattern := range patterns {
+			pattern = strings.TrimSpace(pattern)
+			if strings.HasPrefix(pattern, "!") && match(h, pattern[1:]) {
+				excluded = true
+			}
+		}
+		if !excluded {
+			result = append(result, h)
+		}
+	}
+	return result, nil
 }
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIniInventory = `
+# hosts without group
+1.1.1.1
+
+[all:vars]
+region=beijing
+ansible_user=root
+
+[web]
+web1 ansible_host=10.0.0.1 ansible_user=admin role="web server"
+web2 ansible_host=10.0.0.2
+
+[web:vars]
+role=web
+ansible_user=ubuntu
+ansible_port=2222
+
+[db]
+db1 ansible_host=10.0.1.1 ansible_port=22
+web1
+
+[k8s:children]
+web
+db
+
+[k8s:vars]
+role=k8s
+cluster=dev
+`
+
+func writeTestInventory(t *testing.T, name, content string, files map[string]string) string {
+	dir := t.TempDir()
+	for p, c := range map[string]string{name: content} {
+		files[p] = c
+	}
+	for p, c := range files {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+func getTestHosts(t *testing.T, inv *Inventory, limit string) map[string]InventoryHost {
+	hosts, err := inv.GetHosts(limit)
+	if err != nil {
+		t.Fatalf("GetHosts(%q): %v", limit, err)
+	}
+	result := make(map[string]InventoryHost)
+	for _, h := range hosts {
+		result[h.GetName()] = h
+	}
+	return result
+}
+
+func TestParseIniInventory(t *testing.T) {
+	inv, err := parseIniInventory([]byte(testIniInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Hosts) != 1 || inv.Hosts[0].GetName() != "1.1.1.1" || inv.Hosts[0].Address != "" {
+		t.Errorf("Hosts = %+v", inv.Hosts)
+	}
+	if !reflect.DeepEqual(inv.Vars, map[string]string{"region": "beijing", "ansible_user": "root"}) {
+		t.Errorf("Vars = %v", inv.Vars)
+	}
+	web1 := inv.Groups["web"].Hosts[0]
+	if web1.Name != "web1" || web1.Address != "10.0.0.1" || web1.Username != "admin" || web1.Vars["role"] != "web server" {
+		t.Errorf("web1 = %+v", web1)
+	}
+	if !reflect.DeepEqual(inv.Groups["k8s"].Children, []string{"web", "db"}) {
+		t.Errorf("children of k8s = %v", inv.Groups["k8s"].Children)
+	}
+
+	errors := map[string]string{
+		"[web:meta]\n":                 "unknown section",
+		"[web:vars]\nrole\n":           "invalid variable",
+		"[web]\nweb1 ansible_port=a\n": "invalid port a",
+		"[web]\nweb1 role\n":           "invalid host variable role",
+	}
+	for content, want := range errors {
+		_, err := parseIniInventory([]byte(content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parse %q: error = %v, want %q", content, err, want)
+		}
+	}
+}
+
+func TestInventoryGetHostsPrecedence(t *testing.T) {
+	path := writeTestInventory(t, "hosts", testIniInventory, map[string]string{
+		"group_vars/db.yaml":    "cluster: prod\n",
+		"host_vars/web2.yaml":   "role: canary\nansible_ssh_private_key_file: ~/.ssh/web2\n",
+		"host_vars/1.1.1.1.yml": "ansible_port: \"2200\"\n",
+	})
+	inv, err := LoadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := getTestHosts(t, inv, "")
+	cases := []struct {
+		name           string
+		address        string
+		port           int
+		username       string
+		privateKeyPath string
+		groups         []string
+		vars           map[string]string
+	}{
+		// the host line wins over the group vars
+		{"web1", "10.0.0.1", 2222, "admin", "", []string{"k8s", "db", "web"}, map[string]string{"region": "beijing", "role": "web server", "cluster": "prod"}},
+		// host_vars wins over the group vars
+		{"web2", "10.0.0.2", 2222, "ubuntu", "~/.ssh/web2", []string{"k8s", "web"}, map[string]string{"region": "beijing", "role": "canary", "cluster": "dev"}},
+		// the child group wins over its parent, all vars are the lowest
+		{"db1", "10.0.1.1", 22, "root", "", []string{"k8s", "db"}, map[string]string{"region": "beijing", "role": "k8s", "cluster": "prod"}},
+		{"1.1.1.1", "1.1.1.1", 2200, "root", "", nil, map[string]string{"region": "beijing"}},
+	}
+	if len(hosts) != len(cases) {
+		t.Errorf("hosts = %v", hosts)
+	}
+	for _, c := range cases {
+		h := hosts[c.name]
+		if h.Address != c.address || h.Port != c.port || h.Username != c.username || h.PrivateKeyPath != c.privateKeyPath {
+			t.Errorf("%s: connection = %s:%d %s %s, want %s:%d %s %s", c.name, h.Address, h.Port, h.Username, h.PrivateKeyPath, c.address, c.port, c.username, c.privateKeyPath)
+		}
+		if !reflect.DeepEqual(h.Groups, c.groups) {
+			t.Errorf("%s: groups = %v, want %v", c.name, h.Groups, c.groups)
+		}
+		if !reflect.DeepEqual(h.Vars, c.vars) {
+			t.Errorf("%s: vars = %v, want %v", c.name, h.Vars, c.vars)
+		}
+	}
+}
+
+func TestLoadYamlInventory(t *testing.T) {
+	path := writeTestInventory(t, "inventory.yaml", `
+vars:
+  ansible_user: root
+groups:
+  masters:
+    vars:
+      ansible_port: "2222"
+      ansible_password: secret
+    hosts:
+      - name: master1
+        address: 10.0.0.1
+        username: admin
+      - 10.0.0.2
+  k8s:
+    vars:
+      ansible_ssh_private_key_file: ~/.ssh/k8s
+    children: [masters]
+`, map[string]string{})
+	inv, err := LoadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := getTestHosts(t, inv, "")
+	master1, master2 := hosts["master1"], hosts["10.0.0.2"]
+	if master1.Username != "admin" || master1.Port != 2222 || master1.Password != "secret" || master1.PrivateKeyPath != "~/.ssh/k8s" {
+		t.Errorf("master1 = %+v", master1)
+	}
+	if master2.Username != "root" || master2.Port != 2222 {
+		t.Errorf("10.0.0.2 = %+v", master2)
+	}
+	if len(master1.Vars) != 0 {
+		t.Errorf("the connection vars are kept in vars: %v", master1.Vars)
+	}
+}
+
+func TestInventoryGetHostsErrors(t *testing.T) {
+	cases := map[string]string{
+		"[web]\nweb1\n[web:vars]\nansible_port=ssh\n": "host web1: invalid port ssh",
+		"[k8s:children]\nweb\n":                       "unknown child group web of k8s",
+		"[a:children]\nb\n[b:children]\na\n":          "is a child of itself",
+	}
+	for content, want := range cases {
+		inv, err := parseIniInventory([]byte(content))
+		if err != nil {
+			t.Fatalf("parse %q: %v", content, err)
+		}
+		_, err = inv.GetHosts("")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("GetHosts of %q: error = %v, want %q", content, err, want)
+		}
+	}
+}
+
+func TestInventoryGetHostsLimit(t *testing.T) {
+	inv, err := parseIniInventory([]byte(testIniInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		limit   string
+		want    []string
+		wantErr string
+	}{
+		{"", []string{"1.1.1.1", "db1", "web1", "web2"}, ""},
+		{"all", []string{"1.1.1.1", "db1", "web1", "web2"}, ""},
+		{"web", []string{"web1", "web2"}, ""},
+		{"k8s,!db", []string{"web2"}, ""},
+		{"!k8s", []string{"1.1.1.1"}, ""},
+		{"db1,10.0.0.2", []string{"db1", "web2"}, ""},
+		{"10.0.0.*", []string{"web1", "web2"}, ""},
+		{"web*", []string{"web1", "web2"}, ""},
+		{"cache", nil, "no host matches limit cache"},
+		{"[", nil, "invalid limit ["},
+	}
+	for _, c := range cases {
+		hosts, err := inv.GetHosts(c.limit)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("limit %q: error = %v, want %q", c.limit, err, c.wantErr)
+			}
+			continue
+		}
+		var names []string
+		for _, h := range hosts {
+			names = append(names, h.GetName())
+		}
+		if err != nil || !reflect.DeepEqual(names, c.want) {
+			t.Errorf("limit %q = %v, %v, want %v", c.limit, names, err, c.want)
+		}
+	}
+}
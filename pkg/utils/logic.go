@@ -1,113 +1,549 @@
 package utils
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+// Expression grammar, keywords are case insensitive:
+//
+//	or      = and { ("||" | "or") and }
+//	and     = not { ("&&" | "and") not }
+//	not     = ("!" | "not") not | compare
+//	compare = primary [ op primary ]
+//	op      = "==" | "!=" | ">" | ">=" | "<" | "<=" | "=<" | "contains" | "matches" | "in"
+//	primary = "(" or ")" | "[" [ primary { "," primary } ] "]" | func "(" [ or { "," or } ] ")" | literal
+//	func    = "contains" | "matches" | "startwith" | "endwith" | "version"
+//
+// A literal is a quoted string or a run of bare words, ${name} in a literal is replaced
+// by the value of vars after parsing, so values never change the structure of expression.
+//
+// ">", ">=", "<" and "<=" compare versions if an operand has two dots or more, a "v" prefix
+// or a pre-release suffix, such as 5.15.0-91-generic, then numbers, then strings. A number
+// with one dot is a decimal, version(5.10) > 5.4 compares it as a version.
+
+type exprTokenKind int
+
+const (
+	exprTokenEOF exprTokenKind = iota
+	exprTokenWord
+	exprTokenString
+	exprTokenOp
+	exprTokenLParen
+	exprTokenRParen
+	exprTokenLBracket
+	exprTokenRBracket
+	exprTokenComma
+)
+
+type exprToken struct {
+	kind  exprTokenKind
+	text  string
+	start int
+	end   int
+}
+
+type exprValue struct {
+	text      string
+	list      []string
+	isList    bool
+	isBool    bool
+	isVersion bool
+	b         bool
+}
+
+func (v exprValue) String() string {
+	if v.isBool {
+		return strconv.FormatBool(v.b)
+	}
+	if v.isList {
+		return "[" + strings.Join(v.list, ", ") + "]"
+	}
+	return v.text
+}
+
+func (v exprValue) Bool() (bool, error) {
+	if v.isBool {
+		return v.b, nil
+	}
+	if v.isList {
+		return false, fmt.Errorf("list %s is not a bool", v.String())
+	}
+	return Logic(v.text)
+}
+
+var exprKeywords = map[string]bool{
+	"and":      true,
+	"or":       true,
+	"not":      true,
+	"contains": true,
+	"matches":  true,
+	"in":       true,
+}
+
+var exprFuncs = map[string]bool{
+	"contains":  true,
+	"matches":   true,
+	"startwith": true,
+	"endwith":   true,
+	"version":   true,
+}
+
+var versionRegexp = regexp.MustCompile(`^v?\d+(\.\d+)*([-+].*)?$`)
+
+// dottedVersionRegexp matches the versions that are not decimals
+var dottedVersionRegexp = regexp.MustCompile(`^(v\d+(\.\d+)*|\d+(\.\d+){2,})([-+].*)?$|^\d+\.\d+[-+].*$`)
+
+// LogicExpression evaluates exp without variables
 func LogicExpression(exp string, ifEmptyDefault bool) (result bool, err error) {
-	exp = strings.TrimSpace(exp)
-	// default
-	if len(exp) == 0 {
+	return LogicExpressionWithVars(exp, nil, ifEmptyDefault)
+}
+
+// LogicExpressionWithVars evaluates exp, ${name} is replaced by vars
+func LogicExpressionWithVars(exp string, vars map[string]string, ifEmptyDefault bool) (result bool, err error) {
+	if len(strings.TrimSpace(exp)) == 0 {
 		return ifEmptyDefault, nil
 	}
-	// logic bool
-	logicResult, err := Logic(exp)
+	tokens, err := tokenizeExpression(exp)
+	if err != nil {
+		return false, fmt.Errorf("invalid expression %q: %s", exp, err)
+	}
+	p := &exprParser{exp: exp, tokens: tokens, vars: vars}
+	value, err := p.parseOr()
+	if err == nil && p.peek().kind != exprTokenEOF {
+		err = p.unexpected()
+	}
 	if err == nil {
-		return logicResult, nil
-	}
-	// expression
-	if strings.Contains(exp, "==") {
-		expPair := strings.Split(exp, "==")
-		if len(expPair) == 2 {
-			return strings.ToLower(RemoveStartEndMark(expPair[0])) == strings.ToLower(RemoveStartEndMark(expPair[1])), nil
-		}
-	} else if strings.Contains(exp, "!=") {
-		expPair := strings.Split(exp, "!=")
-		if len(expPair) == 2 {
-			return strings.ToLower(RemoveStartEndMark(expPair[0])) != strings.ToLower(RemoveStartEndMark(expPair[1])), nil
-		}
-	} else if strings.Contains(exp, ">") {
-		expPair := strings.Split(exp, ">")
-		left, err := strconv.ParseFloat(RemoveStartEndMark(expPair[0]), 64)
-		if err != nil {
-			return false, err
+		result, err = value.Bool()
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid expression %q: %s", exp, err)
+	}
+	return result, nil
+}
+
+func tokenizeExpression(exp string) (tokens []exprToken, err error) {
+	i := 0
+	for i < len(exp) {
+		c := exp[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(exp) && exp[i] != c; i++ {
+				if exp[i] == '\\' && i+1 < len(exp) && (exp[i+1] == c || exp[i+1] == '\\') {
+					i++
+				}
+				sb.WriteByte(exp[i])
+			}
+			if i >= len(exp) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: exprTokenString, text: sb.String(), start: start, end: i})
+		case c == '(':
+			tokens = append(tokens, exprToken{kind: exprTokenLParen, text: "(", start: i, end: i + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, exprToken{kind: exprTokenRParen, text: ")", start: i, end: i + 1})
+			i++
+		case c == '[':
+			tokens = append(tokens, exprToken{kind: exprTokenLBracket, text: "[", start: i, end: i + 1})
+			i++
+		case c == ']':
+			tokens = append(tokens, exprToken{kind: exprTokenRBracket, text: "]", start: i, end: i + 1})
+			i++
+		case c == ',':
+			tokens = append(tokens, exprToken{kind: exprTokenComma, text: ",", start: i, end: i + 1})
+			i++
+		default:
+			if op := matchExprOp(exp[i:], true); op != "" {
+				tokens = append(tokens, exprToken{kind: exprTokenOp, text: op, start: i, end: i + len(op)})
+				i += len(op)
+				continue
+			}
+			start := i
+			for i < len(exp) {
+				if strings.HasPrefix(exp[i:], "${") {
+					end := strings.Index(exp[i:], "}")
+					if end < 0 {
+						return nil, fmt.Errorf("unterminated variable at %d", i)
+					}
+					i += end + 1
+					continue
+				}
+				if strings.ContainsRune(" \t\n\r\"'()[],", rune(exp[i])) || matchExprOp(exp[i:], false) != "" {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenWord, text: exp[start:i], start: start, end: i})
 		}
-		right, err := strconv.ParseFloat(RemoveStartEndMark(expPair[1]), 64)
-		if err != nil {
-			return false, err
+	}
+	tokens = append(tokens, exprToken{kind: exprTokenEOF, start: len(exp), end: len(exp)})
+	return tokens, nil
+}
+
+// matchExprOp returns the operator at the start of s, "!" only counts at the start of a token
+func matchExprOp(s string, tokenStart bool) string {
+	for _, op := range []string{"&&", "||", "==", "!=", ">=", "<=", "=<", ">", "<"} {
+		if strings.HasPrefix(s, op) {
+			return op
 		}
-		return left > right, nil
-	} else if strings.Contains(exp, ">=") {
-		expPair := strings.Split(exp, ">=")
-		left, err := strconv.ParseFloat(RemoveStartEndMark(expPair[0]), 64)
+	}
+	if tokenStart && strings.HasPrefix(s, "!") {
+		return "!"
+	}
+	return ""
+}
+
+type exprParser struct {
+	exp    string
+	tokens []exprToken
+	pos    int
+	vars   map[string]string
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != exprTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) unexpected() error {
+	t := p.peek()
+	if t.kind == exprTokenEOF {
+		return fmt.Errorf("unexpected end")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.start)
+}
+
+func (p *exprParser) isKeyword(t exprToken, keywords ...string) bool {
+	if t.kind != exprTokenWord {
+		return false
+	}
+	for _, k := range keywords {
+		if strings.EqualFold(t.text, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprValue, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for t := p.peek(); (t.kind == exprTokenOp && t.text == "||") || p.isKeyword(t, "or"); t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
 		if err != nil {
-			return false, err
+			return right, err
+		}
+		if left, err = boolOperation(left, right, func(l, r bool) bool { return l || r }); err != nil {
+			return left, err
 		}
-		right, err := strconv.ParseFloat(RemoveStartEndMark(expPair[1]), 64)
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprValue, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for t := p.peek(); (t.kind == exprTokenOp && t.text == "&&") || p.isKeyword(t, "and"); t = p.peek() {
+		p.next()
+		right, err := p.parseNot()
 		if err != nil {
-			return false, err
+			return right, err
 		}
-		return left >= right, nil
-	} else if strings.Contains(exp, "<") {
-		expPair := strings.Split(exp, "<")
-		left, err := strconv.ParseFloat(RemoveStartEndMark(expPair[0]), 64)
+		if left, err = boolOperation(left, right, func(l, r bool) bool { return l && r }); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprValue, error) {
+	t := p.peek()
+	if (t.kind == exprTokenOp && t.text == "!") || p.isKeyword(t, "not") {
+		p.next()
+		value, err := p.parseNot()
 		if err != nil {
-			return false, err
+			return value, err
 		}
-		right, err := strconv.ParseFloat(RemoveStartEndMark(expPair[1]), 64)
+		b, err := value.Bool()
 		if err != nil {
-			return false, err
+			return value, err
 		}
-		return left < right, nil
-	} else if strings.Contains(exp, "=<") {
-		expPair := strings.Split(exp, "=<")
-		left, err := strconv.ParseFloat(RemoveStartEndMark(expPair[0]), 64)
+		return exprValue{isBool: true, b: !b}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprValue, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return left, err
+	}
+	t := p.peek()
+	op := ""
+	if t.kind == exprTokenOp && t.text != "&&" && t.text != "||" && t.text != "!" {
+		op = t.text
+	} else if p.isKeyword(t, "contains", "matches", "in") {
+		op = strings.ToLower(t.text)
+	}
+	if op == "" {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return right, err
+	}
+	b, err := compareOperation(op, left, right)
+	return exprValue{isBool: true, b: b}, err
+}
+
+func (p *exprParser) parsePrimary() (exprValue, error) {
+	t := p.peek()
+	switch t.kind {
+	case exprTokenLParen:
+		p.next()
+		value, err := p.parseOr()
 		if err != nil {
-			return false, err
+			return value, err
+		}
+		if p.peek().kind != exprTokenRParen {
+			return value, p.unexpected()
+		}
+		p.next()
+		return value, nil
+	case exprTokenLBracket:
+		p.next()
+		value := exprValue{isList: true, list: []string{}}
+		for p.peek().kind != exprTokenRBracket {
+			if len(value.list) > 0 {
+				if p.peek().kind != exprTokenComma {
+					return value, p.unexpected()
+				}
+				p.next()
+			}
+			item, err := p.parsePrimary()
+			if err != nil {
+				return item, err
+			}
+			value.list = append(value.list, item.String())
 		}
-		right, err := strconv.ParseFloat(RemoveStartEndMark(expPair[1]), 64)
+		p.next()
+		return value, nil
+	case exprTokenString:
+		p.next()
+		return exprValue{text: p.render(t.text)}, nil
+	case exprTokenWord:
+		if p.tokens[p.pos+1].kind == exprTokenLParen && exprFuncs[strings.ToLower(t.text)] {
+			return p.parseFunc()
+		}
+		if exprKeywords[strings.ToLower(t.text)] {
+			return exprValue{}, p.unexpected()
+		}
+		// bare words are joined as one literal, keep the inner spaces
+		start, end := t.start, t.end
+		for p.next(); p.peek().kind == exprTokenWord && !exprKeywords[strings.ToLower(p.peek().text)]; {
+			end = p.next().end
+		}
+		return exprValue{text: p.render(p.exp[start:end])}, nil
+	}
+	return exprValue{}, p.unexpected()
+}
+
+func (p *exprParser) parseFunc() (exprValue, error) {
+	name := strings.ToLower(p.next().text)
+	p.next()
+	args := []exprValue{}
+	for p.peek().kind != exprTokenRParen {
+		if len(args) > 0 {
+			if p.peek().kind != exprTokenComma {
+				return exprValue{}, p.unexpected()
+			}
+			p.next()
+		}
+		arg, err := p.parseOr()
 		if err != nil {
-			return false, err
+			return arg, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if name == "version" {
+		if len(args) != 1 {
+			return exprValue{}, fmt.Errorf("%s need 1 argument, got %d", name, len(args))
+		}
+		if !versionRegexp.MatchString(strings.TrimSpace(args[0].String())) {
+			return exprValue{}, fmt.Errorf("%s is not a version", args[0].String())
 		}
-		return left <= right, nil
-	} else if strings.HasPrefix(exp, "startwith") {
-		parms := strings.TrimPrefix(exp, "startwith")
-		parmsPair := splitFuncParms(parms)
-		if len(parmsPair) == 2 {
-			return strings.HasPrefix(parmsPair[0], parmsPair[1]), nil
+		return exprValue{text: args[0].String(), isVersion: true}, nil
+	}
+	if len(args) != 2 {
+		return exprValue{}, fmt.Errorf("%s need 2 arguments, got %d", name, len(args))
+	}
+	var b bool
+	var err error
+	switch name {
+	case "startwith":
+		b = strings.HasPrefix(args[0].String(), args[1].String())
+	case "endwith":
+		b = strings.HasSuffix(args[0].String(), args[1].String())
+	default:
+		b, err = compareOperation(name, args[0], args[1])
+	}
+	return exprValue{isBool: true, b: b}, err
+}
+
+func (p *exprParser) render(text string) string {
+	for key, value := range p.vars {
+		text = strings.ReplaceAll(text, fmt.Sprintf("${%s}", key), value)
+	}
+	return text
+}
+
+func boolOperation(left, right exprValue, f func(l, r bool) bool) (exprValue, error) {
+	l, err := left.Bool()
+	if err != nil {
+		return left, err
+	}
+	r, err := right.Bool()
+	if err != nil {
+		return right, err
+	}
+	return exprValue{isBool: true, b: f(l, r)}, nil
+}
+
+func compareOperation(op string, left, right exprValue) (bool, error) {
+	switch op {
+	case "==":
+		return equalValue(left.String(), right.String()), nil
+	case "!=":
+		return !equalValue(left.String(), right.String()), nil
+	case "contains":
+		if left.isList {
+			return inList(right.String(), left.list), nil
 		}
-	} else if strings.HasPrefix(exp, "endwith") {
-		parms := strings.TrimPrefix(exp, "endwith")
-		parmsPair := splitFuncParms(parms)
-		if len(parmsPair) == 2 {
-			return strings.HasSuffix(parmsPair[0], parmsPair[1]), nil
+		return strings.Contains(left.String(), right.String()), nil
+	case "matches":
+		re, err := regexp.Compile(right.String())
+		if err != nil {
+			return false, err
 		}
-	} else if strings.HasPrefix(exp, "not startwith") {
-		parms := strings.TrimPrefix(exp, "not startwith(")
-		parms = strings.TrimSuffix(parms, ")")
-		parmsPair := splitFuncParms(parms)
-		if len(parmsPair) == 2 {
-			return !strings.HasPrefix(parmsPair[0], parmsPair[1]), nil
+		return re.MatchString(left.String()), nil
+	case "in":
+		if right.isList {
+			return inList(left.String(), right.list), nil
 		}
-	} else if strings.HasPrefix(exp, "not endwith") {
-		parms := strings.TrimPrefix(exp, "not endwith(")
-		parms = strings.TrimSuffix(parms, ")")
-		parmsPair := splitFuncParms(parms)
-		if len(parmsPair) == 2 {
-			return !strings.HasSuffix(parmsPair[0], parmsPair[1]), nil
+		return inList(left.String(), strings.Split(right.String(), ",")), nil
+	}
+	c := compareValue(left.String(), right.String(), left.isVersion || right.isVersion)
+	switch op {
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=", "=<":
+		return c <= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+func inList(value string, list []string) bool {
+	for _, item := range list {
+		if equalValue(value, strings.TrimSpace(item)) {
+			return true
 		}
 	}
+	return false
+}
 
-	return
+// equalValue compares numbers by value, others case insensitive
+func equalValue(left, right string) bool {
+	l, errL := strconv.ParseFloat(strings.TrimSpace(left), 64)
+	r, errR := strconv.ParseFloat(strings.TrimSpace(right), 64)
+	if errL == nil && errR == nil {
+		return l == r
+	}
+	return strings.EqualFold(left, right)
 }
 
-func splitFuncParms(parms string) []string {
-	parms = strings.TrimPrefix(parms, "(")
-	parms = strings.TrimSuffix(parms, ")")
-	parmsPair := strings.Split(parms, ",")
-	for i := range parmsPair {
-		parmsPair[i] = strings.TrimSpace(parmsPair[i])
+// compareValue compares versions like v1.2.3 and 5.15.0-91-generic, then numbers, then strings,
+// a number with one dot is only compared as a version if version is set
+func compareValue(left, right string, version bool) int {
+	left, right = strings.TrimSpace(left), strings.TrimSpace(right)
+	if versionRegexp.MatchString(left) && versionRegexp.MatchString(right) &&
+		(version || dottedVersionRegexp.MatchString(left) || dottedVersionRegexp.MatchString(right)) {
+		return compareVersion(left, right)
+	}
+	l, errL := strconv.ParseFloat(left, 64)
+	r, errR := strconv.ParseFloat(right, 64)
+	if errL == nil && errR == nil {
+		switch {
+		case l > r:
+			return 1
+		case l < r:
+			return -1
+		}
+		return 0
+	}
+	return strings.Compare(left, right)
+}
+
+func compareVersion(left, right string) int {
+	split := func(v string) ([]string, string) {
+		v = strings.TrimPrefix(v, "v")
+		pre := ""
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v, pre = v[:i], v[i:]
+		}
+		return strings.Split(v, "."), pre
+	}
+	lParts, lPre := split(left)
+	rParts, rPre := split(right)
+	for i := 0; i < len(lParts) || i < len(rParts); i++ {
+		var l, r int
+		if i < len(lParts) {
+			l, _ = strconv.Atoi(lParts[i])
+		}
+		if i < len(rParts) {
+			r, _ = strconv.Atoi(rParts[i])
+		}
+		if l != r {
+			if l > r {
+				return 1
+			}
+			return -1
+		}
+	}
+	// a pre-release is lower than the release
+	switch {
+	case lPre == rPre:
+		return 0
+	case lPre == "":
+		return 1
+	case rPre == "":
+		return -1
 	}
-	return parmsPair
+	return strings.Compare(lPre, rPre)
 }
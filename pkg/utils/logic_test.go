@@ -0,0 +1,94 @@
+package utils
+
+import "testing"
+
+func TestLogicExpressionWithVars(t *testing.T) {
+	vars := map[string]string{
+		"status":        "Successed",
+		"exitcode":      "1",
+		"result":        "connection refused",
+		"kernelVersion": "5.15.0-91-generic",
+		"load":          "1.25",
+		"injected":      "1 || true",
+		"quoted":        `a "b" c`,
+	}
+	tests := []struct {
+		exp     string
+		want    bool
+		wantErr bool
+	}{
+		// bool and precedence
+		{exp: "true", want: true},
+		{exp: "false || true && false", want: false},
+		{exp: "(false || true) && false", want: false},
+		{exp: "true || false && false", want: true},
+		{exp: "!false && true", want: true},
+		{exp: "not true or true", want: true},
+		{exp: "!(true && false)", want: true},
+		{exp: "TRUE AND NOT false", want: true},
+		{exp: "${exitcode} == 1 && ${status} == successed", want: true},
+		{exp: "${exitcode} == 0 || ${result} matches \"timeout|refused\"", want: true},
+		// compare
+		{exp: "${status} != Failed", want: true},
+		{exp: "1.0 == 1", want: true},
+		{exp: "10 > 9", want: true},
+		{exp: "${load} < 1.5", want: true},
+		{exp: "1.5 > 1.25", want: true},
+		{exp: "2 =< 2", want: true},
+		{exp: "abc < abd", want: true},
+		// versions
+		{exp: "${kernelVersion} >= 5.4", want: true},
+		{exp: "${kernelVersion} < 5.4.0", want: false},
+		{exp: "1.10.0 >= 1.9", want: true},
+		{exp: "v1.28.2 < v1.28.10", want: true},
+		{exp: "v1.28.0-rc.1 < v1.28.0", want: true},
+		{exp: "version(5.10) > 5.4", want: true},
+		{exp: "version(1.10) >= 1.9", want: true},
+		// a number with one dot is a decimal
+		{exp: "5.10 > 5.4", want: false},
+		{exp: "1.10 >= 1.9", want: false},
+		{exp: "${kernelVersion} >= 5", want: true},
+		{exp: "version(abc) > 1", wantErr: true},
+		// contains, matches and in
+		{exp: "${result} contains refused", want: true},
+		{exp: "contains(${result}, timeout)", want: false},
+		{exp: "[a, b] contains b", want: true},
+		{exp: "${status} in [Failed, Successed]", want: true},
+		{exp: "b in \"a,b\"", want: true},
+		{exp: "${result} matches ^conn", want: true},
+		{exp: "matches(${result}, \"^refused\")", want: false},
+		{exp: "startwith(${result}, conn) && endwith(${result}, refused)", want: true},
+		{exp: "${result} matches \"(\"", wantErr: true},
+		// quoting
+		{exp: `"a && b" == 'a && b'`, want: true},
+		{exp: `"it's" == 'it\'s'`, want: true},
+		{exp: `${quoted} == 'a "b" c'`, want: true},
+		{exp: "${injected} == \"1 || true\"", want: true},
+		{exp: "${injected} == 1", want: false},
+		{exp: `"unterminated == a`, wantErr: true},
+		// errors
+		{exp: "(true", wantErr: true},
+		{exp: "true &&", wantErr: true},
+		{exp: "contains(a)", wantErr: true},
+		{exp: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := LogicExpressionWithVars(tt.exp, vars, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.exp, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.exp, got, tt.want)
+		}
+	}
+}
+
+func TestLogicExpressionEmpty(t *testing.T) {
+	for _, def := range []bool{true, false} {
+		got, err := LogicExpression("  ", def)
+		if err != nil || got != def {
+			t.Errorf("empty = %v, %v, want %v", got, err, def)
+		}
+	}
+}
@@ -63,6 +63,10 @@ func ShellRm(dst string) string {
 	return fmt.Sprintf(`rm -f %s`, dst)
 }
 
+func ShellFileMd5(filepath string) string {
+	return fmt.Sprintf(`md5sum %s | cut -d" " -f1`, filepath)
+}
+
 func ShellCPUTotal() string {
 	return `grep -c "model name" /proc/cpuinfo`
 }
@@ -131,3 +135,19 @@ func GetAvailableUrl(url string, proxy string) string {
 	}
 	return url
 }
+
+// ShellProcessGroup runs cmd in a new session and writes its process group id to pgidFile,
+// so ShellKillProcessGroup kills the whole command from another session
+func ShellProcessGroup(cmd, pgidFile string) string {
+	script := ShellQuote(fmt.Sprintf("echo $$ > %s; %s", ShellQuote(pgidFile), cmd))
+	return fmt.Sprintf(`if setsid -w true >/dev/null 2>&1; then setsid -w bash -c %s; else bash -c %s; fi; code=$?; rm -f %s; exit $code`, script, script, ShellQuote(pgidFile))
+}
+
+// ShellKillProcessGroup kills the process group of pgidFile, the processes run by sudo need sudo without password
+func ShellKillProcessGroup(sudo bool, pgidFile string) string {
+	kill := "kill"
+	if sudo {
+		kill = "sudo -n kill"
+	}
+	return fmt.Sprintf(`pgid="$(cat %s 2>/dev/null)"; [ -n "$pgid" ] && %s -9 -- "-$pgid"; rm -f %s`, ShellQuote(pgidFile), kill, ShellQuote(pgidFile))
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -46,7 +47,59 @@ func DecodingBase64ToString(src string) (dst string, err error) {
 }
 
 func BuildBase64CmdWithExecutor(sudo bool, rawCmd string, executor string) string {
-	return fmt.Sprintf("base64 -d <<< %s | %s %s", EncodingStringToBase64(rawCmd), GetSudoString(sudo), executor)
+	return BuildBase64CmdWithEnv(sudo, rawCmd, executor, nil, "")
+}
+
+// BuildBase64CmdWithEnv pipes rawCmd to the executor running with env in workdir
+func BuildBase64CmdWithEnv(sudo bool, rawCmd string, executor string, env map[string]string, workdir string) string {
+	return fmt.Sprintf("base64 -d <<< %s | %s", EncodingStringToBase64(rawCmd), BuildExecutorCmd(sudo, executor, env, workdir))
+}
+
+// BuildExecutorCmd returns the command running executor with env in workdir, the script is read from stdin
+func BuildExecutorCmd(sudo bool, executor string, env map[string]string, workdir string) string {
+	cmd := executor
+	if len(env) > 0 {
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		args := []string{"env"}
+		for _, key := range keys {
+			args = append(args, ShellQuote(key+"="+env[key]))
+		}
+		cmd = strings.Join(args, " ") + " " + cmd
+	}
+	if workdir != "" {
+		cmd = "cd " + ShellQuote(workdir) + " && " + cmd
+		// cd as the sudo user, it's a single command read from the pipe
+		if sudo {
+			cmd = "sh -c " + ShellQuote(cmd)
+		} else {
+			cmd = "(" + cmd + ")"
+		}
+	}
+	return GetSudoString(sudo) + cmd
+}
+
+// GetDefaultExecutor is python3 if script is a python script, otherwise sh
+func GetDefaultExecutor(script string) string {
+	if IsPythonScript(script) {
+		return "python3"
+	}
+	return "sh"
+}
+
+// IsPythonScript checks the first line of a multi-line script contains python, such as #!/usr/bin/env python3,
+// a single line such as python3 app.py is a shell command
+func IsPythonScript(script string) bool {
+	first, _, multiline := strings.Cut(script, "\n")
+	return multiline && strings.Contains(first, "python")
+}
+
+// ShellQuote quotes s as a single word of sh
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
 func RemoveStartEndMark(raw string) string {
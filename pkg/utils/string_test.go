@@ -0,0 +1,39 @@
+package utils
+
+import "testing"
+
+func TestGetDefaultExecutor(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"#!/usr/bin/env python3\nprint('ok')", "python3"},
+		{"#!/usr/bin/python\nimport os\n", "python3"},
+		{"python3 app.py", "sh"},
+		{"#!/usr/bin/env python3", "sh"},
+		{"uname -a\npython3 app.py", "sh"},
+		{"", "sh"},
+	}
+	for _, tt := range tests {
+		if got := GetDefaultExecutor(tt.script); got != tt.want {
+			t.Errorf("GetDefaultExecutor(%q) = %s, want %s", tt.script, got, tt.want)
+		}
+		if got := IsPythonScript(tt.script); got != (tt.want == "python3") {
+			t.Errorf("IsPythonScript(%q) = %v", tt.script, got)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":         "''",
+		"a b":      "'a b'",
+		"it's":     `'it'"'"'s'`,
+		"$(id)`x`": "'$(id)`x`'",
+	}
+	for s, want := range tests {
+		if got := ShellQuote(s); got != want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -100,6 +100,69 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/history": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "History"
+                ],
+                "summary": "Query History",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskref",
+                        "name": "taskref",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "since, unix seconds",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "until, unix seconds",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page_size",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/login/check": {
             "get": {
                 "consumes": [
@@ -478,6 +541,41 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/cancel": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "PipelineRun"
+                ],
+                "summary": "Cancel PipelineRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pipelinerun",
+                        "name": "pipelinerun",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/namespaces/{namespace}/pipelines": {
             "get": {
                 "consumes": [
@@ -873,6 +971,168 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/cancel": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "TaskRun"
+                ],
+                "summary": "Cancel TaskRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/history": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "TaskRun"
+                ],
+                "summary": "List TaskRun History",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page_size",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/history/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "TaskRun"
+                ],
+                "summary": "Get TaskRun History",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/logs": {
+            "get": {
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "TaskRun"
+                ],
+                "summary": "Get TaskRun Logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "follow",
+                        "name": "follow",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/namespaces/{namespace}/tasks": {
             "get": {
                 "consumes": [
@@ -1057,6 +1317,52 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/namespaces/{namespace}/tasks/{task}/render": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tasks"
+                ],
+                "summary": "Render Task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "task",
+                        "name": "task",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "variables",
+                        "name": "variables",
+                        "in": "body",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/summary": {
             "get": {
                 "consumes": [
@@ -1097,9 +1403,209 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "v1.EnsureFile": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "description": "Content is rendered as a Go template if the template of step is go",
+                    "type": "string"
+                },
+                "mode": {
+                    "description": "Mode is octal, such as 0644",
+                    "type": "string"
+                },
+                "owner": {
+                    "description": "Owner is user or user:group",
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "State is present or absent, default present\n+kubebuilder:validation:Enum=present;absent",
+                    "type": "string"
+                }
+            }
+        },
+        "v1.EnsureLine": {
+            "type": "object",
+            "properties": {
+                "line": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "regexp": {
+                    "description": "Regexp is the extended regex of awk, default the lines equal to line",
+                    "type": "string"
+                },
+                "state": {
+                    "description": "State is present or absent, absent removes the matching lines, default present\n+kubebuilder:validation:Enum=present;absent",
+                    "type": "string"
+                }
+            }
+        },
+        "v1.EnsurePackage": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "description": "Name is the packages separated by space",
+                    "type": "string"
+                },
+                "state": {
+                    "description": "State is present or absent, default present\n+kubebuilder:validation:Enum=present;absent",
+                    "type": "string"
+                }
+            }
+        },
+        "v1.EnsureService": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "State is started or stopped, default unchanged\n+kubebuilder:validation:Enum=started;stopped",
+                    "type": "string"
+                }
+            }
+        },
+        "v1.EnsureStep": {
+            "type": "object",
+            "properties": {
+                "file": {
+                    "$ref": "#/definitions/v1.EnsureFile"
+                },
+                "line": {
+                    "$ref": "#/definitions/v1.EnsureLine"
+                },
+                "package": {
+                    "$ref": "#/definitions/v1.EnsurePackage"
+                },
+                "service": {
+                    "$ref": "#/definitions/v1.EnsureService"
+                }
+            }
+        },
         "v1.FieldsV1": {
             "type": "object"
         },
+        "v1.HTTPStep": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "caCert": {
+                    "description": "CACert is the PEM of the CA to verify the server certificate, default the system CAs",
+                    "type": "string"
+                },
+                "clientCert": {
+                    "description": "ClientCert and ClientKey are the PEM of the client certificate",
+                    "type": "string"
+                },
+                "clientKey": {
+                    "type": "string"
+                },
+                "expectBody": {
+                    "description": "ExpectBody is a regex the response body must match",
+                    "type": "string"
+                },
+                "expectStatus": {
+                    "description": "ExpectStatus is the expected status codes separated by comma, such as 200,201 or 2xx, default 2xx",
+                    "type": "string"
+                },
+                "extract": {
+                    "description": "Extract saves the values of jsonPath in the JSON response, such as {.data.id}, to the step result",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "headers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "insecureSkipVerify": {
+                    "description": "InsecureSkipVerify skips the verification of the server certificate",
+                    "type": "boolean"
+                },
+                "json": {
+                    "description": "JSON is encoded as the body with Content-Type application/json, the values are escaped after rendered",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "method": {
+                    "description": "Method is the request method, default GET",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "v1.KubeStep": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "+kubebuilder:validation:Enum=apply;get;patch;delete;wait;rollout-restart;cordon;uncordon;drain",
+                    "type": "string"
+                },
+                "apiVersion": {
+                    "type": "string"
+                },
+                "condition": {
+                    "description": "Condition is the type of condition to be True for wait action, such as Ready, or delete for the deletion",
+                    "type": "string"
+                },
+                "force": {
+                    "description": "Force deletes the resources immediately, and drains the pods not managed by controllers",
+                    "type": "boolean"
+                },
+                "jsonPath": {
+                    "description": "JsonPath of the resource to wait for, such as {.status.phase}, to be Value or not empty",
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "labelSelector": {
+                    "description": "LabelSelector selects the resources of get, delete and wait without name",
+                    "type": "string"
+                },
+                "manifest": {
+                    "description": "Manifest is the yaml or json of the resources to apply, documents are separated by ---",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Name of the resource, or the node of cordon, uncordon and drain",
+                    "type": "string"
+                },
+                "namespace": {
+                    "description": "Namespace of the namespaced resources, default \"default\"",
+                    "type": "string"
+                },
+                "patch": {
+                    "description": "Patch is the content of patch action",
+                    "type": "string"
+                },
+                "patchType": {
+                    "description": "PatchType is one of merge, json and strategic, default merge",
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
         "v1.ManagedFieldsEntry": {
             "type": "object",
             "properties": {
@@ -1307,6 +1813,37 @@ const docTemplate = `{
         "v1.PipelineStatus": {
             "type": "object"
         },
+        "v1.Result": {
+            "type": "object",
+            "properties": {
+                "desc": {
+                    "type": "string"
+                },
+                "file": {
+                    "description": "File is read on the target after all steps, instead of step output",
+                    "type": "string"
+                },
+                "jsonPath": {
+                    "description": "JsonPath is a kubectl style jsonpath, such as {.items[0].metadata.name}",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "regex": {
+                    "description": "Regex captures the first submatch, or the whole match without group",
+                    "type": "string"
+                },
+                "step": {
+                    "description": "Step is the step name to capture from, default the last run step",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "Type is checked on capture as the type of variables, list, map and json of jsonPath are printed in JSON\n+kubebuilder:validation:Enum=string;int;bool;duration;list;map;json",
+                    "type": "string"
+                }
+            }
+        },
         "v1.Step": {
             "type": "object",
             "properties": {
@@ -1319,20 +1856,90 @@ const docTemplate = `{
                 "direction": {
                     "type": "string"
                 },
+                "ensure": {
+                    "description": "Ensure makes the target in the state only if it's not, instead of content or file",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.EnsureStep"
+                        }
+                    ]
+                },
+                "env": {
+                    "description": "Env is the environment variables of the executor",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "executor": {
+                    "description": "Executor runs the content read from stdin, such as bash, python3 or any binary with args,\ndefault python3 if the first line of content contains python, otherwise sh",
+                    "type": "string"
+                },
+                "http": {
+                    "description": "HTTP sends a request from the controller or opscli, instead of content or file",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.HTTPStep"
+                        }
+                    ]
+                },
+                "kube": {
+                    "description": "Kube operates the resources through the Kubernetes API, instead of content or file",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.KubeStep"
+                        }
+                    ]
+                },
                 "localfile": {
                     "type": "string"
                 },
+                "maxRetryDelay": {
+                    "description": "MaxRetryDelay is the max seconds of the delay, 0 is unlimited",
+                    "type": "integer"
+                },
                 "name": {
                     "type": "string"
                 },
                 "remotefile": {
                     "type": "string"
                 },
+                "retries": {
+                    "description": "Retries is the max retry times after the first attempt failed",
+                    "type": "integer"
+                },
+                "retryBackoff": {
+                    "description": "RetryBackoff multiplies the delay after every retry, 0 or 1 is constant delay",
+                    "type": "integer"
+                },
+                "retryDelay": {
+                    "description": "RetryDelay is the seconds to wait before the first retry",
+                    "type": "integer"
+                },
+                "template": {
+                    "description": "Template renders content, localfile, remotefile and the content of ensure file as a Go template with .Vars and .Facts,\ninstead of replacing ${name}\n+kubebuilder:validation:Enum=go",
+                    "type": "string"
+                },
                 "timeoutSeconds": {
                     "type": "integer"
                 },
+                "uses": {
+                    "description": "Uses inlines the steps of another task, name or namespace/name, the steps are named \u003cname\u003e.\u003cstep\u003e\nand the results of the task are named \u003cname\u003e.\u003cresult\u003e",
+                    "type": "string"
+                },
                 "when": {
                     "type": "string"
+                },
+                "with": {
+                    "description": "With sets the variables of the used task, default the values of the task",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "workdir": {
+                    "description": "WorkDir is the working directory of the executor",
+                    "type": "string"
                 }
             }
         },
@@ -1367,6 +1974,12 @@ const docTemplate = `{
                 "name": {
                     "type": "string"
                 },
+                "runAfter": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "runAlways": {
                     "type": "boolean"
                 },
@@ -1384,13 +1997,42 @@ const docTemplate = `{
         "v1.TaskSpec": {
             "type": "object",
             "properties": {
+                "batchSize": {
+                    "description": "BatchSize splits hosts into batches, a batch starts after the previous one finished, 0 is one batch",
+                    "type": "integer"
+                },
                 "desc": {
                     "description": "INSERT ADDITIONAL SPEC FIELDS - desired state of cluster\nImportant: Run \"make\" to regenerate code after modifying this file",
                     "type": "string"
                 },
+                "facts": {
+                    "description": "Facts are the custom facts gathered with the built-in ones before the steps, the name to the script,\nthe first line of the output is referred to as ${facts.\u003cname\u003e}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "failureThreshold": {
+                    "description": "FailureThreshold stops the rollout after the number of hosts failed, 0 is never stop",
+                    "type": "integer"
+                },
                 "host": {
                     "type": "string"
                 },
+                "maxUnavailable": {
+                    "description": "MaxUnavailable limits the hosts of a batch, a number or a percentage of all hosts such as 25%\n+kubebuilder:validation:XIntOrString",
+                    "type": "string"
+                },
+                "parallelism": {
+                    "description": "Parallelism is the max hosts running at the same time, default 1",
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1.Result"
+                    }
+                },
                 "runtimeImage": {
                     "type": "string"
                 },
@@ -1414,7 +2056,16 @@ const docTemplate = `{
         "v1.Variable": {
             "type": "object",
             "properties": {
+                "configMapRef": {
+                    "description": "ConfigMapRef reads the value from a ConfigMap in the namespace of the task when running",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.VariableRef"
+                        }
+                    ]
+                },
                 "default": {
+                    "description": "Default may refer to other variables, such as ${namespace}-backup",
                     "type": "string"
                 },
                 "desc": {
@@ -1441,11 +2092,38 @@ const docTemplate = `{
                 "required": {
                     "type": "boolean"
                 },
+                "secretRef": {
+                    "description": "SecretRef reads the value from a Secret in the namespace of the task when running, the value is sensitive",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.VariableRef"
+                        }
+                    ]
+                },
+                "sensitive": {
+                    "description": "Sensitive masks the value in the status and logs",
+                    "type": "boolean"
+                },
+                "type": {
+                    "description": "Type of the value, default string, a list is comma separated or a JSON array,\na map is k1=v1,k2=v2 or a JSON object\n+kubebuilder:validation:Enum=string;int;bool;duration;list;map;json",
+                    "type": "string"
+                },
                 "value": {
                     "type": "string"
                 }
             }
         },
+        "v1.VariableRef": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
         "v1.Variables": {
             "type": "object",
             "additionalProperties": {
@@ -0,0 +1,21 @@
+import json
+import requests
+
+
+def send(status, message, host='${HOSTNAME}', **fields):
+    # report to the events of taskrun, the event of a host is suffixed with its name, host='' for the cluster
+    payload = {
+        'kind': '${TASKRUN}',
+        'status': status,
+        'message': message
+    }
+    subject = 'taskruns.${TASKRUN}.reports'
+    if host:
+        payload['host'] = host
+        subject += '.' + host
+    payload.update(fields)
+    headers = {
+        'Content-Type': 'application/json'
+    }
+    response = requests.post('${OPSSERVER_ENDPOINT}/api/v1/namespaces/${NAMESPACE}/events/' + subject, headers=headers, data=json.dumps(payload))
+    print(response.text)